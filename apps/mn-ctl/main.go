@@ -1,22 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 
 	mn "github.com/NodePrime/open-mininet"
 	"github.com/NodePrime/open-mininet/pool"
+	"github.com/NodePrime/open-mininet/topo"
 	"github.com/peterh/liner"
 )
 
 var (
 	history_fn = "/tmp/.liner_history"
-	names      = []string{"help", "new", "new host", "new switch", "new link", "new router", "dump-json", "import", "recover", "release", "show hosts", "show switches"}
+	names      = []string{"help", "new", "new host", "new switch", "new link", "new router", "new topo", "shape", "dns", "dump-json", "import", "plan", "apply", "reattach", "recover", "release", "show hosts", "show switches", "keep-partial", "cleanup"}
 )
 
 var generalHelpTest = `
@@ -24,7 +26,9 @@ Generat help topic.
 Host always has its own namespace, switch hasn't. Host's netns is equal to it's name.
 Commands:
   new host   [name]     Creates new host instance
-  new switch [name]     Creates new switch instance
+  new switch [name [type]]
+                        Creates new switch instance, type is "ovs" (default)
+                        or "linux" for kernel bridge
   
   new link   [nodeLeft, nodeRigh, LeftLinkOptions, RightLinkOptions]
              Right node should be a namespaced host.
@@ -39,16 +43,59 @@ Commands:
                     new link switch1 host1 {"Cidr":"noip", "Name":"ctrl0"} {"Cidr":"192.168.55.200/24", "Name":"ctrl1"}
 
   new router [name]     Create router, same as host, but with forwarding enabled
+
+  new topo   type size... [TopoOptions]
+             Generate and create topology: linear n, tree depth fanout,
+             star n, fattree k, mesh n, ring n. Hosts are h1, h2, ...,
+             switches s1, s2, ...
+             Options:
+                SwitchType: "ovs" (default) or "linux"
+                Controller: switch controller, e.g. "tcp:127.0.0.1:6633"
+                Protocols:  OpenFlow versions, e.g. ["OpenFlow13"]
+                Cidr:       hosts subnet, "10.0.0.0/8" by default
+
+                E.g.:
+                    new topo tree 2 3 {"SwitchType":"linux"}
+
+  dns hostname [domain] Answer DNS queries for the hosts names from the host
+                        namespace, resolv.conf of hosts points to it
+
+  shape node ifname [ShapingOptions]
+             Change link egress shaping on the fly, no options removes it.
+             Options:
+                Rate, Burst:  tc notation, e.g. "10mbit", "32kbit"
+                Delay, Jitter: e.g. "100ms"
+                Loss, Duplicate, Corrupt, Reorder: percents
+                Limit: queue length in packets
+
+                E.g.:
+                    shape host1 eth0 {"Rate":"1mbit", "Delay":"50ms", "Loss":1}
   dump                  Dump as a plain text
   dump-json             Dump as a json
   show hosts            Print hosts
   show switches         Print switches
+  keep-partial [on|off] Leave objects created by failed "new" or "recover"
+                        for debugging, by default they are removed
   import {file.json}    Import json scheme 
+  recover               Create missing nodes, links and processes of the
+                        scheme, prints how long build steps took
+  cleanup [--session X] Remove namespaces, links, switches, cgroups and files
+                        created by mn, only of session X if given. Session
+                        is set by MN_SESSION environment variable
+  reattach              Rebuild scheme from namespaces, switches and links,
+                        which exist on the machine, e.g. after mn-ctl restart
+  plan [file.json]      Show what has to be added, changed or deleted to bring
+                        the system to the scheme in file, or the current one
+  apply [file.json] [dry-run]
+                        Execute the plan, the file becomes the current scheme
   
   Host command:
   hostname ps           Show processess associated with host
-  hostname proc output  {pid} Show process output
-  hostname proc stop    {pid} Stop process
+  hostname proc output  {pid} Show process stdout and stderr
+  hostname proc tail [-f] {pid}
+                        Show last lines of process output, -f follows it
+  hostname proc stop    {pid} Stop process, SIGINT first, SIGKILL after
+                        StopTimeout
 `
 
 func help(commands ...string) {
@@ -62,6 +109,9 @@ func help(commands ...string) {
 
 var scheme *mn.Scheme = mn.NewScheme()
 
+// keepPartial leaves objects of failed builds, see Journal
+var keepPartial bool
+
 func newNode(commands ...string) {
 	switch commands[0] {
 	case "host":
@@ -96,11 +146,16 @@ func newNode(commands ...string) {
 
 	case "switch":
 		var name string
-		if len(commands) == 2 {
+		if len(commands) >= 2 {
 			name = commands[1]
 		}
 
-		s, err := mn.NewSwitch(name)
+		typ := mn.SwitchOVS
+		if len(commands) == 3 {
+			typ = commands[2]
+		}
+
+		s, err := mn.NewSwitchOf(typ, name)
 		if err != nil {
 			log.Println(err)
 			return
@@ -151,24 +206,211 @@ func newNode(commands ...string) {
 
 		pair := mn.NewLink(node1, node2, left, right)
 
-		if err := pair.Create(); err != nil {
-			log.Println("Unable to create pair:", err)
+		if err := newLink(node1, node2, pair); err != nil {
+			log.Println("Unable to create link:", err)
 			return
 		}
 
-		pair, err := pair.Up()
-		if err != nil {
-			log.Println("Can't bring it up,", err)
+		if err := scheme.UpdateHosts(); err != nil {
+			log.Println("Unable to update hosts files:", err)
 		}
 
-		node1.AddLink(pair.Left)
-		node2.AddLink(pair.Right)
-
 		if pair.IsPatch() {
 			fmt.Println("[Patch]", node1.NodeName(), "<--->", node2.NodeName())
 		}
 
 		return
+
+	case "topo":
+		newTopo(commands[1:]...)
+	}
+}
+
+// newLink creates the pair and plugs it into nodes, all or nothing
+func newLink(node1, node2 mn.Node, pair mn.Pair) error {
+	journal := mn.NewJournal()
+	journal.Keep = keepPartial
+
+	err := func() error {
+		if !pair.IsPatch() {
+			if err := pair.Create(); err != nil {
+				return err
+			}
+
+			journal.Record("link", pair.Left.Name, func() error {
+				pair.Left.Release()
+				return nil
+			})
+		}
+
+		var err error
+		if pair, err = pair.Up(); err != nil {
+			return err
+		}
+
+		ends := []struct {
+			node mn.Node
+			link mn.Link
+		}{{node1, pair.Left}, {node2, pair.Right}}
+
+		// switches first, hosts only keep the link in the scheme
+		for _, end := range ends {
+			s, ok := end.node.(*mn.Switch)
+			if !ok {
+				continue
+			}
+
+			if err := s.AddLink(end.link); err != nil {
+				return err
+			}
+
+			name := end.link.Name
+			journal.Record("port", s.Name+" "+name, func() error {
+				return s.DelPort(name)
+			})
+		}
+
+		for _, end := range ends {
+			if _, ok := end.node.(*mn.Switch); !ok {
+				end.node.AddLink(end.link)
+			}
+		}
+
+		return nil
+	}()
+
+	return journal.Done(err)
+}
+
+// newTopo creates generated topology and adds its nodes to the scheme
+func newTopo(args ...string) {
+	if len(args) < 1 {
+		log.Println("Topology type required, one of", topo.Names())
+		return
+	}
+
+	sizes := make([]int, 0)
+	opts := topo.Options{}
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "{") {
+			if err := json.Unmarshal([]byte(arg), &opts); err != nil {
+				log.Println(err)
+				return
+			}
+			continue
+		}
+
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			log.Println("Bad topology size", arg)
+			return
+		}
+
+		sizes = append(sizes, n)
+	}
+
+	generated, err := topo.New(args[0], sizes, opts)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for node := range generated.Nodes() {
+		if _, found := scheme.GetNode(node.NodeName()); found {
+			log.Println("Node", node.NodeName(), "exists, release the scheme first")
+			return
+		}
+	}
+
+	generated.KeepPartial = keepPartial
+
+	if err := generated.Recover(); err != nil {
+		log.Println("Unable to create topology:", err)
+		return
+	}
+
+	for _, s := range generated.Switches {
+		scheme.AddNode(s)
+		names = append(names, s.NodeName())
+	}
+
+	for _, h := range generated.Hosts {
+		scheme.AddNode(h)
+	}
+
+	if err := scheme.UpdateHosts(); err != nil {
+		log.Println("Unable to update hosts files:", err)
+	}
+
+	fmt.Println("Topology", args[0], "created:", len(generated.Switches), "switches,", len(generated.Hosts), "hosts")
+}
+
+func shape(args ...string) {
+	if len(args) < 2 {
+		log.Println("Node and interface name required, e.g.: shape s1 s1-eth0 {\"Delay\":\"100ms\"}")
+		return
+	}
+
+	var shaping *mn.Shaping
+
+	if len(args) >= 3 && args[2] != "" {
+		shaping = &mn.Shaping{}
+		if err := json.Unmarshal([]byte(strings.Join(args[2:], " ")), shaping); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	if err := scheme.ShapeLink(args[0], args[1], shaping); err != nil {
+		log.Println("Unable to shape link:", err)
+	}
+}
+
+// reconcile shows or applies the plan of desired scheme, which is loaded
+// from file, if given, or the current one otherwise
+func reconcile(apply bool, args ...string) {
+	desired := scheme
+	dryRun := false
+
+	for _, arg := range args {
+		if arg == "dry-run" || arg == "--dry-run" {
+			dryRun = true
+			continue
+		}
+
+		tmp, err := mn.LoadScheme(arg)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		desired = tmp
+	}
+
+	plan, err := desired.Plan()
+	if err != nil {
+		log.Println("Unable to make a plan:", err)
+		return
+	}
+
+	fmt.Println(plan)
+
+	if !apply {
+		return
+	}
+
+	if !dryRun && desired != scheme {
+		scheme.StopDns()
+	}
+
+	if err := desired.Apply(plan, dryRun); err != nil {
+		log.Println("Apply failed:", err)
+		return
+	}
+
+	if !dryRun {
+		scheme = desired
 	}
 }
 
@@ -205,7 +447,12 @@ func hostCommand(commands []string) {
 	switch commands[1] {
 	case "ps":
 		for _, process := range host.Procs {
-			fmt.Printf("%5d %s %s\n", process.GetPid(), process.Command, strings.Join(process.Args, " "))
+			st, status := process.Status(), ""
+			if st.Exit != nil {
+				status = fmt.Sprintf(" (restarts: %d, last exit: %v)", st.Restarts, st.Exit)
+			}
+
+			fmt.Printf("%5d %s %s%s\n", st.Pid, process.Command, strings.Join(process.Args, " "), status)
 		}
 
 	case "start":
@@ -215,6 +462,11 @@ func hostCommand(commands []string) {
 		}
 
 	case "proc":
+		follow := len(commands) > 4 && commands[2] == "tail" && commands[3] == "-f"
+		if follow {
+			commands = append(commands[:3], commands[4:]...)
+		}
+
 		if len(commands) < 4 {
 			log.Println("Please provide a pid of process to show")
 			break
@@ -240,19 +492,42 @@ func hostCommand(commands []string) {
 			break
 		}
 
-		if commands[2] == "output" {
-			out, err := ioutil.ReadFile(proc.Output)
+		if commands[2] == "output" || commands[2] == "tail" {
+			lines, err := proc.Logs()
 			if err != nil {
-				log.Println("Can't open process output file", proc.Output)
+				log.Println("Can't read process output:", err)
 				break
 			}
 
-			fmt.Println(string(out))
+			if commands[2] == "tail" && len(lines) > 10 {
+				lines = lines[len(lines)-10:]
+			}
+
+			for _, l := range lines {
+				fmt.Println(l)
+			}
+		}
+
+		if follow {
+			// Ctrl-C stops following
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+
+			lines, err := proc.Follow(ctx)
+			if err != nil {
+				log.Println("Can't follow process output:", err)
+				stop()
+				break
+			}
+
+			for l := range lines {
+				fmt.Println(l)
+			}
+
+			stop()
 		}
 
 	default:
-		commands = append([]string{"netns", "exec"}, commands...)
-		out, err := mn.RunCommand("ip", commands...)
+		out, err := host.RunCommand(commands[1:]...)
 		if err != nil {
 			log.Println("Error:", err, "Output:", out)
 		}
@@ -328,6 +603,26 @@ func main() {
 				log.Println("Bad arguments")
 			}
 
+		case "shape":
+			shape(commands[1:]...)
+
+		case "dns":
+			if len(commands) < 2 {
+				log.Println("Host name required, e.g.: dns h1 mn")
+				break
+			}
+
+			scheme.StopDns()
+			scheme.Dns = &mn.Dns{Host: commands[1]}
+			if len(commands) > 2 {
+				scheme.Dns.Domain = commands[2]
+			}
+
+			if err := scheme.UpdateHosts(); err != nil {
+				log.Println("Unable to start DNS:", err)
+				scheme.Dns = nil
+			}
+
 		case "dump":
 			dump()
 
@@ -336,6 +631,8 @@ func main() {
 
 		case "import":
 			if len(commands) > 1 {
+				scheme.StopDns()
+
 				tmp, err := mn.NewSchemeFromJson(commands[1])
 				if err != nil {
 					log.Println(err)
@@ -349,16 +646,64 @@ func main() {
 				log.Println("Bad arguments")
 			}
 
+		case "reattach":
+			scheme.StopDns()
+
+			tmp, err := mn.DiscoverScheme()
+			if err != nil {
+				log.Println(err)
+				break
+			}
+
+			scheme = tmp
+
+			fmt.Println("Found", len(scheme.Hosts), "hosts and", len(scheme.Switches), "switches")
+
+		case "plan":
+			reconcile(false, commands[1:]...)
+
+		case "apply":
+			reconcile(true, commands[1:]...)
+
 		case "recover":
 			if scheme != nil {
-				scheme.Recover()
+				scheme.KeepPartial = keepPartial
+
+				report, err := scheme.RecoverReport()
+				if err != nil {
+					log.Println("Unable to recover:", err)
+				}
+
+				fmt.Println(report)
 			}
 
+		case "keep-partial":
+			keepPartial = len(commands) < 2 || commands[1] == "on"
+			fmt.Println("Keep partial state of failed builds:", keepPartial)
+
 		case "release":
 			if scheme != nil {
 				scheme.Release()
 			}
 
+		case "cleanup":
+			session := ""
+			if len(commands) == 3 && commands[1] == "--session" {
+				session = commands[2]
+			} else if len(commands) != 1 {
+				log.Println("Bad arguments")
+				break
+			}
+
+			removed, err := mn.Cleanup(session)
+			for _, object := range removed {
+				fmt.Println("Removed", object)
+			}
+
+			if err != nil {
+				log.Println(err)
+			}
+
 		case "show":
 			if len(commands) == 1 {
 				log.Println("Bad arguments")
@@ -374,7 +719,7 @@ func main() {
 
 			if commands[1] == "switches" {
 				for _, node := range scheme.Switches {
-					fmt.Println(node.NodeName)
+					fmt.Println(node.NodeName())
 				}
 			}
 		}
@@ -1,8 +1,11 @@
 package main
 
 import (
-	mn "github.com/NodePrime/open-mininet"
+	"context"
+	"log"
 	"time"
+
+	mn "github.com/NodePrime/open-mininet"
 )
 
 func main() {
@@ -10,64 +13,75 @@ func main() {
 	// a storage for futher nodes
 	scheme := mn.NewScheme()
 
-	defer scheme.Release()
+	// Run tears the scheme down when the function returns, or on Ctrl-C
+	err := scheme.Run(context.Background(), func(ctx context.Context) error {
+		// create new host h1
+		host1, err := mn.NewHost("h1")
+		if err != nil {
+			return err
+		}
 
-	// create new host h1
-	host1, err := mn.NewHost("h1")
-	if err != nil {
-		panic(err)
-	}
+		scheme.AddNode(host1)
 
-	// create new switch with random name
-	sw, err := mn.NewSwitch()
-	if err != nil {
-		panic(err)
-	}
+		// create new switch with random name
+		sw, err := mn.NewSwitch()
+		if err != nil {
+			return err
+		}
 
-	// interconnect nodes
-	pair := mn.NewLink(sw, host1, mn.Link{Cidr: "noip"}, mn.Link{Cidr: "192.168.44.1/24"})
+		scheme.AddNode(sw)
 
-	// physically create link
-	if err := pair.Create(); err != nil {
-		panic(err)
-	}
+		// interconnect nodes
+		pair := mn.NewLink(sw, host1, mn.Link{Cidr: "noip"}, mn.Link{Cidr: "192.168.44.1/24"})
 
-	// apply cidr, routes, bring interfaces up
-	pair, err = pair.Up()
-	if err != nil {
-		panic(err)
-	}
+		// physically create link
+		if err := pair.Create(); err != nil {
+			return err
+		}
 
-	sw.AddLink(pair.Left)
-	host1.AddLink(pair.Right)
+		// apply cidr, routes, bring interfaces up
+		pair, err = pair.Up()
+		if err != nil {
+			return err
+		}
 
-	scheme.AddNode(host1)
-	scheme.AddNode(sw)
+		sw.AddLink(pair.Left)
+		host1.AddLink(pair.Right)
 
-	// repeat for host2
+		// repeat for host2
 
-	host2, err := mn.NewHost("h2")
-	if err != nil {
-		panic(err)
-	}
+		host2, err := mn.NewHost("h2")
+		if err != nil {
+			return err
+		}
 
-	pair2 := mn.NewLink(sw, host2, mn.Link{Cidr: "noip"}, mn.Link{Cidr: "192.168.44.2/24"})
-	if err := pair2.Create(); err != nil {
-		panic(err)
-	}
-	pair2, err = pair2.Up()
-	if err != nil {
-		panic(err)
-	}
+		scheme.AddNode(host2)
 
-	sw.AddLink(pair2.Left)
-	host2.AddLink(pair2.Right)
+		pair2 := mn.NewLink(sw, host2, mn.Link{Cidr: "noip"}, mn.Link{Cidr: "192.168.44.2/24"})
+		if err := pair2.Create(); err != nil {
+			return err
+		}
+		pair2, err = pair2.Up()
+		if err != nil {
+			return err
+		}
 
-	scheme.AddNode(host2)
+		sw.AddLink(pair2.Left)
+		host2.AddLink(pair2.Right)
 
-	// now we can run some command
+		// now we can run some command
 
-	host1.RunProcess("ping", "-c1", "192.168.44.2")
+		host1.RunProcess("ping", "-c1", "192.168.44.2")
 
-	time.Sleep(time.Second * 1)
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Fatal(err)
+	}
 }
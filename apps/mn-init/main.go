@@ -0,0 +1,118 @@
+// mn-init holds namespaces of an isolated host. It prepares hostname and
+// mounts, reports "ok" to fd 3 and then reaps orphaned processes, as init
+// of the host pid namespace does.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+func main() {
+	name := flag.String("name", "", "host name")
+	uts := flag.Bool("uts", false, "set hostname to the host name")
+	mount := flag.Bool("mount", false, "make private mounts")
+	etc := flag.String("etc", "", "directory with hosts and resolv.conf of the host")
+	proc := flag.Bool("proc", false, "mount /proc of the pid namespace")
+	flag.Parse()
+
+	ready := os.NewFile(3, "ready")
+
+	if err := setup(*name, *uts, *mount, *etc, *proc); err != nil {
+		fmt.Fprintln(ready, "error:", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintln(ready, "ok")
+	ready.Close()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGCHLD, syscall.SIGTERM, os.Interrupt)
+
+	for s := range c {
+		if s != syscall.SIGCHLD {
+			os.Exit(0)
+		}
+
+		reap()
+	}
+}
+
+func setup(name string, uts, mount bool, etc string, proc bool) error {
+	if name == "" {
+		return errors.New("host name is required")
+	}
+
+	if uts {
+		if err := syscall.Sethostname([]byte(name)); err != nil {
+			return errors.New(fmt.Sprintf("sethostname: %v", err))
+		}
+	}
+
+	if !mount {
+		return nil
+	}
+
+	// nothing mounted here should be seen in the root namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return errors.New(fmt.Sprintf("make / private: %v", err))
+	}
+
+	// etc lives in /var/run, so it's bound before tmpfs hides it
+	if etc != "" {
+		for _, file := range []string{"hosts", "resolv.conf"} {
+			if err := bind(filepath.Join(etc, file), "/etc/"+file); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dir := range []string{"/var/run", "/tmp"} {
+		target, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+
+		if err := syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+			return errors.New(fmt.Sprintf("mount tmpfs on %s: %v", target, err))
+		}
+	}
+
+	if proc {
+		if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+			return errors.New(fmt.Sprintf("mount /proc: %v", err))
+		}
+	}
+
+	return nil
+}
+
+func bind(source, target string) error {
+	if _, err := os.Stat(source); err != nil {
+		return err
+	}
+
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+		return errors.New(fmt.Sprintf("bind %s to %s: %v", source, target, err))
+	}
+
+	return nil
+}
+
+// reap waits for all exited children, orphans of the namespace are
+// reparented to init
+func reap() {
+	for {
+		var status syscall.WaitStatus
+
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if pid <= 0 || err != nil {
+			return
+		}
+	}
+}
@@ -8,19 +8,30 @@ import (
 
 	"github.com/3d0c/ogo"
 	"github.com/NodePrime/open-mininet/apps/netapps"
+	"github.com/NodePrime/open-mininet/ofp13"
 )
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	f := flag.String("fakeways", "192.168.55.1,192.168.66.1", "Default gateways expected by hosts")
-	name := flag.String("name", "", "netapp name, supported: l2-forwarder, l3-forwarder")
+	name := flag.String("name", "", "netapp name, supported: l2-forwarder, l3-forwarder, demo; discovery and l2-path-forwarder with OpenFlow13, comma separated, e.g. discovery,l2-path-forwarder")
 	listen := flag.String("listen", ":6633", "controller's ip:port to listen")
 	apiOn := flag.String("apiOn", "", "bind addr:port to serve API, e.g. :8080")
+	protocol := flag.String("protocol", "OpenFlow10", "OpenFlow version of switches, supported: OpenFlow10, OpenFlow13")
 	flag.Parse()
 
 	fakeways := strings.Split(*f, ",")
 
+	switch *protocol {
+	case "OpenFlow10":
+	case "OpenFlow13":
+		listen13(*name, fakeways, *listen, *apiOn)
+		return
+	default:
+		log.Fatalln("Unsupported protocol", *protocol)
+	}
+
 	ctrl := ogo.NewController()
 
 	switch *name {
@@ -40,3 +51,37 @@ func main() {
 
 	ctrl.Listen(*listen)
 }
+
+// listen13 runs OpenFlow 1.3 controller, switches need Protocols
+// ["OpenFlow13"] in the scheme
+func listen13(names string, fakeways []string, listen string, apiOn string) {
+	ctrl := ofp13.NewController()
+
+	if names == "" {
+		log.Println("No netapps selected, controller will be run in core mode")
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		switch name {
+		case "":
+		case "discovery":
+			ctrl.RegisterApplication(netapps.NewDiscovery)
+		case "l2-forwarder":
+			ctrl.RegisterApplication(netapps.NewL2Forwarder13)
+		case "l2-path-forwarder":
+			ctrl.RegisterApplication(netapps.NewL2PathForwarder13)
+		case "l3-forwarder":
+			ctrl.RegisterApplication(netapps.NewL3Forwarder13(fakeways))
+		case "demo":
+			ctrl.RegisterApplication(netapps.NewDemoInstance13)
+		default:
+			log.Fatalln("Unknown netapp", name)
+		}
+	}
+
+	if apiOn != "" {
+		go netapps.NewWebService13(apiOn, ctrl)
+	}
+
+	log.Fatalln(ctrl.Listen(listen))
+}
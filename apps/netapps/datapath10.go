@@ -0,0 +1,96 @@
+package netapps
+
+import (
+	"net"
+
+	"github.com/3d0c/ogo"
+	"github.com/3d0c/ogo/protocol/ofp10"
+	"github.com/3d0c/ogo/protocol/util"
+)
+
+// switch10 is OpenFlow 1.0 switch of ogo as datapath
+type switch10 net.HardwareAddr
+
+func (this switch10) DPID() net.HardwareAddr {
+	return net.HardwareAddr(this)
+}
+
+func (this switch10) send(msg util.Message) {
+	if sw, ok := ogo.Switch(this.DPID()); ok {
+		sw.Send(msg)
+	}
+}
+
+func (this switch10) addFlow(f flowEntry) {
+	msg := ofp10.NewFlowMod()
+	msg.Command = ofp10.FC_ADD
+	msg.Priority = f.priority
+	msg.IdleTimeout = f.idleTimeout
+	msg.HardTimeout = f.hardTimeout
+	msg.BufferId = f.bufferId
+
+	msg.Match.Wildcards = ofp10.FW_ALL
+	if f.inPort != 0 {
+		msg.Match.InPort = uint16(f.inPort)
+		msg.Match.Wildcards ^= ofp10.FW_IN_PORT
+	}
+	if f.ethSrc != nil {
+		msg.Match.DLSrc = f.ethSrc
+		msg.Match.Wildcards ^= ofp10.FW_DL_SRC
+	}
+	if f.ethDst != nil {
+		msg.Match.DLDst = f.ethDst
+		msg.Match.Wildcards ^= ofp10.FW_DL_DST
+	}
+
+	for _, a := range actions10(f.actions) {
+		msg.AddAction(a)
+	}
+
+	this.send(msg)
+}
+
+func (this switch10) packetOut(b buffer, actions ...action) {
+	msg := ofp10.NewPacketOut()
+	msg.InPort = uint16(b.inport)
+	msg.BufferId = b.bufferId
+
+	if b.bufferId == noBuffer {
+		msg.Data = util.NewBuffer(b.data)
+	}
+
+	for _, a := range actions10(actions) {
+		msg.AddAction(a)
+	}
+
+	this.send(msg)
+}
+
+// actions10 converts actions, reserved ports of OpenFlow 1.0 are the lower
+// 16 bits of datapath ones
+func actions10(actions []action) []ofp10.Action {
+	result := make([]ofp10.Action, 0, len(actions))
+
+	for _, a := range actions {
+		if a.ethDst != nil {
+			result = append(result, ofp10.NewActionDLDst(a.ethDst))
+		} else {
+			result = append(result, ofp10.NewActionOutput(uint16(a.port)))
+		}
+	}
+
+	return result
+}
+
+// received10 returns frame of OpenFlow 1.0 packet in and its buffer, ogo
+// decodes the frame, it's encoded back for forwarding logic
+func received10(pkt *ofp10.PacketIn) (*frame, buffer, error) {
+	data, err := pkt.Data.MarshalBinary()
+	if err != nil {
+		return nil, buffer{}, err
+	}
+
+	eth, err := parseFrame(data)
+
+	return eth, buffer{pkt.BufferId, uint32(pkt.InPort), data}, err
+}
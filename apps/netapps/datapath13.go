@@ -0,0 +1,54 @@
+package netapps
+
+import (
+	"github.com/NodePrime/open-mininet/ofp13"
+)
+
+// switch13 is OpenFlow 1.3 switch as datapath, flows go to table 0
+type switch13 struct {
+	*ofp13.Switch
+}
+
+func (this switch13) addFlow(f flowEntry) {
+	msg := ofp13.NewFlowMod()
+	msg.Priority = f.priority
+	msg.IdleTimeout = f.idleTimeout
+	msg.HardTimeout = f.hardTimeout
+	msg.BufferId = f.bufferId
+	msg.Match = ofp13.Match{InPort: f.inPort, EthDst: f.ethDst, EthSrc: f.ethSrc}
+	msg.AddInstruction(ofp13.NewInstructionApplyActions(actions13(f.actions)...))
+
+	this.Send(msg)
+}
+
+func (this switch13) packetOut(b buffer, actions ...action) {
+	packetOut(this.Switch, b, actions13(actions)...)
+}
+
+func actions13(actions []action) []ofp13.Action {
+	result := make([]ofp13.Action, 0, len(actions))
+
+	for _, a := range actions {
+		if a.ethDst != nil {
+			result = append(result, ofp13.NewActionSetField(ofp13.Match{EthDst: a.ethDst}))
+		} else {
+			result = append(result, ofp13.NewActionOutput(a.port))
+		}
+	}
+
+	return result
+}
+
+// packetOut sends packet of the buffer, or its data, if it isn't buffered
+func packetOut(sw *ofp13.Switch, b buffer, actions ...ofp13.Action) {
+	msg := ofp13.NewPacketOut()
+	msg.InPort = b.inport
+	msg.BufferId = b.bufferId
+	msg.Actions = actions
+
+	if b.bufferId == ofp13.NO_BUFFER {
+		msg.Data = b.data
+	}
+
+	sw.Send(msg)
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 
 	"github.com/3d0c/ogo/protocol/ofp10"
+	"github.com/NodePrime/open-mininet/ofp13"
 )
 
 func NewDemoInstance() interface{} {
@@ -24,3 +25,28 @@ func (b *DemoInstance) ConnectionDown(dpid net.HardwareAddr) {
 func (b *DemoInstance) PacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) {
 	log.Println("PacketIn message received from:", dpid, "len:", pkt.Len(), "datalen:", pkt.Data.Len(), "hwsrc:", pkt.Data.HWSrc, "hwdst:", pkt.Data.HWDst, pkt.Data.Ethertype)
 }
+
+func NewDemoInstance13() interface{} {
+	return new(DemoInstance13)
+}
+
+// DemoInstance13 logs events of OpenFlow 1.3 switches
+type DemoInstance13 struct{}
+
+func (b *DemoInstance13) ConnectionUp(sw *ofp13.Switch) {
+	log.Println("Switch connected:", sw, "ports:", sw.Ports())
+}
+
+func (b *DemoInstance13) ConnectionDown(sw *ofp13.Switch) {
+	log.Println("Switch disconnected:", sw)
+}
+
+func (b *DemoInstance13) PortStatus(sw *ofp13.Switch, msg *ofp13.PortStatus) {
+	log.Println("PortStatus message received from:", sw, "reason:", msg.Reason, "port:", msg.Desc.PortNo, msg.Desc.Name, "up:", msg.Desc.Up())
+}
+
+func (b *DemoInstance13) PacketIn(sw *ofp13.Switch, pkt *ofp13.PacketIn) {
+	if eth, err := parseFrame(pkt.Data); err == nil {
+		log.Println("PacketIn message received from:", sw, "table:", pkt.TableId, "inport:", pkt.InPort(), "datalen:", len(pkt.Data), "hwsrc:", eth.src, "hwdst:", eth.dst, eth.ethType)
+	}
+}
@@ -0,0 +1,384 @@
+package netapps
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NodePrime/open-mininet/ofp13"
+)
+
+// DiscoveryInterval is how often LLDP is sent to every port of every
+// switch, default of new topologies
+var DiscoveryInterval = 5 * time.Second
+
+// LinkTimeout is how long a link is kept without LLDP seen on it, default
+// of new topologies
+var LinkTimeout = 3 * DiscoveryInterval
+
+// DefaultTopology is discovered by NewDiscovery and served by web service
+var DefaultTopology = NewTopology()
+
+// DiscoveryPriority of the flow, which sends LLDP to controller
+const DiscoveryPriority = 0xffff
+
+// LLDP TLV types and subtypes
+const (
+	lldpEnd           = 0
+	lldpChassisId     = 1
+	lldpPortId        = 2
+	lldpTTL           = 3
+	lldpChassisLocal  = 7
+	lldpPortComponent = 2
+	lldpDpidPrefix    = "dpid:"
+)
+
+var lldpMulticast = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+// Link is a switch to switch link, packets output to SrcPort of Src are
+// received on DstPort of Dst. Switches are dpids.
+type Link struct {
+	Src     string
+	SrcPort uint32
+	Dst     string
+	DstPort uint32
+}
+
+func (this Link) String() string {
+	return fmt.Sprintf("%s.%d->%s.%d", this.Src, this.SrcPort, this.Dst, this.DstPort)
+}
+
+// Topology is a graph of switches and links between them. Interval and
+// Timeout are set before discovery starts.
+type Topology struct {
+	Interval time.Duration
+	Timeout  time.Duration
+
+	sync.RWMutex
+	switches map[string]bool
+	links    map[Link]time.Time
+}
+
+func NewTopology() *Topology {
+	return &Topology{
+		Interval: DiscoveryInterval,
+		Timeout:  LinkTimeout,
+		switches: make(map[string]bool),
+		links:    make(map[Link]time.Time),
+	}
+}
+
+func (this *Topology) AddSwitch(dpid string) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.switches[dpid] = true
+}
+
+// RemoveSwitch removes the switch with its links
+func (this *Topology) RemoveSwitch(dpid string) {
+	this.Lock()
+	defer this.Unlock()
+
+	delete(this.switches, dpid)
+
+	for l := range this.links {
+		if l.Src == dpid || l.Dst == dpid {
+			delete(this.links, l)
+		}
+	}
+}
+
+// AddLink adds the link or refreshes it
+func (this *Topology) AddLink(l Link) {
+	this.Lock()
+	defer this.Unlock()
+
+	if _, found := this.links[l]; !found {
+		log.Println("Link discovered:", l)
+	}
+
+	this.links[l] = time.Now()
+}
+
+// RemovePort removes links of the port
+func (this *Topology) RemovePort(dpid string, port uint32) {
+	this.Lock()
+	defer this.Unlock()
+
+	for l := range this.links {
+		if (l.Src == dpid && l.SrcPort == port) || (l.Dst == dpid && l.DstPort == port) {
+			delete(this.links, l)
+		}
+	}
+}
+
+// Expire removes links, which weren't seen for Timeout
+func (this *Topology) Expire() {
+	this.Lock()
+	defer this.Unlock()
+
+	for l, seen := range this.links {
+		if time.Since(seen) > this.Timeout {
+			log.Println("Link timed out:", l)
+			delete(this.links, l)
+		}
+	}
+}
+
+// Switches returns dpids of connected switches ordered
+func (this *Topology) Switches() []string {
+	this.RLock()
+	defer this.RUnlock()
+
+	result := make([]string, 0, len(this.switches))
+	for dpid := range this.switches {
+		result = append(result, dpid)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// Links returns links ordered by source
+func (this *Topology) Links() []Link {
+	this.RLock()
+	defer this.RUnlock()
+
+	result := make([]Link, 0, len(this.links))
+	for l := range this.links {
+		result = append(result, l)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Src != result[j].Src {
+			return result[i].Src < result[j].Src
+		}
+
+		return result[i].SrcPort < result[j].SrcPort
+	})
+
+	return result
+}
+
+// LinkPort tells, that the port of the switch is an end of a link, hosts
+// are never learned on it
+func (this *Topology) LinkPort(dpid string, port uint32) bool {
+	this.RLock()
+	defer this.RUnlock()
+
+	for l := range this.links {
+		if (l.Src == dpid && l.SrcPort == port) || (l.Dst == dpid && l.DstPort == port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Path returns links of a shortest path from src to dst switch. Paths of
+// every switch to the same dst form a tree, so flows installed along them
+// by destination never loop.
+func (this *Topology) Path(src, dst string) ([]Link, bool) {
+	links := this.Links()
+
+	// breadth first from dst, next is the link towards dst of every switch
+	next := map[string]Link{}
+	queue := []string{dst}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		for _, l := range links {
+			if _, found := next[l.Src]; l.Dst == cur && l.Src != dst && !found {
+				next[l.Src] = l
+				queue = append(queue, l.Src)
+			}
+		}
+	}
+
+	path := []Link{}
+	for cur := src; cur != dst; {
+		l, found := next[cur]
+		if !found {
+			return nil, false
+		}
+
+		path = append(path, l)
+		cur = l.Dst
+	}
+
+	return path, true
+}
+
+// lldpFrame returns LLDP frame sent from the port of the switch, ttl is in
+// seconds
+func lldpFrame(dpid uint64, port ofp13.Port, ttl uint16) []byte {
+	tlv := func(typ uint8, value []byte) []byte {
+		b := make([]byte, 2, 2+len(value))
+		binary.BigEndian.PutUint16(b, uint16(typ)<<9|uint16(len(value)))
+		return append(b, value...)
+	}
+
+	chassis := append([]byte{lldpChassisLocal}, fmt.Sprintf("%s%016x", lldpDpidPrefix, dpid)...)
+
+	portId := make([]byte, 5)
+	portId[0] = lldpPortComponent
+	binary.BigEndian.PutUint32(portId[1:], port.PortNo)
+
+	payload := tlv(lldpChassisId, chassis)
+	payload = append(payload, tlv(lldpPortId, portId)...)
+	payload = append(payload, tlv(lldpTTL, []byte{uint8(ttl >> 8), uint8(ttl)})...)
+	payload = append(payload, tlv(lldpEnd, nil)...)
+
+	return (&frame{dst: lldpMulticast, src: port.HWAddr, ethType: ethTypeLLDP, payload: payload}).marshal()
+}
+
+// parseLLDP returns dpid and port of LLDP frame sent by discovery
+func parseLLDP(b []byte) (uint64, uint32, error) {
+	var dpid uint64
+	var port uint32
+	var hasDpid, hasPort bool
+
+	for len(b) >= 2 {
+		typ, length := b[0]>>1, int(binary.BigEndian.Uint16(b)&0x1ff)
+		if len(b) < 2+length {
+			return 0, 0, errors.New("LLDP TLV is too short")
+		}
+
+		value := b[2 : 2+length]
+		b = b[2+length:]
+
+		switch {
+		case typ == lldpEnd:
+			b = nil
+		case typ == lldpChassisId && length > 0 && value[0] == lldpChassisLocal:
+			id := string(value[1:])
+			if !strings.HasPrefix(id, lldpDpidPrefix) {
+				break
+			}
+
+			var err error
+			if dpid, err = strconv.ParseUint(id[len(lldpDpidPrefix):], 16, 64); err == nil {
+				hasDpid = true
+			}
+		case typ == lldpPortId && length == 5 && value[0] == lldpPortComponent:
+			port, hasPort = binary.BigEndian.Uint32(value[1:]), true
+		}
+	}
+
+	if !hasDpid || !hasPort {
+		return 0, 0, errors.New("LLDP isn't sent by discovery")
+	}
+
+	return dpid, port, nil
+}
+
+func NewDiscovery() interface{} {
+	return &Discovery{topology: DefaultTopology}
+}
+
+// NewDiscoveryOf returns discovery, which keeps links in the topology
+func NewDiscoveryOf(topology *Topology) func() interface{} {
+	return func() interface{} {
+		return &Discovery{topology: topology}
+	}
+}
+
+// Discovery sends LLDP to every port of the switch every Interval of the
+// topology, LLDP received from other switches are links
+type Discovery struct {
+	topology *Topology
+	stop     chan struct{}
+}
+
+func (this *Discovery) ConnectionUp(sw *ofp13.Switch) {
+	flow := ofp13.NewFlowMod()
+	flow.Priority = DiscoveryPriority
+	flow.Match = ofp13.Match{EthType: ethTypeLLDP}
+	flow.AddInstruction(ofp13.NewInstructionApplyActions(ofp13.NewActionOutput(ofp13.P_CONTROLLER)))
+
+	if err := sw.Send(flow); err != nil {
+		log.Println("Unable to configure discovery of", sw, err)
+		return
+	}
+
+	this.topology.AddSwitch(sw.DPID().String())
+
+	this.stop = make(chan struct{})
+	go this.probe(sw, this.stop)
+}
+
+func (this *Discovery) ConnectionDown(sw *ofp13.Switch) {
+	if this.stop != nil {
+		close(this.stop)
+	}
+
+	this.topology.RemoveSwitch(sw.DPID().String())
+}
+
+// probe sends LLDP to every port, until stopped
+func (this *Discovery) probe(sw *ofp13.Switch, stop chan struct{}) {
+	ticker := time.NewTicker(this.topology.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, p := range sw.Ports() {
+			if p.Up() {
+				this.send(sw, p)
+			}
+		}
+
+		this.topology.Expire()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (this *Discovery) send(sw *ofp13.Switch, p ofp13.Port) {
+	msg := ofp13.NewPacketOut()
+	msg.AddAction(ofp13.NewActionOutput(p.PortNo))
+	msg.Data = lldpFrame(sw.Features().DatapathId, p, uint16(this.topology.Timeout/time.Second))
+
+	sw.Send(msg)
+}
+
+func (this *Discovery) PortStatus(sw *ofp13.Switch, msg *ofp13.PortStatus) {
+	if msg.Reason == ofp13.PR_DELETE || !msg.Desc.Up() {
+		this.topology.RemovePort(sw.DPID().String(), msg.Desc.PortNo)
+		return
+	}
+
+	this.send(sw, msg.Desc)
+}
+
+func (this *Discovery) PacketIn(sw *ofp13.Switch, pkt *ofp13.PacketIn) {
+	eth, err := parseFrame(pkt.Data)
+	if err != nil || eth.ethType != ethTypeLLDP {
+		return
+	}
+
+	dpid, port, err := parseLLDP(eth.payload)
+	if err != nil {
+		return
+	}
+
+	this.topology.AddLink(Link{
+		Src:     ofp13.DPID(dpid).String(),
+		SrcPort: port,
+		Dst:     sw.DPID().String(),
+		DstPort: pkt.InPort(),
+	})
+}
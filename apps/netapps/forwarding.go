@@ -0,0 +1,265 @@
+// Package netapps are OpenFlow applications of mn. Apps of OpenFlow 1.0
+// run on ogo controller, apps of OpenFlow 1.3 run on ofp13 one. Learning
+// and routing logic is shared: it talks to datapath and gets raw ethernet
+// frames, switch10 and switch13 adapt switches of each version to it.
+// Pipelines, which OpenFlow 1.0 has no means for, e.g. tables, groups and
+// meters of L2Forwarder13, are OpenFlow 1.3 only.
+package netapps
+
+import (
+	"log"
+	"net"
+)
+
+// Reserved ports of datapath, OpenFlow 1.0 ones are the lower 16 bits
+const (
+	portInPort = 0xfffffff8
+	portFlood  = 0xfffffffb
+	portNone   = 0xffffffff
+
+	noBuffer = 0xffffffff
+)
+
+// datapath is a switch as forwarding logic sees it, apps of OpenFlow 1.0
+// and 1.3 adapt their switches to it
+type datapath interface {
+	DPID() net.HardwareAddr
+	addFlow(f flowEntry)
+	// packetOut sends packet of the buffer, or its data, if it isn't
+	// buffered
+	packetOut(b buffer, actions ...action)
+}
+
+// action sets destination mac, if ethDst isn't nil, or outputs to port
+type action struct {
+	ethDst net.HardwareAddr
+	port   uint32
+}
+
+func output(port uint32) action {
+	return action{port: port}
+}
+
+func setEthDst(mac net.HardwareAddr) action {
+	return action{ethDst: mac}
+}
+
+// flowEntry matches packets of inPort, ethSrc and ethDst, zero port and nil
+// macs match any
+type flowEntry struct {
+	inPort      uint32
+	ethSrc      net.HardwareAddr
+	ethDst      net.HardwareAddr
+	priority    uint16
+	idleTimeout uint16
+	hardTimeout uint16
+	bufferId    uint32
+	actions     []action
+}
+
+func newFlowEntry() flowEntry {
+	return flowEntry{priority: 10, bufferId: noBuffer}
+}
+
+type buffer struct {
+	bufferId uint32
+	inport   uint32
+	data     []byte
+}
+
+type pair struct {
+	dpid   string
+	ipaddr string
+}
+
+func dpidToMac(dpid net.HardwareAddr) net.HardwareAddr {
+	result := make([]byte, 6)
+	copy(result, dpid[2:])
+	return result
+}
+
+// l2Learning floods packets to unknown destinations and installs flows
+// between learned hosts both ways
+type l2Learning struct {
+	hosts *hostmap
+}
+
+func (this *l2Learning) packetIn(dp datapath, eth *frame, received buffer) {
+	if eth.discovery() {
+		return
+	}
+
+	dpid, inport := dp.DPID(), received.inport
+
+	this.hosts.Add(dpid, eth.src, inport)
+
+	host, found := this.hosts.Host(dpid, eth.dst)
+	if !found {
+		dp.packetOut(received, output(portFlood))
+		return
+	}
+
+	if host.port == inport {
+		log.Println("Same port for packet from", eth.src, "->", eth.dst, "on", dpid, host.port)
+		return
+	}
+
+	f1 := newFlowEntry()
+	f1.inPort = inport
+	f1.ethSrc = eth.src
+	f1.ethDst = eth.dst
+	f1.idleTimeout = 10
+	f1.bufferId = received.bufferId
+	f1.actions = []action{output(host.port)}
+
+	f2 := newFlowEntry()
+	f2.inPort = host.port
+	f2.ethSrc = eth.dst
+	f2.ethDst = eth.src
+	f2.idleTimeout = 3
+	f2.actions = []action{output(inport)}
+
+	log.Println("Installing flow for", eth.src, inport, "<-->", eth.dst, host.port)
+	log.Println("Installing flow for", eth.dst, host.port, "<-->", eth.src, inport)
+
+	dp.addFlow(f1)
+	dp.addFlow(f2)
+
+	// flow mod releases buffered packet only
+	if received.bufferId == noBuffer {
+		dp.packetOut(received, f1.actions...)
+	}
+}
+
+// l3Routing answers ARP for known hosts and fakeways, installs flows to
+// learned destinations and ARPs for unknown ones. Packets waiting for ARP
+// reply are kept by switch and destination ip.
+type l3Routing struct {
+	arpTable *hostmap
+	fakeways []string
+	// fakewayPort is what fakeways are learned on
+	fakewayPort uint32
+	lostBuffers map[pair][]buffer
+}
+
+func newL3Routing(fakeways []string, fakewayPort uint32) *l3Routing {
+	return &l3Routing{
+		arpTable:    NewHostMap(),
+		fakeways:    fakeways,
+		fakewayPort: fakewayPort,
+		lostBuffers: make(map[pair][]buffer),
+	}
+}
+
+func (this *l3Routing) connectionUp(dpid net.HardwareAddr) {
+	for _, fake := range this.fakeways {
+		this.arpTable.Add(dpid, net.ParseIP(fake), host{dpidToMac(dpid), this.fakewayPort})
+	}
+}
+
+func (this *l3Routing) sendLostBuffers(dp datapath, ipaddr net.IP, macaddr net.HardwareAddr, port uint32) {
+	key := pair{dp.DPID().String(), ipaddr.String()}
+
+	buffers, found := this.lostBuffers[key]
+	if !found {
+		return
+	}
+
+	for _, b := range buffers {
+		dp.packetOut(b, setEthDst(macaddr), output(port))
+	}
+
+	delete(this.lostBuffers, key)
+}
+
+func (this *l3Routing) packetIn(dp datapath, ethFrame *frame, received buffer) {
+	if ethFrame.discovery() {
+		return
+	}
+
+	dpid, inport := dp.DPID(), received.inport
+
+	switch ethFrame.ethType {
+	case ethTypeIPv4:
+		src, dst, err := parseIPv4(ethFrame.payload)
+		if err != nil {
+			return
+		}
+
+		this.arpTable.Add(dpid, src, host{ethFrame.src, inport})
+
+		log.Println(dpid, inport, "IP", src, "->", dst)
+
+		this.sendLostBuffers(dp, src, ethFrame.src, inport)
+
+		if host, found := this.arpTable.Host(dpid, dst); found {
+			if host.port == inport {
+				log.Println(dpid, inport, "not sending packet for", dst, "back out of the input port")
+			} else {
+				log.Println(dpid, inport, "installing flow for", src, "=>", dst, "out port", host.port)
+			}
+
+			msg := newFlowEntry()
+			msg.inPort = inport
+			msg.ethDst = ethFrame.dst
+			msg.ethSrc = ethFrame.src
+			msg.idleTimeout = 30
+			msg.hardTimeout = 20
+			msg.bufferId = received.bufferId
+			msg.actions = []action{setEthDst(host.mac), output(host.port)}
+
+			dp.addFlow(msg)
+
+			// flow mod releases buffered packet only
+			if received.bufferId == noBuffer {
+				dp.packetOut(received, msg.actions...)
+			}
+
+			return
+		}
+
+		key := pair{dpid.String(), dst.String()}
+		this.lostBuffers[key] = append(this.lostBuffers[key], received)
+
+		arpReq := &arpPacket{op: arpRequest, hwSrc: ethFrame.src, ipSrc: src, hwDst: broadcast, ipDst: dst}
+		e := &frame{dst: broadcast, src: ethFrame.src, ethType: ethTypeARP, payload: arpReq.marshal()}
+
+		log.Println(dpid, inport, "ARPing for", dst, "on behalf of", src)
+
+		dp.packetOut(buffer{noBuffer, inport, e.marshal()}, output(portFlood))
+	case ethTypeARP:
+		a, err := parseARP(ethFrame.payload)
+		if err != nil {
+			return
+		}
+
+		log.Println(dpid, inport, "ARP", a.op, a.ipSrc, "->", a.ipDst)
+
+		if _, found := this.arpTable.Host(dpid, a.ipSrc); found {
+			log.Println("RE-learned:", dpid, inport, a.ipSrc)
+		} else {
+			log.Println("learned:", dpid, inport, a.ipSrc)
+		}
+
+		this.arpTable.Add(dpid, a.ipSrc, host{ethFrame.src, inport})
+
+		this.sendLostBuffers(dp, a.ipSrc, ethFrame.src, inport)
+
+		if a.op == arpRequest {
+			if host, found := this.arpTable.Host(dpid, a.ipDst); found {
+				reply := &arpPacket{op: arpReply, hwSrc: host.mac, ipSrc: a.ipDst, hwDst: a.hwSrc, ipDst: a.ipSrc}
+				e := &frame{dst: a.hwSrc, src: dpidToMac(dpid), ethType: ethTypeARP, payload: reply.marshal()}
+
+				log.Println(dpid, inport, "answering ARP for", a.ipDst)
+
+				dp.packetOut(buffer{noBuffer, inport, e.marshal()}, output(portInPort))
+
+				return
+			}
+		}
+
+		log.Println(dpid, inport, "Flooding ARP", a.ipSrc, "->", a.ipDst)
+
+		dp.packetOut(received, output(portFlood))
+	}
+}
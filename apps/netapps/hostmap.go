@@ -2,95 +2,95 @@ package netapps
 
 import (
 	"net"
+	"sort"
 	"sync"
 )
 
 type host struct {
 	mac  net.HardwareAddr
-	port uint16
+	port uint32
 }
 
+// DefaultHosts are learned by L2Forwarder13 and NewL2PathForwarder13, and
+// served by web service
+var DefaultHosts = NewHostMap()
+
+// LearnedHost is a host learned on the port of the switch, Ip is set for
+// hosts learned by ip
+type LearnedHost struct {
+	Dpid string
+	Mac  string
+	Ip   string `json:",omitempty"`
+	Port uint32
+}
+
+// hostmap keeps hosts learned by every switch, by mac and by ip
 type hostmap struct {
 	byMacIp map[string]map[string]host
-	byMac   map[string]host
+	byMac   map[string]map[string]host
 	sync.RWMutex
 }
 
 func NewHostMap() *hostmap {
 	return &hostmap{
 		byMacIp: make(map[string]map[string]host),
-		byMac:   make(map[string]host),
+		byMac:   make(map[string]map[string]host),
 	}
 }
 
+// Host returns host learned by the switch, Host(dpid, mac) or Host(dpid, ip)
 func (this *hostmap) Host(v ...interface{}) (h host, ok bool) {
 	this.RLock()
 	defer this.RUnlock()
 
-	switch len(v) {
-	case 0:
-		panic("Wrong call, expected at least one argument to hostMap.Host(...)")
+	if len(v) != 2 {
+		panic("Wrong call, expected two arguments to hostMap.Host(...)")
+	}
 
-	case 1:
-		mac, ok := v[0].(net.HardwareAddr)
-		if !ok {
-			panic("Expected net.HardwareAddr")
-		}
+	dpid, ok := v[0].(net.HardwareAddr)
+	if !ok {
+		panic("First argument expected to be net.HardwareAddr")
+	}
 
-		h, ok := this.byMac[mac.String()]
+	switch addr := v[1].(type) {
+	case net.HardwareAddr:
+		h, ok := this.byMac[dpid.String()][addr.String()]
 		return h, ok
-
-	case 2:
-		dpid, ok := v[0].(net.HardwareAddr)
-		if !ok {
-			panic("First argument expected to be net.HardwareAddr")
-		}
-
-		ip, ok := v[1].(net.IP)
-		if !ok {
-			panic("Second argument expected to be net.IP")
-		}
-
-		h, ok := this.byMacIp[dpid.String()][ip.String()]
+	case net.IP:
+		h, ok := this.byMacIp[dpid.String()][addr.String()]
 		return h, ok
+	default:
+		panic("Second argument expected to be net.HardwareAddr or net.IP")
 	}
-
-	return
 }
 
+// Add learns host on the switch, Add(dpid, mac, port) or Add(dpid, ip, host)
 func (this *hostmap) Add(v ...interface{}) {
-	this.RLock()
-	defer this.RUnlock()
-
-	switch len(v) {
-	case 0:
-		panic("Wrong call, expected at least one argument to hostMap.Host(...)")
-
-	case 2:
-		mac, ok := v[0].(net.HardwareAddr)
-		if !ok {
-			panic("Expected first argument to be a net.HardwareAddr")
-		}
+	this.Lock()
+	defer this.Unlock()
 
-		port, ok := v[1].(uint16)
-		if !ok {
-			panic("Expected second argument to be an uint16")
-		}
+	if len(v) != 3 {
+		panic("Wrong call, expected three arguments to hostMap.Add(...)")
+	}
 
-		this.byMac[mac.String()] = host{mac, port}
-		return
+	dpid, ok := v[0].(net.HardwareAddr)
+	if !ok {
+		panic("Expected first argument to be a net.HardwareAddr")
+	}
 
-	case 3:
-		dpid, ok := v[0].(net.HardwareAddr)
+	switch addr := v[1].(type) {
+	case net.HardwareAddr:
+		port, ok := v[2].(uint32)
 		if !ok {
-			panic("Expected first argument to be a net.HardwareAddr")
+			panic("Expected third argument to be an uint32")
 		}
 
-		ip, ok := v[1].(net.IP)
-		if !ok {
-			panic("Expected first argument to be a net.HardwareAddr")
+		if _, found := this.byMac[dpid.String()]; !found {
+			this.byMac[dpid.String()] = make(map[string]host)
 		}
 
+		this.byMac[dpid.String()][addr.String()] = host{addr, port}
+	case net.IP:
 		h, ok := v[2].(host)
 		if !ok {
 			panic("Expected third argument to be a host")
@@ -100,17 +100,94 @@ func (this *hostmap) Add(v ...interface{}) {
 			this.byMacIp[dpid.String()] = make(map[string]host)
 		}
 
-		this.byMacIp[dpid.String()][ip.String()] = h
+		this.byMacIp[dpid.String()][addr.String()] = h
+	default:
+		panic("Expected second argument to be a net.HardwareAddr or net.IP")
+	}
+}
 
-		return
+// Locate returns the switch, which learned the mac, and the host
+func (this *hostmap) Locate(mac net.HardwareAddr) (string, host, bool) {
+	this.RLock()
+	defer this.RUnlock()
 
-	default:
-		panic("Wrong call, expected 2 or three arguments")
+	for dpid, hosts := range this.byMac {
+		if h, found := hosts[mac.String()]; found {
+			return dpid, h, true
+		}
 	}
 
+	return "", host{}, false
+}
+
+// Forget removes the mac learned by any switch
+func (this *hostmap) Forget(mac net.HardwareAddr) {
+	this.Lock()
+	defer this.Unlock()
+
+	for _, hosts := range this.byMac {
+		delete(hosts, mac.String())
+	}
 }
 
+// ForgetPort removes macs learned on the port of the switch
+func (this *hostmap) ForgetPort(dpid net.HardwareAddr, port uint32) {
+	this.Lock()
+	defer this.Unlock()
+
+	for mac, h := range this.byMac[dpid.String()] {
+		if h.port == port {
+			delete(this.byMac[dpid.String()], mac)
+		}
+	}
+}
+
+// Dpid returns hosts learned by ip on the switch
 func (this *hostmap) Dpid(dpid net.HardwareAddr) (map[string]host, bool) {
+	this.RLock()
+	defer this.RUnlock()
+
 	iptohost, found := this.byMacIp[dpid.String()]
 	return iptohost, found
 }
+
+// Hosts returns hosts learned by the switch, by any switch for empty dpid,
+// ordered by dpid, mac and ip
+func (this *hostmap) Hosts(dpid string) []LearnedHost {
+	this.RLock()
+	defer this.RUnlock()
+
+	result := make([]LearnedHost, 0)
+
+	for _, table := range []map[string]map[string]host{this.byMac, this.byMacIp} {
+		for d, hosts := range table {
+			if dpid != "" && d != dpid {
+				continue
+			}
+
+			for key, h := range hosts {
+				learned := LearnedHost{Dpid: d, Mac: h.mac.String(), Port: h.port}
+				if ip := net.ParseIP(key); ip != nil {
+					learned.Ip = ip.String()
+				}
+
+				result = append(result, learned)
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Dpid != b.Dpid {
+			return a.Dpid < b.Dpid
+		}
+
+		if a.Mac != b.Mac {
+			return a.Mac < b.Mac
+		}
+
+		return a.Ip < b.Ip
+	})
+
+	return result
+}
@@ -0,0 +1,99 @@
+package netapps
+
+import (
+	"log"
+
+	"github.com/NodePrime/open-mininet/ofp13"
+)
+
+// OpenFlow 1.3 learning switch. Table 0 learns: packets of unknown source
+// go to controller and to table 1, which forwards by destination and floods
+// misses through an ALL group. Packets to controller are limited by meter.
+const (
+	L2LearnTable   = 0
+	L2ForwardTable = 1
+	L2FloodGroup   = 1
+	L2Meter        = 1
+)
+
+// L2MeterRate is packets per second a switch sends to L2Forwarder13
+var L2MeterRate uint32 = 1000
+
+// L2IdleTimeout is idle timeout of learned flows in seconds
+var L2IdleTimeout uint16 = 60
+
+func NewL2Forwarder13() interface{} {
+	return &L2Forwarder13{DefaultHosts}
+}
+
+type L2Forwarder13 struct {
+	*hostmap
+}
+
+func (this *L2Forwarder13) ConnectionUp(sw *ofp13.Switch) {
+	meter := ofp13.NewMeterMod(ofp13.MC_ADD, ofp13.MF_PKTPS, L2Meter)
+	meter.AddBand(ofp13.MeterBand{Type: ofp13.MBT_DROP, Rate: L2MeterRate})
+
+	learn := ofp13.NewFlowMod()
+	learn.TableId = L2LearnTable
+	learn.AddInstruction(ofp13.NewInstructionMeter(L2Meter))
+	learn.AddInstruction(ofp13.NewInstructionApplyActions(ofp13.NewActionOutput(ofp13.P_CONTROLLER)))
+	learn.AddInstruction(ofp13.NewInstructionGotoTable(L2ForwardTable))
+
+	flood := ofp13.NewFlowMod()
+	flood.TableId = L2ForwardTable
+	flood.AddInstruction(ofp13.NewInstructionApplyActions(ofp13.NewActionGroup(L2FloodGroup)))
+
+	for _, msg := range []ofp13.Message{meter, floodGroup(sw, ofp13.GC_ADD), learn, flood} {
+		if err := sw.Send(msg); err != nil {
+			log.Println("Unable to configure", sw, err)
+			return
+		}
+	}
+
+	log.Println("Connection up for", sw, "ports:", len(sw.Ports()))
+}
+
+// floodGroup sends packet to every port of the switch
+func floodGroup(sw *ofp13.Switch, command uint16) *ofp13.GroupMod {
+	group := ofp13.NewGroupMod(command, ofp13.GT_ALL, L2FloodGroup)
+	for _, p := range sw.Ports() {
+		group.AddBucket(ofp13.NewBucket(ofp13.NewActionOutput(p.PortNo)))
+	}
+
+	return group
+}
+
+func (this *L2Forwarder13) PortStatus(sw *ofp13.Switch, msg *ofp13.PortStatus) {
+	sw.Send(floodGroup(sw, ofp13.GC_MODIFY))
+}
+
+func (this *L2Forwarder13) PacketIn(sw *ofp13.Switch, pkt *ofp13.PacketIn) {
+	eth, err := parseFrame(pkt.Data)
+	if err != nil || eth.discovery() {
+		return
+	}
+
+	// the packet is forwarded by table 1 already, it's only learned here,
+	// flows of moved or expired source are replaced
+	this.hostmap.Add(sw.DPID(), eth.src, pkt.InPort())
+
+	log.Println("Learned", eth.src, "on", sw, pkt.InPort())
+
+	learned := ofp13.NewFlowMod()
+	learned.TableId = L2LearnTable
+	learned.Priority = 10
+	learned.IdleTimeout = L2IdleTimeout
+	learned.Match = ofp13.Match{InPort: pkt.InPort(), EthSrc: eth.src}
+	learned.AddInstruction(ofp13.NewInstructionGotoTable(L2ForwardTable))
+
+	forward := ofp13.NewFlowMod()
+	forward.TableId = L2ForwardTable
+	forward.Priority = 10
+	forward.IdleTimeout = L2IdleTimeout
+	forward.Match = ofp13.Match{EthDst: eth.src}
+	forward.AddInstruction(ofp13.NewInstructionApplyActions(ofp13.NewActionOutput(pkt.InPort())))
+
+	sw.Send(forward)
+	sw.Send(learned)
+}
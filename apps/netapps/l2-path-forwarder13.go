@@ -0,0 +1,261 @@
+package netapps
+
+import (
+	"log"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/NodePrime/open-mininet/ofp13"
+)
+
+// OpenFlow 1.3 learning switch for topologies with loops. Hosts are learned
+// per datapath on edge ports only, i.e. ports without a discovered link.
+// Unknown destinations are flooded by controller to edge ports of every
+// switch, so broadcasts never come back over links. Known destinations get
+// flows by destination along the shortest path on every switch of it.
+const (
+	L2PathPriority = 10
+	L2PathCookie   = 0x6c32
+)
+
+func NewL2PathForwarder13() interface{} {
+	return &L2PathForwarder13{defaultFabric}
+}
+
+// NewL2PathForwarderOf returns forwarder, which finds paths in the topology,
+// it's discovered by NewDiscoveryOf(topology)
+func NewL2PathForwarderOf(topology *Topology) func() interface{} {
+	f := newFabric(topology)
+
+	return func() interface{} {
+		return &L2PathForwarder13{f}
+	}
+}
+
+var defaultFabric = &fabric{
+	topology: DefaultTopology,
+	hosts:    DefaultHosts,
+	switches: make(map[string]*ofp13.Switch),
+}
+
+// fabric is shared by forwarders of all switches, flows of a path are sent
+// to switches, which aren't the one packet came in
+type fabric struct {
+	topology *Topology
+	hosts    *hostmap
+
+	sync.RWMutex
+	switches map[string]*ofp13.Switch
+}
+
+func newFabric(topology *Topology) *fabric {
+	return &fabric{
+		topology: topology,
+		hosts:    NewHostMap(),
+		switches: make(map[string]*ofp13.Switch),
+	}
+}
+
+func (this *fabric) add(sw *ofp13.Switch) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.switches[sw.DPID().String()] = sw
+}
+
+func (this *fabric) remove(sw *ofp13.Switch) {
+	this.Lock()
+	defer this.Unlock()
+
+	delete(this.switches, sw.DPID().String())
+}
+
+func (this *fabric) get(dpid string) (*ofp13.Switch, bool) {
+	this.RLock()
+	defer this.RUnlock()
+
+	sw, found := this.switches[dpid]
+	return sw, found
+}
+
+// all returns connected switches ordered by dpid
+func (this *fabric) all() []*ofp13.Switch {
+	this.RLock()
+	defer this.RUnlock()
+
+	result := make([]*ofp13.Switch, 0, len(this.switches))
+	for _, sw := range this.switches {
+		result = append(result, sw)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DPID().String() < result[j].DPID().String()
+	})
+
+	return result
+}
+
+// clear deletes flows installed along paths, all of them or only ones to
+// the mac
+func (this *fabric) clear(mac net.HardwareAddr) {
+	flow := ofp13.NewFlowMod()
+	flow.Command = ofp13.FC_DELETE
+	flow.TableId = ofp13.TT_ALL
+	flow.Cookie = L2PathCookie
+	flow.CookieMask = 0xffffffffffffffff
+
+	if mac != nil {
+		flow.Match = ofp13.Match{EthDst: mac}
+	}
+
+	for _, sw := range this.all() {
+		sw.Send(flow)
+	}
+}
+
+type L2PathForwarder13 struct {
+	*fabric
+}
+
+func (this *L2PathForwarder13) ConnectionUp(sw *ofp13.Switch) {
+	// OpenFlow 1.3 switch drops table misses by default
+	miss := ofp13.NewFlowMod()
+	miss.AddInstruction(ofp13.NewInstructionApplyActions(ofp13.NewActionOutput(ofp13.P_CONTROLLER)))
+
+	if err := sw.Send(miss); err != nil {
+		log.Println("Unable to configure", sw, err)
+		return
+	}
+
+	this.fabric.add(sw)
+}
+
+func (this *L2PathForwarder13) ConnectionDown(sw *ofp13.Switch) {
+	this.fabric.remove(sw)
+
+	// paths through the switch are gone
+	this.fabric.clear(nil)
+}
+
+func (this *L2PathForwarder13) PortStatus(sw *ofp13.Switch, msg *ofp13.PortStatus) {
+	if msg.Reason != ofp13.PR_DELETE && msg.Desc.Up() {
+		return
+	}
+
+	this.hosts.ForgetPort(sw.DPID(), msg.Desc.PortNo)
+	this.fabric.clear(nil)
+}
+
+func (this *L2PathForwarder13) PacketIn(sw *ofp13.Switch, pkt *ofp13.PacketIn) {
+	eth, err := parseFrame(pkt.Data)
+	if err != nil || eth.discovery() {
+		return
+	}
+
+	dpid, inport := sw.DPID().String(), pkt.InPort()
+	received := buffer{pkt.BufferId, inport, pkt.Data}
+
+	if !this.topology.LinkPort(dpid, inport) {
+		this.learn(sw, eth.src, inport)
+	}
+
+	if eth.dst[0]&1 == 1 {
+		this.flood(sw, received)
+		return
+	}
+
+	dst, h, found := this.hosts.Locate(eth.dst)
+	if found && this.topology.LinkPort(dst, h.port) {
+		// learned before the link was discovered
+		this.hosts.Forget(eth.dst)
+		found = false
+	}
+
+	if !found {
+		this.flood(sw, received)
+		return
+	}
+
+	path, found := this.topology.Path(dpid, dst)
+	if !found {
+		log.Println("No path from", dpid, "to", eth.dst, "on", dst)
+		this.flood(sw, received)
+		return
+	}
+
+	port := h.port
+	if len(path) > 0 {
+		port = path[0].SrcPort
+	}
+
+	if port == inport {
+		log.Println("Same port for packet from", eth.src, "->", eth.dst, "on", sw, port)
+		return
+	}
+
+	// the last hop is installed first, so the packet rarely outruns flows
+	this.install(dst, eth.dst, h.port)
+	for i := len(path) - 1; i >= 0; i-- {
+		this.install(path[i].Src, eth.dst, path[i].SrcPort)
+	}
+
+	packetOut(sw, received, ofp13.NewActionOutput(port))
+}
+
+// learn keeps mac on the edge port, flows to a moved host are deleted
+func (this *L2PathForwarder13) learn(sw *ofp13.Switch, mac net.HardwareAddr, port uint32) {
+	dpid, h, found := this.hosts.Locate(mac)
+	if found && dpid == sw.DPID().String() && h.port == port {
+		return
+	}
+
+	if found {
+		this.hosts.Forget(mac)
+		this.fabric.clear(mac)
+	}
+
+	this.hosts.Add(sw.DPID(), mac, port)
+
+	log.Println("Learned", mac, "on", sw, port)
+}
+
+// install sends flow to the mac on the switch
+func (this *L2PathForwarder13) install(dpid string, mac net.HardwareAddr, port uint32) {
+	sw, found := this.fabric.get(dpid)
+	if !found {
+		return
+	}
+
+	flow := ofp13.NewFlowMod()
+	flow.Cookie = L2PathCookie
+	flow.Priority = L2PathPriority
+	flow.IdleTimeout = L2IdleTimeout
+	flow.Match = ofp13.Match{EthDst: mac}
+	flow.AddInstruction(ofp13.NewInstructionApplyActions(ofp13.NewActionOutput(port)))
+
+	sw.Send(flow)
+}
+
+// flood sends packet to edge ports of every switch, except the one it came
+// in
+func (this *L2PathForwarder13) flood(in *ofp13.Switch, b buffer) {
+	for _, sw := range this.fabric.all() {
+		dpid := sw.DPID().String()
+
+		actions := make([]ofp13.Action, 0)
+		for _, p := range sw.Ports() {
+			if !p.Up() || this.topology.LinkPort(dpid, p.PortNo) || (sw == in && p.PortNo == b.inport) {
+				continue
+			}
+
+			actions = append(actions, ofp13.NewActionOutput(p.PortNo))
+		}
+
+		if sw == in {
+			packetOut(sw, b, actions...)
+		} else if len(actions) > 0 {
+			packetOut(sw, buffer{ofp13.NO_BUFFER, ofp13.P_CONTROLLER, b.data}, actions...)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package netapps
+
+import (
+	"log"
+
+	"github.com/NodePrime/open-mininet/ofp13"
+)
+
+func NewL3Forwarder13(f []string) func() interface{} {
+	return func() interface{} {
+		return &L3Forwarder13{newL3Routing(f, ofp13.P_LOCAL)}
+	}
+}
+
+// L3Forwarder13 is L3Forwarder for OpenFlow 1.3 switches
+type L3Forwarder13 struct {
+	*l3Routing
+}
+
+func (this *L3Forwarder13) ConnectionUp(sw *ofp13.Switch) {
+	this.l3Routing.connectionUp(sw.DPID())
+
+	// OpenFlow 1.3 switch drops table misses by default
+	miss := ofp13.NewFlowMod()
+	miss.AddInstruction(ofp13.NewInstructionApplyActions(ofp13.NewActionOutput(ofp13.P_CONTROLLER)))
+
+	if err := sw.Send(miss); err != nil {
+		log.Println("Unable to configure", sw, err)
+	}
+
+	log.Println("Connection up for", sw)
+}
+
+func (this *L3Forwarder13) PacketIn(sw *ofp13.Switch, pkt *ofp13.PacketIn) {
+	eth, err := parseFrame(pkt.Data)
+	if err != nil {
+		return
+	}
+
+	this.l3Routing.packetIn(switch13{sw}, eth, buffer{pkt.BufferId, pkt.InPort(), pkt.Data})
+}
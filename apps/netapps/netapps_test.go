@@ -0,0 +1,845 @@
+package netapps
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/3d0c/ogo"
+	"github.com/3d0c/ogo/protocol/ofp10"
+	"github.com/NodePrime/open-mininet/ofp10fake"
+	"github.com/NodePrime/open-mininet/ofp13"
+)
+
+var (
+	mac1, _ = net.ParseMAC("00:00:00:00:01:01")
+	mac2, _ = net.ParseMAC("00:00:00:00:02:02")
+)
+
+func ethFrame(dst, src net.HardwareAddr, ethType uint16, payload []byte) []byte {
+	return (&frame{dst: dst, src: src, ethType: ethType, payload: payload}).marshal()
+}
+
+func ipv4Frame(dst, src net.HardwareAddr, ipSrc, ipDst string) []byte {
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	ip[9] = 1
+	copy(ip[12:], net.ParseIP(ipSrc).To4())
+	copy(ip[16:], net.ParseIP(ipDst).To4())
+
+	return ethFrame(dst, src, ethTypeIPv4, ip)
+}
+
+func arpFrame(op uint16, dst, src net.HardwareAddr, ipSrc, ipDst string) []byte {
+	a := &arpPacket{op: op, hwSrc: src, ipSrc: net.ParseIP(ipSrc), hwDst: dst, ipDst: net.ParseIP(ipDst)}
+	return ethFrame(dst, src, ethTypeARP, a.marshal())
+}
+
+func connect(t *testing.T, app func() interface{}, sw *ofp13.FakeSwitch) {
+	ctrl := ofp13.NewController()
+	ctrl.RegisterApplication(app)
+
+	if err := sw.Connect(ctrl); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func inject(t *testing.T, sw *ofp13.FakeSwitch, port uint32, data []byte) {
+	sw.Inject(port, data)
+
+	if err := sw.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect checks, that every port got given number of packets, they are
+// returned ordered by port
+func expect(t *testing.T, sw *ofp13.FakeSwitch, counts map[uint32]int) [][]byte {
+	ports := make([]int, 0, len(counts))
+	for port := range counts {
+		ports = append(ports, int(port))
+	}
+
+	sort.Ints(ports)
+
+	result := make([][]byte, 0)
+
+	for _, p := range ports {
+		port, n := uint32(p), counts[uint32(p)]
+		out := sw.Transmitted(port)
+		if len(out) != n {
+			t.Fatalf("Expected %d packets on port %d, obtained %d", n, port, len(out))
+		}
+
+		result = append(result, out...)
+	}
+
+	return result
+}
+
+func TestL2Forwarder13(t *testing.T) {
+	sw := ofp13.NewFakeSwitch(1, 1, 2, 3)
+	defer sw.Close()
+
+	connect(t, NewL2Forwarder13, sw)
+
+	if m, found := sw.Meter(L2Meter); !found || len(m.Bands) != 1 || m.Bands[0].Rate != L2MeterRate {
+		t.Fatal("Expected meter", L2Meter, "obtained:", m)
+	}
+
+	if g, found := sw.Group(L2FloodGroup); !found || len(g.Buckets) != 3 {
+		t.Fatal("Expected flood group of 3 ports, obtained:", g)
+	}
+
+	if len(sw.Flows(L2LearnTable)) != 1 || len(sw.Flows(L2ForwardTable)) != 1 {
+		t.Fatal("Expected table-miss entries")
+	}
+
+	// unknown destination is flooded by the switch
+	inject(t, sw, 1, ethFrame(mac2, mac1, ethTypeIPv4, nil))
+	expect(t, sw, map[uint32]int{1: 0, 2: 1, 3: 1})
+
+	inject(t, sw, 2, ethFrame(mac1, mac2, ethTypeIPv4, nil))
+	expect(t, sw, map[uint32]int{1: 1, 2: 0, 3: 0})
+
+	inject(t, sw, 1, ethFrame(mac2, mac1, ethTypeIPv4, nil))
+	expect(t, sw, map[uint32]int{1: 0, 2: 1, 3: 0})
+
+	learned := sw.Flows(L2LearnTable)
+	if len(learned) != 3 || learned[0].Priority != 10 || learned[0].IdleTimeout != L2IdleTimeout {
+		t.Fatal("Expected learned sources of 2 hosts, obtained:", learned)
+	}
+
+	for _, f := range learned[:2] {
+		if f.Match.EthSrc.String() == mac1.String() && (f.Match.InPort != 1 || f.PacketCount != 1) {
+			t.Fatal("Expected the last packet of", mac1, "matched by learned flow, obtained:", f)
+		}
+	}
+
+	// link discovery isn't learned
+	inject(t, sw, 3, ethFrame(broadcast, mac2, ethTypeLLDP, nil))
+	expect(t, sw, map[uint32]int{1: 1, 2: 1, 3: 0})
+
+	if len(sw.Flows(L2LearnTable)) != 3 {
+		t.Fatal("Expected LLDP source not learned")
+	}
+
+	// host moved to port 3
+	inject(t, sw, 3, ethFrame(mac1, mac2, ethTypeIPv4, nil))
+	inject(t, sw, 1, ethFrame(mac2, mac1, ethTypeIPv4, nil))
+	expect(t, sw, map[uint32]int{1: 1, 2: 0, 3: 1})
+
+	sw.AddPort(4)
+
+	if err := sw.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, _ := sw.Group(L2FloodGroup); len(g.Buckets) != 4 {
+		t.Fatal("Expected flood group updated with port 4, obtained:", g)
+	}
+}
+
+// l2Learning13 runs learning of L2Forwarder on OpenFlow 1.3 switch
+type l2Learning13 struct {
+	*l2Learning
+}
+
+func (this *l2Learning13) ConnectionUp(sw *ofp13.Switch) {
+	miss := ofp13.NewFlowMod()
+	miss.AddInstruction(ofp13.NewInstructionApplyActions(ofp13.NewActionOutput(ofp13.P_CONTROLLER)))
+	sw.Send(miss)
+}
+
+func (this *l2Learning13) PacketIn(sw *ofp13.Switch, pkt *ofp13.PacketIn) {
+	if eth, err := parseFrame(pkt.Data); err == nil {
+		this.l2Learning.packetIn(switch13{sw}, eth, buffer{pkt.BufferId, pkt.InPort(), pkt.Data})
+	}
+}
+
+func TestL2Learning(t *testing.T) {
+	sw := ofp13.NewFakeSwitch(1, 1, 2, 3)
+	defer sw.Close()
+
+	connect(t, func() interface{} { return &l2Learning13{&l2Learning{NewHostMap()}} }, sw)
+
+	// unknown destination is flooded by controller
+	inject(t, sw, 1, ethFrame(mac2, mac1, ethTypeIPv4, nil))
+	expect(t, sw, map[uint32]int{1: 0, 2: 1, 3: 1})
+
+	// known one gets flows both ways, unbuffered packet is sent too
+	inject(t, sw, 2, ethFrame(mac1, mac2, ethTypeIPv4, nil))
+	expect(t, sw, map[uint32]int{1: 1, 2: 0, 3: 0})
+
+	flows := sw.Flows(0)
+	if len(flows) != 3 {
+		t.Fatal("Expected flows both ways and table-miss, obtained:", flows)
+	}
+
+	for _, f := range flows[:2] {
+		if f.Match.InPort == 0 || f.Match.EthSrc == nil || f.Match.EthDst == nil {
+			t.Fatal("Expected flow of in port, source and destination, obtained:", f)
+		}
+	}
+
+	inject(t, sw, 1, ethFrame(mac2, mac1, ethTypeIPv4, nil))
+	expect(t, sw, map[uint32]int{1: 0, 2: 1, 3: 0})
+
+	for _, f := range sw.Flows(0) {
+		if f.Match.InPort == 1 && f.PacketCount != 1 {
+			t.Fatal("Expected packet forwarded by flow, obtained:", f)
+		}
+	}
+}
+
+func TestL3Forwarder13(t *testing.T) {
+	sw := ofp13.NewFakeSwitch(1, 1, 2, 3)
+	sw.Buffers = true
+	defer sw.Close()
+
+	connect(t, NewL3Forwarder13([]string{"192.168.55.1", "192.168.66.1"}), sw)
+
+	gw := dpidToMac(sw.DPID())
+
+	// ARP for the fake gateway is answered by controller
+	inject(t, sw, 1, arpFrame(arpRequest, broadcast, mac1, "192.168.55.2", "192.168.55.1"))
+
+	out := expect(t, sw, map[uint32]int{1: 1, 2: 0, 3: 0})
+	eth, _ := parseFrame(out[0])
+	a, err := parseARP(eth.payload)
+	if err != nil || a.op != arpReply || a.hwSrc.String() != gw.String() || !a.ipSrc.Equal(net.ParseIP("192.168.55.1")) {
+		t.Fatal("Expected ARP reply of gateway, obtained:", a, err)
+	}
+
+	// destination is unknown, controller ARPs for it and keeps the buffer
+	inject(t, sw, 1, ipv4Frame(gw, mac1, "192.168.55.2", "192.168.66.2"))
+
+	out = expect(t, sw, map[uint32]int{1: 0, 2: 1, 3: 1})
+	eth, _ = parseFrame(out[0])
+	if a, err = parseARP(eth.payload); err != nil || a.op != arpRequest || !a.ipDst.Equal(net.ParseIP("192.168.66.2")) {
+		t.Fatal("Expected ARP request for 192.168.66.2, obtained:", a, err)
+	}
+
+	// reply releases the lost buffer to the learned host and is flooded
+	inject(t, sw, 2, arpFrame(arpReply, mac1, mac2, "192.168.66.2", "192.168.55.2"))
+
+	out = expect(t, sw, map[uint32]int{1: 1, 2: 1, 3: 1})
+	if eth, _ = parseFrame(out[1]); eth.ethType != ethTypeIPv4 || eth.dst.String() != mac2.String() {
+		t.Fatal("Expected lost packet sent to", mac2, "obtained:", eth)
+	}
+
+	// known destination gets a flow, which forwards buffered packet too
+	inject(t, sw, 1, ipv4Frame(gw, mac1, "192.168.55.2", "192.168.66.2"))
+	inject(t, sw, 1, ipv4Frame(gw, mac1, "192.168.55.2", "192.168.66.2"))
+
+	for _, data := range expect(t, sw, map[uint32]int{1: 0, 2: 2, 3: 0}) {
+		if eth, _ = parseFrame(data); eth.dst.String() != mac2.String() {
+			t.Fatal("Expected destination rewritten to", mac2, "obtained:", eth.dst)
+		}
+	}
+
+	flows := sw.Flows(0)
+	if len(flows) != 2 || flows[0].Match.InPort != 1 || flows[0].PacketCount != 2 {
+		t.Fatal("Expected flow from port 1 matched twice, obtained:", flows)
+	}
+}
+
+// apps registered with ogo may apply to every switch, so OpenFlow 1.0 tests
+// share one controller, app10 runs the app chosen for its switch by connect10
+var (
+	ctrl10     sync.Once
+	ctrl10Addr string
+
+	apps10Mu sync.Mutex
+	apps10   = make(map[string]func() interface{})
+)
+
+type app10 struct {
+	sync.Mutex
+	app interface{}
+}
+
+func (this *app10) get(dpid net.HardwareAddr) interface{} {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.app == nil {
+		apps10Mu.Lock()
+		this.app = apps10[dpid.String()]()
+		apps10Mu.Unlock()
+	}
+
+	return this.app
+}
+
+func (this *app10) ConnectionUp(dpid net.HardwareAddr) {
+	if app, ok := this.get(dpid).(interface {
+		ConnectionUp(net.HardwareAddr)
+	}); ok {
+		app.ConnectionUp(dpid)
+	}
+}
+
+func (this *app10) PacketIn(dpid net.HardwareAddr, pkt *ofp10.PacketIn) {
+	if app, ok := this.get(dpid).(interface {
+		PacketIn(net.HardwareAddr, *ofp10.PacketIn)
+	}); ok {
+		app.PacketIn(dpid, pkt)
+	}
+}
+
+func connect10(t *testing.T, app func() interface{}, sw *ofp10fake.Switch) {
+	ctrl10.Do(func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctrl10Addr = l.Addr().String()
+		l.Close()
+
+		ctrl := ogo.NewController()
+		ctrl.RegisterApplication(func() interface{} { return new(app10) })
+
+		go ctrl.Listen(ctrl10Addr)
+	})
+
+	apps10Mu.Lock()
+	apps10[sw.DPID().String()] = app
+	apps10Mu.Unlock()
+
+	if err := sw.Connect(ctrl10Addr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func inject10(t *testing.T, sw *ofp10fake.Switch, port uint16, data []byte) {
+	sw.Inject(port, data)
+
+	if err := sw.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect10 checks, that every port got given number of packets, they are
+// returned ordered by port. LLDP, which ogo may send for its own discovery,
+// is skipped.
+func expect10(t *testing.T, sw *ofp10fake.Switch, counts map[uint16]int) [][]byte {
+	ports := make([]int, 0, len(counts))
+	for port := range counts {
+		ports = append(ports, int(port))
+	}
+
+	sort.Ints(ports)
+
+	result := make([][]byte, 0)
+
+	for _, p := range ports {
+		port, n := uint16(p), counts[uint16(p)]
+
+		out := make([][]byte, 0)
+		for _, data := range sw.Transmitted(port) {
+			if eth, err := parseFrame(data); err != nil || !eth.discovery() {
+				out = append(out, data)
+			}
+		}
+
+		if len(out) != n {
+			t.Fatalf("Expected %d packets on port %d, obtained %d", n, port, len(out))
+		}
+
+		result = append(result, out...)
+	}
+
+	return result
+}
+
+func TestL2Forwarder(t *testing.T) {
+	sw := ofp10fake.NewSwitch(11, 1, 2, 3)
+	defer sw.Close()
+
+	connect10(t, NewL2Forwarder, sw)
+
+	// unknown destination is flooded by controller
+	data := ethFrame(mac2, mac1, ethTypeIPv4, nil)
+	inject10(t, sw, 1, data)
+
+	for _, out := range expect10(t, sw, map[uint16]int{1: 0, 2: 1, 3: 1}) {
+		if string(out) != string(data) {
+			t.Fatal("Expected flooded packet, obtained:", out)
+		}
+	}
+
+	// known one gets flows both ways, unbuffered packet is sent too
+	data = ethFrame(mac1, mac2, ethTypeIPv4, nil)
+	inject10(t, sw, 2, data)
+
+	if out := expect10(t, sw, map[uint16]int{1: 1, 2: 0, 3: 0}); string(out[0]) != string(data) {
+		t.Fatal("Expected packet sent to", mac1, "obtained:", out[0])
+	}
+
+	flows := sw.Flows()
+	if len(flows) != 2 {
+		t.Fatal("Expected flows both ways, obtained:", flows)
+	}
+
+	exact := ofp10fake.FW_ALL &^ (ofp10fake.FW_IN_PORT | ofp10fake.FW_DL_SRC | ofp10fake.FW_DL_DST)
+	for _, f := range flows {
+		if f.Match.Wildcards != uint32(exact) || f.Priority != 10 {
+			t.Fatal("Expected flow of in port, source and destination, obtained:", f)
+		}
+	}
+
+	inject10(t, sw, 1, ethFrame(mac2, mac1, ethTypeIPv4, nil))
+	expect10(t, sw, map[uint16]int{1: 0, 2: 1, 3: 0})
+
+	for _, f := range sw.Flows() {
+		if f.Match.InPort == 1 && f.PacketCount != 1 {
+			t.Fatal("Expected packet forwarded by flow, obtained:", f)
+		}
+	}
+
+	// link discovery isn't learned
+	inject10(t, sw, 3, ethFrame(broadcast, mac1, ethTypeLLDP, nil))
+	expect10(t, sw, map[uint16]int{1: 0, 2: 0, 3: 0})
+
+	if len(sw.Flows()) != 2 {
+		t.Fatal("Expected LLDP ignored")
+	}
+}
+
+func TestL3Forwarder(t *testing.T) {
+	sw := ofp10fake.NewSwitch(12, 1, 2, 3)
+	sw.Buffers = true
+	defer sw.Close()
+
+	connect10(t, NewL3Forwarder([]string{"192.168.55.1", "192.168.66.1"}), sw)
+
+	gw := dpidToMac(sw.DPID())
+
+	// ARP for the fake gateway is answered by controller
+	inject10(t, sw, 1, arpFrame(arpRequest, broadcast, mac1, "192.168.55.2", "192.168.55.1"))
+
+	out := expect10(t, sw, map[uint16]int{1: 1, 2: 0, 3: 0})
+	eth, _ := parseFrame(out[0])
+	a, err := parseARP(eth.payload)
+	if err != nil || a.op != arpReply || a.hwSrc.String() != gw.String() || !a.ipSrc.Equal(net.ParseIP("192.168.55.1")) {
+		t.Fatal("Expected ARP reply of gateway, obtained:", a, err)
+	}
+
+	// destination is unknown, controller ARPs for it and keeps the buffer
+	inject10(t, sw, 1, ipv4Frame(gw, mac1, "192.168.55.2", "192.168.66.2"))
+
+	out = expect10(t, sw, map[uint16]int{1: 0, 2: 1, 3: 1})
+	eth, _ = parseFrame(out[0])
+	if a, err = parseARP(eth.payload); err != nil || a.op != arpRequest || !a.ipDst.Equal(net.ParseIP("192.168.66.2")) {
+		t.Fatal("Expected ARP request for 192.168.66.2, obtained:", a, err)
+	}
+
+	// reply releases the lost buffer to the learned host and is flooded
+	inject10(t, sw, 2, arpFrame(arpReply, mac1, mac2, "192.168.66.2", "192.168.55.2"))
+
+	out = expect10(t, sw, map[uint16]int{1: 1, 2: 1, 3: 1})
+	if eth, _ = parseFrame(out[1]); eth.ethType != ethTypeIPv4 || eth.dst.String() != mac2.String() {
+		t.Fatal("Expected lost packet sent to", mac2, "obtained:", eth)
+	}
+
+	// known destination gets a flow, which forwards buffered packet too
+	inject10(t, sw, 1, ipv4Frame(gw, mac1, "192.168.55.2", "192.168.66.2"))
+	inject10(t, sw, 1, ipv4Frame(gw, mac1, "192.168.55.2", "192.168.66.2"))
+
+	for _, data := range expect10(t, sw, map[uint16]int{1: 0, 2: 2, 3: 0}) {
+		if eth, _ = parseFrame(data); eth.dst.String() != mac2.String() {
+			t.Fatal("Expected destination rewritten to", mac2, "obtained:", eth.dst)
+		}
+	}
+
+	flows := sw.Flows()
+	if len(flows) != 1 || flows[0].Match.InPort != 1 || flows[0].PacketCount != 2 {
+		t.Fatal("Expected flow from port 1 matched twice, obtained:", flows)
+	}
+}
+
+func TestLLDP(t *testing.T) {
+	port := ofp13.Port{PortNo: 3, HWAddr: mac1}
+
+	eth, err := parseFrame(lldpFrame(0x0102, port, 15))
+	if err != nil || eth.ethType != ethTypeLLDP || eth.src.String() != mac1.String() {
+		t.Fatal("Wrong LLDP frame:", eth, err)
+	}
+
+	dpid, no, err := parseLLDP(eth.payload)
+	if err != nil || dpid != 0x0102 || no != 3 {
+		t.Fatal("Expected dpid 0x0102 port 3, obtained:", dpid, no, err)
+	}
+
+	if _, _, err := parseLLDP([]byte{0x02, 0x03, 0x04, 0x00, 0x01}); err == nil {
+		t.Fatal("Expected error of foreign LLDP")
+	}
+}
+
+// waitLinks waits, until topology has n links
+func waitLinks(t *testing.T, topology *Topology, n int) []Link {
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		links := topology.Links()
+		if len(links) == n {
+			return links
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d links, obtained: %v", n, links)
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	topology := NewTopology()
+	topology.Interval = 20 * time.Millisecond
+
+	ctrl := ofp13.NewController()
+	ctrl.RegisterApplication(NewDiscoveryOf(topology))
+
+	// s1.3 <--> s2.1, s2.2 <--> s3.1
+	s1 := ofp13.NewFakeSwitch(1, 1, 2, 3)
+	defer s1.Close()
+
+	s2 := ofp13.NewFakeSwitch(2, 1, 2, 3)
+	defer s2.Close()
+
+	s3 := ofp13.NewFakeSwitch(3, 1, 2)
+	defer s3.Close()
+
+	ofp13.FakeLink(s1, 3, s2, 1)
+	ofp13.FakeLink(s2, 2, s3, 1)
+
+	for _, sw := range []*ofp13.FakeSwitch{s1, s2, s3} {
+		if err := sw.Connect(ctrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := waitLinks(t, topology, 4)
+
+	dpid := func(sw *ofp13.FakeSwitch) string {
+		return sw.DPID().String()
+	}
+
+	expected := []Link{
+		{dpid(s1), 3, dpid(s2), 1},
+		{dpid(s2), 1, dpid(s1), 3},
+		{dpid(s2), 2, dpid(s3), 1},
+		{dpid(s3), 1, dpid(s2), 2},
+	}
+
+	if !reflect.DeepEqual(links, expected) {
+		t.Fatal("Expected links", expected, "obtained:", links)
+	}
+
+	if switches := topology.Switches(); len(switches) != 3 {
+		t.Fatal("Expected 3 switches, obtained:", switches)
+	}
+
+	s2.DeletePort(2)
+	waitLinks(t, topology, 2)
+
+	s1.Close()
+	waitLinks(t, topology, 0)
+
+	if switches := topology.Switches(); !reflect.DeepEqual(switches, []string{dpid(s2), dpid(s3)}) {
+		t.Fatal("Expected switches s2 and s3, obtained:", switches)
+	}
+}
+
+// settle syncs switches, until packets sent over their links are handled
+func settle(t *testing.T, switches ...*ofp13.FakeSwitch) {
+	for i := 0; i < len(switches); i++ {
+		for _, sw := range switches {
+			if err := sw.Sync(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// received returns packets transmitted to the port except LLDP of discovery
+func received(sw *ofp13.FakeSwitch, port uint32) [][]byte {
+	result := make([][]byte, 0)
+	for _, data := range sw.Transmitted(port) {
+		if eth, err := parseFrame(data); err == nil && !eth.discovery() {
+			result = append(result, data)
+		}
+	}
+
+	return result
+}
+
+func TestL2PathForwarder13(t *testing.T) {
+	topology := NewTopology()
+	topology.Interval = 20 * time.Millisecond
+
+	ctrl := ofp13.NewController()
+	ctrl.RegisterApplication(NewDiscoveryOf(topology))
+	ctrl.RegisterApplication(NewL2PathForwarderOf(topology))
+
+	// loop s1.2 <--> s2.1, s2.2 <--> s3.1, s3.2 <--> s1.3, hosts are on
+	// s1.1 and s3.3
+	s1 := ofp13.NewFakeSwitch(1, 1, 2, 3)
+	defer s1.Close()
+
+	s2 := ofp13.NewFakeSwitch(2, 1, 2, 3)
+	defer s2.Close()
+
+	s3 := ofp13.NewFakeSwitch(3, 1, 2, 3)
+	defer s3.Close()
+
+	ofp13.FakeLink(s1, 2, s2, 1)
+	ofp13.FakeLink(s2, 2, s3, 1)
+	ofp13.FakeLink(s3, 2, s1, 3)
+
+	for _, sw := range []*ofp13.FakeSwitch{s1, s2, s3} {
+		if err := sw.Connect(ctrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitLinks(t, topology, 6)
+
+	check := func(sw *ofp13.FakeSwitch, port uint32, n int) {
+		if out := received(sw, port); len(out) != n {
+			t.Fatalf("Expected %d packets on %s port %d, obtained %d", n, sw.DPID(), port, len(out))
+		}
+	}
+
+	// broadcast reaches edge ports once and doesn't loop
+	s1.Inject(1, arpFrame(arpRequest, broadcast, mac1, "10.0.0.1", "10.0.0.2"))
+	settle(t, s1, s2, s3)
+
+	check(s1, 1, 0)
+	check(s2, 3, 1)
+	check(s3, 3, 1)
+
+	// reply goes along the shortest path s3.2 -> s1.3
+	s3.Inject(3, arpFrame(arpReply, mac1, mac2, "10.0.0.2", "10.0.0.1"))
+	settle(t, s3, s1, s2)
+
+	check(s1, 1, 1)
+	check(s2, 3, 0)
+
+	flow := func(sw *ofp13.FakeSwitch, mac net.HardwareAddr) *ofp13.FakeFlow {
+		for _, f := range sw.Flows(0) {
+			if f.Cookie == L2PathCookie && f.Match.EthDst.String() == mac.String() {
+				return &f
+			}
+		}
+
+		return nil
+	}
+
+	if f := flow(s3, mac1); f == nil || !outputs(f, 2) {
+		t.Fatal("Expected flow to", mac1, "on s3 port 2, obtained:", f)
+	}
+
+	if f := flow(s1, mac1); f == nil || !outputs(f, 1) {
+		t.Fatal("Expected flow to", mac1, "on s1 port 1, obtained:", f)
+	}
+
+	if f := flow(s2, mac1); f != nil {
+		t.Fatal("Expected no flow on s2 off the path, obtained:", f)
+	}
+
+	// the first packet to h2 installs the path, the second one is
+	// forwarded by flows
+	s1.Inject(1, ipv4Frame(mac2, mac1, "10.0.0.1", "10.0.0.2"))
+	settle(t, s1, s3, s2)
+	s1.Inject(1, ipv4Frame(mac2, mac1, "10.0.0.1", "10.0.0.2"))
+	settle(t, s1, s3, s2)
+
+	check(s3, 3, 2)
+	check(s2, 3, 0)
+
+	if f := flow(s1, mac2); f == nil || !outputs(f, 3) || f.PacketCount != 1 {
+		t.Fatal("Expected flow to", mac2, "on s1 port 3 matched once, obtained:", f)
+	}
+
+	// the direct link is down, the path goes around through s2
+	s1.DeletePort(3)
+	waitLinks(t, topology, 4)
+	settle(t, s1, s2, s3)
+
+	if f := flow(s1, mac2); f != nil {
+		t.Fatal("Expected paths deleted, obtained:", f)
+	}
+
+	s1.Inject(1, ipv4Frame(mac2, mac1, "10.0.0.1", "10.0.0.2"))
+	settle(t, s1, s2, s3)
+
+	check(s3, 3, 1)
+
+	if f := flow(s2, mac2); f == nil || !outputs(f, 2) {
+		t.Fatal("Expected flow to", mac2, "on s2 port 2, obtained:", f)
+	}
+}
+
+// outputs tells, that the flow outputs to the port
+func outputs(f *ofp13.FakeFlow, port uint32) bool {
+	for _, i := range f.Instructions {
+		if ia, ok := i.(*ofp13.InstructionActions); ok {
+			for _, a := range ia.Actions {
+				if o, ok := a.(*ofp13.ActionOutput); ok && o.Port == port {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// call sends request to handler and checks the status, JSON of reply is
+// decoded into value
+func call(t *testing.T, handler http.Handler, method, path, body string, status int, value interface{}) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+	if w.Code != status {
+		t.Fatalf("Expected status %d of %s %s, obtained %d: %s", status, method, path, w.Code, w.Body)
+	}
+
+	if value != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	ctrl := ofp13.NewController()
+	ctrl.RegisterApplication(NewL2Forwarder13)
+
+	sw := ofp13.NewFakeSwitch(9, 1, 2, 3)
+	defer sw.Close()
+
+	if err := sw.Connect(ctrl); err != nil {
+		t.Fatal(err)
+	}
+
+	api := NewAPI(ctrl).Router()
+	dpid := sw.DPID().String()
+	flows := "/v1/switches/" + dpid + "/flows"
+
+	var switches []SwitchEntry
+	call(t, api, "GET", "/v1/switches", "", http.StatusOK, &switches)
+
+	if len(switches) != 1 || switches[0].Dpid != dpid || len(switches[0].Ports) != 3 || !switches[0].Ports[0].Up {
+		t.Fatal("Expected switch with 3 ports, obtained:", switches)
+	}
+
+	call(t, api, "GET", "/v1/switches/00:00:00:00:00:00:00:02", "", http.StatusNotFound, nil)
+	call(t, api, "GET", "/v1/switches/s1/flows", "", http.StatusBadRequest, nil)
+
+	flow := `{"Priority": 100, "Match": {"InPort": 1, "EthType": 2048, "IPv4Dst": "10.0.0.2"},
+		"Actions": [{"Type": "SET_FIELD", "Field": {"EthDst": "00:00:00:00:02:02"}}, {"Type": "OUTPUT", "Port": 2}]}`
+
+	for _, body := range []string{
+		`{"Priority": 100, "Match": {"InPort": 1}, "Unknown": 1}`,
+		`{"Priority": 100, "Match": {"IPv4Dst": "10.0.0.2"}}`,
+		`{"Priority": 100, "Match": {"EthDst": "00:00:00:00:02"}}`,
+		`{"Priority": 100, "Actions": [{"Type": "OUTPUT"}]}`,
+		`{"Priority": 100, "Actions": [{"Type": "OUTPUT", "Port": 2, "GroupId": 1}]}`,
+		`{"TableId": 1, "GotoTable": 1}`,
+		`{"Priority": -1}`,
+		flow + `{}`,
+	} {
+		call(t, api, "POST", flows, body, http.StatusBadRequest, nil)
+	}
+
+	// the fake switch has 8 tables
+	call(t, api, "POST", flows, `{"TableId": 8}`, http.StatusBadRequest, nil)
+
+	call(t, api, "POST", flows, flow, http.StatusCreated, nil)
+	call(t, api, "PUT", flows, strings.Replace(flow, `"Port": 2`, `"Port": 3`, 1), http.StatusOK, nil)
+
+	inject(t, sw, 1, ipv4Frame(mac1, mac2, "10.0.0.1", "10.0.0.2"))
+	expect(t, sw, map[uint32]int{1: 0, 2: 0, 3: 1})
+
+	// source of port 2 is learned by L2Forwarder13
+	inject(t, sw, 2, ethFrame(mac1, mac2, ethTypeARP, nil))
+
+	var stats []FlowStatsEntry
+	call(t, api, "GET", flows+"?table=0", "", http.StatusOK, &stats)
+
+	found := false
+	for _, f := range stats {
+		if f.Priority == 100 {
+			found = true
+
+			if f.PacketCount != 1 || f.Match.IPv4Dst != "10.0.0.2" || len(f.Actions) != 2 || f.Actions[1].Port != 3 ||
+				f.Actions[0].Field == nil || f.Actions[0].Field.EthDst != mac2.String() {
+				t.Fatal("Wrong modified flow:", f)
+			}
+		}
+	}
+
+	if !found {
+		t.Fatal("Expected added flow, obtained:", stats)
+	}
+
+	var ports []ofp13.PortStats
+	call(t, api, "GET", "/v1/switches/"+dpid+"/stats/ports?port=3", "", http.StatusOK, &ports)
+
+	// the packet of port 2 is flooded to port 3 too
+	if len(ports) != 1 || ports[0].PortNo != 3 || ports[0].TxPackets != 2 {
+		t.Fatal("Expected port 3 transmitted 2 packets, obtained:", ports)
+	}
+
+	call(t, api, "GET", "/v1/switches/"+dpid+"/stats/ports?port=7", "", http.StatusNotFound, nil)
+
+	var tables []ofp13.TableStats
+	call(t, api, "GET", "/v1/switches/"+dpid+"/stats/tables", "", http.StatusOK, &tables)
+
+	if len(tables) != ofp13.FakeTables || tables[0].LookupCount != 2 || tables[0].MatchedCount != 2 {
+		t.Fatal("Expected 2 lookups in table 0, obtained:", tables)
+	}
+
+	var hosts []LearnedHost
+	call(t, api, "GET", "/v1/switches/"+dpid+"/hosts", "", http.StatusOK, &hosts)
+
+	if !reflect.DeepEqual(hosts, []LearnedHost{{Dpid: dpid, Mac: mac2.String(), Port: 2}}) {
+		t.Fatal("Expected", mac2, "learned on port 2, obtained:", hosts)
+	}
+
+	call(t, api, "GET", "/v1/hosts", "", http.StatusOK, &hosts)
+
+	if len(hosts) == 0 {
+		t.Fatal("Expected hosts of all switches")
+	}
+
+	call(t, api, "DELETE", flows, flow, http.StatusNoContent, nil)
+
+	if f := sw.Flows(0); len(f) != 2 {
+		t.Fatal("Expected flows of L2Forwarder13 left, obtained:", f)
+	}
+
+	call(t, api, "DELETE", flows, "", http.StatusNoContent, nil)
+
+	if len(sw.Flows(0)) != 0 || len(sw.Flows(1)) != 0 {
+		t.Fatal("Expected all flows deleted")
+	}
+
+	sw.Close()
+
+	for deadline := time.Now().Add(5 * time.Second); len(ctrl.Switches()) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected switch disconnected")
+		}
+	}
+
+	call(t, api, "GET", flows, "", http.StatusNotFound, nil)
+}
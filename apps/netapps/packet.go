@@ -0,0 +1,106 @@
+package netapps
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Minimal ethernet, ARP and IPv4 decoding for OpenFlow 1.3 netapps, which
+// get raw packet data in PacketIn
+const (
+	ethTypeIPv4 = 0x0800
+	ethTypeARP  = 0x0806
+	ethTypeLLDP = 0x88cc
+	ethTypeBDDP = 0xa0f1
+
+	arpRequest = 1
+	arpReply   = 2
+
+	ethHeaderLen = 14
+	arpLen       = 28
+	ipv4MinLen   = 20
+)
+
+var broadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+type frame struct {
+	dst     net.HardwareAddr
+	src     net.HardwareAddr
+	ethType uint16
+	payload []byte
+}
+
+func parseFrame(b []byte) (*frame, error) {
+	if len(b) < ethHeaderLen {
+		return nil, errors.New(fmt.Sprintf("Ethernet frame is too short: %d bytes", len(b)))
+	}
+
+	return &frame{
+		dst:     net.HardwareAddr(b[0:6]),
+		src:     net.HardwareAddr(b[6:12]),
+		ethType: binary.BigEndian.Uint16(b[12:]),
+		payload: b[ethHeaderLen:],
+	}, nil
+}
+
+// discovery tells, that frame is link discovery one, forwarders ignore them
+func (this *frame) discovery() bool {
+	return this.ethType == ethTypeLLDP || this.ethType == ethTypeBDDP
+}
+
+func (this *frame) marshal() []byte {
+	b := make([]byte, ethHeaderLen, ethHeaderLen+len(this.payload))
+	copy(b, this.dst)
+	copy(b[6:], this.src)
+	binary.BigEndian.PutUint16(b[12:], this.ethType)
+
+	return append(b, this.payload...)
+}
+
+type arpPacket struct {
+	op    uint16
+	hwSrc net.HardwareAddr
+	ipSrc net.IP
+	hwDst net.HardwareAddr
+	ipDst net.IP
+}
+
+// parseARP decodes ethernet/IPv4 ARP
+func parseARP(b []byte) (*arpPacket, error) {
+	if len(b) < arpLen || b[4] != 6 || b[5] != 4 {
+		return nil, errors.New("Wrong ARP packet")
+	}
+
+	return &arpPacket{
+		op:    binary.BigEndian.Uint16(b[6:]),
+		hwSrc: net.HardwareAddr(b[8:14]),
+		ipSrc: net.IP(b[14:18]),
+		hwDst: net.HardwareAddr(b[18:24]),
+		ipDst: net.IP(b[24:28]),
+	}, nil
+}
+
+func (this *arpPacket) marshal() []byte {
+	b := make([]byte, arpLen)
+	binary.BigEndian.PutUint16(b, 1)
+	binary.BigEndian.PutUint16(b[2:], ethTypeIPv4)
+	b[4], b[5] = 6, 4
+	binary.BigEndian.PutUint16(b[6:], this.op)
+	copy(b[8:], this.hwSrc)
+	copy(b[14:], this.ipSrc.To4())
+	copy(b[18:], this.hwDst)
+	copy(b[24:], this.ipDst.To4())
+
+	return b
+}
+
+// parseIPv4 returns source and destination of IPv4 packet
+func parseIPv4(b []byte) (net.IP, net.IP, error) {
+	if len(b) < ipv4MinLen || b[0]>>4 != 4 {
+		return nil, nil, errors.New("Wrong IPv4 packet")
+	}
+
+	return net.IP(b[12:16]), net.IP(b[16:20]), nil
+}
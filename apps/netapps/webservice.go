@@ -20,13 +20,29 @@ func Switches(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 
-	fmt.Fprint(w, "%s\n", string(b))
+	fmt.Fprintf(w, "%s\n", string(b))
 }
 
+// TopologyGraph returns switches and links discovered by NewDiscovery
+func TopologyGraph(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	graph := struct {
+		Switches []string
+		Links    []Link
+	}{DefaultTopology.Switches(), DefaultTopology.Links()}
+
+	b, err := json.MarshalIndent(graph, "", "      ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "%s\n", string(b))
+}
+
+// AddFlow sends flow mods of the body to OpenFlow 1.0 switch
 func AddFlow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	decoder := json.NewDecoder(r.Body)
 
-	// var mods FlowMods
 	type payload struct {
 		FlowMods FlowMods
 	}
@@ -42,44 +58,25 @@ func AddFlow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	dpid, err := net.ParseMAC(ps.ByName("dpid"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if sw, ok := ogo.Switch(dpid); ok {
-		for _, mod := range p.FlowMods {
-			fmt.Println("mod")
-			fmt.Println(mod)
-			sw.Send(mod)
-		}
-	}
-}
-
-func TestMod(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	f1 := ofp10.NewFlowMod()
-
-	f1.Match.DLSrc, _ = net.ParseMAC("22:11:11:22:22:22")
-	f1.Match.DLDst, _ = net.ParseMAC("55:44:55:66:77:88")
-	f1.Match.InPort = 5
-	// f1.Flags = ofp10.FC_ADD
-	// f1.Match.Wildcards = ofp10.FW_ALL ^ ofp10.FW_DL_SRC ^ ofp10.FW_DL_DST
-
-	f1.AddAction(ofp10.NewActionOutput(1))
-
-	dpid, err := net.ParseMAC(ps.ByName("dpid"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	sw, ok := ogo.Switch(dpid)
+	if !ok {
+		http.Error(w, fmt.Sprintf("switch %s isn't connected", dpid), http.StatusNotFound)
+		return
 	}
 
-	if sw, ok := ogo.Switch(dpid); ok {
-		sw.Send(f1)
+	for _, mod := range p.FlowMods {
+		sw.Send(mod)
 	}
-
 }
 
 func NewWebService(apiOn string) {
 	router := httprouter.New()
 	router.GET("/switches", Switches)
 	router.POST("/switches/:dpid/flows", AddFlow)
-	router.GET("/test/:dpid", TestMod)
+	router.GET("/topology", TopologyGraph)
 
 	log.Fatal(http.ListenAndServe(apiOn, router))
 }
@@ -0,0 +1,642 @@
+package netapps
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/NodePrime/open-mininet/ofp13"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MaxRequestBody is the largest body of API request in bytes
+var MaxRequestBody int64 = 1 << 20
+
+// Action types of API flows
+const (
+	ActionOutput   = "OUTPUT"
+	ActionGroup    = "GROUP"
+	ActionSetField = "SET_FIELD"
+)
+
+// MatchEntry is match of API flows, zero fields are wildcards, addresses
+// are strings
+type MatchEntry struct {
+	InPort  uint32 `json:",omitempty"`
+	EthDst  string `json:",omitempty"`
+	EthSrc  string `json:",omitempty"`
+	EthType uint16 `json:",omitempty"`
+	IPProto uint8  `json:",omitempty"`
+	IPv4Src string `json:",omitempty"`
+	IPv4Dst string `json:",omitempty"`
+}
+
+// ActionEntry is OUTPUT to Port, GROUP of GroupId or SET_FIELD of Field
+type ActionEntry struct {
+	Type    string
+	Port    uint32      `json:",omitempty"`
+	GroupId uint32      `json:",omitempty"`
+	Field   *MatchEntry `json:",omitempty"`
+}
+
+// FlowEntry is a flow of API. Instructions are executed in the order of
+// OpenFlow: Meter, Actions applied, ClearActions, WriteActions, GotoTable.
+type FlowEntry struct {
+	TableId      uint8
+	Priority     uint16
+	IdleTimeout  uint16 `json:",omitempty"`
+	HardTimeout  uint16 `json:",omitempty"`
+	Cookie       uint64 `json:",omitempty"`
+	Match        MatchEntry
+	Meter        uint32        `json:",omitempty"`
+	Actions      []ActionEntry `json:",omitempty"`
+	ClearActions bool          `json:",omitempty"`
+	WriteActions []ActionEntry `json:",omitempty"`
+	GotoTable    uint8         `json:",omitempty"`
+}
+
+// FlowStatsEntry is a flow of the switch with its counters
+type FlowStatsEntry struct {
+	FlowEntry
+	DurationSec uint32
+	PacketCount uint64
+	ByteCount   uint64
+}
+
+// PortEntry is a port of the switch
+type PortEntry struct {
+	PortNo    uint32
+	Name      string
+	HWAddr    string
+	Up        bool
+	CurrSpeed uint32
+	MaxSpeed  uint32
+}
+
+// SwitchEntry is a connected switch
+type SwitchEntry struct {
+	Dpid    string
+	Tables  uint8
+	Buffers uint32
+	Ports   []PortEntry
+}
+
+func (this MatchEntry) match() (ofp13.Match, error) {
+	var result ofp13.Match
+	var err error
+
+	result.InPort, result.EthType, result.IPProto = this.InPort, this.EthType, this.IPProto
+
+	for _, f := range []struct {
+		value string
+		addr  *net.HardwareAddr
+	}{{this.EthDst, &result.EthDst}, {this.EthSrc, &result.EthSrc}} {
+		if f.value == "" {
+			continue
+		}
+
+		if *f.addr, err = net.ParseMAC(f.value); err != nil || len(*f.addr) != 6 {
+			return result, errors.New(fmt.Sprintf("Wrong ethernet address %q", f.value))
+		}
+	}
+
+	for _, f := range []struct {
+		value string
+		ip    *net.IP
+	}{{this.IPv4Src, &result.IPv4Src}, {this.IPv4Dst, &result.IPv4Dst}} {
+		if f.value == "" {
+			continue
+		}
+
+		if *f.ip = net.ParseIP(f.value).To4(); *f.ip == nil {
+			return result, errors.New(fmt.Sprintf("Wrong IPv4 address %q", f.value))
+		}
+	}
+
+	return result, result.Validate()
+}
+
+func matchEntry(m ofp13.Match) MatchEntry {
+	result := MatchEntry{InPort: m.InPort, EthType: m.EthType, IPProto: m.IPProto}
+
+	if m.EthDst != nil {
+		result.EthDst = m.EthDst.String()
+	}
+
+	if m.EthSrc != nil {
+		result.EthSrc = m.EthSrc.String()
+	}
+
+	if m.IPv4Src != nil {
+		result.IPv4Src = m.IPv4Src.String()
+	}
+
+	if m.IPv4Dst != nil {
+		result.IPv4Dst = m.IPv4Dst.String()
+	}
+
+	return result
+}
+
+func actions(entries []ActionEntry) ([]ofp13.Action, error) {
+	result := make([]ofp13.Action, 0, len(entries))
+
+	for i, a := range entries {
+		switch {
+		case a.Type == ActionOutput && a.Port != 0 && a.GroupId == 0 && a.Field == nil:
+			result = append(result, ofp13.NewActionOutput(a.Port))
+		case a.Type == ActionGroup && a.Port == 0 && a.GroupId != 0 && a.Field == nil:
+			result = append(result, ofp13.NewActionGroup(a.GroupId))
+		case a.Type == ActionSetField && a.Port == 0 && a.GroupId == 0 && a.Field != nil:
+			field, err := a.Field.match()
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Action %d: %v", i, err))
+			}
+
+			if field.Equal(ofp13.Match{}) || field.InPort != 0 {
+				return nil, errors.New(fmt.Sprintf("Action %d: Field sets nothing or InPort", i))
+			}
+
+			result = append(result, ofp13.NewActionSetField(field))
+		default:
+			return nil, errors.New(fmt.Sprintf("Action %d: expected OUTPUT with Port, GROUP with GroupId or SET_FIELD with Field", i))
+		}
+	}
+
+	return result, nil
+}
+
+func actionEntries(list []ofp13.Action) []ActionEntry {
+	result := make([]ActionEntry, 0, len(list))
+
+	for _, a := range list {
+		switch action := a.(type) {
+		case *ofp13.ActionOutput:
+			result = append(result, ActionEntry{Type: ActionOutput, Port: action.Port})
+		case *ofp13.ActionGroup:
+			result = append(result, ActionEntry{Type: ActionGroup, GroupId: action.GroupId})
+		case *ofp13.ActionSetField:
+			field := matchEntry(action.Field)
+			result = append(result, ActionEntry{Type: ActionSetField, Field: &field})
+		case *ofp13.ActionRaw:
+			result = append(result, ActionEntry{Type: fmt.Sprintf("RAW_%d", action.Type)})
+		}
+	}
+
+	return result
+}
+
+// flowMod validates the flow and returns flow mod of the command
+func (this FlowEntry) flowMod(command uint8) (*ofp13.FlowMod, error) {
+	result := ofp13.NewFlowMod()
+	result.Command = command
+	result.TableId = this.TableId
+	result.Priority = this.Priority
+	result.IdleTimeout = this.IdleTimeout
+	result.HardTimeout = this.HardTimeout
+	result.Cookie = this.Cookie
+
+	if this.TableId > ofp13.TT_MAX {
+		return nil, errors.New(fmt.Sprintf("Wrong TableId %d", this.TableId))
+	}
+
+	var err error
+	if result.Match, err = this.Match.match(); err != nil {
+		return nil, err
+	}
+
+	if this.Meter != 0 {
+		result.AddInstruction(ofp13.NewInstructionMeter(this.Meter))
+	}
+
+	if len(this.Actions) > 0 {
+		list, err := actions(this.Actions)
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddInstruction(ofp13.NewInstructionApplyActions(list...))
+	}
+
+	if this.ClearActions {
+		result.AddInstruction(ofp13.NewInstructionClearActions())
+	}
+
+	if len(this.WriteActions) > 0 {
+		list, err := actions(this.WriteActions)
+		if err != nil {
+			return nil, err
+		}
+
+		result.AddInstruction(ofp13.NewInstructionWriteActions(list...))
+	}
+
+	if this.GotoTable != 0 {
+		if this.GotoTable <= this.TableId || this.GotoTable > ofp13.TT_MAX {
+			return nil, errors.New(fmt.Sprintf("GotoTable %d isn't after table %d", this.GotoTable, this.TableId))
+		}
+
+		result.AddInstruction(ofp13.NewInstructionGotoTable(this.GotoTable))
+	}
+
+	return result, nil
+}
+
+func flowStatsEntry(f ofp13.FlowStats) FlowStatsEntry {
+	result := FlowStatsEntry{
+		FlowEntry: FlowEntry{
+			TableId:     f.TableId,
+			Priority:    f.Priority,
+			IdleTimeout: f.IdleTimeout,
+			HardTimeout: f.HardTimeout,
+			Cookie:      f.Cookie,
+			Match:       matchEntry(f.Match),
+		},
+		DurationSec: f.DurationSec,
+		PacketCount: f.PacketCount,
+		ByteCount:   f.ByteCount,
+	}
+
+	for _, i := range f.Instructions {
+		switch ins := i.(type) {
+		case *ofp13.InstructionMeter:
+			result.Meter = ins.MeterId
+		case *ofp13.InstructionGotoTable:
+			result.GotoTable = ins.TableId
+		case *ofp13.InstructionActions:
+			switch ins.Type {
+			case ofp13.IT_APPLY_ACTIONS:
+				result.Actions = actionEntries(ins.Actions)
+			case ofp13.IT_WRITE_ACTIONS:
+				result.WriteActions = actionEntries(ins.Actions)
+			case ofp13.IT_CLEAR_ACTIONS:
+				result.ClearActions = true
+			}
+		}
+	}
+
+	return result
+}
+
+func switchEntry(sw *ofp13.Switch) SwitchEntry {
+	features := sw.Features()
+
+	result := SwitchEntry{
+		Dpid:    sw.DPID().String(),
+		Tables:  features.Tables,
+		Buffers: features.Buffers,
+		Ports:   make([]PortEntry, 0),
+	}
+
+	for _, p := range sw.Ports() {
+		result.Ports = append(result.Ports, PortEntry{
+			PortNo:    p.PortNo,
+			Name:      p.Name,
+			HWAddr:    p.HWAddr.String(),
+			Up:        p.Up(),
+			CurrSpeed: p.CurrSpeed,
+			MaxSpeed:  p.MaxSpeed,
+		})
+	}
+
+	return result
+}
+
+// API is REST API of OpenFlow 1.3 controller, routes are prefixed by
+// version, e.g. /v1/switches
+type API struct {
+	controller *ofp13.Controller
+	hosts      *hostmap
+	topology   *Topology
+}
+
+// NewAPI returns API of the controller, hosts and topology are learned by
+// forwarders and discovery by default
+func NewAPI(ctrl *ofp13.Controller) *API {
+	return &API{controller: ctrl, hosts: DefaultHosts, topology: DefaultTopology}
+}
+
+func (this *API) Router() *httprouter.Router {
+	router := httprouter.New()
+	router.GET("/v1/switches", this.Switches)
+	router.GET("/v1/switches/:dpid", this.Switch)
+	router.GET("/v1/switches/:dpid/flows", this.Flows)
+	router.POST("/v1/switches/:dpid/flows", this.AddFlow)
+	router.PUT("/v1/switches/:dpid/flows", this.ModifyFlow)
+	router.DELETE("/v1/switches/:dpid/flows", this.DeleteFlows)
+	router.GET("/v1/switches/:dpid/stats/ports", this.PortStats)
+	router.GET("/v1/switches/:dpid/stats/tables", this.TableStats)
+	router.GET("/v1/switches/:dpid/hosts", this.Hosts)
+	router.GET("/v1/hosts", this.Hosts)
+	router.GET("/v1/topology", this.Topology)
+
+	return router
+}
+
+// NewWebService13 serves API of OpenFlow 1.3 controller and the topology
+func NewWebService13(apiOn string, ctrl *ofp13.Controller) {
+	router := NewAPI(ctrl).Router()
+	router.GET("/topology", TopologyGraph)
+
+	log.Fatal(http.ListenAndServe(apiOn, router))
+}
+
+// reply writes value as JSON with the status
+func reply(w http.ResponseWriter, status int, value interface{}) {
+	b, err := json.MarshalIndent(value, "", "      ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s\n", string(b))
+}
+
+func replyError(w http.ResponseWriter, status int, err error) {
+	reply(w, status, struct{ Error string }{err.Error()})
+}
+
+// replySwitchError tells, why the switch didn't do the request
+func replySwitchError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case *ofp13.Error:
+		replyError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("Switch rejected request: %v", err)))
+	default:
+		if err == ofp13.ErrDisconnected {
+			replyError(w, http.StatusNotFound, err)
+			return
+		}
+
+		replyError(w, http.StatusBadGateway, err)
+	}
+}
+
+// connected returns switch of dpid parameter, or replies, that it isn't
+// connected
+func (this *API) connected(w http.ResponseWriter, ps httprouter.Params) (*ofp13.Switch, bool) {
+	dpid, err := net.ParseMAC(ps.ByName("dpid"))
+	if err != nil || len(dpid) != 8 {
+		replyError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("Wrong dpid %q, expected e.g. 00:00:00:00:00:00:00:01", ps.ByName("dpid"))))
+		return nil, false
+	}
+
+	sw, found := this.controller.Switch(dpid)
+	if !found {
+		replyError(w, http.StatusNotFound, errors.New(fmt.Sprintf("Switch %s isn't connected", dpid)))
+		return nil, false
+	}
+
+	return sw, true
+}
+
+// decode reads exactly one JSON value of the body, unknown fields are
+// errors
+func decode(r *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, MaxRequestBody))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(value); err != nil {
+		return errors.New(fmt.Sprintf("Can't decode body: %v", err))
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("Body has data after JSON value")
+	}
+
+	return nil
+}
+
+func (this *API) Switches(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	result := make([]SwitchEntry, 0)
+	for _, sw := range this.controller.Switches() {
+		result = append(result, switchEntry(sw))
+	}
+
+	reply(w, http.StatusOK, result)
+}
+
+func (this *API) Switch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if sw, ok := this.connected(w, ps); ok {
+		reply(w, http.StatusOK, switchEntry(sw))
+	}
+}
+
+// Flows returns flows of all tables or of ?table=N with their counters
+func (this *API) Flows(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sw, ok := this.connected(w, ps)
+	if !ok {
+		return
+	}
+
+	table := uint8(ofp13.TT_ALL)
+	if s := r.URL.Query().Get("table"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 8)
+		if err != nil || n > ofp13.TT_MAX {
+			replyError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("Wrong table %q", s)))
+			return
+		}
+
+		table = uint8(n)
+	}
+
+	parts, err := sw.Request(ofp13.NewFlowStatsRequest(table, ofp13.Match{}))
+	if err != nil {
+		replySwitchError(w, err)
+		return
+	}
+
+	result := make([]FlowStatsEntry, 0)
+	for _, part := range parts {
+		flows, err := part.(*ofp13.MultipartReply).FlowStats()
+		if err != nil {
+			replySwitchError(w, err)
+			return
+		}
+
+		for _, f := range flows {
+			result = append(result, flowStatsEntry(f))
+		}
+	}
+
+	reply(w, http.StatusOK, result)
+}
+
+// apply sends flow of the body with the command
+func (this *API) apply(w http.ResponseWriter, r *http.Request, ps httprouter.Params, command uint8, status int) {
+	sw, ok := this.connected(w, ps)
+	if !ok {
+		return
+	}
+
+	var flow FlowEntry
+	if err := decode(r, &flow); err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	mod, err := flow.flowMod(command)
+	if err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := sw.Apply(mod); err != nil {
+		replySwitchError(w, err)
+		return
+	}
+
+	reply(w, status, flow)
+}
+
+// AddFlow adds flow of the body, flow of the same table, priority and match
+// is replaced
+func (this *API) AddFlow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	this.apply(w, r, ps, ofp13.FC_ADD, http.StatusCreated)
+}
+
+// ModifyFlow changes instructions of the flow of the same table, priority
+// and match
+func (this *API) ModifyFlow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	this.apply(w, r, ps, ofp13.FC_MODIFY_STRICT, http.StatusOK)
+}
+
+// DeleteFlows deletes the flow of the same table, priority and match as
+// the body, or all flows, if body is empty
+func (this *API) DeleteFlows(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sw, ok := this.connected(w, ps)
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxRequestBody))
+	if err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	mod := ofp13.NewFlowMod()
+	mod.Command = ofp13.FC_DELETE
+	mod.TableId = ofp13.TT_ALL
+
+	if len(bytes.TrimSpace(body)) > 0 {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var flow FlowEntry
+		if err := decode(r, &flow); err != nil {
+			replyError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if mod, err = flow.flowMod(ofp13.FC_DELETE_STRICT); err != nil {
+			replyError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := sw.Apply(mod); err != nil {
+		replySwitchError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PortStats returns counters of all ports or of ?port=N
+func (this *API) PortStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sw, ok := this.connected(w, ps)
+	if !ok {
+		return
+	}
+
+	port := uint32(ofp13.P_ANY)
+	if s := r.URL.Query().Get("port"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			replyError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("Wrong port %q", s)))
+			return
+		}
+
+		if _, found := sw.Port(uint32(n)); !found {
+			replyError(w, http.StatusNotFound, errors.New(fmt.Sprintf("Switch %s has no port %d", sw, n)))
+			return
+		}
+
+		port = uint32(n)
+	}
+
+	parts, err := sw.Request(ofp13.NewPortStatsRequest(port))
+	if err != nil {
+		replySwitchError(w, err)
+		return
+	}
+
+	result := make([]ofp13.PortStats, 0)
+	for _, part := range parts {
+		stats, err := part.(*ofp13.MultipartReply).PortStats()
+		if err != nil {
+			replySwitchError(w, err)
+			return
+		}
+
+		result = append(result, stats...)
+	}
+
+	reply(w, http.StatusOK, result)
+}
+
+func (this *API) TableStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sw, ok := this.connected(w, ps)
+	if !ok {
+		return
+	}
+
+	parts, err := sw.Request(ofp13.NewTableStatsRequest())
+	if err != nil {
+		replySwitchError(w, err)
+		return
+	}
+
+	result := make([]ofp13.TableStats, 0)
+	for _, part := range parts {
+		stats, err := part.(*ofp13.MultipartReply).TableStats()
+		if err != nil {
+			replySwitchError(w, err)
+			return
+		}
+
+		result = append(result, stats...)
+	}
+
+	reply(w, http.StatusOK, result)
+}
+
+// Hosts returns hosts learned by all switches or by the switch of dpid
+func (this *API) Hosts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dpid := ""
+	if ps.ByName("dpid") != "" {
+		sw, ok := this.connected(w, ps)
+		if !ok {
+			return
+		}
+
+		dpid = sw.DPID().String()
+	}
+
+	reply(w, http.StatusOK, this.hosts.Hosts(dpid))
+}
+
+func (this *API) Topology(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	reply(w, http.StatusOK, struct {
+		Switches []string
+		Links    []Link
+	}{this.topology.Switches(), this.topology.Links()})
+}
@@ -3,7 +3,6 @@ package mn
 import (
 	"encoding/json"
 	"log"
-	"strings"
 
 	"github.com/NodePrime/open-mininet/cgroup"
 )
@@ -51,9 +50,18 @@ func (this *Cgroup) UnmarshalJSON(b []byte) error {
 	}
 
 	this.Name = cg.Name
-	this.Cgroup = cgroup.NewCgroup(cg.Name)
+	this.Controllers = cg.Controllers
+
+	return this.apply()
+}
+
+// apply physically creates cgroup with Controllers and their params
+func (this *Cgroup) apply() error {
+	cgroup.Init()
 
-	if err := this.SetControllers(cg.Controllers); err != nil {
+	this.Cgroup = cgroup.NewCgroup(this.Name)
+
+	if err := this.SetControllers(this.Controllers); err != nil {
 		return err
 	}
 
@@ -61,13 +69,16 @@ func (this *Cgroup) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	if err := this.SetParams(cg.Controllers); err != nil {
-		return err
-	}
+	own(ownedCgroup, this.Name)
 
-	this.Controllers = cg.Controllers
+	return this.SetParams(this.Controllers)
+}
 
-	return nil
+// Exists checks cgroup presence in any of mounted hierarchies
+func (this *Cgroup) Exists() bool {
+	cgroup.Init()
+
+	return cgroup.NewCgroup(this.Name).Get() == nil
 }
 
 func (this *Cgroup) SetControllers(controllers []Controller) error {
@@ -108,22 +119,17 @@ func (this *Cgroup) SetParams(controllers []Controller) error {
 	return nil
 }
 
-func (this *Cgroup) Release() {
-	if this != nil {
-		this.DeleteExt(cgroup.DeleteRecursive)
+// Release deletes cgroup, its processes are moved to the root
+func (this *Cgroup) Release() error {
+	if this == nil || this.Cgroup == nil {
+		return nil
 	}
-}
 
-func (this *Cgroup) CgExecCommand() []string {
-	var groups string
-
-	command := []string{FullPathFor("cgexec"), "-g"}
-
-	for _, controller := range this.Controllers {
-		groups += controller.Name + ","
+	if err := this.DeleteExt(cgroup.DeleteRecursive); err != nil {
+		return err
 	}
 
-	groups = strings.TrimRight(groups, ",") + ":" + this.Name
+	disown(ownedCgroup, this.Name)
 
-	return append(command, groups)
+	return nil
 }
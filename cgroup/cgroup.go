@@ -1,512 +1,543 @@
-// Based on Vincent Batts <vbatts@hashbangbash.com> libcgroup bindings
-// https://github.com/vbatts/go-cgroup
-
+// Package cgroup manages control groups through cgroupfs, without libcgroup
+// and cgexec. Both unified (v2) and legacy (v1) hierarchies are supported,
+// parameters of one version are translated to the other one, so
+// "cfs_quota_us" works on v2 and "cpu.max" works on v1.
 package cgroup
 
-/*
-#include <libcgroup.h>
-#cgo LDFLAGS: -lcgroup
-
-// work around for the 'type' special word
-enum cgroup_file_type type_from_file_info(struct cgroup_file_info fi) {
-  return fi.type;
-}
-*/
-import "C"
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"runtime"
-	"unsafe"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+type Version int
+
+const (
+	V1 Version = 1
+	V2 Version = 2
 )
 
+var (
+	// Root is where cgroupfs is mounted
+	Root = "/sys/fs/cgroup"
+
+	// Parent is a group all groups are created in, relative to Root for
+	// v2, or to every controller hierarchy for v1.
+	Parent = "mn"
+)
+
+var (
+	ErrNotExist = errors.New("cgroup does not exist")
+
+	version   Version
+	versionMu sync.Mutex
+)
+
+// Init detects version of the cgroup hierarchy mounted at Root. Hybrid
+// setups, where v2 is mounted without controllers, are treated as v1.
+func Init() error {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+
+	if _, err := os.Stat(Root); err != nil {
+		return err
+	}
+
+	version = V1
+	if _, err := os.Stat(filepath.Join(Root, "cgroup.controllers")); err == nil {
+		version = V2
+	}
+
+	return nil
+}
+
+// GetVersion returns version detected by Init
+func GetVersion() Version {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+
+	return version
+}
+
 type Cgroup struct {
-	g *C.struct_cgroup
+	name        string
+	controllers []string
 }
 
 func NewCgroup(name string) *Cgroup {
-	cg := &Cgroup{
-		C.cgroup_new_cgroup(C.CString(name)),
+	if GetVersion() == 0 {
+		Init()
 	}
 
-	runtime.SetFinalizer(cg, freeCgroupThings)
-	return cg
+	return &Cgroup{name: name, controllers: make([]string, 0)}
 }
 
+// AddController makes controller enabled for the group. It's applied by
+// Create, values could be set after that.
 func (cg *Cgroup) AddController(name string) (Controller, error) {
-	result := C.cgroup_add_controller(cg.g, C.CString(name))
-	if result == nil {
-		return Controller{}, errors.New(fmt.Sprintf("Unable to add controller %s", name))
+	name = controllerName(name)
+	if name == "" {
+		return Controller{}, errors.New("Unable to add controller with empty name")
+	}
+
+	for _, c := range cg.controllers {
+		if c == name {
+			return Controller{cg, name}, nil
+		}
 	}
 
-	return Controller{result}, nil
+	cg.controllers = append(cg.controllers, name)
+
+	return Controller{cg, name}, nil
 }
 
 func (cg *Cgroup) GetController(name string) Controller {
-	return Controller{
-		C.cgroup_get_controller(cg.g, C.CString(name)),
-	}
+	return Controller{cg, controllerName(name)}
 }
 
-func freeCgroupThings(cg *Cgroup) {
-	freeCgroup(*cg)
-	freeControllers(*cg)
-}
+// Create makes group directory, for v2 the controllers are enabled in
+// subtree_control of every ancestor first.
+func (cg Cgroup) Create() error {
+	if GetVersion() == V2 {
+		if err := cg.enableControllers(); err != nil {
+			return err
+		}
+
+		return mkdir(cg.dir(""))
+	}
+
+	for _, c := range cg.controllers {
+		if err := mkdir(cg.dir(c)); err != nil {
+			return err
+		}
+	}
 
-func freeCgroup(cg Cgroup) {
-	C.cgroup_free(&cg.g)
+	return nil
 }
 
-func freeControllers(cg Cgroup) {
-	C.cgroup_free_controllers(cg.g)
+func (cg Cgroup) enableControllers() error {
+	if len(cg.controllers) == 0 {
+		return nil
+	}
+
+	enable := make([]string, 0)
+	for _, c := range cg.controllers {
+		enable = append(enable, "+"+c)
+	}
+
+	dir := Root
+	for _, part := range strings.Split(filepath.Clean(Parent), "/") {
+		if err := write(filepath.Join(dir, "cgroup.subtree_control"), strings.Join(enable, " ")); err != nil {
+			return err
+		}
+
+		dir = filepath.Join(dir, part)
+		if err := mkdir(dir); err != nil {
+			return err
+		}
+	}
+
+	return write(filepath.Join(dir, "cgroup.subtree_control"), strings.Join(enable, " "))
 }
 
-// Physically create a control group in kernel. The group is created in all
-// hierarchies, which cover controllers added by Cgroup.AddController().
-//
-// TODO correct docs for golang implementation
-//
-// All parameters set by cgroup_add_value_* functions are written.
-// The created groups has owner which was set by cgroup_set_uid_gid() and
-// permissions set by cgroup_set_permissions.
-//
-//   foo = cgroup.NewCgroup("foo)
-//   foo.Create()
-func (cg Cgroup) Create() error {
-	return _err(C.cgroup_create_cgroup(cg.g, C.int(0)))
-}
-
-// Same as Create(), but all errors are ignored when setting
-// owner of the group and/or its tasks file.
-func (cg Cgroup) CreateIgnoreOwnership() error {
-	return _err(C.cgroup_create_cgroup(cg.g, C.int(1)))
-}
-
-// Physically create new control group in kernel, with all parameters and values
-// copied from its parent group. The group is created in all hierarchies, where
-// the parent group exists. I.e. following code creates subgroup in all
-// hierarchies, because all of them have root (=parent) group.
-//
-//   foo = cgroup.NewCgroup("foo)
-//   foo.CreateFromParent()
-func (cg Cgroup) CreateFromParent() error {
-	return _err(C.cgroup_create_cgroup_from_parent(cg.g, C.int(0)))
-}
-
-// Same as CreateFromParent(), but all errors are ignored when setting
-// owner of the group and/or its tasks file.
-func (cg Cgroup) CreateFromParentIgnoreOwnership() error {
-	return _err(C.cgroup_create_cgroup_from_parent(cg.g, C.int(1)))
-}
-
-// Physically modify a control group in kernel. All parameters added by
-// cgroup_add_value_ or cgroup_set_value_ are written.
-// Currently it's not possible to change and owner of a group.
-//
-// TODO correct docs for golang implementation
-func (cg Cgroup) Modify() error {
-	return _err(C.cgroup_modify_cgroup(cg.g))
-}
-
-// Physically remove a control group from kernel. The group is removed from
-// all hierarchies,  which cover controllers added by Cgroup.AddController()
-// or GetCgroup(). All tasks inside the group are automatically moved
-// to parent group.
-//
-// The group being removed must be empty, i.e. without subgroups. Use
-// cgroup_delete_cgroup_ext() for recursive delete.
-//
-// TODO correct docs for golang implementation
-func (cg Cgroup) Delete() error {
-	return _err(C.cgroup_delete_cgroup(cg.g, C.int(0)))
+// Get loads list of controllers of existing group, ErrNotExist is returned
+// if group isn't found in any hierarchy.
+func (cg *Cgroup) Get() error {
+	if GetVersion() == V2 {
+		if _, err := os.Stat(cg.dir("")); err != nil {
+			return ErrNotExist
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(cg.dir(""), "cgroup.controllers"))
+		if err != nil {
+			return err
+		}
+
+		cg.controllers = strings.Fields(string(data))
+
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(Root)
+	if err != nil {
+		return err
+	}
+
+	cg.controllers = make([]string, 0)
+	for _, entry := range entries {
+		if _, err := os.Stat(cg.dir(entry.Name())); err == nil {
+			cg.controllers = append(cg.controllers, entry.Name())
+		}
+	}
+
+	if len(cg.controllers) == 0 {
+		return ErrNotExist
+	}
+
+	return nil
 }
 
-// Same as Delete(), but ignores errors when migrating.
-func (cg Cgroup) DeleteIgnoreMigration() error {
-	return _err(C.cgroup_delete_cgroup(cg.g, C.int(1)))
+// AddProc moves process into the group, its children started afterwards
+// are born there.
+func (cg Cgroup) AddProc(pid int) error {
+	for _, dir := range cg.dirs() {
+		if err := write(filepath.Join(dir, "cgroup.procs"), strconv.Itoa(pid)); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// Physically remove a control group from kernel.
-// All tasks are automatically moved to parent group.
-// If DeleteIgnoreMigration flag is used, the errors that occurred
-// during the task movement are ignored.
-// DeleteRecursive flag specifies that all subgroups should be removed
-// too. If root group is being removed with this flag specified, all subgroups
-// are removed but the root group itself is left undeleted.
-func (cg Cgroup) DeleteExt(flags DeleteFlag) error {
-	return _err(C.cgroup_delete_cgroup_ext(cg.g, C.int(flags)))
-}
-
-// Read all information regarding the group from kernel.
-// Based on name of the group, list of controllers and all parameters and their
-// values are read from all hierarchies, where a group with given name exists.
-// All existing controllers are replaced. I.e. following code will fill root with
-// controllers from all hierarchies, because the root group is available in all of
-// them.
-//
-//   root := cgroup.NewCgroup("/")
-//   err := root.Get()
-func (cg Cgroup) Get() error {
-	return _err(C.cgroup_get_cgroup(cg.g))
-}
-
-type UID C.uid_t
-type GID C.gid_t
-
-// Set owner of the group control files and the @c tasks file. This function
-// modifies only libcgroup internal cgroup structure, use
-// Cgroup.Create() afterwards to create the group with given owners.
-// @param cgroup
-// @param tasks_uid UID of the owner of group's @c tasks file.
-// @param tasks_gid GID of the owner of group's @c tasks file.
-// @param control_uid UID of the owner of group's control files (i.e.
-// parameters).
-// @param control_gid GID of the owner of group's control files (i.e.
-// parameters).
-func (cg Cgroup) SetUidGid(tasks_uid UID, tasks_gid GID,
-	control_uid UID, control_gid GID) error {
-	return _err(C.cgroup_set_uid_gid(cg.g,
-		C.uid_t(tasks_uid), C.gid_t(tasks_gid),
-		C.uid_t(control_uid), C.gid_t(control_gid)))
-
-}
-
-// Return owners of the group's @c tasks file and control files.
-// The data is read from libcgroup internal cgroup structure, use
-// Cgroup.SetUidGid() or Cgroup.Get() to fill it.
-func (cg Cgroup) GetUidGid() (tasks_uid UID, tasks_gid GID, control_uid UID, control_gid GID, err error) {
-	var (
-		c_t_u C.uid_t
-		c_t_g C.gid_t
-		c_c_u C.uid_t
-		c_c_g C.gid_t
-	)
-	err = _err(C.cgroup_set_uid_gid(cg.g,
-		c_t_u,
-		c_t_g,
-		c_c_u,
-		c_c_g))
-	return UID(c_t_u), GID(c_t_g), UID(c_c_u), GID(c_c_g), err
+// Open returns group directory of v2 hierarchy, which could be passed to
+// clone as CgroupFD, so process is born in the group. It's an error for v1.
+func (cg Cgroup) Open() (*os.File, error) {
+	if GetVersion() != V2 {
+		return nil, errors.New("Unable to open cgroup directory, v2 is required")
+	}
 
+	return os.Open(cg.dir(""))
 }
 
-const (
-	// Uninitialized file/directory permissions used for task/control files.
-	NO_PERMS = C.NO_PERMS
+// Do calls f on a locked thread moved to the group, so processes forked
+// by f are born in it, then the thread is moved back. It's for v1, where
+// threads could be moved separately, v2 has Open for that. If the thread
+// can't be moved back, it stays locked and runtime throws it away.
+func (cg Cgroup) Do(f func() error) error {
+	if GetVersion() != V1 {
+		return errors.New("Unable to move thread to cgroup, v1 is required")
+	}
 
-	// Uninitialized UID/GID used for task/control files.
-	NO_UID_GID = C.NO_UID_GID
-)
+	runtime.LockOSThread()
 
-type Mode C.mode_t
+	tid := strconv.Itoa(syscall.Gettid())
 
-/*
-Stores given file permissions of the group's control and tasks files
-into the cgroup data structure. Use NO_PERMS if permissions shouldn't
-be changed or a value which applicable to chmod(2). Please note that
-the given permissions are masked with the file owner's permissions.
-For example if a control file has permissions 640 and control_fperm is
-471 the result will be 460.
+	origin, err := threadGroups(tid)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
 
-control_dperm Directory permission for the group.
-control_fperm File permission for the control files.
-task_fperm File permissions for task file.
+	restore := func() error {
+		for _, c := range cg.controllers {
+			path, found := origin[c]
+			if !found {
+				continue
+			}
 
-  g := cgroup.NewCgroup("foo")
-  g.SetPermissions(cgroup.Mode(0777), cgroup.Mode(0777), cgroup.Mode(0777))
-*/
-func (cg Cgroup) SetPermissions(control_dperm, control_fperm, task_fperm Mode) {
-	C.cgroup_set_permissions(cg.g, C.mode_t(control_dperm),
-		C.mode_t(control_fperm), C.mode_t(task_fperm))
-}
+			if err := write(filepath.Join(Root, c, path, "tasks"), tid); err != nil {
+				return err
+			}
+		}
 
-/*
-Copy all controllers, parameters and their values. All existing controllers
-in the source group are discarded.
-*/
-func CopyCgroup(src, dest Cgroup) error {
-	return _err(C.cgroup_copy_cgroup(src.g, dest.g))
-}
+		return nil
+	}
+
+	for _, c := range cg.controllers {
+		if err := write(filepath.Join(cg.dir(c), "tasks"), tid); err != nil {
+			if restore() == nil {
+				runtime.UnlockOSThread()
+			}
+
+			return err
+		}
+	}
 
-/*
-Compare names, owners, controllers, parameters and values of two groups.
+	result := f()
 
-Return value of:
- * nil - a and b are equal
- * ECGROUPNOTEQUAL - groups are not equal
- * ECGCONTROLLERNOTEQUAL - controllers are not equal
+	if err := restore(); err != nil {
+		return errors.New(fmt.Sprintf("Unable to move thread back from %s: %v, thread is abandoned", cg.name, err))
+	}
+
+	runtime.UnlockOSThread()
 
-*/
-func CompareCgroup(a, b Cgroup) error {
-	return _err(C.cgroup_compare_cgroup(a.g, b.g))
+	return result
 }
 
-/*
-Structure describing a controller attached to one struct @c cgroup, including
-parameters of the group and their values. The structure is opaque to
-applications.
-*/
-type Controller struct {
-	c *C.struct_cgroup_controller
+// threadGroups returns group paths of the thread by controller names
+func threadGroups(tid string) (map[string]string, error) {
+	data, err := ioutil.ReadFile("/proc/self/task/" + tid + "/cgroup")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+
+	// 4:cpu,cpuacct:/user.slice
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		for _, c := range strings.Split(parts[1], ",") {
+			result[c] = parts[2]
+		}
+	}
+
+	return result, nil
 }
 
-/*
-Add parameter and its value to internal libcgroup structures.
-Use Cgroup.Modify() or Cgroup.Create() to write it to kernel.
+// Procs returns pids of processes in the group
+func (cg Cgroup) Procs() ([]int, error) {
+	seen := make(map[int]bool)
+	result := make([]int, 0)
 
-Name of the parameter and its value
-*/
-func (c Controller) AddValueString(name, value string) error {
-	return _err(C.cgroup_add_value_string(c.c, C.CString(name), C.CString(value)))
+	for _, dir := range cg.dirs() {
+		pids, err := readPids(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pid := range pids {
+			if !seen[pid] {
+				seen[pid] = true
+				result = append(result, pid)
+			}
+		}
+	}
+
+	return result, nil
 }
 
-func (c Controller) AddValueInt64(name string, value int64) error {
-	return _err(C.cgroup_add_value_int64(c.c, C.CString(name), C.int64_t(value)))
+type DeleteFlag int
+
+const (
+	// Ignore errors caused by migration of tasks to parent group.
+	DeleteIgnoreMigration DeleteFlag = 1 << iota
+
+	// Recursively delete all child groups.
+	DeleteRecursive
+
+	// Delete the cgroup only if it is empty, i.e. it has no subgroups and
+	// no processes inside. This flag cannot be used with DeleteRecursive
+	DeleteEmptyOnly
+)
+
+func (cg Cgroup) Delete() error {
+	return cg.DeleteExt(0)
 }
 
-func (c Controller) AddValueBool(name string, value bool) error {
-	return _err(C.cgroup_add_value_bool(c.c, C.CString(name), C.bool(value)))
+// DeleteExt removes group. Processes are moved to the hierarchy root,
+// v2 doesn't allow them in Parent, which has controllers enabled.
+func (cg Cgroup) DeleteExt(flags DeleteFlag) error {
+	for _, dir := range cg.dirs() {
+		if err := deleteDir(dir, flags); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-/*
-Use Cgroup.Get() to fill these values with data from the kernel
-*/
-func (c Controller) GetValueString(name string) (value string, err error) {
-	var v *C.char
-	err = _err(C.cgroup_get_value_string(c.c, C.CString(name), &v))
-	return C.GoString(v), err
+func deleteDir(dir string, flags DeleteFlag) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if flags&DeleteRecursive == 0 {
+			return errors.New(fmt.Sprintf("Unable to delete %s, it has subgroups", dir))
+		}
+
+		if err := deleteDir(filepath.Join(dir, entry.Name()), flags); err != nil {
+			return err
+		}
+	}
+
+	pids, err := readPids(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(pids) > 0 && flags&DeleteEmptyOnly != 0 {
+		return errors.New(fmt.Sprintf("Unable to delete %s, it has processes", dir))
+	}
+
+	root := hierarchyRoot(dir)
+	for _, pid := range pids {
+		err := write(filepath.Join(root, "cgroup.procs"), strconv.Itoa(pid))
+		if err != nil && flags&DeleteIgnoreMigration == 0 {
+			return err
+		}
+	}
+
+	return os.Remove(dir)
 }
 
-func (c Controller) GetValueInt64(name string) (value int64, err error) {
-	var v C.int64_t
-	err = _err(C.cgroup_get_value_int64(c.c, C.CString(name), &v))
-	return int64(v), err
+// dir returns group directory in hierarchy of given controller, controller
+// is ignored for v2
+func (cg Cgroup) dir(controller string) string {
+	if GetVersion() == V2 {
+		return filepath.Join(Root, Parent, cg.name)
+	}
+
+	return filepath.Join(Root, controller, Parent, cg.name)
 }
 
-func (c Controller) GetValueBool(name string) (value bool, err error) {
-	var v C.bool
-	err = _err(C.cgroup_get_value_bool(c.c, C.CString(name), &v))
-	return bool(v), err
+func (cg Cgroup) dirs() []string {
+	if GetVersion() == V2 {
+		return []string{cg.dir("")}
+	}
+
+	result := make([]string, 0)
+	for _, c := range cg.controllers {
+		result = append(result, cg.dir(c))
+	}
+
+	return result
 }
 
-/*
-Set a parameter value in @c libcgroup internal structures.
-Use Cgroup.Modify() or Cgroup.Create() to write it to kernel.
-*/
-func (c Controller) SetValueString(name, value string) error {
-	return _err(C.cgroup_set_value_string(c.c, C.CString(name), C.CString(value)))
+// file returns path of a control file, for v1 hierarchy is chosen by the
+// file prefix, e.g. cpu.shares lives in cpu hierarchy
+func (cg Cgroup) file(name string) string {
+	controller := strings.SplitN(name, ".", 2)[0]
+	return filepath.Join(cg.dir(controller), name)
 }
 
-func (c Controller) SetValueInt64(name string, value int64) error {
-	return _err(C.cgroup_set_value_int64(c.c, C.CString(name), C.int64_t(value)))
+func (cg Cgroup) read(name string) (string, error) {
+	data, err := ioutil.ReadFile(cg.file(name))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
 }
 
-func (c Controller) SetValueUint64(name string, value uint64) error {
-	return _err(C.cgroup_set_value_uint64(c.c, C.CString(name), C.u_int64_t(value)))
+func (cg Cgroup) write(name, value string) error {
+	return write(cg.file(name), value)
 }
 
-func (c Controller) SetValueBool(name string, value bool) error {
-	return _err(C.cgroup_set_value_bool(c.c, C.CString(name), C.bool(value)))
+// Controller is a handle of group parameters of one controller
+type Controller struct {
+	cg   *Cgroup
+	name string
 }
 
-/*
-Compare names, parameters and values of two controllers.
+// SetValueString writes parameter. Name is either full file name, like
+// "cpu.max", or without controller prefix, like "cfs_quota_us".
+func (c Controller) SetValueString(name, value string) error {
+	k, err := c.knob(name)
+	if err != nil {
+		return err
+	}
 
-Return value of:
- * nil - a and b are equal
- * ECGCONTROLLERNOTEQUAL - controllers are not equal
-*/
-func CompareControllers(a, b Controller) error {
-	return _err(C.cgroup_compare_controllers(a.c, b.c))
+	return k.set(c.cg, value)
 }
 
-/*
-Initialize libcgroup. Information about mounted hierarchies are examined
-and cached internally (just what's mounted where, not the groups themselves).
-*/
-func Init() error {
-	return _err(C.cgroup_init())
+func (c Controller) SetValueInt64(name string, value int64) error {
+	return c.SetValueString(name, strconv.FormatInt(value, 10))
 }
 
-/*
-Load configuration file and mount and create control groups described there.
-See cgconfig.conf man page for format of the file.
-*/
-func LoadConfig(filename string) error {
-	return _err(C.cgroup_config_load_config(C.CString(filename)))
+func (c Controller) SetValueUint64(name string, value uint64) error {
+	return c.SetValueString(name, strconv.FormatUint(value, 10))
 }
 
-/*
-Delete all control groups and unmount all hierarchies.
-*/
-func Unload() error {
-	return _err(C.cgroup_unload_cgroups())
+func (c Controller) SetValueBool(name string, value bool) error {
+	if value {
+		return c.SetValueString(name, "1")
+	}
+
+	return c.SetValueString(name, "0")
 }
 
-type DeleteFlag int
+func (c Controller) GetValueString(name string) (string, error) {
+	k, err := c.knob(name)
+	if err != nil {
+		return "", err
+	}
 
-const (
-	// Ignore errors caused by migration of tasks to parent group.
-	DeleteIgnoreMigration = DeleteFlag(C.CGFLAG_DELETE_IGNORE_MIGRATION)
+	return k.get(c.cg)
+}
 
-	// Recursively delete all child groups.
-	DeleteRecursive = DeleteFlag(C.CGFLAG_DELETE_RECURSIVE)
-
-	/*
-		Delete the cgroup only if it is empty, i.e. it has no subgroups and
-		no processes inside. This flag cannot be used with
-		DeleteRecursive
-	*/
-	DeleteEmptyOnly = DeleteFlag(C.CGFLAG_DELETE_EMPTY_ONLY)
-)
+func (c Controller) GetValueInt64(name string) (int64, error) {
+	v, err := c.GetValueString(name)
+	if err != nil {
+		return 0, err
+	}
 
-/*
-Delete all cgroups and unmount all mount points defined in specified config
-file.
+	return strconv.ParseInt(v, 10, 64)
+}
 
-The groups are either removed recursively or only the empty ones, based
-on given flags. Mount point are always umounted only if they are empty,
-regardless of any flags.
+func (c Controller) GetValueBool(name string) (bool, error) {
+	v, err := c.GetValueString(name)
+	if err != nil {
+		return false, err
+	}
 
-The groups are sorted before they are removed, so the removal of empty ones
-actually works (i.e. subgroups are removed first).
-*/
-func UnloadFromConfig(filename string, flags DeleteFlag) error {
-	return _err(C.cgroup_config_unload_config(C.CString(filename), C.int(flags)))
+	return v == "1", nil
 }
 
-/*
-Sets default permissions of groups created by subsequent
-cgroup_config_load_config() calls. If a config file contains a 'default {}'
-section, the default permissions from the config file is then used.
-
-Use cgroup_new_cgroup() to create a dummy group and cgroup_set_uid_gid() and
-cgroup_set_permissions() to set its permissions. Use NO_UID_GID instead of
-GID/UID and NO_PERMS instead of file/directory permissions to let kernel
-decide the default permissions where you don't want specific user and/or
-permissions. Kernel then uses current user/group and permissions from umask
-then.
+func (c Controller) knob(name string) (knob, error) {
+	if c.cg == nil || c.name == "" {
+		return knob{}, errors.New(fmt.Sprintf("Unknown controller for %s", name))
+	}
 
-New default permissions from this group are copied to libcgroup internal
-structures.
-*/
-func SetDefault(cg Cgroup) error {
-	return _err(C.cgroup_config_set_default(cg.g))
+	return lookup(GetVersion(), c.name, name), nil
 }
 
-type FileInfo struct {
-	Type     FileType
-	Path     string
-	Parent   string
-	FullPath string
-	Depth    int8
+func mkdir(dir string) error {
+	return os.MkdirAll(dir, 0755)
 }
 
-func fromCFileInfo(cData C.struct_cgroup_file_info) FileInfo {
-	return FileInfo{
-		Type:     FileType(C.type_from_file_info(cData)),
-		Path:     C.GoString(cData.path),
-		Parent:   C.GoString(cData.parent),
-		FullPath: C.GoString(cData.full_path),
-		Depth:    int8(cData.depth),
+func write(file, value string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
 	}
-}
+	defer f.Close()
 
-type FileType int
+	if _, err := f.WriteString(value); err != nil {
+		return errors.New(fmt.Sprintf("Unable to write %q to %s: %v", value, file, err))
+	}
 
-const (
-	FileTypeFile  = FileType(C.CGROUP_FILE_TYPE_FILE)
-	FileTypeDir   = FileType(C.CGROUP_FILE_TYPE_DIR)
-	FileTypeOther = FileType(C.CGROUP_FILE_TYPE_OTHER)
-)
+	return nil
+}
 
-/*
-int cgroup_walk_tree_begin(const char *controller, const char *base_path, int depth,
-				void **handle, struct cgroup_file_info *info,
-				int *base_level);
-*/
-
-/*
-Information model for Controllers available
-*/
-type ControllerData struct {
-	Name       string
-	Hierarchy  int
-	NumCgroups int
-	Enabled    int
-}
-
-func fromCControllerData(cData C.struct_controller_data) ControllerData {
-	return ControllerData{
-		Name:       C.GoString(&cData.name[0]),
-		Hierarchy:  int(cData.hierarchy),
-		NumCgroups: int(cData.num_cgroups),
-		Enabled:    int(cData.enabled),
-	}
-}
-
-func GetAllControllers() (controllers []ControllerData, err error) {
-	var (
-		cd     C.struct_controller_data
-		handle unsafe.Pointer
-	)
-	err = _err(C.cgroup_get_all_controller_begin(&handle, &cd))
+func readPids(dir string) ([]int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs"))
 	if err != nil {
-		return controllers, err
+		if os.IsNotExist(err) {
+			return []int{}, nil
+		}
+		return nil, err
 	}
-	defer C.cgroup_get_all_controller_end(&handle)
 
-	controllers = append(controllers, fromCControllerData(cd))
-	for {
-		err = _err(C.cgroup_get_all_controller_next(&handle, &cd))
-		if err != nil && err != ECGEOF {
-			return controllers, err
-		}
-		controllers = append(controllers, fromCControllerData(cd))
-		if err == ECGEOF {
-			break
+	result := make([]int, 0)
+	for _, line := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(line); err == nil {
+			result = append(result, pid)
 		}
 	}
-	return controllers, nil
-}
 
-func GetSubSysMountPoint(controller string) (string, error) {
-	var mp *C.char
-	err := _err(C.cgroup_get_subsys_mount_point(C.CString(controller), &mp))
-	if err != nil {
-		return "", err
-	}
-	return C.GoString(mp), nil
+	return result, nil
 }
 
-var (
-	// End-of-file for iterators
-	ECGEOF                = errors.New(C.GoString(C.cgroup_strerror(C.ECGEOF)))
-	ECGOTHER              = errors.New(C.GoString(C.cgroup_strerror(C.ECGOTHER)))
-	ECGROUPNOTEQUAL       = errors.New(C.GoString(C.cgroup_strerror(C.ECGROUPNOTEQUAL)))
-	ECGCONTROLLERNOTEQUAL = errors.New(C.GoString(C.cgroup_strerror(C.ECGCONTROLLERNOTEQUAL)))
-)
+// hierarchyRoot returns root of the hierarchy dir belongs to
+func hierarchyRoot(dir string) string {
+	if GetVersion() == V2 {
+		return Root
+	}
 
-/*
-Return last errno, which caused ECGOTHER error.
-*/
-func LastError() error {
-	return _err(C.cgroup_get_last_errno())
-}
+	rel, err := filepath.Rel(Root, dir)
+	if err != nil {
+		return Root
+	}
 
-func _err(num C.int) error {
-	switch num {
-	case 0:
-		return nil
-	case C.ECGEOF:
-		return ECGEOF
-	case C.ECGOTHER:
-		return ECGOTHER
-	case C.ECGROUPNOTEQUAL:
-		return ECGROUPNOTEQUAL
-	case C.ECGCONTROLLERNOTEQUAL:
-		return ECGCONTROLLERNOTEQUAL
-	}
-	// There's a lot. We'll create them as they come
-	return errors.New(C.GoString(C.cgroup_strerror(num)))
+	return filepath.Join(Root, strings.SplitN(rel, string(filepath.Separator), 2)[0])
 }
@@ -0,0 +1,187 @@
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeRoot points Root to a temp dir looking like hierarchy of version v
+func fakeRoot(t *testing.T, v Version) func() {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v == V2 {
+		if err := write(filepath.Join(dir, "cgroup.controllers"), "cpu io memory pids"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root := Root
+	Root = dir
+
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return func() {
+		Root = root
+		Init()
+		os.RemoveAll(dir)
+	}
+}
+
+func expectFile(t *testing.T, file, expected string) {
+	data, err := ioutil.ReadFile(filepath.Join(Root, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != expected {
+		t.Fatalf("Expected %s=%q, obtained: %q", file, expected, string(data))
+	}
+}
+
+func TestV2(t *testing.T) {
+	defer fakeRoot(t, V2)()
+
+	if GetVersion() != V2 {
+		t.Fatal("Expected v2, obtained:", GetVersion())
+	}
+
+	cg := NewCgroup("h1")
+	for _, name := range []string{"cpu", "memory", "blkio", "pids"} {
+		if _, err := cg.AddController(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cg.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFile(t, "cgroup.subtree_control", "+cpu +memory +io +pids")
+	expectFile(t, "mn/cgroup.subtree_control", "+cpu +memory +io +pids")
+
+	cpu := cg.GetController("cpu")
+	if err := cpu.SetValueInt64("cfs_quota_us", 50000); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.SetValueInt64("cfs_period_us", 200000); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFile(t, "mn/h1/cpu.max", "50000 200000")
+
+	if v, err := cpu.GetValueInt64("cfs_quota_us"); err != nil || v != 50000 {
+		t.Fatal("Expected quota 50000, obtained:", v, err)
+	}
+
+	if err := cg.GetController("memory").SetValueInt64("limit_in_bytes", -1); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFile(t, "mn/h1/memory.max", "max")
+
+	if err := cg.GetController("blkio").SetValueString("throttle.read_bps_device", "8:0 1048576"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFile(t, "mn/h1/io.max", "8:0 rbps=1048576")
+
+	if err := cg.GetController("pids").SetValueInt64("max", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFile(t, "mn/h1/pids.max", "100")
+
+	if err := cg.AddProc(42); err != nil {
+		t.Fatal(err)
+	}
+
+	procs, err := cg.Procs()
+	if err != nil || len(procs) != 1 || procs[0] != 42 {
+		t.Fatal("Expected [42], obtained:", procs, err)
+	}
+
+	// kernel fills it in real hierarchy
+	if err := write(filepath.Join(Root, "mn/h1/cgroup.controllers"), "cpu io memory pids"); err != nil {
+		t.Fatal(err)
+	}
+
+	found := NewCgroup("h1")
+	if err := found.Get(); err != nil || len(found.controllers) != 4 {
+		t.Fatal("Expected 4 controllers, obtained:", found.controllers, err)
+	}
+
+	if err := NewCgroup("h2").Get(); err != ErrNotExist {
+		t.Fatal("Expected ErrNotExist, obtained:", err)
+	}
+}
+
+func TestV1(t *testing.T) {
+	defer fakeRoot(t, V1)()
+
+	if GetVersion() != V1 {
+		t.Fatal("Expected v1, obtained:", GetVersion())
+	}
+
+	cg := NewCgroup("h1")
+	for _, name := range []string{"cpu", "memory", "io"} {
+		if _, err := cg.AddController(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cg.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cg.GetController("cpu").SetValueString("cpu.max", "max 50000"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFile(t, "cpu/mn/h1/cpu.cfs_quota_us", "-1")
+	expectFile(t, "cpu/mn/h1/cpu.cfs_period_us", "50000")
+
+	if v, err := cg.GetController("cpu").GetValueString("max"); err != nil || v != "max 50000" {
+		t.Fatal("Expected \"max 50000\", obtained:", v, err)
+	}
+
+	if err := cg.GetController("memory").SetValueString("max", "max"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFile(t, "memory/mn/h1/memory.limit_in_bytes", "-1")
+
+	io := cg.GetController("io")
+	if err := io.SetValueString("io.max", "8:0 rbps=1048576 wiops=100"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFile(t, "blkio/mn/h1/blkio.throttle.read_bps_device", "8:0 1048576")
+	expectFile(t, "blkio/mn/h1/blkio.throttle.write_iops_device", "8:0 100")
+
+	if v, err := io.GetValueString("max"); err != nil || v != "8:0 rbps=1048576 wiops=100" {
+		t.Fatal("Expected io.max back, obtained:", v, err)
+	}
+
+	if err := cg.AddProc(42); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFile(t, "memory/mn/h1/cgroup.procs", "42")
+
+	found := NewCgroup("h1")
+	if err := found.Get(); err != nil || len(found.controllers) != 3 {
+		t.Fatal("Expected 3 controllers, obtained:", found.controllers, err)
+	}
+
+	if err := NewCgroup("h2").Get(); err != ErrNotExist {
+		t.Fatal("Expected ErrNotExist, obtained:", err)
+	}
+}
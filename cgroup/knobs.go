@@ -0,0 +1,307 @@
+package cgroup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// knob reads and writes one group parameter, which could live in a file
+// with different name, or in a part of file, in this cgroup version.
+type knob struct {
+	get func(cg *Cgroup) (string, error)
+	set func(cg *Cgroup, value string) error
+}
+
+// v1 parameters on v2 hierarchy
+var v1ToV2 = map[string]knob{
+	"cpu.cfs_quota_us":                 field("cpu.max", 0, "max 100000", unlimited("-1", "max"), unlimited("max", "-1")),
+	"cpu.cfs_period_us":                field("cpu.max", 1, "max 100000", same, same),
+	"cpu.shares":                       converted("cpu.weight", scale(2, 262144, 1, 10000), scale(1, 10000, 2, 262144)),
+	"memory.limit_in_bytes":            converted("memory.max", unlimited("-1", "max"), unlimited("max", "-1")),
+	"memory.soft_limit_in_bytes":       converted("memory.low", unlimited("-1", "max"), unlimited("max", "-1")),
+	"memory.memsw.limit_in_bytes":      converted("memory.swap.max", unlimited("-1", "max"), unlimited("max", "-1")),
+	"blkio.weight":                     converted("io.weight", scale(10, 1000, 1, 10000), scale(1, 10000, 10, 1000)),
+	"blkio.throttle.read_bps_device":   ioMax("rbps"),
+	"blkio.throttle.write_bps_device":  ioMax("wbps"),
+	"blkio.throttle.read_iops_device":  ioMax("riops"),
+	"blkio.throttle.write_iops_device": ioMax("wiops"),
+}
+
+// v2 parameters on v1 hierarchy
+var v2ToV1 = map[string]knob{
+	"cpu.max":         cpuMax(),
+	"cpu.weight":      converted("cpu.shares", scale(1, 10000, 2, 262144), scale(2, 262144, 1, 10000)),
+	"memory.max":      converted("memory.limit_in_bytes", unlimited("max", "-1"), same),
+	"memory.low":      converted("memory.soft_limit_in_bytes", unlimited("max", "-1"), same),
+	"memory.swap.max": converted("memory.memsw.limit_in_bytes", unlimited("max", "-1"), same),
+	"io.weight":       converted("blkio.weight", scale(1, 10000, 10, 1000), scale(10, 1000, 1, 10000)),
+	"io.max":          blkioThrottle(),
+}
+
+// v1 throttle files by io.max keys
+var throttleFiles = map[string]string{
+	"rbps":  "blkio.throttle.read_bps_device",
+	"wbps":  "blkio.throttle.write_bps_device",
+	"riops": "blkio.throttle.read_iops_device",
+	"wiops": "blkio.throttle.write_iops_device",
+}
+
+// controllerName returns name of controller in this cgroup version
+func controllerName(name string) string {
+	switch {
+	case GetVersion() == V2 && name == "blkio":
+		return "io"
+	case GetVersion() == V2 && name == "cpuacct":
+		return "cpu"
+	case GetVersion() == V1 && name == "io":
+		return "blkio"
+	}
+
+	return name
+}
+
+// lookup returns knob for parameter name with or without controller
+// prefix, e.g. "cfs_quota_us", "cpu.cfs_quota_us" or "cpu.max".
+func lookup(v Version, controller, name string) knob {
+	aliases := map[string]string{"io": "blkio", "blkio": "io"}
+
+	names := []string{controller + "." + name}
+	if alias, found := aliases[controller]; found {
+		names = append(names, alias+"."+name)
+	}
+
+	if strings.HasPrefix(name, controller+".") || strings.HasPrefix(name, aliases[controller]+".") {
+		names = []string{name}
+	}
+
+	table := v2ToV1
+	if v == V2 {
+		table = v1ToV2
+	}
+
+	for _, n := range names {
+		if k, found := table[n]; found {
+			return k
+		}
+	}
+
+	return converted(names[0], same, same)
+}
+
+func same(v string) string {
+	return v
+}
+
+// unlimited replaces "no limit" value of one version with the other one
+func unlimited(from, to string) func(string) string {
+	return func(v string) string {
+		if v == from {
+			return to
+		}
+
+		return v
+	}
+}
+
+// scale maps value from one range to another, like cpu.shares to cpu.weight
+func scale(fromMin, fromMax, toMin, toMax int64) func(string) string {
+	return func(v string) string {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return v
+		}
+
+		return strconv.FormatInt(toMin+(n-fromMin)*(toMax-toMin)/(fromMax-fromMin), 10)
+	}
+}
+
+// converted is a file, which value is converted on write and read back
+func converted(file string, to, from func(string) string) knob {
+	return knob{
+		get: func(cg *Cgroup) (string, error) {
+			v, err := cg.read(file)
+			if err != nil {
+				return "", err
+			}
+
+			// io.weight and cpu.weight may look like "default 100"
+			fields := strings.Fields(v)
+			if len(fields) == 2 && fields[0] == "default" {
+				v = fields[1]
+			}
+
+			return from(v), nil
+		},
+		set: func(cg *Cgroup, value string) error {
+			return cg.write(file, to(value))
+		},
+	}
+}
+
+// field is a space separated field of file, like quota and period of
+// cpu.max. Other fields are kept, def is used if file is empty.
+func field(file string, i int, def string, to, from func(string) string) knob {
+	return knob{
+		get: func(cg *Cgroup) (string, error) {
+			v, err := cg.read(file)
+			if err != nil {
+				return "", err
+			}
+
+			fields := strings.Fields(v)
+			if i >= len(fields) {
+				return "", errors.New(fmt.Sprintf("Unexpected %s content: %s", file, v))
+			}
+
+			return from(fields[i]), nil
+		},
+		set: func(cg *Cgroup, value string) error {
+			v, err := cg.read(file)
+			if err != nil || v == "" {
+				v = def
+			}
+
+			fields := strings.Fields(v)
+			fields[i] = to(value)
+
+			return cg.write(file, strings.Join(fields, " "))
+		},
+	}
+}
+
+// ioMax is v1 throttle file on v2, "8:0 1048576" is written to io.max
+// as "8:0 rbps=1048576"
+func ioMax(key string) knob {
+	return knob{
+		get: func(cg *Cgroup) (string, error) {
+			v, err := cg.read("io.max")
+			if err != nil {
+				return "", err
+			}
+
+			result := make([]string, 0)
+			for _, line := range strings.Split(v, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) < 2 {
+					continue
+				}
+
+				for _, kv := range fields[1:] {
+					if strings.HasPrefix(kv, key+"=") {
+						result = append(result, fields[0]+" "+unlimited("max", "0")(kv[len(key)+1:]))
+					}
+				}
+			}
+
+			return strings.Join(result, "\n"), nil
+		},
+		set: func(cg *Cgroup, value string) error {
+			fields := strings.Fields(value)
+			if len(fields) != 2 {
+				return errors.New(fmt.Sprintf("Expected \"major:minor value\", obtained: %s", value))
+			}
+
+			return cg.write("io.max", fields[0]+" "+key+"="+unlimited("0", "max")(fields[1]))
+		},
+	}
+}
+
+// blkioThrottle is io.max on v1, each key of "8:0 rbps=1048576 wiops=100"
+// goes to its own throttle file
+func blkioThrottle() knob {
+	return knob{
+		get: func(cg *Cgroup) (string, error) {
+			limits := make(map[string][]string)
+
+			for _, key := range []string{"rbps", "wbps", "riops", "wiops"} {
+				v, err := cg.read(throttleFiles[key])
+				if os.IsNotExist(err) {
+					continue
+				} else if err != nil {
+					return "", err
+				}
+
+				for _, line := range strings.Split(v, "\n") {
+					fields := strings.Fields(line)
+					if len(fields) == 2 {
+						limits[fields[0]] = append(limits[fields[0]], key+"="+fields[1])
+					}
+				}
+			}
+
+			devices := make([]string, 0)
+			for dev := range limits {
+				devices = append(devices, dev)
+			}
+
+			sort.Strings(devices)
+
+			result := make([]string, 0)
+			for _, dev := range devices {
+				result = append(result, dev+" "+strings.Join(limits[dev], " "))
+			}
+
+			return strings.Join(result, "\n"), nil
+		},
+		set: func(cg *Cgroup, value string) error {
+			for _, line := range strings.Split(value, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) < 2 {
+					continue
+				}
+
+				for _, kv := range fields[1:] {
+					parts := strings.SplitN(kv, "=", 2)
+					file, found := throttleFiles[parts[0]]
+					if !found || len(parts) != 2 {
+						return errors.New(fmt.Sprintf("Unknown io.max limit: %s", kv))
+					}
+
+					if err := cg.write(file, fields[0]+" "+unlimited("max", "0")(parts[1])); err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// cpuMax is cpu.max on v1, "quota period" goes to cfs_quota_us and
+// cfs_period_us
+func cpuMax() knob {
+	return knob{
+		get: func(cg *Cgroup) (string, error) {
+			quota, err := cg.read("cpu.cfs_quota_us")
+			if err != nil {
+				return "", err
+			}
+
+			period, err := cg.read("cpu.cfs_period_us")
+			if err != nil {
+				return "", err
+			}
+
+			return unlimited("-1", "max")(quota) + " " + period, nil
+		},
+		set: func(cg *Cgroup, value string) error {
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				return errors.New("Expected \"quota [period]\"")
+			}
+
+			if len(fields) > 1 {
+				if err := cg.write("cpu.cfs_period_us", fields[1]); err != nil {
+					return err
+				}
+			}
+
+			return cg.write("cpu.cfs_quota_us", unlimited("max", "-1")(fields[0]))
+		},
+	}
+}
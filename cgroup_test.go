@@ -91,7 +91,8 @@ func TestCgroupCtrl(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if vs != "2G" {
-		t.Fatal("Expected value=2G, obtained:", v)
+	// value is read back from cgroupfs, which reports bytes
+	if vs != "2147483648" {
+		t.Fatal("Expected value=2147483648, obtained:", vs)
 	}
 }
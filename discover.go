@@ -0,0 +1,323 @@
+package mn
+
+import (
+	"log"
+	"net"
+	"sort"
+)
+
+// endpoint is a link in the namespace, empty ns is the root one
+type endpoint struct {
+	ns   string
+	name string
+}
+
+// DiscoverScheme builds scheme of what actually exists on the machine.
+// Every OVS bridge is a switch, linux bridges are taken only if some
+// namespace is plugged in, so docker0 and the like are left out. Namespace
+// in NETNS_RUN_DIR is a host, if a session owns it, or a veth connects it
+// to a switch or another host. Links are paired by veth peer indexes.
+func DiscoverScheme() (*Scheme, error) {
+	namespaces, err := listNetNs()
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]*liveNs)
+	if live[""], err = observeNs(""); err != nil {
+		return nil, err
+	}
+
+	for _, ns := range namespaces {
+		state, err := observeNs(ns)
+		if err != nil {
+			log.Println("Skipping netns", ns, err)
+			continue
+		}
+
+		live[ns] = state
+	}
+
+	if err := observeNsIds(live); err != nil {
+		return nil, err
+	}
+
+	peers := vethPeers(live)
+	scheme := NewScheme()
+
+	// switch ports by name, to know who is on the other side of host links
+	owners := make(map[string]*Switch)
+	ports := make(map[string][]string)
+
+	types := make([]string, 0)
+	for typ := range switchBackends {
+		types = append(types, typ)
+	}
+
+	sort.Strings(types)
+
+	for _, typ := range types {
+		names, err := switchBackends[typ].List()
+		if err != nil {
+			log.Println("Unable to list", typ, "switches:", err)
+			continue
+		}
+
+		for _, name := range names {
+			if _, found := scheme.GetSwitch(name); found {
+				continue
+			}
+
+			portNames, err := switchBackends[typ].Ports(name)
+			if err != nil {
+				log.Println("Unable to get ports of", name, err)
+				continue
+			}
+
+			hosted := false
+			for _, port := range portNames {
+				if peer, found := peers[endpoint{"", port}]; found && peer.ns != "" {
+					hosted = true
+				}
+			}
+
+			if typ == SwitchLinux && !hosted {
+				continue
+			}
+
+			sw := &Switch{Name: name, Type: typ, Ports: make(Links, 0)}
+			for _, port := range portNames {
+				owners[port] = sw
+			}
+
+			ports[name] = portNames
+			scheme.AddNode(sw)
+		}
+	}
+
+	for _, sw := range scheme.Switches {
+		for _, name := range ports[sw.Name] {
+			if l := live[""].link(name); l != nil {
+				link := discoveredLink(sw.Name, "", l)
+				if peer, found := peers[endpoint{"", name}]; found {
+					link.Peer = peerOf(peer, owners)
+				}
+
+				sw.Ports = append(sw.Ports, link)
+				continue
+			}
+
+			if link, found := discoveredPatch(sw, name, owners); found {
+				sw.Ports = append(sw.Ports, link)
+			}
+		}
+	}
+
+	owned, err := ownedNamespaces()
+	if err != nil {
+		log.Println("Unable to read owned namespaces:", err)
+	}
+
+	hosts := hostNamespaces(live, peers, owners, owned)
+
+	for _, ns := range namespaces {
+		state, found := live[ns]
+		if !found || !hosts[ns] {
+			continue
+		}
+
+		h := &Host{Name: ns, netns: &NetNs{name: ns}, Links: make(Links, 0)}
+
+		names := make([]string, 0)
+		for name := range state.Links {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			l := state.Links[name]
+			if l.Kind != "veth" {
+				continue
+			}
+
+			link := discoveredLink(ns, ns, l)
+			if peer, found := peers[endpoint{ns, name}]; found {
+				link.Peer = peerOf(peer, owners)
+			}
+
+			h.Links = append(h.Links, link)
+		}
+
+		scheme.AddNode(h)
+	}
+
+	if err := scheme.adoptPool(nil); err != nil {
+		return nil, err
+	}
+
+	return scheme, nil
+}
+
+// hostNamespaces returns namespaces, which are hosts: owned ones and ones
+// connected by veth to a switch port or, in turn, to such a namespace
+func hostNamespaces(live map[string]*liveNs, peers map[endpoint]endpoint, owners map[string]*Switch, owned map[string]bool) map[string]bool {
+	result := make(map[string]bool)
+	for ns := range live {
+		if ns != "" && owned[ns] {
+			result[ns] = true
+		}
+	}
+
+	for added := true; added; {
+		added = false
+
+		for ns, state := range live {
+			if ns == "" || result[ns] {
+				continue
+			}
+
+			for name := range state.Links {
+				peer, found := peers[endpoint{ns, name}]
+				if !found {
+					continue
+				}
+
+				if _, port := owners[peer.name]; (peer.ns == "" && port) || result[peer.ns] {
+					result[ns] = true
+					added = true
+					break
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// vethPeers pairs veth ends. Interface indexes are unique only inside a
+// namespace, so ends must point to each other and peer namespace id of
+// each end must be the namespace of the other one.
+func vethPeers(live map[string]*liveNs) map[endpoint]endpoint {
+	ends := make([]endpoint, 0)
+	for ns, state := range live {
+		for name, l := range state.Links {
+			if l.Kind == "veth" {
+				ends = append(ends, endpoint{ns, name})
+			}
+		}
+	}
+
+	sort.Slice(ends, func(i, j int) bool {
+		if ends[i].ns != ends[j].ns {
+			return ends[i].ns < ends[j].ns
+		}
+		return ends[i].name < ends[j].name
+	})
+
+	result := make(map[endpoint]endpoint)
+
+	for _, a := range ends {
+		if _, found := result[a]; found {
+			continue
+		}
+
+		la := live[a.ns].Links[a.name]
+
+		for _, b := range ends {
+			if _, found := result[b]; found || b == a {
+				continue
+			}
+
+			lb := live[b.ns].Links[b.name]
+
+			if la.PeerIndex != lb.Index || lb.PeerIndex != la.Index {
+				continue
+			}
+
+			if ns, found := live[a.ns].peerNs(la); !found || ns != b.ns {
+				continue
+			}
+
+			if ns, found := live[b.ns].peerNs(lb); !found || ns != a.ns {
+				continue
+			}
+
+			result[a] = b
+			result[b] = a
+
+			break
+		}
+	}
+
+	return result
+}
+
+// discoveredPatch returns patch port of the switch, if backend has them
+func discoveredPatch(sw *Switch, name string, owners map[string]*Switch) (Link, bool) {
+	b, err := sw.backend()
+	if err != nil {
+		return Link{}, false
+	}
+
+	patcher, ok := b.(interface {
+		PatchPeer(port string) (string, error)
+	})
+	if !ok {
+		return Link{}, false
+	}
+
+	peer, err := patcher.PatchPeer(name)
+	if err != nil || peer == "" {
+		return Link{}, false
+	}
+
+	link := Link{Name: name, NodeName: sw.Name, Cidr: noip, State: "UP"}
+	link.Peer = peerOf(endpoint{"", peer}, owners)
+
+	return link.SetPatch(), true
+}
+
+func discoveredLink(node, ns string, l *liveLink) Link {
+	result := Link{
+		Cidr:     noip,
+		HwAddr:   l.HwAddr,
+		Name:     l.Name,
+		NodeName: node,
+		NetNs:    ns,
+		State:    "DOWN",
+		Routes:   l.Routes,
+		Shaping:  l.Shaping,
+	}
+
+	// IPv4 address, if any, is the Cidr, the rest are Addrs
+	for i, addr := range l.Addrs {
+		if ip, _, _ := net.ParseCIDR(addr); ip.To4() != nil || i == len(l.Addrs)-1 {
+			result.Cidr = addr
+			if len(l.Addrs) > 1 {
+				result.Addrs = append(append([]string{}, l.Addrs[:i]...), l.Addrs[i+1:]...)
+			}
+			break
+		}
+	}
+
+	if l.Up {
+		result.State = "UP"
+	}
+
+	return result
+}
+
+// peerOf turns the other end into Peer, root namespace ends belong to
+// switches
+func peerOf(e endpoint, owners map[string]*Switch) Peer {
+	result := Peer{Name: e.name, IfName: e.name, NodeName: e.ns}
+
+	if e.ns == "" {
+		if sw, found := owners[e.name]; found {
+			result.NodeName = sw.Name
+		}
+	}
+
+	return result
+}
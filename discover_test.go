@@ -0,0 +1,144 @@
+package mn
+
+import (
+	"testing"
+)
+
+func TestDiscoverScheme(t *testing.T) {
+	defer tempStateFile(t)()
+
+	scheme := planScheme(t, "10.55.0.2/24", true)
+	defer scheme.Release()
+
+	if err := scheme.Apply(nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := DiscoverScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := found.GetSwitch("plan-s1")
+	if !ok {
+		t.Fatal("Expected switch plan-s1 discovered, obtained:", found)
+	}
+
+	if s.Type != SwitchLinux || len(s.Ports) != 2 {
+		t.Fatal("Unexpected switch discovered:", s)
+	}
+
+	h, ok := found.GetHost("plan-h1")
+	if !ok {
+		t.Fatal("Expected host plan-h1 discovered, obtained:", found)
+	}
+
+	if len(h.Links) != 1 {
+		t.Fatal("Expected one link, obtained:", h.Links)
+	}
+
+	link := h.Links[0]
+	expected := Peer{Name: "plan-h1-eth0", IfName: "plan-h1-eth0", NodeName: "plan-s1"}
+
+	if link.Name != "eth0" || link.Cidr != "10.55.0.2/24" || link.State != "UP" || link.Peer != expected {
+		t.Fatal("Unexpected link discovered:", link)
+	}
+
+	if len(link.Routes) != 1 || link.Routes[0] != (Route{Dst: "10.66.0.0/16", Gw: "10.55.0.1"}) {
+		t.Fatal("Unexpected routes discovered:", link.Routes)
+	}
+
+	port := s.Ports.LinkByPeer(Peer{IfName: "plan-h1-eth0", NodeName: "plan-s1"})
+	if port.Peer.NodeName != "plan-h1" || port.Peer.IfName != "eth0" {
+		t.Fatal("Unexpected port discovered:", port)
+	}
+
+	// discovered scheme is the reality, nothing to do
+	plan, err := found.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.Empty() {
+		t.Fatal("Expected no changes for discovered scheme, obtained:\n", plan)
+	}
+}
+
+func TestVethPeers(t *testing.T) {
+	veth := func(peerNsId int) map[string]*liveLink {
+		return map[string]*liveLink{
+			"eth": {Name: "eth", Kind: "veth", Index: 3, PeerIndex: 3, PeerNetNsId: peerNsId},
+		}
+	}
+
+	// two pairs with the same indexes, h1 <-> h3 and h2 <-> h4, namespace
+	// ids are local to the namespace seeing them
+	live := map[string]*liveNs{
+		"h1": {Name: "h1", Links: veth(0), NsIds: map[int]string{0: "h3", 1: "h2"}},
+		"h2": {Name: "h2", Links: veth(1), NsIds: map[int]string{0: "h1", 1: "h4"}},
+		"h3": {Name: "h3", Links: veth(0), NsIds: map[int]string{0: "h1"}},
+		"h4": {Name: "h4", Links: veth(0), NsIds: map[int]string{0: "h2"}},
+	}
+
+	peers := vethPeers(live)
+
+	expected := map[endpoint]endpoint{
+		{"h1", "eth"}: {"h3", "eth"},
+		{"h3", "eth"}: {"h1", "eth"},
+		{"h2", "eth"}: {"h4", "eth"},
+		{"h4", "eth"}: {"h2", "eth"},
+	}
+
+	if len(peers) != len(expected) {
+		t.Fatal("Expected peers", expected, "obtained", peers)
+	}
+
+	for a, b := range expected {
+		if peers[a] != b {
+			t.Fatal("Expected", a, "paired with", b, "obtained", peers[a])
+		}
+	}
+
+	// peer namespace without id isn't guessed
+	delete(live["h4"].NsIds, 0)
+
+	if peer, found := vethPeers(live)[endpoint{"h2", "eth"}]; found {
+		t.Fatal("Expected h2/eth unpaired, obtained", peer)
+	}
+}
+
+func TestHostNamespaces(t *testing.T) {
+	live := map[string]*liveNs{
+		"":        {Links: map[string]*liveLink{"s1-h1": {}, "veth0": {}}},
+		"h1":      {Links: map[string]*liveLink{"eth0": {}, "eth1": {}}},
+		"h2":      {Links: map[string]*liveLink{"eth0": {}}},
+		"owned":   {Links: map[string]*liveLink{}},
+		"foreign": {Links: map[string]*liveLink{"eth0": {}}},
+	}
+
+	// h1 is on switch s1, h2 is behind h1, foreign is plugged into a veth of
+	// root namespace, which isn't a switch port
+	peers := map[endpoint]endpoint{
+		{"", "s1-h1"}:       {"h1", "eth0"},
+		{"h1", "eth0"}:      {"", "s1-h1"},
+		{"h1", "eth1"}:      {"h2", "eth0"},
+		{"h2", "eth0"}:      {"h1", "eth1"},
+		{"", "veth0"}:       {"foreign", "eth0"},
+		{"foreign", "eth0"}: {"", "veth0"},
+	}
+
+	owners := map[string]*Switch{"s1-h1": {Name: "s1"}}
+
+	hosts := hostNamespaces(live, peers, owners, map[string]bool{"owned": true, "gone": true})
+
+	expected := map[string]bool{"h1": true, "h2": true, "owned": true}
+	if len(hosts) != len(expected) {
+		t.Fatal("Expected hosts", expected, "obtained", hosts)
+	}
+
+	for ns := range expected {
+		if !hosts[ns] {
+			t.Fatal("Expected hosts", expected, "obtained", hosts)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package mn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Dns is a tiny DNS responder, which runs in the namespace of Host and
+// answers A and AAAA queries for the scheme names, see Scheme.Names.
+// Names could be asked with or without Domain suffix. It's served by the
+// process which created the scheme, hosts' resolv.conf points to it.
+type Dns struct {
+	Host   string
+	Domain string
+	server *dnsServer
+}
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsTypeANY  = 255
+
+	dnsRcodeFormErr  = 1
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotImp   = 4
+
+	// names change between runs, don't let them be cached for long
+	dnsTTL = 5
+)
+
+type dnsServer struct {
+	conn   net.PacketConn
+	domain string
+
+	sync.RWMutex
+	names map[string][]net.IP
+}
+
+// update starts responder, if it isn't running, and replaces its names
+func (this *Dns) update(scheme *Scheme) error {
+	if this.server == nil {
+		h, err := scheme.dnsHost()
+		if err != nil {
+			return err
+		}
+
+		var conn net.PacketConn
+
+		err = h.NetNs().Do(func() error {
+			var err error
+			conn, err = net.ListenPacket("udp", ":53")
+			return err
+		})
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Unable to start DNS in %s: %v", h.Name, err))
+		}
+
+		this.server = &dnsServer{conn: conn, domain: strings.ToLower(strings.Trim(this.Domain, "."))}
+
+		go this.server.serve()
+	}
+
+	names := make(map[string][]net.IP)
+	for name, ips := range scheme.Names() {
+		names[strings.ToLower(name)] = ips
+	}
+
+	this.server.Lock()
+	this.server.names = names
+	this.server.Unlock()
+
+	return nil
+}
+
+func (this *Dns) stop() {
+	if this != nil && this.server != nil {
+		this.server.conn.Close()
+		this.server = nil
+	}
+}
+
+// resolvConf points host to the DNS host address, the one from the same
+// subnet, if any
+func (this *Dns) resolvConf(scheme *Scheme, h *Host) string {
+	var nameserver net.IP
+
+	if dnsHost, err := scheme.dnsHost(); err == nil {
+		own := make([]string, 0)
+		for _, l := range h.Links {
+			own = append(own, l.Cidrs()...)
+		}
+
+		for _, l := range dnsHost.Links {
+			for _, cidr := range l.Cidrs() {
+				ip, _, _ := net.ParseCIDR(cidr)
+
+				if nameserver == nil {
+					nameserver = ip
+				}
+
+				for _, c := range own {
+					if _, subnet, err := net.ParseCIDR(c); err == nil && subnet.Contains(ip) {
+						nameserver = ip
+					}
+				}
+			}
+		}
+	}
+
+	result := ""
+	if nameserver != nil {
+		result += "nameserver " + nameserver.String() + "\n"
+	}
+
+	if this.Domain != "" {
+		result += "search " + this.Domain + "\n"
+	}
+
+	return result
+}
+
+func (this *dnsServer) serve() {
+	buf := make([]byte, 512)
+
+	for {
+		n, addr, err := this.conn.ReadFrom(buf)
+		if err != nil {
+			if !strings.Contains(err.Error(), "use of closed") {
+				log.Println("DNS stopped:", err)
+			}
+			return
+		}
+
+		if resp := this.answer(buf[:n]); resp != nil {
+			this.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (this *dnsServer) lookup(name string) ([]net.IP, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if this.domain != "" {
+		name = strings.TrimSuffix(name, "."+this.domain)
+	}
+
+	this.RLock()
+	defer this.RUnlock()
+
+	ips, found := this.names[name]
+
+	return ips, found
+}
+
+// answer makes response to the query, nil if it isn't a query at all
+func (this *dnsServer) answer(query []byte) []byte {
+	if len(query) < 12 || query[2]&0x80 != 0 {
+		return nil
+	}
+
+	opcode := (query[2] >> 3) & 0x0f
+
+	reply := func(rcode byte, question []byte, answers [][]byte) []byte {
+		resp := make([]byte, 12)
+		copy(resp, query[:2])
+
+		// QR, opcode, AA, RD copied
+		resp[2] = 0x80 | opcode<<3 | 0x04 | query[2]&0x01
+		resp[3] = rcode
+
+		if question != nil {
+			binary.BigEndian.PutUint16(resp[4:], 1)
+		}
+
+		binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+
+		resp = append(resp, question...)
+		for _, a := range answers {
+			resp = append(resp, a...)
+		}
+
+		return resp
+	}
+
+	if opcode != 0 {
+		return reply(dnsRcodeNotImp, nil, nil)
+	}
+
+	if binary.BigEndian.Uint16(query[4:]) != 1 {
+		return reply(dnsRcodeFormErr, nil, nil)
+	}
+
+	labels := make([]string, 0)
+	i := 12
+
+	for {
+		if i >= len(query) {
+			return reply(dnsRcodeFormErr, nil, nil)
+		}
+
+		n := int(query[i])
+		i++
+
+		if n == 0 {
+			break
+		}
+
+		// compression isn't expected in a question
+		if n&0xc0 != 0 || i+n > len(query) {
+			return reply(dnsRcodeFormErr, nil, nil)
+		}
+
+		labels = append(labels, string(query[i:i+n]))
+		i += n
+	}
+
+	if i+4 > len(query) {
+		return reply(dnsRcodeFormErr, nil, nil)
+	}
+
+	qtype := binary.BigEndian.Uint16(query[i:])
+	question := query[12 : i+4]
+
+	ips, found := this.lookup(strings.Join(labels, "."))
+	if !found {
+		return reply(dnsRcodeNXDomain, question, nil)
+	}
+
+	answers := make([][]byte, 0)
+
+	for _, ip := range ips {
+		var rtype uint16
+		var data []byte
+
+		if ip4 := ip.To4(); ip4 != nil {
+			rtype, data = dnsTypeA, ip4
+		} else {
+			rtype, data = dnsTypeAAAA, ip.To16()
+		}
+
+		if qtype != rtype && qtype != dnsTypeANY {
+			continue
+		}
+
+		// pointer to the question name, type, class IN, ttl, length
+		rr := []byte{0xc0, 12, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(rr[2:], rtype)
+		binary.BigEndian.PutUint32(rr[6:], dnsTTL)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(data)))
+
+		answers = append(answers, append(rr, data...))
+	}
+
+	return reply(0, question, answers)
+}
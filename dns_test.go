@@ -0,0 +1,158 @@
+package mn
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dnsQuery(name string, qtype uint16) []byte {
+	query := []byte{0x12, 0x34, 0x01, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+
+	for _, label := range strings.Split(name, ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+
+	query = append(query, 0, 0, 0, 0, 1)
+	binary.BigEndian.PutUint16(query[len(query)-4:], qtype)
+
+	return query
+}
+
+// dnsAnswers returns rcode and addresses from the response to dnsQuery
+func dnsAnswers(t *testing.T, query, resp []byte) (byte, []string) {
+	if len(resp) < len(query) || resp[0] != 0x12 || resp[1] != 0x34 || resp[2]&0x80 == 0 {
+		t.Fatal("Unexpected response:", resp)
+	}
+
+	result := make([]string, 0)
+
+	rest := resp[len(query):]
+	for i := 0; i < int(binary.BigEndian.Uint16(resp[6:])); i++ {
+		size := int(binary.BigEndian.Uint16(rest[10:]))
+		result = append(result, net.IP(rest[12:12+size]).String())
+		rest = rest[12+size:]
+	}
+
+	return resp[3] & 0x0f, result
+}
+
+func TestDnsAnswer(t *testing.T) {
+	server := &dnsServer{domain: "mn", names: map[string][]net.IP{
+		"h1":      {net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")},
+		"h1-eth0": {net.ParseIP("10.0.0.1")},
+	}}
+
+	cases := []struct {
+		name     string
+		qtype    uint16
+		rcode    byte
+		expected []string
+	}{
+		{"h1", dnsTypeA, 0, []string{"10.0.0.1"}},
+		{"H1-eth0.mn", dnsTypeA, 0, []string{"10.0.0.1"}},
+		{"h1", dnsTypeAAAA, 0, []string{"fd00::1"}},
+		{"h1", dnsTypeANY, 0, []string{"10.0.0.1", "fd00::1"}},
+		{"h1-eth0", dnsTypeAAAA, 0, []string{}},
+		{"h2", dnsTypeA, dnsRcodeNXDomain, []string{}},
+	}
+
+	for _, c := range cases {
+		query := dnsQuery(c.name, c.qtype)
+
+		rcode, answers := dnsAnswers(t, query, server.answer(query))
+		if rcode != c.rcode || strings.Join(answers, ",") != strings.Join(c.expected, ",") {
+			t.Fatal("Unexpected answer for", c.name, c.qtype, "obtained:", rcode, answers)
+		}
+	}
+
+	if resp := server.answer([]byte{1, 2, 3}); resp != nil {
+		t.Fatal("Expected short packet ignored, obtained:", resp)
+	}
+}
+
+func TestSchemeDns(t *testing.T) {
+	defer tempStateFile(t)()
+
+	dir, err := ioutil.TempDir("", "mn-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	orig := HostsDir
+	HostsDir = dir
+	defer func() { HostsDir = orig }()
+
+	scheme := planScheme(t, "10.55.0.2/24", true)
+	scheme.Dns = &Dns{Host: "plan-h2", Domain: "mn"}
+
+	defer scheme.Release()
+
+	if err := scheme.Apply(nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(dir + "/plan-h1/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), "10.55.0.3\tplan-h2-eth0.mn plan-h2-eth0 plan-h2\n") {
+		t.Fatal("Expected plan-h2 in hosts file, obtained:", string(data))
+	}
+
+	data, err = ioutil.ReadFile(dir + "/plan-h1/resolv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "nameserver 10.55.0.3\nsearch mn\n" {
+		t.Fatal("Unexpected resolv.conf:", string(data))
+	}
+
+	h1, _ := scheme.GetHost("plan-h1")
+
+	// new link shows up without restart
+	h1.Links[0].Cidr = "10.55.0.4/24"
+	if err := scheme.UpdateHosts(); err != nil {
+		t.Fatal(err)
+	}
+
+	query := dnsQuery("plan-h1-eth0.mn", dnsTypeA)
+	resp := make([]byte, 512)
+
+	err = h1.NetNs().Do(func() error {
+		conn, err := net.Dial("udp", "10.55.0.3:53")
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(time.Second))
+
+		if _, err := conn.Write(query); err != nil {
+			return err
+		}
+
+		n, err := conn.Read(resp)
+		resp = resp[:n]
+
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcode, answers := dnsAnswers(t, query, resp)
+	if rcode != 0 || len(answers) != 1 || answers[0] != "10.55.0.4" {
+		t.Fatal("Unexpected answer:", rcode, answers)
+	}
+}
@@ -0,0 +1,195 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RecoverWorkers bounds the number of build steps Recover runs at once
+var RecoverWorkers = 8
+
+// BuildStep is a finished step of a build and the time it took
+type BuildStep struct {
+	Kind     string
+	Name     string
+	Duration time.Duration
+}
+
+// BuildReport tells where build time goes. Total is the wall time, it is
+// less than the sum of steps, when they run concurrently.
+type BuildReport struct {
+	Total time.Duration
+	Steps []BuildStep
+}
+
+// String returns count, total and max time of every step kind, and the
+// slowest steps
+func (this BuildReport) String() string {
+	if len(this.Steps) == 0 {
+		return fmt.Sprintf("Nothing to build, %v", this.Total)
+	}
+
+	kinds := make([]string, 0)
+	counts := make(map[string]int)
+	totals := make(map[string]time.Duration)
+	maxs := make(map[string]time.Duration)
+
+	for _, step := range this.Steps {
+		if counts[step.Kind] == 0 {
+			kinds = append(kinds, step.Kind)
+		}
+
+		counts[step.Kind]++
+		totals[step.Kind] += step.Duration
+
+		if step.Duration > maxs[step.Kind] {
+			maxs[step.Kind] = step.Duration
+		}
+	}
+
+	lines := []string{fmt.Sprintf("Built %d steps in %v", len(this.Steps), this.Total)}
+
+	for _, kind := range kinds {
+		lines = append(lines, fmt.Sprintf("  %-8s %4d  total %-12v max %v", kind, counts[kind], totals[kind], maxs[kind]))
+	}
+
+	slowest := append([]BuildStep{}, this.Steps...)
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].Duration > slowest[j].Duration
+	})
+
+	if len(slowest) > 5 {
+		slowest = slowest[:5]
+	}
+
+	lines = append(lines, "Slowest:")
+	for _, step := range slowest {
+		lines = append(lines, fmt.Sprintf("  %-8s %-30s %v", step.Kind, step.Name, step.Duration))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// graphStep runs once all of its dependencies are done
+type graphStep struct {
+	kind     string
+	name     string
+	run      func() error
+	next     []*graphStep
+	waiting  int
+	done     bool
+	err      error
+	duration time.Duration
+}
+
+// buildGraph is a set of steps with dependencies between them, steps
+// which don't depend on each other run concurrently
+type buildGraph struct {
+	steps []*graphStep
+}
+
+// add appends step depending on given ones, nil dependencies are
+// skipped, so steps for objects which already exist could be omitted
+func (this *buildGraph) add(kind, name string, run func() error, deps ...*graphStep) *graphStep {
+	step := &graphStep{kind: kind, name: name, run: run}
+
+	for _, dep := range deps {
+		if dep == nil {
+			continue
+		}
+
+		dep.next = append(dep.next, step)
+		step.waiting++
+	}
+
+	this.steps = append(this.steps, step)
+
+	return step
+}
+
+// run executes the graph with at most workers steps at once. After the
+// first error no new steps are started, the running ones are waited for
+// and the error is returned.
+func (this *buildGraph) run(workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ready := make([]*graphStep, 0)
+	for _, step := range this.steps {
+		if step.waiting == 0 {
+			ready = append(ready, step)
+		}
+	}
+
+	finished := make(chan *graphStep)
+	running := 0
+
+	var failed error
+
+	for running > 0 || (failed == nil && len(ready) > 0) {
+		for failed == nil && len(ready) > 0 && running < workers {
+			step := ready[0]
+			ready = ready[1:]
+			running++
+
+			go func() {
+				start := time.Now()
+				step.err = step.run()
+				step.duration = time.Since(start)
+
+				finished <- step
+			}()
+		}
+
+		step := <-finished
+		running--
+		step.done = true
+
+		if step.err != nil {
+			log.Println("Build step", step.kind, step.name, "failed:", step.err)
+
+			if failed == nil {
+				failed = step.err
+			}
+
+			continue
+		}
+
+		for _, next := range step.next {
+			next.waiting--
+			if next.waiting == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	if failed != nil {
+		return failed
+	}
+
+	for _, step := range this.steps {
+		if !step.done {
+			return errors.New(fmt.Sprintf("Build step %s %s has cyclic dependencies", step.kind, step.name))
+		}
+	}
+
+	return nil
+}
+
+// report returns finished steps in the order they were added
+func (this *buildGraph) report(total time.Duration) *BuildReport {
+	report := &BuildReport{Total: total, Steps: make([]BuildStep, 0)}
+
+	for _, step := range this.steps {
+		if step.done {
+			report.Steps = append(report.Steps, BuildStep{step.kind, step.name, step.duration})
+		}
+	}
+
+	return report
+}
@@ -1,20 +1,26 @@
 package mn
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"os"
-	"time"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/NodePrime/open-mininet/cgroup"
 )
 
 type Host struct {
-	Cgroup *Cgroup
-	Name   string
-	netns  *NetNs
-	Links  Links
-	Procs  Procs
+	Cgroup     *Cgroup
+	Isolation  *Isolation
+	Name       string
+	netns      *NetNs
+	Links      Links
+	Procs      Procs
+	supervisor *Supervisor
 }
 
 func (this Host) String() string {
@@ -73,6 +79,7 @@ func (this *Host) UnmarshalJSON(b []byte) error {
 	this.netns = &NetNs{name: host.Name}
 	this.Procs = host.Procs
 	this.Cgroup = host.Cgroup
+	this.Isolation = host.Isolation
 
 	if !this.netns.Exists() {
 		if err := this.NetNs().Create(); err != nil {
@@ -98,95 +105,94 @@ func (this *Host) RunProcess(args ...string) (*Process, error) {
 }
 
 func (this *Host) runProcess(args ...string) (*Process, error) {
-	var command []string
-
-	if this.Cgroup != nil {
-		command = this.Cgroup.CgExecCommand()
-	}
+	p := &Process{Command: args[0], Args: args[1:]}
 
-	ipCmd := FullPathFor("ip")
-	if ipCmd == "" {
-		return nil, errors.New("ip command not found the PATH")
+	if err := this.Supervisor().start(p); err != nil {
+		return nil, err
 	}
 
-	if this.NetNs() != nil {
-		command = append(command, []string{ipCmd, "netns", "exec", this.NetNs().Name()}...)
-	}
+	return p, nil
+}
 
-	command = append(command, args...)
+// RunCommand runs command in the host namespace and cgroup and returns
+// its combined output.
+func (this Host) RunCommand(args ...string) (string, error) {
+	var out bytes.Buffer
 
-	p := &Process{Command: args[0], Args: args[1:]}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
 
-	fname := fmt.Sprintf("/tmp/output.%d", time.Now().Nanosecond())
-	pout, err := os.Create(fname)
-	if err != nil {
-		log.Println("Unable to create temp file", fname, "for process stderr/stdout")
-		p.attr.Files = []*os.File{nil, os.Stdout, os.Stderr}
+	if err := this.spawn(cmd.SysProcAttr, cmd.Start); err != nil {
+		return out.String(), err
 	}
 
-	// var procAttr os.ProcAttr
-	// procAttr.Files = []*os.File{nil, os.Stdout, os.Stderr}
-	p.attr.Files = []*os.File{nil, pout, pout}
+	err := cmd.Wait()
 
-	p.Output = fname
+	return out.String(), err
+}
 
-	process, err := os.StartProcess(command[0], command, &p.attr)
-	if err != nil {
-		return nil, err
+// spawn calls start, which forks a process, with the thread switched to
+// the host namespace. The process is born in the host cgroup: for v2 it's
+// cloned right into the group, for v1 the forking thread is moved there.
+// Isolated hosts fork from a throwaway thread, which joins namespaces of
+// the host init.
+func (this Host) spawn(sys *syscall.SysProcAttr, start func() error) error {
+	sys.UseCgroupFD = false
+
+	run := start
+	if this.Isolation.enabled() {
+		run = func() error {
+			return this.enterInit(start)
+		}
 	}
 
-	// detach process
-	// process.Release()
-
-	p.Process = process
+	if this.Cgroup != nil && cgroup.GetVersion() == cgroup.V1 {
+		run = func(run func() error) func() error {
+			return func() error {
+				return this.Cgroup.Do(run)
+			}
+		}(run)
+	}
 
-	fmt.Println("Started", command, "All output goes to", fname)
+	// NetNs.Do runs on a thread of its own, so thread is moved to v1 group
+	// there
+	if !this.Isolation.enabled() && this.NetNs() != nil {
+		run = func(run func() error) func() error {
+			return func() error {
+				return this.NetNs().Do(run)
+			}
+		}(run)
+	}
 
-	go func() {
-		pid := p.Pid
-		s, err := process.Wait()
+	if this.Cgroup != nil && cgroup.GetVersion() == cgroup.V2 {
+		dir, err := this.Cgroup.Open()
 		if err != nil {
-			panic(err)
-		}
-
-		for i, _ := range this.Procs {
-			if this.Procs[i].Process == nil {
-				continue
-			}
-			if this.Procs[i].Pid == pid {
-				this.Procs[i].Pid = 0
-			}
+			return err
 		}
+		defer dir.Close()
 
-		log.Printf("Process [%d] %v finished with %v, %v", pid, command, s.Exited(), s.String())
-	}()
-
-	return p, nil
-}
-
-func (this Host) RunCommand(args ...string) (string, error) {
-	var command []string
-
-	if this.Cgroup != nil {
-		command = this.Cgroup.CgExecCommand()
+		sys.UseCgroupFD = true
+		sys.CgroupFD = int(dir.Fd())
 	}
 
-	ipCmd := FullPathFor("ip")
-	if ipCmd == "" {
-		return "", errors.New("ip command not found the PATH")
+	if this.Isolation.enabled() {
+		return throwawayThread(run)
 	}
 
-	if this.NetNs() != nil {
-		command = append(command, []string{ipCmd, "netns", "exec", this.NetNs().Name()}...)
-	}
-
-	command = append(command, args...)
-
-	return RunCommand(command[0], command[1:]...)
+	return run()
 }
 
 func (this Host) EnableForwarding() error {
-	_, err := this.RunCommand("sysctl", "net.ipv4.ip_forward=1")
+	args := []string{"sysctl", "net.ipv4.ip_forward=1"}
+
+	// kernel could be built or booted without IPv6
+	if _, err := os.Stat("/proc/sys/net/ipv6"); err == nil {
+		args = append(args, "net.ipv6.conf.all.forwarding=1")
+	}
+
+	_, err := this.RunCommand(args...)
 	return err
 }
 
@@ -218,22 +224,103 @@ func (this Host) GetLinks() Links {
 	return this.Links
 }
 
-func (this Host) Release() error {
-	if err := this.netns.Release(); err != nil {
-		log.Println(err)
+// Release stops processes, removes links, namespace, cgroup and files of
+// the host. Errors and objects left are returned as TeardownError.
+func (this *Host) Release() error {
+	result := &TeardownError{}
+
+	this.stopProcs(result)
+	this.releaseLinks(result)
+	this.releaseNs(result)
+	this.releaseCgroup(result)
+	this.releaseFiles(result)
+
+	result.Leaked = this.leaked()
+
+	return result.err()
+}
+
+// stopProcs stops processes at once, every Stop waits for its process to
+// exit. Supervised processes are stopped also between restarts.
+func (this *Host) stopProcs(result *TeardownError) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for _, proc := range this.Procs {
+		// never started
+		if proc.GetPid() == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(proc *Process, pid int) {
+			defer wg.Done()
+
+			if err := proc.Stop(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+				mu.Lock()
+				result.add(errors.New(fmt.Sprintf("Unable to stop %s [%d] %s: %v", this.Name, pid, proc.Command, err)))
+				mu.Unlock()
+			}
+		}(proc, proc.GetPid())
 	}
 
+	wg.Wait()
+}
+
+// releaseLinks goes before namespace, which could outlive its mount
+// point, e.g. while DNS socket is open in it, and keep them
+func (this *Host) releaseLinks(result *TeardownError) {
 	for _, link := range this.Links {
-		link.Release()
+		result.add(link.remove())
 	}
+}
+
+func (this *Host) releaseNs(result *TeardownError) {
+	if this.Isolation.enabled() {
+		this.releaseInit()
+	}
+
+	if this.netns == nil || !this.netns.Exists() {
+		return
+	}
+
+	result.add(this.netns.Release())
+}
+
+func (this *Host) releaseCgroup(result *TeardownError) {
+	if err := this.Cgroup.Release(); err != nil {
+		result.add(errors.New(fmt.Sprintf("Unable to delete cgroup %s: %v", this.Cgroup.Name, err)))
+	}
+}
+
+func (this *Host) releaseFiles(result *TeardownError) {
+	result.add(os.RemoveAll(this.etcDir()))
+	disown(ownedFile, this.etcDir())
+}
+
+// leaked returns objects of the host, which are still there
+func (this *Host) leaked() []string {
+	result := make([]string, 0)
 
 	for _, proc := range this.Procs {
-		proc.Stop()
+		if proc.alive() {
+			result = append(result, fmt.Sprintf("process %s [%d] %s", this.Name, proc.GetPid(), proc.Command))
+		}
 	}
 
-	this.Cgroup.Release()
+	if this.netns != nil && this.netns.Exists() {
+		result = append(result, "netns "+this.Name)
+	}
 
-	return nil
+	if this.Cgroup != nil && this.Cgroup.Exists() {
+		result = append(result, "cgroup "+this.Cgroup.Name)
+	}
+
+	if _, err := os.Stat(this.etcDir()); err == nil {
+		result = append(result, "file "+this.etcDir())
+	}
+
+	return result
 }
 
 func (this *Host) AddLink(l Link) error {
@@ -241,30 +328,31 @@ func (this *Host) AddLink(l Link) error {
 	return nil
 }
 
-func (this *Host) recoverProcs() error {
+func (this *Host) recoverProcs(journal *Journal) error {
+	taken := make(map[int]bool)
+
 	for i, proc := range this.Procs {
 		fmt.Println("Recovering ", proc.Command, proc.Args)
 
-		var p *os.Process
-		var err error
-
-		if p, err = proc.findProcessByName(this.NetNs().Name()); err != nil {
+		adopted, err := proc.adopt(this.NetNs().Name(), taken)
+		if err != nil {
 			return err
 		}
 
-		if p != nil {
-			proc.Process = p
-		} else {
-			c := append([]string{proc.Command}, proc.Args...)
-			result, err := this.runProcess(c...)
-			if err != nil {
-				return err
-			}
-
-			this.Procs[i].Process = result.Process
-
+		if adopted != nil {
 			continue
 		}
+
+		if err := this.Supervisor().start(this.Procs[i]); err != nil {
+			return err
+		}
+
+		started := this.Procs[i]
+
+		journal.Record("process", fmt.Sprintf("%s [%d] %s", this.Name, started.GetPid(), started.Command), func() error {
+			started.Stop()
+			return nil
+		})
 	}
 
 	return nil
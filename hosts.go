@@ -0,0 +1,112 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// Names returns addresses of the scheme hosts by name. Every host is
+// known as its name, resolving to all its addresses, and every link as
+// "name-ifname".
+func (this Scheme) Names() map[string][]net.IP {
+	result := make(map[string][]net.IP)
+
+	for _, h := range this.Hosts {
+		for _, l := range h.Links {
+			for _, cidr := range l.Cidrs() {
+				ip, _, _ := net.ParseCIDR(cidr)
+
+				result[h.Name] = append(result[h.Name], ip)
+				result[h.Name+"-"+l.Name] = append(result[h.Name+"-"+l.Name], ip)
+			}
+		}
+	}
+
+	return result
+}
+
+// HostsFile returns /etc/hosts content for the host
+func (this Scheme) HostsFile(host string) string {
+	lines := []string{
+		"127.0.0.1\tlocalhost",
+		"::1\tlocalhost ip6-localhost ip6-loopback",
+		"127.0.1.1\t" + host,
+	}
+
+	for _, h := range this.Hosts {
+		for _, l := range h.Links {
+			names := h.Name + "-" + l.Name + " " + h.Name
+			if this.Dns != nil && this.Dns.Domain != "" {
+				names = h.Name + "-" + l.Name + "." + this.Dns.Domain + " " + names
+			}
+
+			for _, cidr := range l.Cidrs() {
+				ip, _, _ := net.ParseCIDR(cidr)
+				lines = append(lines, ip.String()+"\t"+names)
+			}
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// UpdateHosts writes hosts files of all hosts to HostsDir and, if Dns
+// is set, their resolv.conf, and updates names the DNS responder answers
+// for. Files are rewritten in place, so bind mounts of isolated hosts
+// see the changes.
+func (this *Scheme) UpdateHosts() error {
+	for _, h := range this.Hosts {
+		dir := h.etcDir()
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		own(ownedFile, dir)
+
+		if err := rewrite(dir+"/hosts", this.HostsFile(h.Name)); err != nil {
+			return err
+		}
+
+		if this.Dns == nil {
+			continue
+		}
+
+		if err := rewrite(dir+"/resolv.conf", this.Dns.resolvConf(this, h)); err != nil {
+			return err
+		}
+	}
+
+	if this.Dns != nil {
+		return this.Dns.update(this)
+	}
+
+	return nil
+}
+
+// rewrite replaces file content keeping the inode
+func rewrite(fname, content string) error {
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (this Scheme) dnsHost() (*Host, error) {
+	h, found := this.GetHost(this.Dns.Host)
+	if !found {
+		return nil, errors.New(fmt.Sprintf("DNS host %s not found in the scheme", this.Dns.Host))
+	}
+
+	return h, nil
+}
@@ -0,0 +1,285 @@
+package mn
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netns"
+)
+
+// Isolation enables namespaces of a host besides the network one. They are
+// held by mn-init process, which is started once per host: with Uts it
+// sets hostname to the host name, with Pid it's the init of the host and
+// reaps orphans, with Mount it mounts tmpfs over /var/run and /tmp, and
+// binds host own /etc/hosts and /etc/resolv.conf. Host processes join
+// these namespaces.
+type Isolation struct {
+	Uts   bool
+	Pid   bool
+	Mount bool
+}
+
+// HostsDir keeps hosts and resolv.conf files of isolated hosts, they are
+// bound to /etc of the host mount namespace
+var HostsDir = "/var/run/mn/hosts"
+
+// InitCommand is the init of isolated hosts, it's looked up in the PATH
+const InitCommand = "mn-init"
+
+// how long to wait for init to get ready
+const initTimeout = 5 * time.Second
+
+func (this *Isolation) enabled() bool {
+	return this != nil && (this.Uts || this.Pid || this.Mount)
+}
+
+func (this Isolation) cloneflags() uintptr {
+	var flags uintptr
+
+	if this.Uts {
+		flags |= syscall.CLONE_NEWUTS
+	}
+
+	if this.Pid {
+		flags |= syscall.CLONE_NEWPID
+	}
+
+	if this.Mount {
+		flags |= syscall.CLONE_NEWNS
+	}
+
+	return flags
+}
+
+// namespaces returns /proc/<pid>/ns entries to join, the mount one goes
+// last, after it paths of the root mount namespace aren't reachable
+func (this Isolation) namespaces() []string {
+	result := []string{"net"}
+
+	if this.Uts {
+		result = append(result, "uts")
+	}
+
+	if this.Pid {
+		result = append(result, "pid")
+	}
+
+	if this.Mount {
+		result = append(result, "mnt")
+	}
+
+	return result
+}
+
+func initArgs(name string) []string {
+	return []string{InitCommand, "-name", name}
+}
+
+// isInit tells whether cmdline is init of the host
+func isInit(name, cmdline string) bool {
+	prefix := strings.Join(initArgs(name), " ")
+	return cmdline == prefix || strings.HasPrefix(cmdline, prefix+" ")
+}
+
+// initPid finds mn-init of the host, 0 if it isn't running
+func (this Host) initPid() int {
+	procs, err := nsProcs(this.Name)
+	if err != nil {
+		return 0
+	}
+
+	for pid, cmd := range procs {
+		if isInit(this.Name, cmd) {
+			return pid
+		}
+	}
+
+	return 0
+}
+
+// startInit runs mn-init in the host netns and cgroup with new namespaces
+// and waits until it reports the namespaces are ready.
+func (this Host) startInit() (int, error) {
+	command, err := exec.LookPath(InitCommand)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := this.writeEtc(); err != nil {
+		return 0, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	args := initArgs(this.Name)
+	if this.Isolation.Uts {
+		args = append(args, "-uts")
+	}
+
+	if this.Isolation.Mount {
+		args = append(args, "-mount", "-etc", this.etcDir())
+	}
+
+	if this.Isolation.Pid && this.Isolation.Mount {
+		args = append(args, "-proc")
+	}
+
+	attr := &os.ProcAttr{
+		Files: []*os.File{nil, nil, os.Stderr, w},
+		Sys: &syscall.SysProcAttr{
+			Cloneflags: this.Isolation.cloneflags(),
+			Setsid:     true,
+		},
+	}
+
+	var process *os.Process
+
+	host := this
+	host.Isolation = nil
+
+	err = host.spawn(attr.Sys, func() error {
+		var err error
+		process, err = os.StartProcess(command, args, attr)
+		return err
+	})
+
+	w.Close()
+
+	if err != nil {
+		return 0, err
+	}
+
+	go process.Wait()
+
+	ready := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if strings.TrimSpace(line) != "ok" {
+			ready <- errors.New(fmt.Sprintf("%s of %s failed: %s %v", InitCommand, this.Name, line, err))
+			return
+		}
+
+		ready <- nil
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(initTimeout):
+		err = errors.New(fmt.Sprintf("%s of %s isn't ready in %v", InitCommand, this.Name, initTimeout))
+	}
+
+	if err != nil {
+		process.Kill()
+		return 0, err
+	}
+
+	return process.Pid, nil
+}
+
+// enterInit switches the current thread to the namespaces of host init.
+// Thread mustn't be reused afterwards, joining the mount namespace needs
+// own fs attributes, which can't be shared back.
+func (this Host) enterInit(start func() error) error {
+	pid := this.initPid()
+	if pid == 0 {
+		var err error
+		if pid, err = this.startInit(); err != nil {
+			return err
+		}
+	}
+
+	if this.Isolation.Mount {
+		if err := syscall.Unshare(syscall.CLONE_FS); err != nil {
+			return errors.New(fmt.Sprintf("Unable to unshare fs attributes: %v", err))
+		}
+	}
+
+	for _, ns := range this.Isolation.namespaces() {
+		if err := setnsPath(fmt.Sprintf("/proc/%d/ns/%s", pid, ns)); err != nil {
+			return errors.New(fmt.Sprintf("Unable to join %s namespace of %s: %v", ns, this.Name, err))
+		}
+	}
+
+	return start()
+}
+
+// releaseInit kills host init, for pid namespace it means all processes
+// of the host are killed.
+func (this Host) releaseInit() {
+	if pid := this.initPid(); pid != 0 {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
+
+func (this Host) etcDir() string {
+	return HostsDir + "/" + this.Name
+}
+
+// writeEtc makes host own hosts and resolv.conf, unless they exist
+func (this Host) writeEtc() error {
+	if err := os.MkdirAll(this.etcDir(), 0755); err != nil {
+		return err
+	}
+
+	own(ownedFile, this.etcDir())
+
+	hosts := this.etcDir() + "/hosts"
+	if _, err := os.Stat(hosts); os.IsNotExist(err) {
+		content := "127.0.0.1\tlocalhost\n" +
+			"::1\tlocalhost ip6-localhost ip6-loopback\n" +
+			"127.0.1.1\t" + this.Name + "\n"
+
+		if err := ioutil.WriteFile(hosts, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	resolv := this.etcDir() + "/resolv.conf"
+	if _, err := os.Stat(resolv); os.IsNotExist(err) {
+		content, err := ioutil.ReadFile("/etc/resolv.conf")
+		if err != nil {
+			content = []byte{}
+		}
+
+		if err := ioutil.WriteFile(resolv, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// throwawayThread calls f on a locked thread, which is never unlocked, so
+// runtime terminates it when f returns.
+func throwawayThread(f func() error) error {
+	result := make(chan error, 1)
+
+	go func() {
+		runtime.LockOSThread()
+		result <- f()
+	}()
+
+	return <-result
+}
+
+func setnsPath(path string) error {
+	ns, err := netns.GetFromPath(path)
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	return netns.Setns(ns, 0)
+}
@@ -0,0 +1,149 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Journal records kernel and switch objects created by a build, so they
+// can be removed if the build fails halfway. Rollback undoes them in the
+// reverse order, unless Keep is set to leave the partial state for
+// debugging. Commit forgets them, once the build is done.
+type Journal struct {
+	Keep bool
+
+	sync.Mutex
+	entries []journalEntry
+}
+
+type journalEntry struct {
+	object string
+	name   string
+	undo   func() error
+}
+
+func NewJournal() *Journal {
+	return &Journal{entries: make([]journalEntry, 0)}
+}
+
+// Record adds created object and the way to remove it. Nil journal
+// records nothing, so builds could run without one.
+func (this *Journal) Record(object, name string, undo func() error) {
+	if this == nil {
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.entries = append(this.entries, journalEntry{object, name, undo})
+}
+
+// Entries describes recorded objects in the order they were created
+func (this *Journal) Entries() []string {
+	if this == nil {
+		return nil
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	result := make([]string, 0)
+	for _, e := range this.entries {
+		result = append(result, e.object+" "+e.name)
+	}
+
+	return result
+}
+
+// Commit forgets recorded objects, they stay
+func (this *Journal) Commit() {
+	if this == nil {
+		return
+	}
+
+	this.Lock()
+	this.entries = this.entries[:0]
+	this.Unlock()
+}
+
+// Rollback removes recorded objects, the last created goes first. All of
+// them are tried, errors are collected. With Keep nothing is removed,
+// objects are only logged.
+func (this *Journal) Rollback() error {
+	if this == nil {
+		return nil
+	}
+
+	this.Lock()
+	entries := this.entries
+	this.entries = make([]journalEntry, 0)
+	this.Unlock()
+
+	if this.Keep {
+		for _, e := range entries {
+			log.Println("Keeping", e.object, e.name)
+		}
+
+		return nil
+	}
+
+	failed := make([]string, 0)
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+
+		if err := e.undo(); err != nil {
+			log.Println("Unable to remove", e.object, e.name, err)
+			failed = append(failed, fmt.Sprintf("%s %s: %v", e.object, e.name, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New("Rollback failed: " + strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// Done finishes build with its result: commits on success, rolls back
+// on error. Error of the build is returned, rollback errors are added.
+func (this *Journal) Done(err error) error {
+	if err == nil {
+		this.Commit()
+		return nil
+	}
+
+	if rerr := this.Rollback(); rerr != nil {
+		return errors.New(fmt.Sprintf("%v, %v", err, rerr))
+	}
+
+	return err
+}
+
+// recordPair journals veth pair, deleting one end removes both. Addresses
+// stay allocated in the pool, they belong to the scheme.
+func (this *Journal) recordPair(pair Pair) {
+	this.Record("link", linkTarget(pair.Left)+" <-> "+linkTarget(pair.Right), func() error {
+		return dropLink(pair.Left)
+	})
+}
+
+// recordPort journals link attached to the switch
+func (this *Journal) recordPort(s *Switch, l Link) {
+	this.Record("port", s.Name+" "+l.Name, func() error {
+		b, err := s.backend()
+		if err != nil {
+			return err
+		}
+
+		if !b.Exists(s.Name) || !s.HasPort(l.Name) {
+			return nil
+		}
+
+		return b.DelPort(s.Name, l.Name)
+	})
+}
@@ -0,0 +1,109 @@
+package mn
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJournalRollback(t *testing.T) {
+	undone := make([]string, 0)
+
+	j := NewJournal()
+	for _, name := range []string{"a", "b", "c"} {
+		n := name
+		j.Record("object", n, func() error {
+			undone = append(undone, n)
+			if n == "b" {
+				return errors.New("busy")
+			}
+			return nil
+		})
+	}
+
+	err := j.Done(errors.New("build failed"))
+	if err == nil || !strings.Contains(err.Error(), "build failed") || !strings.Contains(err.Error(), "object b: busy") {
+		t.Fatal("Expected build and rollback errors, obtained", err)
+	}
+
+	if strings.Join(undone, ",") != "c,b,a" {
+		t.Fatal("Expected reverse order, obtained", undone)
+	}
+
+	if len(j.Entries()) != 0 {
+		t.Fatal("Expected empty journal after rollback, obtained", j.Entries())
+	}
+
+	j.Record("object", "d", func() error {
+		t.Fatal("Expected committed object kept")
+		return nil
+	})
+
+	if err := j.Done(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	j.Keep = true
+	j.Record("object", "e", func() error {
+		t.Fatal("Expected object kept with Keep")
+		return nil
+	})
+
+	if err := j.Done(errors.New("build failed")); err == nil || err.Error() != "build failed" {
+		t.Fatal("Expected build error, obtained", err)
+	}
+
+	var none *Journal
+	none.Record("object", "f", nil)
+	if err := none.Done(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// brokenScheme fails on the second host, its route gateway is unreachable
+func brokenScheme(t *testing.T) *Scheme {
+	scheme := planScheme(t, "10.55.0.2/24", true)
+
+	h2, _ := scheme.GetHost("plan-h2")
+	h2.Links[0].Routes = []Route{{Dst: "10.77.0.0/16", Gw: "10.99.0.1"}}
+
+	return scheme
+}
+
+func TestSchemeRecoverRollback(t *testing.T) {
+	scheme := brokenScheme(t)
+	defer scheme.Release()
+
+	if err := scheme.Recover(); err == nil {
+		t.Fatal("Expected error recovering scheme with unreachable gateway")
+	}
+
+	s, _ := scheme.GetSwitch("plan-s1")
+	if s.Exists() {
+		t.Fatal("Expected switch removed by rollback")
+	}
+
+	for _, h := range scheme.Hosts {
+		if h.NetNs().Exists() {
+			t.Fatal("Expected netns", h.Name, "removed by rollback")
+		}
+	}
+
+	for _, port := range s.Ports {
+		if port.Exists() {
+			t.Fatal("Expected link", port.Name, "removed by rollback")
+		}
+	}
+
+	scheme = brokenScheme(t)
+	scheme.KeepPartial = true
+
+	if err := scheme.Recover(); err == nil {
+		t.Fatal("Expected error recovering scheme with unreachable gateway")
+	}
+
+	h1, _ := scheme.GetHost("plan-h1")
+	if !h1.NetNs().Exists() {
+		t.Fatal("Expected partial state kept")
+	}
+}
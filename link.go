@@ -3,6 +3,7 @@ package mn
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"reflect"
 
@@ -19,6 +20,15 @@ type Route struct {
 	Gw  string
 }
 
+// Ipv6 controls kernel IPv6 behaviour of the link. Static addresses of
+// emulated networks are usually wanted without autoconfigured ones and
+// usable right away, without duplicate address detection.
+type Ipv6 struct {
+	Disable bool
+	NoSlaac bool
+	NoDad   bool
+}
+
 type Peer struct {
 	Name     string
 	IfName   string
@@ -27,12 +37,15 @@ type Peer struct {
 
 type Link struct {
 	Cidr      string
+	Addrs     []string
+	Ipv6      *Ipv6
 	HwAddr    string
 	Name      string
 	NodeName  string
 	NetNs     string
 	State     string
 	Routes    []Route
+	Shaping   *Shaping
 	PeerName  string
 	Peer      Peer
 	patch     bool
@@ -55,14 +68,22 @@ func NewLink(left, right Node, refs ...Link) Pair {
 		break
 	}
 
-	if reflect.TypeOf(left).Elem() == reflect.TypeOf(right).Elem() && reflect.TypeOf(left).Elem() == reflect.TypeOf(Switch{}) {
+	if canPatch(left, right) {
 		result.Left = result.Left.SetNodeName(left).SetName(left, "pp").SetPatch()
 		result.Right = result.Right.SetNodeName(right).SetName(right, "pp").SetPatch()
+	} else if isSwitch(left) && isSwitch(right) {
+		// switches without patch ports support are connected
+		// with plain veth pair, without any addresses
+		result.Left = result.Left.SetNoIp().SetHwAddr().
+			SetName(left, "pp").SetNodeName(left).SetState("DOWN")
+
+		result.Right = result.Right.SetNoIp().SetHwAddr().
+			SetName(right, "pp").SetNodeName(right).SetState("DOWN")
 	} else {
-		result.Left = result.Left.SetCidr().SetHwAddr().
+		result.Left = result.Left.SetCidr().SetCidr6().SetHwAddr().
 			SetNetNs(left).SetName(right, "eth").SetNodeName(left).SetState("DOWN").SetRoute()
 
-		result.Right = result.Right.SetCidr().SetHwAddr().
+		result.Right = result.Right.SetCidr().SetCidr6().SetHwAddr().
 			SetNetNs(right).SetName(right, "eth").SetNodeName(right).SetState("DOWN").SetRoute()
 	}
 
@@ -72,6 +93,20 @@ func NewLink(left, right Node, refs ...Link) Pair {
 	return result
 }
 
+func isSwitch(n Node) bool {
+	return reflect.TypeOf(n).Elem() == reflect.TypeOf(Switch{})
+}
+
+// canPatch reports whether both nodes are switches, which can be
+// connected with patch ports
+func canPatch(left, right Node) bool {
+	if !isSwitch(left) || !isSwitch(right) {
+		return false
+	}
+
+	return left.(*Switch).SupportsPatch() && right.(*Switch).SupportsPatch()
+}
+
 // type link Link
 //
 // func (this *Link) UnmarshalJSON(b []byte) error {
@@ -94,23 +129,7 @@ func (this Pair) ByNodeName(n Node) Link {
 }
 
 func (this Pair) Create() error {
-	command := []string{"link", "add", "name", this.Left.Name, "type", "veth", "peer", "name", this.Right.Name}
-
-	if this.Right.NetNs != "" {
-		command = append(command, "netns", this.Right.NetNs)
-	}
-
-	if out, err := RunCommand("ip", command...); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
-	}
-
-	if this.Left.NetNs != "" {
-		if err := this.Left.MoveToNs(this.Left.NetNs); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return linkBackend.CreatePair(this.Left, this.Right)
 }
 
 func (this Pair) Up() (Pair, error) {
@@ -118,6 +137,14 @@ func (this Pair) Up() (Pair, error) {
 		return this, nil
 	}
 
+	if err := this.Left.ApplyIpv6(); err != nil {
+		return this, errors.New(fmt.Sprint("Unable to Left.ApplyIpv6, error:", err))
+	}
+
+	if err := this.Right.ApplyIpv6(); err != nil {
+		return this, errors.New(fmt.Sprint("Unable to Right.ApplyIpv6, error:", err))
+	}
+
 	if err := this.Left.ApplyCidr(); err != nil {
 		return this, errors.New(fmt.Sprint("Unable to Left.ApplyCidr, error:", err))
 	}
@@ -138,6 +165,14 @@ func (this Pair) Up() (Pair, error) {
 		return this, errors.New(fmt.Sprint("Unable to ApplyRoutes(), error:", err))
 	}
 
+	if err := this.Left.ApplyShaping(); err != nil {
+		return this, errors.New(fmt.Sprint("Unable to Left.ApplyShaping(), error:", err))
+	}
+
+	if err := this.Right.ApplyShaping(); err != nil {
+		return this, errors.New(fmt.Sprint("Unable to Right.ApplyShaping(), error:", err))
+	}
+
 	this.Left = this.Left.SetState("UP")
 	this.Right = this.Right.SetState("UP")
 
@@ -155,33 +190,41 @@ func (this Pair) IsPatch() bool {
 	return this.Left.patch
 }
 
+// Release deletes the link and returns its addresses to the pool
 func (this Link) Release() {
-	command := []string{"ip", "link", "delete", this.Name}
+	this.remove()
+}
 
-	if this.NetNs != "" {
-		command = append([]string{"ip", "netns", "exec", this.NetNs}, command...)
+// remove is Release, which reports errors. Links, which are gone, e.g.
+// with the other end or namespace, are fine.
+func (this Link) remove() error {
+	for _, cidr := range this.Cidrs() {
+		pool.ThePool().Release(cidr)
 	}
 
-	RunCommand(command[0], command[1:]...)
+	pool.ThePool().ReleaseMac(this.HwAddr)
+
+	return dropLink(this)
 }
 
-func (this Link) ApplyMac() error {
-	if out, err := RunCommand("ip", "link", "set", "dev", this.Name, "address", this.HwAddr); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+// dropLink deletes link, addresses stay allocated. Links, which are gone,
+// e.g. with the other end or namespace, are fine.
+func dropLink(l Link) error {
+	err := linkBackend.Delete(l)
+	if err == nil || errors.Is(err, ErrLinkNotFound) || errors.Is(err, ErrNamespaceMissing) {
+		return nil
 	}
 
-	return nil
+	return err
 }
 
-func (this Link) Up() error {
-	command := []string{"ip", "link", "set", this.Name, "up"}
-
-	if this.NetNs != "" {
-		command = append([]string{"ip", "netns", "exec", this.NetNs}, command...)
-	}
+func (this Link) ApplyMac() error {
+	return linkBackend.SetHwAddr(this)
+}
 
-	if out, err := RunCommand(command[0], command[1:]...); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+func (this Link) Up() error {
+	if err := linkBackend.Up(this); err != nil {
+		return err
 	}
 
 	this.State = "UP"
@@ -189,57 +232,138 @@ func (this Link) Up() error {
 	return nil
 }
 
+// ApplyCidr adds Cidr and Addrs to the link
 func (this Link) ApplyCidr() error {
-	if _, _, err := net.ParseCIDR(this.Cidr); err != nil {
-		// omit setting ip, by passing some garbage to input
-		return nil
+	for _, cidr := range this.Cidrs() {
+		if err := linkBackend.AddAddr(this, cidr); err != nil {
+			return err
+		}
 	}
 
-	command := []string{"ip", "addr", "add", this.Cidr, "dev", this.Name}
+	return nil
+}
 
-	if this.NetNs != "" {
-		command = append([]string{"ip", "netns", "exec", this.NetNs}, command...)
+// ApplyIpv6 sets IPv6 behaviour of the link, links without Ipv6 field keep
+// kernel defaults. It goes before addresses, DAD is done when they are
+// added.
+func (this Link) ApplyIpv6() error {
+	if this.Ipv6 == nil {
+		return nil
 	}
 
-	if out, err := RunCommand(command[0], command[1:]...); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+	return linkBackend.SetIpv6(this)
+}
+
+// Cidrs returns all addresses of the link, Cidr goes first. Garbage, like
+// noip, is omitted.
+func (this Link) Cidrs() []string {
+	result := make([]string, 0)
+
+	for _, cidr := range append([]string{this.Cidr}, this.Addrs...) {
+		if _, _, err := net.ParseCIDR(cidr); err == nil {
+			result = append(result, cidr)
+		}
 	}
 
-	return nil
+	return result
 }
 
 func (this Link) ApplyRoutes() error {
 	for _, route := range this.Routes {
-		commands := []string{"route", "add", "-net", route.Dst, "gw", route.Gw}
-		if this.NetNs != "" {
-			commands = append([]string{"ip", "netns", "exec", this.NetNs}, commands...)
+		if err := linkBackend.AddRoute(this, route); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		out, err := RunCommand(commands[0], commands[1:]...)
-		if err != nil {
-			return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+// ApplyShaping configures link egress according to Shaping field.
+// Links without shaping are left untouched.
+func (this Link) ApplyShaping() error {
+	if this.Shaping == nil {
+		return nil
+	}
+
+	if err := this.Shaping.Validate(); err != nil {
+		return err
+	}
+
+	return linkBackend.Shape(this)
+}
+
+// Shape changes shaping of existing link, nil removes it.
+func (this Link) Shape(s *Shaping) (Link, error) {
+	if s != nil {
+		if err := s.Validate(); err != nil {
+			return this, err
 		}
 	}
 
-	return nil
+	this.Shaping = s
+
+	return this, linkBackend.Shape(this)
 }
 
 func (this Link) Exists() bool {
-	_, err := RunCommand("ip", "link", "show", this.Name)
-	return err == nil
+	return linkBackend.Exists(this)
 }
 
 func (this Link) MoveToNs(netns string) error {
-	if out, err := RunCommand("ip", "link", "set", this.Name, "netns", netns); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+	return linkBackend.MoveToNs(this, netns)
+}
+
+// SetCidr allocates address from the default pool subnet, given address
+// is reserved in the pool. Link is left without address, if there is no
+// free one.
+func (this Link) SetCidr() Link {
+	if this.Cidr != "" {
+		for _, cidr := range this.Cidrs() {
+			if err := pool.ThePool().Reserve(cidr); err != nil {
+				log.Println("Link address:", err)
+			}
+		}
+
+		return this
 	}
 
-	return nil
+	cidr, err := pool.ThePool().Allocate("")
+	if err != nil {
+		log.Println("Unable to allocate address:", err)
+		cidr = noip
+	}
+
+	this.Cidr = cidr
+
+	return this
 }
 
-func (this Link) SetCidr() Link {
+// SetCidr6 adds IPv6 address from the pool, if IPv6 prefix was added to
+// the pool and the link has no IPv6 address yet. Links without addresses
+// (noip) or with IPv6 disabled are left as they are.
+func (this Link) SetCidr6() Link {
+	if !pool.ThePool().Has6() || this.Cidr == noip || (this.Ipv6 != nil && this.Ipv6.Disable) {
+		return this
+	}
+
+	for _, cidr := range this.Cidrs() {
+		if ip, _, _ := net.ParseCIDR(cidr); ip.To4() == nil {
+			return this
+		}
+	}
+
+	if cidr := pool.ThePool().NextCidr6(); cidr != "" {
+		this.Addrs = append(this.Addrs, cidr)
+	} else {
+		log.Println("Unable to allocate IPv6 address")
+	}
+
+	return this
+}
+
+func (this Link) SetNoIp() Link {
 	if this.Cidr == "" {
-		this.Cidr = pool.ThePool().NextCidr()
+		this.Cidr = noip
 	}
 
 	return this
@@ -248,6 +372,8 @@ func (this Link) SetCidr() Link {
 func (this Link) SetHwAddr() Link {
 	if this.HwAddr == "" {
 		this.HwAddr = pool.ThePool().NextMac(firstrealhw().HardwareAddr.String())
+	} else if err := pool.ThePool().ReserveMac(this.HwAddr); err != nil {
+		log.Println("Link hwaddr:", err)
 	}
 
 	return this
@@ -0,0 +1,100 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LinkBackend does the actual kernel work behind Pair and Link methods.
+// NetlinkBackend is used by default, ExecBackend keeps the old way of
+// forking ip/route utilities and can be selected with SetLinkBackend.
+type LinkBackend interface {
+	CreatePair(left, right Link) error
+	Up(l Link) error
+	SetHwAddr(l Link) error
+	// SetIpv6 applies l.Ipv6 sysctls of the link
+	SetIpv6(l Link) error
+	AddAddr(l Link, cidr string) error
+	AddRoute(l Link, r Route) error
+	DelAddr(l Link, cidr string) error
+	DelRoute(l Link, r Route) error
+	MoveToNs(l Link, netns string) error
+	Exists(l Link) bool
+	Delete(l Link) error
+	// Shape replaces egress qdiscs of the link according to l.Shaping,
+	// nil Shaping removes them
+	Shape(l Link) error
+}
+
+var (
+	ErrLinkExists       = errors.New("link exists")
+	ErrLinkNotFound     = errors.New("link not found")
+	ErrNamespaceMissing = errors.New("namespace missing")
+	ErrAddrExists       = errors.New("address exists")
+	ErrRouteExists      = errors.New("route exists")
+)
+
+// LinkError describes failed link operation. Err is one of the Err* values
+// above, when the failure reason is known, or an underlying error otherwise.
+type LinkError struct {
+	Op     string
+	Link   string
+	NetNs  string
+	Err    error
+	Output string
+}
+
+func (this *LinkError) Error() string {
+	result := this.Op + " " + this.Link
+	if this.NetNs != "" {
+		result += " (netns " + this.NetNs + ")"
+	}
+
+	result += ": " + this.Err.Error()
+
+	if this.Output != "" {
+		result += fmt.Sprintf(", output: %s", this.Output)
+	}
+
+	return result
+}
+
+func (this *LinkError) Unwrap() error {
+	return this.Err
+}
+
+func linkError(op string, l Link, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &LinkError{Op: op, Link: l.Name, NetNs: l.NetNs, Err: err}
+}
+
+// ipv6Sysctls returns net.ipv6.conf.<link> values for l.Ipv6
+func ipv6Sysctls(l Link) [][2]string {
+	flag := func(v bool) string {
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+
+	return [][2]string{
+		{"disable_ipv6", flag(l.Ipv6.Disable)},
+		{"autoconf", flag(!l.Ipv6.NoSlaac)},
+		{"accept_ra", flag(!l.Ipv6.NoSlaac)},
+		{"accept_dad", flag(!l.Ipv6.NoDad)},
+	}
+}
+
+var linkBackend LinkBackend = NetlinkBackend{}
+
+// SetLinkBackend replaces backend used by all Pair and Link operations.
+func SetLinkBackend(b LinkBackend) {
+	linkBackend = b
+}
+
+func GetLinkBackend() LinkBackend {
+	return linkBackend
+}
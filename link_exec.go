@@ -0,0 +1,177 @@
+package mn
+
+import (
+	"strings"
+)
+
+// ExecBackend runs iproute2 and net-tools utilities. It's slow, but
+// doesn't need anything except binaries in the PATH.
+type ExecBackend struct{}
+
+func (this ExecBackend) CreatePair(left, right Link) error {
+	command := []string{"link", "add", "name", left.Name, "type", "veth", "peer", "name", right.Name}
+
+	if right.NetNs != "" {
+		command = append(command, "netns", right.NetNs)
+	}
+
+	if out, err := RunCommand("ip", command...); err != nil {
+		return execError("create", left, err, out)
+	}
+
+	if left.NetNs != "" {
+		if err := this.MoveToNs(left, left.NetNs); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range []Link{left, right} {
+		if out, err := runInNs(l.NetNs, "ip", "link", "set", "dev", l.Name, "alias", ownerTag()); err != nil {
+			return execError("set alias", l, err, out)
+		}
+	}
+
+	return nil
+}
+
+func (this ExecBackend) Up(l Link) error {
+	if out, err := runInNs(l.NetNs, "ip", "link", "set", l.Name, "up"); err != nil {
+		return execError("up", l, err, out)
+	}
+
+	return nil
+}
+
+func (this ExecBackend) SetHwAddr(l Link) error {
+	if out, err := runInNs(l.NetNs, "ip", "link", "set", "dev", l.Name, "address", l.HwAddr); err != nil {
+		return execError("set address", l, err, out)
+	}
+
+	return nil
+}
+
+func (this ExecBackend) AddAddr(l Link, cidr string) error {
+	if out, err := runInNs(l.NetNs, "ip", "addr", "add", cidr, "dev", l.Name); err != nil {
+		return execError("add address", l, err, out)
+	}
+
+	return nil
+}
+
+func (this ExecBackend) SetIpv6(l Link) error {
+	for _, kv := range ipv6Sysctls(l) {
+		key := "net.ipv6.conf." + l.Name + "." + kv[0]
+		if out, err := runInNs(l.NetNs, "sysctl", "-w", key+"="+kv[1]); err != nil {
+			return execError("set ipv6", l, err, out)
+		}
+	}
+
+	return nil
+}
+
+func (this ExecBackend) AddRoute(l Link, r Route) error {
+	if out, err := runInNs(l.NetNs, "ip", "route", "add", r.Dst, "via", r.Gw); err != nil {
+		return execError("add route", l, err, out)
+	}
+
+	return nil
+}
+
+func (this ExecBackend) DelAddr(l Link, cidr string) error {
+	if out, err := runInNs(l.NetNs, "ip", "addr", "del", cidr, "dev", l.Name); err != nil {
+		return execError("delete address", l, err, out)
+	}
+
+	return nil
+}
+
+func (this ExecBackend) DelRoute(l Link, r Route) error {
+	if out, err := runInNs(l.NetNs, "ip", "route", "del", r.Dst, "via", r.Gw); err != nil {
+		return execError("delete route", l, err, out)
+	}
+
+	return nil
+}
+
+func (this ExecBackend) MoveToNs(l Link, netns string) error {
+	if out, err := RunCommand("ip", "link", "set", l.Name, "netns", netns); err != nil {
+		return execError("move", l, err, out)
+	}
+
+	return nil
+}
+
+func (this ExecBackend) Exists(l Link) bool {
+	_, err := runInNs(l.NetNs, "ip", "link", "show", l.Name)
+	return err == nil
+}
+
+func (this ExecBackend) Delete(l Link) error {
+	if out, err := runInNs(l.NetNs, "ip", "link", "delete", l.Name); err != nil {
+		return execError("delete", l, err, out)
+	}
+
+	return nil
+}
+
+func (this ExecBackend) Shape(l Link) error {
+	// there could be nothing to delete, it's fine
+	runInNs(l.NetNs, "tc", "qdisc", "del", "dev", l.Name, "root")
+
+	if l.Shaping == nil {
+		return nil
+	}
+
+	parent := []string{"root", "handle", "1:"}
+
+	if l.Shaping.Rate != "" {
+		command := append([]string{"tc", "qdisc", "add", "dev", l.Name}, parent...)
+		command = append(command, l.Shaping.tbfArgs()...)
+
+		if out, err := runInNs(l.NetNs, command...); err != nil {
+			return execError("shape", l, err, out)
+		}
+
+		parent = []string{"parent", "1:1", "handle", "10:"}
+	}
+
+	if l.Shaping.hasNetem() {
+		command := append([]string{"tc", "qdisc", "add", "dev", l.Name}, parent...)
+		command = append(command, l.Shaping.netemArgs()...)
+
+		if out, err := runInNs(l.NetNs, command...); err != nil {
+			return execError("shape", l, err, out)
+		}
+	}
+
+	return nil
+}
+
+func runInNs(netns string, command ...string) (string, error) {
+	if netns != "" {
+		command = append([]string{"ip", "netns", "exec", netns}, command...)
+	}
+
+	return RunCommand(command[0], command[1:]...)
+}
+
+// execError maps well known iproute2 complaints to the LinkBackend errors,
+// so callers don't have to parse combined output.
+func execError(op string, l Link, err error, out string) error {
+	result := &LinkError{Op: op, Link: l.Name, NetNs: l.NetNs, Err: err, Output: strings.TrimSpace(out)}
+
+	switch {
+	case strings.Contains(out, "Cannot open network namespace"), strings.Contains(out, `Invalid "netns" value`):
+		result.Err = ErrNamespaceMissing
+	case strings.Contains(out, "File exists") && op == "add address":
+		result.Err = ErrAddrExists
+	case strings.Contains(out, "File exists") && op == "add route":
+		result.Err = ErrRouteExists
+	case strings.Contains(out, "File exists"):
+		result.Err = ErrLinkExists
+	case strings.Contains(out, "Cannot find device"), strings.Contains(out, "does not exist"):
+		result.Err = ErrLinkNotFound
+	}
+
+	return result
+}
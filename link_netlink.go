@@ -0,0 +1,341 @@
+package mn
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// NetlinkBackend talks to the kernel directly over netlink sockets opened
+// in the link's namespace, without forking anything.
+type NetlinkBackend struct{}
+
+func (this NetlinkBackend) CreatePair(left, right Link) error {
+	h, err := nlHandle(left.NetNs)
+	if err != nil {
+		return linkError("create", left, err)
+	}
+	defer h.Delete()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: left.Name},
+		PeerName:  right.Name,
+	}
+
+	if right.NetNs != "" {
+		ns, err := netns.GetFromName(right.NetNs)
+		if err != nil {
+			return linkError("create", right, nsError(err))
+		}
+		defer ns.Close()
+
+		veth.PeerNamespace = netlink.NsFd(ns)
+	}
+
+	if err := h.LinkAdd(veth); err != nil {
+		return linkError("create", left, nlError(err))
+	}
+
+	// kernel ignores alias of new links, it's set afterwards
+	for _, l := range []Link{left, right} {
+		err := this.withLink("set alias", l, func(h *netlink.Handle, link netlink.Link) error {
+			return h.LinkSetAlias(link, ownerTag())
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this NetlinkBackend) Up(l Link) error {
+	return this.withLink("up", l, func(h *netlink.Handle, link netlink.Link) error {
+		return h.LinkSetUp(link)
+	})
+}
+
+func (this NetlinkBackend) SetHwAddr(l Link) error {
+	hw, err := net.ParseMAC(l.HwAddr)
+	if err != nil {
+		return linkError("set address", l, err)
+	}
+
+	return this.withLink("set address", l, func(h *netlink.Handle, link netlink.Link) error {
+		return h.LinkSetHardwareAddr(link, hw)
+	})
+}
+
+// SetIpv6 writes sysctls from the link namespace, /proc/sys/net belongs
+// to the namespace of the opening thread
+func (this NetlinkBackend) SetIpv6(l Link) error {
+	write := func() error {
+		dir := "/proc/sys/net/ipv6/conf/" + l.Name + "/"
+
+		if _, err := os.Stat(dir); err != nil {
+			return ErrLinkNotFound
+		}
+
+		for _, kv := range ipv6Sysctls(l) {
+			if err := ioutil.WriteFile(dir+kv[0], []byte(kv[1]), 0644); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var err error
+	if l.NetNs == "" {
+		err = write()
+	} else {
+		err = NetNs{name: l.NetNs}.Do(write)
+	}
+
+	return linkError("set ipv6", l, err)
+}
+
+func (this NetlinkBackend) AddAddr(l Link, cidr string) error {
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		return linkError("add address", l, err)
+	}
+
+	return this.withLink("add address", l, func(h *netlink.Handle, link netlink.Link) error {
+		if err := h.AddrAdd(link, addr); err != nil {
+			if errors.Is(err, syscall.EEXIST) {
+				return ErrAddrExists
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (this NetlinkBackend) AddRoute(l Link, r Route) error {
+	_, dst, err := net.ParseCIDR(normRoute(r).Dst)
+	if err != nil {
+		return linkError("add route", l, err)
+	}
+
+	h, err := nlHandle(l.NetNs)
+	if err != nil {
+		return linkError("add route", l, err)
+	}
+	defer h.Delete()
+
+	if err := h.RouteAdd(&netlink.Route{Dst: dst, Gw: net.ParseIP(r.Gw)}); err != nil {
+		if errors.Is(err, syscall.EEXIST) {
+			return linkError("add route", l, ErrRouteExists)
+		}
+		return linkError("add route", l, nlError(err))
+	}
+
+	return nil
+}
+
+func (this NetlinkBackend) DelAddr(l Link, cidr string) error {
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		return linkError("delete address", l, err)
+	}
+
+	return this.withLink("delete address", l, func(h *netlink.Handle, link netlink.Link) error {
+		return h.AddrDel(link, addr)
+	})
+}
+
+func (this NetlinkBackend) DelRoute(l Link, r Route) error {
+	_, dst, err := net.ParseCIDR(normRoute(r).Dst)
+	if err != nil {
+		return linkError("delete route", l, err)
+	}
+
+	h, err := nlHandle(l.NetNs)
+	if err != nil {
+		return linkError("delete route", l, err)
+	}
+	defer h.Delete()
+
+	if err := h.RouteDel(&netlink.Route{Dst: dst, Gw: net.ParseIP(r.Gw)}); err != nil {
+		return linkError("delete route", l, err)
+	}
+
+	return nil
+}
+
+func (this NetlinkBackend) MoveToNs(l Link, name string) error {
+	ns, err := netns.GetFromName(name)
+	if err != nil {
+		return linkError("move", l, nsError(err))
+	}
+	defer ns.Close()
+
+	return this.withLink("move", l, func(h *netlink.Handle, link netlink.Link) error {
+		return h.LinkSetNsFd(link, int(ns))
+	})
+}
+
+func (this NetlinkBackend) Exists(l Link) bool {
+	h, err := nlHandle(l.NetNs)
+	if err != nil {
+		return false
+	}
+	defer h.Delete()
+
+	_, err = h.LinkByName(l.Name)
+	return err == nil
+}
+
+func (this NetlinkBackend) Delete(l Link) error {
+	return this.withLink("delete", l, func(h *netlink.Handle, link netlink.Link) error {
+		return h.LinkDel(link)
+	})
+}
+
+func (this NetlinkBackend) Shape(l Link) error {
+	return this.withLink("shape", l, func(h *netlink.Handle, link netlink.Link) error {
+		qdiscs, err := h.QdiscList(link)
+		if err != nil {
+			return err
+		}
+
+		// default qdisc has zero handle and can't be deleted
+		for _, q := range qdiscs {
+			if q.Attrs().Parent == netlink.HANDLE_ROOT && q.Attrs().Handle != 0 {
+				if err := h.QdiscDel(q); err != nil {
+					return err
+				}
+			}
+		}
+
+		if l.Shaping == nil {
+			return nil
+		}
+
+		s := *l.Shaping
+		index := link.Attrs().Index
+		parent := uint32(netlink.HANDLE_ROOT)
+		handle := netlink.MakeHandle(1, 0)
+
+		if s.Rate != "" {
+			rate, err := parseRate(s.Rate)
+			if err != nil {
+				return err
+			}
+
+			burst, err := parseSize(s.burst())
+			if err != nil {
+				return err
+			}
+
+			// kernel takes the bucket size as time to send it in ticks
+			tbf := &netlink.Tbf{
+				QdiscAttrs: netlink.QdiscAttrs{LinkIndex: index, Handle: handle, Parent: parent},
+				Rate:       rate / 8,
+				Buffer:     netlink.Xmittime(rate/8, burst),
+				Limit:      uint32(float64(rate/8)*defaultLatency.Seconds()) + burst,
+			}
+
+			if err := h.QdiscAdd(tbf); err != nil {
+				return err
+			}
+
+			parent = netlink.MakeHandle(1, 1)
+			handle = netlink.MakeHandle(10, 0)
+		}
+
+		if !s.hasNetem() {
+			return nil
+		}
+
+		attrs := netlink.NetemQdiscAttrs{
+			Limit:       uint32(s.limit()),
+			Loss:        float32(s.Loss),
+			Duplicate:   float32(s.Duplicate),
+			CorruptProb: float32(s.Corrupt),
+			ReorderProb: float32(s.Reorder),
+		}
+
+		if s.Delay != "" {
+			d, err := time.ParseDuration(s.Delay)
+			if err != nil {
+				return err
+			}
+			attrs.Latency = uint32(d.Microseconds())
+		}
+
+		if s.Jitter != "" {
+			d, err := time.ParseDuration(s.Jitter)
+			if err != nil {
+				return err
+			}
+			attrs.Jitter = uint32(d.Microseconds())
+		}
+
+		return h.QdiscAdd(netlink.NewNetem(netlink.QdiscAttrs{LinkIndex: index, Handle: handle, Parent: parent}, attrs))
+	})
+}
+
+func (this NetlinkBackend) withLink(op string, l Link, fn func(*netlink.Handle, netlink.Link) error) error {
+	h, err := nlHandle(l.NetNs)
+	if err != nil {
+		return linkError(op, l, err)
+	}
+	defer h.Delete()
+
+	link, err := h.LinkByName(l.Name)
+	if err != nil {
+		return linkError(op, l, nlError(err))
+	}
+
+	if err := fn(h, link); err != nil {
+		return linkError(op, l, nlError(err))
+	}
+
+	return nil
+}
+
+// nlHandle returns netlink handle bound to the named namespace,
+// empty name means current (root) namespace.
+func nlHandle(name string) (*netlink.Handle, error) {
+	if name == "" {
+		return netlink.NewHandle()
+	}
+
+	ns, err := netns.GetFromName(name)
+	if err != nil {
+		return nil, nsError(err)
+	}
+	defer ns.Close()
+
+	return netlink.NewHandleAt(ns)
+}
+
+func nsError(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNamespaceMissing
+	}
+
+	return err
+}
+
+func nlError(err error) error {
+	var notFound netlink.LinkNotFoundError
+
+	switch {
+	case errors.As(err, &notFound), errors.Is(err, syscall.ENODEV):
+		return ErrLinkNotFound
+	case errors.Is(err, syscall.EEXIST):
+		return ErrLinkExists
+	}
+
+	return err
+}
@@ -1,6 +1,7 @@
 package mn
 
 import (
+	"errors"
 	"log"
 	"reflect"
 	"testing"
@@ -96,12 +97,15 @@ func TestLink(t *testing.T) {
 			expected: Pair{
 				Link{
 					"192.168.66.1/24",
+					nil,
+					nil,
 					"00:00:00:00:00:00",
 					h2.NodeName() + "-eth0",
 					h1.NodeName(),
 					"",
 					"DOWN",
 					[]Route{},
+					nil,
 					"",
 					Peer{
 						Name:     "veth0",
@@ -113,12 +117,15 @@ func TestLink(t *testing.T) {
 				},
 				Link{
 					"192.168.66.2/24",
+					nil,
+					nil,
 					"00:00:00:00:00:00",
 					"veth0",
 					h2.NodeName(),
 					h2.NodeName(),
 					"DOWN",
 					[]Route{{"0.0.0.0/0", "192.168.66.1"}},
+					nil,
 					"",
 					Peer{
 						Name:     h2.NodeName() + "-eth0",
@@ -296,3 +303,51 @@ func TestHostToHost(t *testing.T) {
 		t.Fatal("Ping failed:", err)
 	}
 }
+
+func TestLinkBackendErrors(t *testing.T) {
+	backends := []LinkBackend{NetlinkBackend{}, ExecBackend{}}
+
+	defer SetLinkBackend(GetLinkBackend())
+
+	for _, backend := range backends {
+		SetLinkBackend(backend)
+
+		h, err := NewHost()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pair := Pair{
+			Left:  Link{Name: h.NodeName() + "-eth0"},
+			Right: Link{Name: "veth0", NetNs: h.NodeName()},
+		}
+
+		if err := pair.Create(); err != nil {
+			h.Release()
+			t.Fatal(err)
+		}
+
+		if err := pair.Create(); !errors.Is(err, ErrLinkExists) {
+			pair.Left.Release()
+			h.Release()
+			t.Fatal("Expected", ErrLinkExists, "obtained:", err)
+		}
+
+		pair.Left.Release()
+		h.Release()
+
+		missing := Pair{
+			Left:  Link{Name: "xcvxcv-eth0"},
+			Right: Link{Name: "veth0", NetNs: "xcvxcvxc444"},
+		}
+
+		if err := missing.Create(); !errors.Is(err, ErrNamespaceMissing) {
+			missing.Left.Release()
+			t.Fatal("Expected", ErrNamespaceMissing, "obtained:", err)
+		}
+
+		if err := (Link{Name: "xcvxcvxc444"}).Up(); !errors.Is(err, ErrLinkNotFound) {
+			t.Fatal("Expected", ErrLinkNotFound, "obtained:", err)
+		}
+	}
+}
@@ -1,22 +1,13 @@
 package mn
 
-/*
-#include <unistd.h>
-#include <syscall.h>
-
-int setns(int fd, int nstype) {
-	return syscall(__NR_setns, fd, nstype);
-}
-*/
-import "C"
-
 import (
 	"errors"
 	"fmt"
-	"log"
 	"os"
-	"strings"
+	"runtime"
 	"syscall"
+
+	"github.com/vishvananda/netns"
 )
 
 const NETNS_RUN_DIR = "/var/run/netns"
@@ -41,26 +32,48 @@ func NewNetNs(name string) (*NetNs, error) {
 	return this, nil
 }
 
+// Create makes namespace and bind mounts it to NETNS_RUN_DIR, like
+// "ip netns add" does.
 func (this NetNs) Create() error {
-	if out, err := RunCommand("ip", "netns", "add", this.name); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+	err := lockedThread(func() error {
+		ns, err := netns.NewNamed(this.name)
+		if err != nil {
+			return err
+		}
+
+		return ns.Close()
+	})
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to create netns %s: %v", this.name, err))
 	}
 
+	own(ownedNetns, this.name)
+
 	return nil
 }
 
 func (this NetNs) Exists() bool {
-	out, err := RunCommand("ip", "netns", "list")
+	_, err := os.Stat(NETNS_RUN_DIR + "/" + this.name)
+	return err == nil
+}
+
+// Do calls f on a thread of its own switched to the namespace. Processes
+// started by f are born in the namespace.
+func (this NetNs) Do(f func() error) error {
+	ns, err := netns.GetFromName(this.name)
 	if err != nil {
-		log.Println("Error: %v, output: %s", err, out)
-		return true
+		return nsError(err)
 	}
+	defer ns.Close()
 
-	if strings.Contains(out, this.name) {
-		return true
-	}
+	return lockedThread(func() error {
+		if err := netns.Set(ns); err != nil {
+			return err
+		}
 
-	return false
+		return f()
+	})
 }
 
 func (this NetNs) Release() error {
@@ -76,9 +89,42 @@ func (this NetNs) Release() error {
 		return err
 	}
 
+	disown(ownedNetns, this.name)
+
 	return nil
 }
 
 func (this NetNs) Name() string {
 	return this.name
 }
+
+// lockedThread calls f on a dedicated locked thread and brings the thread
+// back to the original namespace. If that fails, thread stays locked, so
+// runtime terminates it with the goroutine instead of reusing it.
+func lockedThread(f func() error) error {
+	result := make(chan error, 1)
+
+	go func() {
+		runtime.LockOSThread()
+
+		origin, err := netns.Get()
+		if err != nil {
+			runtime.UnlockOSThread()
+			result <- err
+			return
+		}
+		defer origin.Close()
+
+		err = f()
+
+		if err := netns.Set(origin); err != nil {
+			result <- errors.New(fmt.Sprintf("Unable to restore netns: %v, thread is abandoned", err))
+			return
+		}
+
+		runtime.UnlockOSThread()
+		result <- err
+	}()
+
+	return <-result
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -19,13 +20,6 @@ func init() {
 		log.Println("ip command not found in the PATH. Please check that iproute2 utility has been installed")
 		os.Exit(1)
 	}
-
-	out, _ := RunCommand(FullPathFor("ip"), "netns", "help")
-
-	if !strings.Contains(out, "identify") {
-		log.Println("iproute2 utility doesn't support netns identify command, please update it.")
-		os.Exit(1)
-	}
 }
 
 func TestNewHost(t *testing.T) {
@@ -77,6 +71,129 @@ func TestHostRunProcess(t *testing.T) {
 	}
 }
 
+func TestHostRunProcessPid(t *testing.T) {
+	h, err := NewHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer h.Release()
+
+	h.Cgroup, _ = NewCgroup(h.Name)
+	if err := h.Cgroup.SetControllers([]Controller{{Name: "pids"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Cgroup.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer h.Cgroup.Release()
+
+	p, err := h.RunProcess("sleep", "30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cgroup is removed by Release only when process is gone
+	defer func() {
+		p.Kill()
+
+		for i := 0; i < 100; i++ {
+			if pids, _ := h.Cgroup.Procs(); len(pids) == 0 {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	// tracked pid is the command itself, not a wrapper
+	procs, err := nsProcs(h.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if procs[p.Pid] != "sleep 30" {
+		t.Fatal("Expected \"sleep 30\" with pid", p.Pid, "in", h.Name, "obtained:", procs)
+	}
+
+	pids, err := h.Cgroup.Procs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pids) != 1 || pids[0] != p.Pid {
+		t.Fatal("Expected pid", p.Pid, "in cgroup, obtained:", pids)
+	}
+
+	out, err := h.RunCommand("cat", "/proc/self/cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "/mn/"+h.Name) {
+		t.Fatal("Expected command in cgroup", h.Name, "obtained:", out)
+	}
+}
+
+func TestHostIsolation(t *testing.T) {
+	os.Setenv("PATH", os.Getenv("GOPATH")+"/bin:"+os.Getenv("PATH"))
+
+	h, err := NewHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Isolation = &Isolation{Uts: true, Pid: true, Mount: true}
+
+	defer h.Release()
+
+	out, err := h.RunCommand("hostname")
+	if err != nil {
+		t.Fatal(err, out)
+	}
+
+	if strings.TrimSpace(out) != h.Name {
+		t.Fatal("Expected hostname", h.Name, "obtained:", out)
+	}
+
+	out, err = h.RunCommand("cat", "/etc/hosts", "/proc/1/cmdline")
+	if err != nil {
+		t.Fatal(err, out)
+	}
+
+	if !strings.Contains(out, "127.0.1.1\t"+h.Name) || !strings.Contains(out, InitCommand) {
+		t.Fatal("Expected host own /etc/hosts and mn-init as pid 1, obtained:", out)
+	}
+
+	// process pid is the one from the root namespace
+	p, err := h.RunProcess("sleep", "30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	procs, err := nsProcs(h.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if procs[p.Pid] != "sleep 30" {
+		t.Fatal("Expected \"sleep 30\" with pid", p.Pid, "obtained:", procs)
+	}
+
+	// /tmp is private
+	fname := "/tmp/" + h.Name
+	if out, err := h.RunCommand("touch", fname); err != nil {
+		t.Fatal(err, out)
+	}
+
+	if _, err := os.Stat(fname); !os.IsNotExist(err) {
+		os.Remove(fname)
+		t.Fatal("Expected", fname, "visible only in", h.Name)
+	}
+}
+
 func TestRouter(t *testing.T) {
 	r, err := NewRouter()
 	if err != nil {
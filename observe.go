@@ -0,0 +1,420 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// liveLink is what kernel knows about a network interface
+type liveLink struct {
+	Name      string
+	Kind      string
+	HwAddr    string
+	Index     int
+	PeerIndex int
+	// PeerNetNsId is id of the veth peer namespace, as seen from this
+	// one, -1 if peer is in the same namespace
+	PeerNetNsId int
+	Up          bool
+	Addrs       []string
+	Routes      []Route
+	Qdisc       string
+	// Shaping is read from the root tbf and netem qdiscs
+	Shaping *Shaping
+}
+
+// liveNs is the network state of namespace, empty name is the root one
+type liveNs struct {
+	Name   string
+	Links  map[string]*liveLink
+	Routes []Route
+	// NsIds are names of other namespaces by id, as this one sees them
+	NsIds map[int]string
+}
+
+// peerNs returns namespace of the veth peer
+func (this *liveNs) peerNs(l *liveLink) (string, bool) {
+	if l.PeerNetNsId < 0 {
+		return this.Name, true
+	}
+
+	name, found := this.NsIds[l.PeerNetNsId]
+	return name, found
+}
+
+// listNetNs returns names of namespaces created with ip netns or NetNs
+func listNetNs() ([]string, error) {
+	entries, err := ioutil.ReadDir(NETNS_RUN_DIR)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	for _, entry := range entries {
+		result = append(result, entry.Name())
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// observeNs reads links, global addresses, root qdiscs and gateway routes of
+// the namespace. Loopback and connected routes are skipped, they are
+// created by the kernel.
+func observeNs(name string) (*liveNs, error) {
+	h, err := nlHandle(name)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Delete()
+
+	links, err := h.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &liveNs{Name: name, Links: make(map[string]*liveLink)}
+
+	for _, link := range links {
+		attrs := link.Attrs()
+		if attrs.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		l := &liveLink{
+			Name:        attrs.Name,
+			Kind:        link.Type(),
+			HwAddr:      attrs.HardwareAddr.String(),
+			Index:       attrs.Index,
+			PeerNetNsId: -1,
+			Up:          attrs.Flags&net.FlagUp != 0,
+			Addrs:       make([]string, 0),
+		}
+
+		if l.Kind == "veth" {
+			l.PeerIndex = attrs.ParentIndex
+			l.PeerNetNsId = attrs.NetNsID
+		}
+
+		addrs, err := h.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			if addr.Scope == int(netlink.SCOPE_UNIVERSE) {
+				l.Addrs = append(l.Addrs, addr.IPNet.String())
+			}
+		}
+
+		qdiscs, err := h.QdiscList(link)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, q := range qdiscs {
+			if q.Attrs().Parent == netlink.HANDLE_ROOT && q.Attrs().Handle != 0 {
+				l.Qdisc = q.Type()
+				l.Shaping = observeShaping(q, qdiscs)
+			}
+		}
+
+		result.Links[l.Name] = l
+	}
+
+	routes, err := h.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range routes {
+		if r.Gw == nil {
+			continue
+		}
+
+		dst := "0.0.0.0/0"
+		if r.Gw.To4() == nil {
+			dst = "::/0"
+		}
+		if r.Dst != nil {
+			dst = r.Dst.String()
+		}
+
+		route := Route{Dst: dst, Gw: r.Gw.String()}
+		result.Routes = append(result.Routes, route)
+
+		for _, l := range result.Links {
+			if l.Index == r.LinkIndex {
+				l.Routes = append(l.Routes, route)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// observeShaping returns shaping set by root qdisc, nil if it's neither tbf
+// nor netem. Netem under tbf is its child.
+func observeShaping(root netlink.Qdisc, qdiscs []netlink.Qdisc) *Shaping {
+	result := &Shaping{}
+
+	switch q := root.(type) {
+	case *netlink.Tbf:
+		result.Rate = strconv.FormatUint(q.Rate*8, 10) + "bit"
+		result.Burst = strconv.FormatUint(uint64(netlink.Xmitsize(q.Rate, q.Buffer)), 10) + "b"
+
+		major, _ := netlink.MajorMinor(q.Handle)
+		for _, child := range qdiscs {
+			netem, ok := child.(*netlink.Netem)
+			if parent, _ := netlink.MajorMinor(child.Attrs().Parent); ok && parent == major {
+				observeNetem(result, netem)
+			}
+		}
+	case *netlink.Netem:
+		observeNetem(result, q)
+	default:
+		return nil
+	}
+
+	return result
+}
+
+// observeNetem converts kernel ticks and probabilities of netem, percents
+// are rounded to hundredths
+func observeNetem(s *Shaping, q *netlink.Netem) {
+	ticks := func(t uint32) string {
+		return (time.Duration(math.Round(float64(t)/netlink.TickInUsec())) * time.Microsecond).String()
+	}
+	probability := func(p uint32) float64 {
+		return math.Round(float64(p)/math.MaxUint32*1e4) / 100
+	}
+
+	if q.Latency > 0 {
+		s.Delay = ticks(q.Latency)
+	}
+	if q.Jitter > 0 {
+		s.Jitter = ticks(q.Jitter)
+	}
+
+	s.Loss = probability(q.Loss)
+	s.Duplicate = probability(q.Duplicate)
+	s.Corrupt = probability(q.CorruptProb)
+	s.Reorder = probability(q.ReorderProb)
+	s.Limit = int(q.Limit)
+}
+
+// observeNsIds fills NsIds of the namespaces. Kernel assigns id to the
+// peer namespace, when veth end is moved there, namespaces without one
+// aren't mapped.
+func observeNsIds(live map[string]*liveNs) error {
+	fds := make(map[string]netns.NsHandle)
+	defer func() {
+		for _, fd := range fds {
+			fd.Close()
+		}
+	}()
+
+	for name := range live {
+		var fd netns.NsHandle
+		var err error
+
+		if name == "" {
+			fd, err = netns.Get()
+		} else {
+			fd, err = netns.GetFromName(name)
+		}
+
+		if err != nil {
+			return nsError(err)
+		}
+
+		fds[name] = fd
+	}
+
+	for name, state := range live {
+		h, err := nlHandle(name)
+		if err != nil {
+			return err
+		}
+
+		state.NsIds = make(map[int]string)
+
+		for other, fd := range fds {
+			if other == name {
+				continue
+			}
+
+			id, err := h.GetNetNsIdByFd(int(fd))
+			if err != nil {
+				h.Delete()
+				return err
+			}
+
+			if id >= 0 {
+				state.NsIds[id] = other
+			}
+		}
+
+		h.Delete()
+	}
+
+	return nil
+}
+
+// procInfo is a process as /proc shows it
+type procInfo struct {
+	Pid  int
+	Args []string
+	// StartTime is in clock ticks after boot
+	StartTime uint64
+}
+
+func (this procInfo) cmdline() string {
+	return strings.TrimSpace(strings.Join(this.Args, " "))
+}
+
+// readProc reads command line and start time of the process
+func readProc(pid int) (procInfo, error) {
+	result := procInfo{Pid: pid}
+
+	cmdline, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil {
+		return result, err
+	}
+
+	if len(cmdline) > 0 {
+		result.Args = strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	}
+
+	result.StartTime, err = procStartTime(pid)
+
+	return result, err
+}
+
+// procStartTime returns field 22 of /proc/<pid>/stat, the time process
+// started after boot. Command name in field 2 could have spaces and
+// parentheses, so fields are counted after the last ')'.
+func procStartTime(pid int) (uint64, error) {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+
+	i := strings.LastIndex(string(stat), ")")
+	if i < 0 {
+		return 0, errors.New(fmt.Sprintf("Wrong /proc/%d/stat", pid))
+	}
+
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return 0, errors.New(fmt.Sprintf("Wrong /proc/%d/stat", pid))
+	}
+
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// nsProcInfo returns processes running in the namespace, keyed by pid.
+// Namespace of a process is compared by inode of /proc/<pid>/ns/net.
+func nsProcInfo(name string) (map[int]procInfo, error) {
+	var target syscall.Stat_t
+	if err := syscall.Stat(NETNS_RUN_DIR+"/"+name, &target); err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int]procInfo)
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		var st syscall.Stat_t
+		if err := syscall.Stat("/proc/"+entry.Name()+"/ns/net", &st); err != nil {
+			continue
+		}
+
+		if st.Ino != target.Ino || st.Dev != target.Dev {
+			continue
+		}
+
+		// kernel threads have no command line
+		info, err := readProc(pid)
+		if err != nil || len(info.Args) == 0 {
+			continue
+		}
+
+		result[pid] = info
+	}
+
+	return result, nil
+}
+
+// nsProcs returns command lines of processes running in the namespace,
+// keyed by pid
+func nsProcs(name string) (map[int]string, error) {
+	procs, err := nsProcInfo(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int]string)
+	for pid, info := range procs {
+		result[pid] = info.cmdline()
+	}
+
+	return result, nil
+}
+
+// normCidr brings address to the form kernel reports it, "" if cidr is
+// not an address, e.g. noip
+func normCidr(cidr string) string {
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ""
+	}
+
+	return (&net.IPNet{IP: ip, Mask: ipnet.Mask}).String()
+}
+
+// normRoute brings route to the form kernel reports it, "default" Dst
+// becomes the default route of the gateway family
+func normRoute(r Route) Route {
+	if r.Dst == "default" {
+		r.Dst = "0.0.0.0/0"
+		if r.Family() == FamilyInet6 {
+			r.Dst = "::/0"
+		}
+	}
+
+	if _, dst, err := net.ParseCIDR(r.Dst); err == nil {
+		r.Dst = dst.String()
+	}
+
+	if gw := net.ParseIP(r.Gw); gw != nil {
+		r.Gw = gw.String()
+	}
+
+	return r
+}
@@ -0,0 +1,525 @@
+// Package ofp10fake is an in-memory OpenFlow 1.0 datapath for tests of apps
+// of ogo controller, which speaks OpenFlow 1.0 only. Switch connects to the
+// controller over loopback TCP, the way Open vSwitch does, keeps a flow
+// table from flow mods and forwards packets between its ports. Packets
+// without matching flow are sent to controller.
+package ofp10fake
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConnectTimeout limits Connect and Sync
+const ConnectTimeout = 5 * time.Second
+
+// Idle is how long controller sends nothing, until Sync returns
+var Idle = 50 * time.Millisecond
+
+// Flow is a flow entry of Switch with its counters
+type Flow struct {
+	FlowMod
+	PacketCount uint64
+	ByteCount   uint64
+}
+
+type fakePacket struct {
+	inPort uint16
+	data   []byte
+}
+
+type fakePort struct {
+	Port
+	transmitted [][]byte
+}
+
+// Switch is OpenFlow 1.0 datapath with a single flow table, packet buffers
+// and ports. Packets output to ports are kept, until taken by Transmitted.
+type Switch struct {
+	// Buffers makes switch buffer packets sent to controller
+	Buffers bool
+
+	dpid uint64
+
+	sync.Mutex
+	ports      map[uint16]*fakePort
+	flows      []*Flow
+	buffers    map[uint32]fakePacket
+	nextBuffer uint32
+	conn       net.Conn
+	connected  bool
+	// active is when the last message was sent or received
+	active time.Time
+}
+
+// NewSwitch returns switch with given datapath id and port numbers, ports
+// are named s<dpid>-eth<no>
+func NewSwitch(dpid uint64, ports ...uint16) *Switch {
+	result := &Switch{
+		dpid:    dpid,
+		ports:   make(map[uint16]*fakePort),
+		buffers: make(map[uint32]fakePacket),
+	}
+
+	for _, no := range ports {
+		result.ports[no] = &fakePort{Port: result.newPort(no)}
+	}
+
+	return result
+}
+
+func (this *Switch) newPort(no uint16) Port {
+	hw := make(net.HardwareAddr, 6)
+	hw[0] = 0x02
+	binary.BigEndian.PutUint16(hw[1:], uint16(this.dpid))
+	binary.BigEndian.PutUint16(hw[4:], no)
+
+	return Port{PortNo: no, HWAddr: hw, Name: fmt.Sprintf("s%d-eth%d", this.dpid, no)}
+}
+
+// DPID returns datapath id the way ogo keeps it
+func (this *Switch) DPID() net.HardwareAddr {
+	result := make(net.HardwareAddr, 8)
+	binary.BigEndian.PutUint64(result, this.dpid)
+
+	return result
+}
+
+// Connect connects the switch to controller listening on addr and waits,
+// until it's handshaked and applications handle connection up
+func (this *Switch) Connect(addr string) error {
+	deadline := time.Now().Add(ConnectTimeout)
+
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			go this.Serve(conn)
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return err
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for {
+		this.Lock()
+		connected := this.connected
+		this.Unlock()
+
+		if connected {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("Switch %s didn't connect", this.DPID()))
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	return this.Sync()
+}
+
+// Serve talks to controller on conn, until it's closed
+func (this *Switch) Serve(conn net.Conn) error {
+	this.Lock()
+	this.conn = conn
+	this.send(message{Header: Header{Type: T_HELLO}})
+	this.Unlock()
+
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+
+		this.Lock()
+		this.active = time.Now()
+		this.handle(msg)
+		this.Unlock()
+	}
+}
+
+// Sync waits, until controller sends nothing for Idle, i.e. its apps have
+// handled everything the switch has sent
+func (this *Switch) Sync() error {
+	for deadline := time.Now().Add(ConnectTimeout); ; time.Sleep(time.Millisecond) {
+		this.Lock()
+		connected, active := this.connected, this.active
+		this.Unlock()
+
+		if !connected {
+			return errors.New("Switch isn't connected")
+		}
+
+		if time.Since(active) >= Idle {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("Controller of %s is still busy", this.DPID()))
+		}
+	}
+}
+
+// Close disconnects the switch
+func (this *Switch) Close() {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.conn != nil {
+		this.conn.Close()
+	}
+
+	this.connected = false
+}
+
+// Inject makes packet received on the port
+func (this *Switch) Inject(port uint16, data []byte) {
+	this.Lock()
+	defer this.Unlock()
+
+	if _, found := this.ports[port]; found {
+		this.forward(port, append([]byte(nil), data...))
+	}
+}
+
+// Transmitted returns packets output to the port since the last call
+func (this *Switch) Transmitted(port uint16) [][]byte {
+	this.Lock()
+	defer this.Unlock()
+
+	p, found := this.ports[port]
+	if !found {
+		return nil
+	}
+
+	result := p.transmitted
+	p.transmitted = nil
+
+	return result
+}
+
+// Flows returns flow entries in the order they are matched
+func (this *Switch) Flows() []Flow {
+	this.Lock()
+	defer this.Unlock()
+
+	result := make([]Flow, 0, len(this.flows))
+	for _, f := range this.flows {
+		result = append(result, *f)
+	}
+
+	return result
+}
+
+// AddPort adds port and tells controller about it
+func (this *Switch) AddPort(no uint16) {
+	this.Lock()
+	defer this.Unlock()
+
+	p := &fakePort{Port: this.newPort(no)}
+	this.ports[no] = p
+	this.send(portStatus(PR_ADD, p.Port))
+}
+
+// DeletePort deletes port and tells controller about it
+func (this *Switch) DeletePort(no uint16) {
+	this.Lock()
+	defer this.Unlock()
+
+	if p, found := this.ports[no]; found {
+		delete(this.ports, no)
+		this.send(portStatus(PR_DELETE, p.Port))
+	}
+}
+
+func (this *Switch) send(msg message) {
+	if this.conn != nil {
+		this.conn.Write(msg.marshal())
+		this.active = time.Now()
+	}
+}
+
+func (this *Switch) sortedPorts() []Port {
+	result := make([]Port, 0, len(this.ports))
+	for _, p := range this.ports {
+		result = append(result, p.Port)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].PortNo < result[j].PortNo
+	})
+
+	return result
+}
+
+func (this *Switch) handle(msg message) {
+	switch msg.Type {
+	case T_HELLO, T_ECHO_REPLY, T_ERROR, T_BARRIER_REPLY, T_SET_CONFIG, T_PORT_MOD:
+	case T_ECHO_REQUEST:
+		this.send(message{Header{Type: T_ECHO_REPLY, Xid: msg.Xid}, msg.body})
+	case T_FEATURES_REQUEST:
+		this.send(featuresReply(msg.Xid, this.dpid, 256, this.sortedPorts()))
+		this.connected = true
+	case T_GET_CONFIG_REQUEST:
+		// no flags, miss send length of 128
+		this.send(message{Header{Type: T_GET_CONFIG_REPLY, Xid: msg.Xid}, []byte{0, 0, 0, 128}})
+	case T_BARRIER_REQUEST:
+		this.send(message{Header: Header{Type: T_BARRIER_REPLY, Xid: msg.Xid}})
+	case T_FLOW_MOD:
+		var m FlowMod
+		if err := m.unmarshal(msg.body); err != nil {
+			this.send(errorMsg(msg, ET_BAD_REQUEST, BRC_BAD_LEN))
+			return
+		}
+
+		this.flowMod(msg, &m)
+	case T_PACKET_OUT:
+		var m PacketOut
+		if err := m.unmarshal(msg.body); err != nil {
+			this.send(errorMsg(msg, ET_BAD_REQUEST, BRC_BAD_LEN))
+			return
+		}
+
+		data := m.Data
+		if m.BufferId != NO_BUFFER {
+			buffered, found := this.buffers[m.BufferId]
+			if !found {
+				this.send(errorMsg(msg, ET_BAD_REQUEST, BRC_BUFFER_UNKNOWN))
+				return
+			}
+
+			delete(this.buffers, m.BufferId)
+			data = buffered.data
+		}
+
+		this.execute(m.Actions, m.InPort, data)
+	case T_STATS_REQUEST:
+		this.send(errorMsg(msg, ET_BAD_REQUEST, BRC_BAD_STAT))
+	case T_VENDOR:
+		this.send(errorMsg(msg, ET_BAD_REQUEST, BRC_BAD_VENDOR))
+	default:
+		this.send(errorMsg(msg, ET_BAD_REQUEST, BRC_BAD_TYPE))
+	}
+}
+
+// selects tells, that non-strict modify or delete selects the flow
+func selects(f *Flow, match Match, outPort uint16) bool {
+	return match.Covers(f.Match) && (outPort == P_NONE || outputs(f.Actions, outPort))
+}
+
+func outputs(actions []Action, port uint16) bool {
+	for _, a := range actions {
+		if a.Type == AT_OUTPUT && a.Port == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rank orders flows, exact matches go before wildcarded ones
+func rank(f *Flow) int {
+	if f.Match.Wildcards&FW_ALL == 0 {
+		return 0x10000
+	}
+
+	return int(f.Priority)
+}
+
+func (this *Switch) add(m *FlowMod) {
+	for i, f := range this.flows {
+		if f.Priority == m.Priority && f.Match.Equal(m.Match) {
+			this.flows[i] = &Flow{FlowMod: *m}
+			return
+		}
+	}
+
+	this.flows = append(this.flows, &Flow{FlowMod: *m})
+	sort.SliceStable(this.flows, func(i, j int) bool {
+		return rank(this.flows[i]) > rank(this.flows[j])
+	})
+}
+
+func (this *Switch) flowMod(msg message, m *FlowMod) {
+	strict := m.Command == FC_MODIFY_STRICT || m.Command == FC_DELETE_STRICT
+	selected := func(f *Flow) bool {
+		if strict {
+			return f.Priority == m.Priority && f.Match.Equal(m.Match)
+		}
+
+		return selects(f, m.Match, m.OutPort)
+	}
+
+	switch m.Command {
+	case FC_ADD:
+		this.add(m)
+	case FC_MODIFY, FC_MODIFY_STRICT:
+		modified := false
+		for _, f := range this.flows {
+			if selected(f) {
+				f.Actions = m.Actions
+				modified = true
+			}
+		}
+
+		// modify adds the flow, if there is nothing to modify
+		if !modified {
+			this.add(m)
+		}
+	case FC_DELETE, FC_DELETE_STRICT:
+		kept := make([]*Flow, 0, len(this.flows))
+		for _, f := range this.flows {
+			if !selected(f) {
+				kept = append(kept, f)
+			}
+		}
+
+		this.flows = kept
+
+		return
+	default:
+		this.send(errorMsg(msg, ET_FLOW_MOD, FMFC_BAD_COMMAND))
+		return
+	}
+
+	if m.BufferId != NO_BUFFER {
+		buffered, found := this.buffers[m.BufferId]
+		if !found {
+			this.send(errorMsg(msg, ET_BAD_REQUEST, BRC_BUFFER_UNKNOWN))
+			return
+		}
+
+		delete(this.buffers, m.BufferId)
+
+		this.forward(buffered.inPort, buffered.data)
+	}
+}
+
+// packetMatch returns fields of the packet, ARP opcode and addresses are
+// matched as IP protocol and addresses
+func packetMatch(inPort uint16, data []byte) Match {
+	result := Match{InPort: inPort}
+
+	if len(data) < 14 {
+		return result
+	}
+
+	result.DLDst = net.HardwareAddr(data[0:6])
+	result.DLSrc = net.HardwareAddr(data[6:12])
+	result.DLType = binary.BigEndian.Uint16(data[12:])
+
+	switch payload := data[14:]; result.DLType {
+	case 0x0800:
+		if len(payload) < 20 {
+			break
+		}
+
+		result.NWProto = payload[9]
+		result.NWSrc = net.IP(payload[12:16])
+		result.NWDst = net.IP(payload[16:20])
+
+		ihl := int(payload[0]&0x0f) * 4
+		if (result.NWProto == 6 || result.NWProto == 17) && len(payload) >= ihl+4 {
+			result.TPSrc = binary.BigEndian.Uint16(payload[ihl:])
+			result.TPDst = binary.BigEndian.Uint16(payload[ihl+2:])
+		}
+	case 0x0806:
+		if len(payload) < 28 {
+			break
+		}
+
+		result.NWProto = payload[7]
+		result.NWSrc = net.IP(payload[14:18])
+		result.NWDst = net.IP(payload[24:28])
+	}
+
+	return result
+}
+
+// forward matches packet against the flow table, packets without matching
+// flow are sent to controller
+func (this *Switch) forward(inPort uint16, data []byte) {
+	match := packetMatch(inPort, data)
+
+	for _, f := range this.flows {
+		if f.Match.Covers(match) {
+			f.PacketCount++
+			f.ByteCount += uint64(len(data))
+
+			this.execute(f.Actions, inPort, data)
+			return
+		}
+	}
+
+	this.toController(inPort, data, R_NO_MATCH)
+}
+
+func (this *Switch) toController(inPort uint16, data []byte, reason uint8) {
+	bufferId := uint32(NO_BUFFER)
+
+	if this.Buffers {
+		this.nextBuffer++
+		this.buffers[this.nextBuffer] = fakePacket{inPort, data}
+		bufferId = this.nextBuffer
+	}
+
+	this.send(packetIn(bufferId, inPort, reason, data))
+}
+
+// execute applies actions to a copy of packet
+func (this *Switch) execute(actions []Action, inPort uint16, data []byte) {
+	data = append([]byte(nil), data...)
+
+	for _, a := range actions {
+		switch a.Type {
+		case AT_SET_DL_DST:
+			if len(data) >= 14 {
+				copy(data[0:6], a.DLAddr)
+			}
+		case AT_SET_DL_SRC:
+			if len(data) >= 14 {
+				copy(data[6:12], a.DLAddr)
+			}
+		case AT_OUTPUT:
+			this.output(a.Port, inPort, data)
+		}
+	}
+}
+
+func (this *Switch) output(port uint16, inPort uint16, data []byte) {
+	switch port {
+	case P_CONTROLLER:
+		this.toController(inPort, data, R_ACTION)
+	case P_TABLE:
+		this.forward(inPort, data)
+	case P_IN_PORT:
+		this.transmit(inPort, data)
+	case P_FLOOD, P_ALL:
+		for no := range this.ports {
+			if no != inPort {
+				this.transmit(no, data)
+			}
+		}
+	default:
+		// packets aren't sent back to the input port
+		if port != inPort {
+			this.transmit(port, data)
+		}
+	}
+}
+
+func (this *Switch) transmit(port uint16, data []byte) {
+	if p, found := this.ports[port]; found {
+		p.transmitted = append(p.transmitted, append([]byte(nil), data...))
+	}
+}
@@ -0,0 +1,116 @@
+package ofp10fake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// controller accepts the switch and makes the handshake
+func controller(t *testing.T, sw *Switch) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sw.Connect(l.Addr().String())
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := read(t, conn); msg.Type != T_HELLO {
+		t.Fatal("Expected hello, obtained:", msg.Header)
+	}
+
+	conn.Write(message{Header: Header{Type: T_FEATURES_REQUEST, Xid: 7}}.marshal())
+
+	msg := read(t, conn)
+	if msg.Type != T_FEATURES_REPLY || msg.Xid != 7 || len(msg.body) != 24+2*phyPortLen {
+		t.Fatal("Expected features reply of 2 ports, obtained:", msg.Header)
+	}
+
+	if binary.BigEndian.Uint64(msg.body) != 5 || binary.BigEndian.Uint16(msg.body[24+phyPortLen:]) != 2 {
+		t.Fatal("Expected datapath 5 with port 2, obtained:", msg.body)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func read(t *testing.T, conn net.Conn) message {
+	msg, err := readMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func flowMod(inPort, outPort uint16, bufferId uint32) []byte {
+	b := make([]byte, flowModLen+actionLen)
+	b[0], b[1] = VERSION, T_FLOW_MOD
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	binary.BigEndian.PutUint32(b[8:], FW_ALL&^FW_IN_PORT)
+	binary.BigEndian.PutUint16(b[12:], inPort)
+	binary.BigEndian.PutUint16(b[62:], 10)
+	binary.BigEndian.PutUint32(b[64:], bufferId)
+	binary.BigEndian.PutUint16(b[68:], P_NONE)
+	binary.BigEndian.PutUint16(b[74:], actionLen)
+	binary.BigEndian.PutUint16(b[76:], outPort)
+
+	return b
+}
+
+func TestSwitch(t *testing.T) {
+	sw := NewSwitch(5, 1, 2)
+	sw.Buffers = true
+	defer sw.Close()
+
+	conn := controller(t, sw)
+	defer conn.Close()
+
+	data := bytes.Repeat([]byte{0xaa}, 60)
+
+	// table miss goes to controller
+	sw.Inject(1, data)
+
+	msg := read(t, conn)
+	if msg.Type != T_PACKET_IN || binary.BigEndian.Uint16(msg.body[6:]) != 1 || !bytes.Equal(msg.body[10:], data) {
+		t.Fatal("Expected packet in from port 1, obtained:", msg.Header, msg.body)
+	}
+
+	// flow mod releases the buffer and forwards the next packet
+	conn.Write(flowMod(1, 2, binary.BigEndian.Uint32(msg.body)))
+	conn.Write(message{Header: Header{Type: T_BARRIER_REQUEST, Xid: 9}}.marshal())
+
+	if msg = read(t, conn); msg.Type != T_BARRIER_REPLY || msg.Xid != 9 {
+		t.Fatal("Expected barrier reply, obtained:", msg.Header)
+	}
+
+	sw.Inject(1, data)
+
+	if out := sw.Transmitted(2); len(out) != 2 || !bytes.Equal(out[1], data) {
+		t.Fatal("Expected 2 packets on port 2, obtained:", out)
+	}
+
+	if flows := sw.Flows(); len(flows) != 1 || flows[0].Match.InPort != 1 || flows[0].PacketCount != 2 {
+		t.Fatal("Expected flow of port 1 matched twice, obtained:", flows)
+	}
+
+	// unknown buffer is an error
+	conn.Write(flowMod(2, 1, 77))
+
+	if msg = read(t, conn); msg.Type != T_ERROR || binary.BigEndian.Uint16(msg.body[2:]) != BRC_BUFFER_UNKNOWN {
+		t.Fatal("Expected buffer unknown error, obtained:", msg.Header, msg.body)
+	}
+}
@@ -0,0 +1,429 @@
+package ofp10fake
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+const VERSION = 0x01
+
+// Message types of OpenFlow 1.0
+const (
+	T_HELLO              = 0
+	T_ERROR              = 1
+	T_ECHO_REQUEST       = 2
+	T_ECHO_REPLY         = 3
+	T_VENDOR             = 4
+	T_FEATURES_REQUEST   = 5
+	T_FEATURES_REPLY     = 6
+	T_GET_CONFIG_REQUEST = 7
+	T_GET_CONFIG_REPLY   = 8
+	T_SET_CONFIG         = 9
+	T_PACKET_IN          = 10
+	T_PORT_STATUS        = 12
+	T_PACKET_OUT         = 13
+	T_FLOW_MOD           = 14
+	T_PORT_MOD           = 15
+	T_STATS_REQUEST      = 16
+	T_BARRIER_REQUEST    = 18
+	T_BARRIER_REPLY      = 19
+)
+
+// Reserved ports
+const (
+	P_MAX        = 0xff00
+	P_IN_PORT    = 0xfff8
+	P_TABLE      = 0xfff9
+	P_NORMAL     = 0xfffa
+	P_FLOOD      = 0xfffb
+	P_ALL        = 0xfffc
+	P_CONTROLLER = 0xfffd
+	P_LOCAL      = 0xfffe
+	P_NONE       = 0xffff
+)
+
+const NO_BUFFER = 0xffffffff
+
+// Flow mod commands
+const (
+	FC_ADD           = 0
+	FC_MODIFY        = 1
+	FC_MODIFY_STRICT = 2
+	FC_DELETE        = 3
+	FC_DELETE_STRICT = 4
+)
+
+// Wildcards of match
+const (
+	FW_IN_PORT      = 1 << 0
+	FW_DL_VLAN      = 1 << 1
+	FW_DL_SRC       = 1 << 2
+	FW_DL_DST       = 1 << 3
+	FW_DL_TYPE      = 1 << 4
+	FW_NW_PROTO     = 1 << 5
+	FW_TP_SRC       = 1 << 6
+	FW_TP_DST       = 1 << 7
+	FW_NW_SRC_SHIFT = 8
+	FW_NW_DST_SHIFT = 14
+	FW_DL_VLAN_PCP  = 1 << 20
+	FW_NW_TOS       = 1 << 21
+	FW_ALL          = (1 << 22) - 1
+)
+
+// Action types
+const (
+	AT_OUTPUT     = 0
+	AT_SET_DL_SRC = 4
+	AT_SET_DL_DST = 5
+)
+
+// Packet in reasons
+const (
+	R_NO_MATCH = 0
+	R_ACTION   = 1
+)
+
+// Port status reasons
+const (
+	PR_ADD    = 0
+	PR_DELETE = 1
+)
+
+const (
+	headerLen     = 8
+	matchLen      = 40
+	phyPortLen    = 48
+	flowModLen    = 72
+	packetOutLen  = 16
+	packetInLen   = 18
+	actionLen     = 8
+	actionAddrLen = 16
+)
+
+var ErrShort = errors.New("Message is too short")
+
+// Header starts every message
+type Header struct {
+	Version uint8
+	Type    uint8
+	Length  uint16
+	Xid     uint32
+}
+
+// message is a raw message, body follows the header
+type message struct {
+	Header
+	body []byte
+}
+
+func readMessage(r io.Reader) (message, error) {
+	var result message
+
+	b := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return result, err
+	}
+
+	result.Version = b[0]
+	result.Type = b[1]
+	result.Length = binary.BigEndian.Uint16(b[2:])
+	result.Xid = binary.BigEndian.Uint32(b[4:])
+
+	if result.Length < headerLen {
+		return result, ErrShort
+	}
+
+	result.body = make([]byte, result.Length-headerLen)
+	_, err := io.ReadFull(r, result.body)
+
+	return result, err
+}
+
+func (this message) marshal() []byte {
+	b := make([]byte, headerLen, headerLen+len(this.body))
+	b[0] = VERSION
+	b[1] = this.Type
+	binary.BigEndian.PutUint16(b[2:], uint16(headerLen+len(this.body)))
+	binary.BigEndian.PutUint32(b[4:], this.Xid)
+
+	return append(b, this.body...)
+}
+
+// Match of flow entry, VLAN and ToS fields aren't matched by the fake
+type Match struct {
+	Wildcards uint32
+	InPort    uint16
+	DLSrc     net.HardwareAddr
+	DLDst     net.HardwareAddr
+	DLType    uint16
+	NWProto   uint8
+	NWSrc     net.IP
+	NWDst     net.IP
+	TPSrc     uint16
+	TPDst     uint16
+}
+
+func (this *Match) unmarshal(b []byte) error {
+	if len(b) < matchLen {
+		return ErrShort
+	}
+
+	this.Wildcards = binary.BigEndian.Uint32(b)
+	this.InPort = binary.BigEndian.Uint16(b[4:])
+	this.DLSrc = append(net.HardwareAddr(nil), b[6:12]...)
+	this.DLDst = append(net.HardwareAddr(nil), b[12:18]...)
+	this.DLType = binary.BigEndian.Uint16(b[22:])
+	this.NWProto = b[25]
+	this.NWSrc = append(net.IP(nil), b[28:32]...)
+	this.NWDst = append(net.IP(nil), b[32:36]...)
+	this.TPSrc = binary.BigEndian.Uint16(b[36:])
+	this.TPDst = binary.BigEndian.Uint16(b[38:])
+
+	return nil
+}
+
+// nwMask returns mask of the address wildcarded by bits at shift
+func (this *Match) nwMask(shift uint) net.IPMask {
+	bits := int(this.Wildcards>>shift) & 0x3f
+	if bits > 32 {
+		bits = 32
+	}
+
+	return net.CIDRMask(32-bits, 32)
+}
+
+// Covers tells, that the match selects packet with fields of other, which
+// wildcards nothing
+func (this Match) Covers(other Match) bool {
+	w := this.Wildcards
+
+	switch {
+	case w&FW_IN_PORT == 0 && this.InPort != other.InPort:
+		return false
+	case w&FW_DL_SRC == 0 && this.DLSrc.String() != other.DLSrc.String():
+		return false
+	case w&FW_DL_DST == 0 && this.DLDst.String() != other.DLDst.String():
+		return false
+	case w&FW_DL_TYPE == 0 && this.DLType != other.DLType:
+		return false
+	case w&FW_NW_PROTO == 0 && this.NWProto != other.NWProto:
+		return false
+	case w&FW_TP_SRC == 0 && this.TPSrc != other.TPSrc:
+		return false
+	case w&FW_TP_DST == 0 && this.TPDst != other.TPDst:
+		return false
+	}
+
+	return nwEqual(this.NWSrc, other.NWSrc, this.nwMask(FW_NW_SRC_SHIFT)) &&
+		nwEqual(this.NWDst, other.NWDst, this.nwMask(FW_NW_DST_SHIFT))
+}
+
+// nwEqual compares addresses under the mask, nil is 0.0.0.0
+func nwEqual(a, b net.IP, mask net.IPMask) bool {
+	if a == nil {
+		a = net.IPv4zero
+	}
+
+	if b == nil {
+		b = net.IPv4zero
+	}
+
+	return a.To4().Mask(mask).Equal(b.To4().Mask(mask))
+}
+
+// Equal tells, that matches select the same packets
+func (this Match) Equal(other Match) bool {
+	return this.Wildcards == other.Wildcards && this.Covers(other) && other.Covers(this)
+}
+
+// Action outputs to Port or sets DLAddr, as Type says
+type Action struct {
+	Type   uint16
+	Port   uint16
+	DLAddr net.HardwareAddr
+}
+
+func unmarshalActions(b []byte) ([]Action, error) {
+	result := make([]Action, 0)
+
+	for len(b) > 0 {
+		if len(b) < actionLen {
+			return nil, ErrShort
+		}
+
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < actionLen || len(b) < length {
+			return nil, ErrShort
+		}
+
+		a := Action{Type: binary.BigEndian.Uint16(b)}
+
+		switch a.Type {
+		case AT_OUTPUT:
+			a.Port = binary.BigEndian.Uint16(b[4:])
+		case AT_SET_DL_SRC, AT_SET_DL_DST:
+			if length < actionAddrLen {
+				return nil, ErrShort
+			}
+
+			a.DLAddr = append(net.HardwareAddr(nil), b[4:10]...)
+		}
+
+		result = append(result, a)
+		b = b[length:]
+	}
+
+	return result, nil
+}
+
+// FlowMod is body of flow mod message
+type FlowMod struct {
+	Match       Match
+	Cookie      uint64
+	Command     uint16
+	IdleTimeout uint16
+	HardTimeout uint16
+	Priority    uint16
+	BufferId    uint32
+	OutPort     uint16
+	Flags       uint16
+	Actions     []Action
+}
+
+func (this *FlowMod) unmarshal(b []byte) error {
+	if len(b)+headerLen < flowModLen {
+		return ErrShort
+	}
+
+	if err := this.Match.unmarshal(b); err != nil {
+		return err
+	}
+
+	b = b[matchLen:]
+
+	this.Cookie = binary.BigEndian.Uint64(b)
+	this.Command = binary.BigEndian.Uint16(b[8:])
+	this.IdleTimeout = binary.BigEndian.Uint16(b[10:])
+	this.HardTimeout = binary.BigEndian.Uint16(b[12:])
+	this.Priority = binary.BigEndian.Uint16(b[14:])
+	this.BufferId = binary.BigEndian.Uint32(b[16:])
+	this.OutPort = binary.BigEndian.Uint16(b[20:])
+	this.Flags = binary.BigEndian.Uint16(b[22:])
+
+	var err error
+	this.Actions, err = unmarshalActions(b[24:])
+
+	return err
+}
+
+// PacketOut is body of packet out message
+type PacketOut struct {
+	BufferId uint32
+	InPort   uint16
+	Actions  []Action
+	Data     []byte
+}
+
+func (this *PacketOut) unmarshal(b []byte) error {
+	if len(b)+headerLen < packetOutLen {
+		return ErrShort
+	}
+
+	this.BufferId = binary.BigEndian.Uint32(b)
+	this.InPort = binary.BigEndian.Uint16(b[4:])
+
+	n := int(binary.BigEndian.Uint16(b[6:]))
+	if len(b) < 8+n {
+		return ErrShort
+	}
+
+	var err error
+	if this.Actions, err = unmarshalActions(b[8 : 8+n]); err != nil {
+		return err
+	}
+
+	this.Data = append([]byte(nil), b[8+n:]...)
+
+	return nil
+}
+
+func packetIn(bufferId uint32, inPort uint16, reason uint8, data []byte) message {
+	b := make([]byte, packetInLen-headerLen, packetInLen-headerLen+len(data))
+	binary.BigEndian.PutUint32(b, bufferId)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(data)))
+	binary.BigEndian.PutUint16(b[6:], inPort)
+	b[8] = reason
+
+	return message{Header{Type: T_PACKET_IN}, append(b, data...)}
+}
+
+// Port is description of switch port
+type Port struct {
+	PortNo uint16
+	HWAddr net.HardwareAddr
+	Name   string
+	Config uint32
+	State  uint32
+}
+
+func (this Port) marshal() []byte {
+	b := make([]byte, phyPortLen)
+	binary.BigEndian.PutUint16(b, this.PortNo)
+	copy(b[2:8], this.HWAddr)
+	copy(b[8:23], this.Name)
+	binary.BigEndian.PutUint32(b[24:], this.Config)
+	binary.BigEndian.PutUint32(b[28:], this.State)
+
+	return b
+}
+
+func featuresReply(xid uint32, dpid uint64, buffers uint32, ports []Port) message {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint64(b, dpid)
+	binary.BigEndian.PutUint32(b[8:], buffers)
+	// one table
+	b[12] = 1
+	// output, set_dl_src and set_dl_dst actions
+	binary.BigEndian.PutUint32(b[20:], 1<<AT_OUTPUT|1<<AT_SET_DL_SRC|1<<AT_SET_DL_DST)
+
+	for _, p := range ports {
+		b = append(b, p.marshal()...)
+	}
+
+	return message{Header{Type: T_FEATURES_REPLY, Xid: xid}, b}
+}
+
+func portStatus(reason uint8, p Port) message {
+	b := make([]byte, 8)
+	b[0] = reason
+
+	return message{Header{Type: T_PORT_STATUS}, append(b, p.marshal()...)}
+}
+
+// Error types and codes sent by the fake
+const (
+	ET_BAD_REQUEST = 1
+	ET_FLOW_MOD    = 3
+
+	BRC_BAD_TYPE       = 1
+	BRC_BAD_STAT       = 2
+	BRC_BAD_VENDOR     = 3
+	BRC_BAD_LEN        = 6
+	BRC_BUFFER_UNKNOWN = 8
+	FMFC_BAD_COMMAND   = 4
+)
+
+func errorMsg(request message, typ, code uint16) message {
+	data := request.marshal()
+	if len(data) > 64 {
+		data = data[:64]
+	}
+
+	b := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint16(b, typ)
+	binary.BigEndian.PutUint16(b[2:], code)
+
+	return message{Header{Type: T_ERROR, Xid: request.Xid}, append(b, data...)}
+}
@@ -0,0 +1,283 @@
+package ofp13
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Action types
+const (
+	AT_OUTPUT    = 0
+	AT_GROUP     = 22
+	AT_SET_FIELD = 25
+)
+
+// Action is applied to a packet by flow instructions, packet out or group
+// bucket
+type Action interface {
+	marshal() []byte
+}
+
+type ActionOutput struct {
+	Port   uint32
+	MaxLen uint16
+}
+
+// NewActionOutput sends the packet to the port, whole packet is sent to
+// controller
+func NewActionOutput(port uint32) *ActionOutput {
+	return &ActionOutput{Port: port, MaxLen: CML_NO_BUFFER}
+}
+
+func (this *ActionOutput) marshal() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint16(b, AT_OUTPUT)
+	binary.BigEndian.PutUint16(b[2:], 16)
+	binary.BigEndian.PutUint32(b[4:], this.Port)
+	binary.BigEndian.PutUint16(b[8:], this.MaxLen)
+
+	return b
+}
+
+type ActionGroup struct {
+	GroupId uint32
+}
+
+func NewActionGroup(id uint32) *ActionGroup {
+	return &ActionGroup{GroupId: id}
+}
+
+func (this *ActionGroup) marshal() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b, AT_GROUP)
+	binary.BigEndian.PutUint16(b[2:], 8)
+	binary.BigEndian.PutUint32(b[4:], this.GroupId)
+
+	return b
+}
+
+// ActionSetField rewrites packet fields, which are set in Field
+type ActionSetField struct {
+	Field Match
+}
+
+func NewActionSetField(field Match) *ActionSetField {
+	return &ActionSetField{Field: field}
+}
+
+func (this *ActionSetField) marshal() []byte {
+	fields := this.Field.fields()
+
+	b := make([]byte, pad8(4+len(fields)))
+	binary.BigEndian.PutUint16(b, AT_SET_FIELD)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	copy(b[4:], fields)
+
+	return b
+}
+
+// ActionRaw is action of type, which isn't decoded
+type ActionRaw struct {
+	Type uint16
+	Body []byte
+}
+
+func (this *ActionRaw) marshal() []byte {
+	b := make([]byte, 4, 4+len(this.Body))
+	binary.BigEndian.PutUint16(b, this.Type)
+	binary.BigEndian.PutUint16(b[2:], uint16(4+len(this.Body)))
+
+	return append(b, this.Body...)
+}
+
+func marshalActions(actions []Action) []byte {
+	b := make([]byte, 0)
+	for _, a := range actions {
+		b = append(b, a.marshal()...)
+	}
+
+	return b
+}
+
+func unmarshalActions(b []byte) ([]Action, error) {
+	result := make([]Action, 0)
+
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, ErrShort
+		}
+
+		typ, length := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if length < 4 || len(b) < length {
+			return nil, errors.New(fmt.Sprintf("Wrong action length %d", length))
+		}
+
+		switch {
+		case typ == AT_OUTPUT && length >= 10:
+			result = append(result, &ActionOutput{Port: binary.BigEndian.Uint32(b[4:]), MaxLen: binary.BigEndian.Uint16(b[8:])})
+		case typ == AT_GROUP && length >= 8:
+			result = append(result, &ActionGroup{GroupId: binary.BigEndian.Uint32(b[4:])})
+		case typ == AT_SET_FIELD:
+			a := &ActionSetField{}
+			if err := a.Field.setFields(trimOxm(b[4:length])); err != nil {
+				return nil, err
+			}
+			result = append(result, a)
+		default:
+			result = append(result, &ActionRaw{Type: typ, Body: append([]byte(nil), b[4:length]...)})
+		}
+
+		b = b[length:]
+	}
+
+	return result, nil
+}
+
+// trimOxm drops padding after the single OXM of set field action
+func trimOxm(b []byte) []byte {
+	if len(b) >= 4 && 4+int(b[3]) <= len(b) {
+		return b[:4+int(b[3])]
+	}
+
+	return b
+}
+
+// Instruction types
+const (
+	IT_GOTO_TABLE    = 1
+	IT_WRITE_ACTIONS = 3
+	IT_APPLY_ACTIONS = 4
+	IT_CLEAR_ACTIONS = 5
+	IT_METER         = 6
+)
+
+// Instruction of a flow, it's executed, when packet matches the flow
+type Instruction interface {
+	marshal() []byte
+}
+
+type InstructionGotoTable struct {
+	TableId uint8
+}
+
+func NewInstructionGotoTable(id uint8) *InstructionGotoTable {
+	return &InstructionGotoTable{TableId: id}
+}
+
+func (this *InstructionGotoTable) marshal() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b, IT_GOTO_TABLE)
+	binary.BigEndian.PutUint16(b[2:], 8)
+	b[4] = this.TableId
+
+	return b
+}
+
+// InstructionActions applies actions at once (IT_APPLY_ACTIONS), writes
+// them to action set (IT_WRITE_ACTIONS) or clears the set
+// (IT_CLEAR_ACTIONS)
+type InstructionActions struct {
+	Type    uint16
+	Actions []Action
+}
+
+func NewInstructionApplyActions(actions ...Action) *InstructionActions {
+	return &InstructionActions{Type: IT_APPLY_ACTIONS, Actions: actions}
+}
+
+func NewInstructionWriteActions(actions ...Action) *InstructionActions {
+	return &InstructionActions{Type: IT_WRITE_ACTIONS, Actions: actions}
+}
+
+func NewInstructionClearActions() *InstructionActions {
+	return &InstructionActions{Type: IT_CLEAR_ACTIONS}
+}
+
+func (this *InstructionActions) AddAction(a Action) {
+	this.Actions = append(this.Actions, a)
+}
+
+func (this *InstructionActions) marshal() []byte {
+	actions := marshalActions(this.Actions)
+
+	b := make([]byte, 8, 8+len(actions))
+	binary.BigEndian.PutUint16(b, this.Type)
+	binary.BigEndian.PutUint16(b[2:], uint16(8+len(actions)))
+
+	return append(b, actions...)
+}
+
+type InstructionMeter struct {
+	MeterId uint32
+}
+
+func NewInstructionMeter(id uint32) *InstructionMeter {
+	return &InstructionMeter{MeterId: id}
+}
+
+func (this *InstructionMeter) marshal() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b, IT_METER)
+	binary.BigEndian.PutUint16(b[2:], 8)
+	binary.BigEndian.PutUint32(b[4:], this.MeterId)
+
+	return b
+}
+
+// InstructionRaw is instruction of type, which isn't decoded
+type InstructionRaw struct {
+	Type uint16
+	Body []byte
+}
+
+func (this *InstructionRaw) marshal() []byte {
+	b := make([]byte, 4, 4+len(this.Body))
+	binary.BigEndian.PutUint16(b, this.Type)
+	binary.BigEndian.PutUint16(b[2:], uint16(4+len(this.Body)))
+
+	return append(b, this.Body...)
+}
+
+func marshalInstructions(instructions []Instruction) []byte {
+	b := make([]byte, 0)
+	for _, i := range instructions {
+		b = append(b, i.marshal()...)
+	}
+
+	return b
+}
+
+func unmarshalInstructions(b []byte) ([]Instruction, error) {
+	result := make([]Instruction, 0)
+
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, ErrShort
+		}
+
+		typ, length := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if length < 4 || len(b) < length {
+			return nil, errors.New(fmt.Sprintf("Wrong instruction length %d", length))
+		}
+
+		switch {
+		case typ == IT_GOTO_TABLE && length >= 8:
+			result = append(result, &InstructionGotoTable{TableId: b[4]})
+		case (typ == IT_WRITE_ACTIONS || typ == IT_APPLY_ACTIONS || typ == IT_CLEAR_ACTIONS) && length >= 8:
+			actions, err := unmarshalActions(b[8:length])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, &InstructionActions{Type: typ, Actions: actions})
+		case typ == IT_METER && length >= 8:
+			result = append(result, &InstructionMeter{MeterId: binary.BigEndian.Uint32(b[4:])})
+		default:
+			result = append(result, &InstructionRaw{Type: typ, Body: append([]byte(nil), b[4:length]...)})
+		}
+
+		b = b[length:]
+	}
+
+	return result, nil
+}
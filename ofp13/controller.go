@@ -0,0 +1,537 @@
+package ofp13
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Applications implement reactors for events they are interested in
+type ConnectionUpReactor interface {
+	ConnectionUp(sw *Switch)
+}
+
+type ConnectionDownReactor interface {
+	ConnectionDown(sw *Switch)
+}
+
+type PacketInReactor interface {
+	PacketIn(sw *Switch, pkt *PacketIn)
+}
+
+type PortStatusReactor interface {
+	PortStatus(sw *Switch, msg *PortStatus)
+}
+
+// RequestTimeout is how long Request waits for reply of a switch
+var RequestTimeout = 5 * time.Second
+
+var ErrDisconnected = errors.New("Switch disconnected")
+
+// Switch is a datapath connected to controller
+type Switch struct {
+	conn     net.Conn
+	features FeaturesReply
+	xid      uint32
+	writeMu  sync.Mutex
+
+	sync.Mutex
+	ports   map[uint32]Port
+	pending map[uint32]chan Message
+
+	apps   []interface{}
+	events chan Message
+	done   chan struct{}
+}
+
+func newSwitch(conn net.Conn, features FeaturesReply) *Switch {
+	return &Switch{
+		conn:     conn,
+		features: features,
+		xid:      features.Header.Xid,
+		ports:    make(map[uint32]Port),
+		pending:  make(map[uint32]chan Message),
+		apps:     make([]interface{}, 0),
+		events:   make(chan Message, 1024),
+		done:     make(chan struct{}),
+	}
+}
+
+func (this *Switch) DPID() net.HardwareAddr {
+	return DPID(this.features.DatapathId)
+}
+
+func (this *Switch) String() string {
+	return this.DPID().String()
+}
+
+func (this *Switch) Features() FeaturesReply {
+	return this.features
+}
+
+// Ports returns ports of the switch ordered by number, local port isn't
+// included
+func (this *Switch) Ports() []Port {
+	this.Lock()
+	defer this.Unlock()
+
+	result := make([]Port, 0, len(this.ports))
+	for _, p := range this.ports {
+		if p.PortNo <= P_MAX {
+			result = append(result, p)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].PortNo < result[j].PortNo
+	})
+
+	return result
+}
+
+func (this *Switch) Port(no uint32) (Port, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	p, found := this.ports[no]
+	return p, found
+}
+
+func (this *Switch) setPorts(ports []Port) {
+	this.Lock()
+	defer this.Unlock()
+
+	for _, p := range ports {
+		this.ports[p.PortNo] = p
+	}
+}
+
+// Send writes message to the switch, it gets new xid, if it has none
+func (this *Switch) Send(msg Message) error {
+	if msg.header().Xid == 0 {
+		msg.header().Xid = atomic.AddUint32(&this.xid, 1)
+	}
+
+	this.writeMu.Lock()
+	defer this.writeMu.Unlock()
+
+	return WriteMessage(this.conn, msg)
+}
+
+// Request sends message and waits for the reply, multipart reply could
+// take several messages. Error of the switch is returned as *Error.
+func (this *Switch) Request(msg Message) ([]Message, error) {
+	xid := atomic.AddUint32(&this.xid, 1)
+	msg.header().Xid = xid
+
+	ch := make(chan Message, 64)
+
+	this.Lock()
+	this.pending[xid] = ch
+	this.Unlock()
+
+	defer func() {
+		this.Lock()
+		delete(this.pending, xid)
+		this.Unlock()
+	}()
+
+	if err := this.Send(msg); err != nil {
+		return nil, err
+	}
+
+	result := make([]Message, 0)
+	timeout := time.After(RequestTimeout)
+
+	for {
+		select {
+		case reply := <-ch:
+			if err, ok := reply.(*Error); ok {
+				return nil, err
+			}
+
+			result = append(result, reply)
+
+			if mp, ok := reply.(*MultipartReply); ok && mp.More() {
+				continue
+			}
+
+			return result, nil
+		case <-timeout:
+			return nil, errors.New(fmt.Sprintf("No reply of %s for xid %d", this, xid))
+		case <-this.done:
+			return nil, ErrDisconnected
+		}
+	}
+}
+
+// Barrier waits, until switch processes messages sent before
+func (this *Switch) Barrier() error {
+	_, err := this.Request(NewBarrierRequest())
+	return err
+}
+
+// Apply sends message, which has no reply on success, e.g. flow mod, and
+// waits, until switch processes it. Error of the switch is returned as
+// *Error.
+func (this *Switch) Apply(msg Message) error {
+	xid := atomic.AddUint32(&this.xid, 1)
+	msg.header().Xid = xid
+
+	ch := make(chan Message, 1)
+
+	this.Lock()
+	this.pending[xid] = ch
+	this.Unlock()
+
+	defer func() {
+		this.Lock()
+		delete(this.pending, xid)
+		this.Unlock()
+	}()
+
+	if err := this.Send(msg); err != nil {
+		return err
+	}
+
+	// error of the message comes before reply of the barrier
+	if err := this.Barrier(); err != nil {
+		return err
+	}
+
+	select {
+	case reply := <-ch:
+		if err, ok := reply.(*Error); ok {
+			return err
+		}
+	default:
+	}
+
+	return nil
+}
+
+func (this *Switch) Disconnect() {
+	this.conn.Close()
+}
+
+// reply passes message to Request waiting for it
+func (this *Switch) reply(msg Message) bool {
+	this.Lock()
+	ch, found := this.pending[msg.header().Xid]
+	this.Unlock()
+
+	if !found {
+		return false
+	}
+
+	select {
+	case ch <- msg:
+	default:
+		log.Println("Dropped reply of", this, "for xid", msg.header().Xid)
+	}
+
+	return true
+}
+
+// syncEvent is queued by Sync after events received before
+type syncEvent struct {
+	Raw
+	done chan struct{}
+}
+
+// Sync waits, until applications handle events received from the switch
+// before and the switch processes messages they have sent
+func (this *Switch) Sync() error {
+	if err := this.Barrier(); err != nil {
+		return err
+	}
+
+	event := &syncEvent{done: make(chan struct{})}
+
+	select {
+	case this.events <- event:
+	case <-this.done:
+		return ErrDisconnected
+	}
+
+	select {
+	case <-event.done:
+	case <-this.done:
+		return ErrDisconnected
+	}
+
+	return this.Barrier()
+}
+
+// dispatch calls applications, events of a switch are handled in order.
+// Events received before disconnect are handled too.
+func (this *Switch) dispatch() {
+	for _, app := range this.apps {
+		if r, ok := app.(ConnectionUpReactor); ok {
+			r.ConnectionUp(this)
+		}
+	}
+
+	for {
+		select {
+		case msg := <-this.events:
+			this.handle(msg)
+			continue
+		case <-this.done:
+		}
+
+		for len(this.events) > 0 {
+			this.handle(<-this.events)
+		}
+
+		break
+	}
+
+	for _, app := range this.apps {
+		if r, ok := app.(ConnectionDownReactor); ok {
+			r.ConnectionDown(this)
+		}
+	}
+}
+
+func (this *Switch) handle(msg Message) {
+	if e, ok := msg.(*syncEvent); ok {
+		close(e.done)
+		return
+	}
+
+	for _, app := range this.apps {
+		switch m := msg.(type) {
+		case *PacketIn:
+			if r, ok := app.(PacketInReactor); ok {
+				r.PacketIn(this, m)
+			}
+		case *PortStatus:
+			if r, ok := app.(PortStatusReactor); ok {
+				r.PortStatus(this, m)
+			}
+		}
+	}
+}
+
+// Controller accepts OpenFlow 1.3 switches and passes their events to
+// applications
+type Controller struct {
+	sync.RWMutex
+	apps     []func() interface{}
+	switches map[string]*Switch
+}
+
+func NewController() *Controller {
+	return &Controller{
+		apps:     make([]func() interface{}, 0),
+		switches: make(map[string]*Switch),
+	}
+}
+
+// RegisterApplication adds application, f creates its instance for every
+// connected switch
+func (this *Controller) RegisterApplication(f func() interface{}) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.apps = append(this.apps, f)
+}
+
+func (this *Controller) Switch(dpid net.HardwareAddr) (*Switch, bool) {
+	this.RLock()
+	defer this.RUnlock()
+
+	sw, found := this.switches[dpid.String()]
+	return sw, found
+}
+
+// Switches returns connected switches ordered by dpid
+func (this *Controller) Switches() []*Switch {
+	this.RLock()
+	defer this.RUnlock()
+
+	result := make([]*Switch, 0, len(this.switches))
+	for _, sw := range this.switches {
+		result = append(result, sw)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].features.DatapathId < result[j].features.DatapathId
+	})
+
+	return result
+}
+
+// Listen accepts switches on tcp address, e.g. ":6633"
+func (this *Controller) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return this.Serve(l)
+}
+
+func (this *Controller) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if err := this.ServeConn(conn); err != nil {
+				log.Println("Switch", conn.RemoteAddr(), "disconnected:", err)
+			}
+		}()
+	}
+}
+
+// ServeConn talks to the switch until it disconnects
+func (this *Controller) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	sw, err := this.handshake(conn)
+	if err != nil {
+		return err
+	}
+
+	read := make(chan error, 1)
+	go func() {
+		read <- this.read(sw)
+	}()
+
+	reply, err := sw.Request(NewPortDescRequest())
+	if err != nil {
+		conn.Close()
+		<-read
+		return errors.New(fmt.Sprintf("Unable to get ports of %s: %v", sw, err))
+	}
+
+	for _, msg := range reply {
+		if mp, ok := msg.(*MultipartReply); ok {
+			ports, err := mp.Ports()
+			if err != nil {
+				conn.Close()
+				<-read
+				return err
+			}
+
+			sw.setPorts(ports)
+		}
+	}
+
+	this.Lock()
+	if prev, found := this.switches[sw.DPID().String()]; found {
+		prev.Disconnect()
+	}
+
+	this.switches[sw.DPID().String()] = sw
+
+	for _, f := range this.apps {
+		sw.apps = append(sw.apps, f())
+	}
+	this.Unlock()
+
+	log.Println("Switch", sw, "connected from", conn.RemoteAddr())
+
+	dispatched := make(chan struct{})
+	go func() {
+		sw.dispatch()
+		close(dispatched)
+	}()
+
+	err = <-read
+
+	this.Lock()
+	if this.switches[sw.DPID().String()] == sw {
+		delete(this.switches, sw.DPID().String())
+	}
+	this.Unlock()
+
+	<-dispatched
+
+	return err
+}
+
+// handshake agrees on version and reads features of the switch
+func (this *Controller) handshake(conn net.Conn) (*Switch, error) {
+	if err := WriteMessage(conn, NewHello()); err != nil {
+		return nil, err
+	}
+
+	hello, err := ReadMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := hello.(*Hello); !ok {
+		return nil, errors.New(fmt.Sprintf("Expected hello, obtained message type %d", hello.header().Type))
+	}
+
+	if hello.header().Version < Version {
+		WriteMessage(conn, &Error{ErrType: 0, Code: 0, Data: []byte("OpenFlow 1.3 is required")})
+		return nil, errors.New(fmt.Sprintf("Switch speaks OpenFlow version %d, 1.3 is required", hello.header().Version))
+	}
+
+	if err := WriteMessage(conn, NewFeaturesRequest()); err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := ReadMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		switch m := msg.(type) {
+		case *FeaturesReply:
+			return newSwitch(conn, *m), nil
+		case *EchoRequest:
+			WriteMessage(conn, &EchoReply{echo{Header: Header{Xid: m.Header.Xid}, Data: m.Data}})
+		case *Error:
+			return nil, m
+		}
+	}
+}
+
+// read handles messages of the switch, until it disconnects
+func (this *Controller) read(sw *Switch) error {
+	defer close(sw.done)
+
+	for {
+		msg, err := ReadMessage(sw.conn)
+		if err != nil {
+			return err
+		}
+
+		switch m := msg.(type) {
+		case *EchoRequest:
+			sw.Send(&EchoReply{echo{Header: Header{Xid: m.Header.Xid}, Data: m.Data}})
+		case *PacketIn:
+			sw.events <- m
+		case *PortStatus:
+			sw.Lock()
+			if m.Reason == PR_DELETE {
+				delete(sw.ports, m.Desc.PortNo)
+			} else {
+				sw.ports[m.Desc.PortNo] = m.Desc
+			}
+			sw.Unlock()
+
+			sw.events <- m
+		default:
+			if !sw.reply(msg) {
+				if e, ok := msg.(*Error); ok {
+					log.Println("Switch", sw, "error:", e)
+				}
+			}
+		}
+	}
+}
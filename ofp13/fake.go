@@ -0,0 +1,825 @@
+package ofp13
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Error types and codes sent by FakeSwitch
+const (
+	ET_BAD_REQUEST = 1
+	ET_FLOW_MOD    = 5
+	ET_GROUP_MOD   = 6
+
+	BRC_BAD_TYPE       = 1
+	BRC_BAD_MULTIPART  = 2
+	BRC_BUFFER_UNKNOWN = 8
+	BRC_BAD_TABLE_ID   = 9
+	BRC_BAD_PORT       = 11
+	FMFC_BAD_TABLE_ID  = 2
+	GMFC_UNKNOWN_GROUP = 8
+)
+
+// FakeTables is number of flow tables of FakeSwitch
+const FakeTables = 8
+
+// FakeFlow is a flow entry of FakeSwitch with its counters
+type FakeFlow struct {
+	FlowMod
+	PacketCount uint64
+	ByteCount   uint64
+	added       time.Time
+}
+
+type fakePacket struct {
+	inPort uint32
+	data   []byte
+}
+
+type fakePort struct {
+	Port
+	peer        *FakeSwitch
+	peerPort    uint32
+	transmitted [][]byte
+	stats       PortStats
+	added       time.Time
+}
+
+// FakeSwitch is an in-memory OpenFlow 1.3 datapath for tests of network
+// applications. It has flow tables with goto, apply and write actions,
+// groups, meters (which don't limit rate), packet buffers and ports, which
+// could be linked to ports of other fake switches. Packets output to other
+// ports are kept, until taken by Transmitted. Flow, table and port stats
+// requests are answered with counters of the packets passed.
+type FakeSwitch struct {
+	// Buffers makes switch buffer packets sent to controller
+	Buffers bool
+
+	dpid uint64
+
+	sync.Mutex
+	ports      map[uint32]*fakePort
+	tables     map[uint8][]*FakeFlow
+	groups     map[uint32]*GroupMod
+	meters     map[uint32]*MeterMod
+	buffers    map[uint32]fakePacket
+	nextBuffer uint32
+	lookups    [FakeTables]uint64
+	matched    [FakeTables]uint64
+	conn       net.Conn
+	ctrl       *Controller
+
+	queueMu sync.Mutex
+	queue   []func()
+	wakeup  chan struct{}
+	closed  chan struct{}
+}
+
+// NewFakeSwitch returns switch with given datapath id and port numbers,
+// ports are named s<dpid>-eth<no>
+func NewFakeSwitch(dpid uint64, ports ...uint32) *FakeSwitch {
+	result := &FakeSwitch{
+		dpid:    dpid,
+		ports:   make(map[uint32]*fakePort),
+		tables:  make(map[uint8][]*FakeFlow),
+		groups:  make(map[uint32]*GroupMod),
+		meters:  make(map[uint32]*MeterMod),
+		buffers: make(map[uint32]fakePacket),
+		wakeup:  make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+
+	for _, no := range ports {
+		result.ports[no] = &fakePort{Port: result.newPort(no), added: time.Now()}
+	}
+
+	go result.run()
+
+	return result
+}
+
+func (this *FakeSwitch) newPort(no uint32) Port {
+	hw := make(net.HardwareAddr, 6)
+	hw[0] = 0x02
+	binary.BigEndian.PutUint16(hw[1:], uint16(this.dpid))
+	binary.BigEndian.PutUint16(hw[4:], uint16(no))
+
+	return Port{
+		PortNo:    no,
+		HWAddr:    hw,
+		Name:      fmt.Sprintf("s%d-eth%d", this.dpid, no),
+		State:     PS_LIVE,
+		CurrSpeed: 10000000,
+		MaxSpeed:  10000000,
+	}
+}
+
+func (this *FakeSwitch) DPID() net.HardwareAddr {
+	return DPID(this.dpid)
+}
+
+// FakeLink connects ports of fake switches, packets output to one port are
+// received by the other
+func FakeLink(a *FakeSwitch, aPort uint32, b *FakeSwitch, bPort uint32) error {
+	for _, end := range []struct {
+		sw, peer     *FakeSwitch
+		no, peerPort uint32
+	}{{a, b, aPort, bPort}, {b, a, bPort, aPort}} {
+		end.sw.Lock()
+		p, found := end.sw.ports[end.no]
+		if found {
+			p.peer, p.peerPort = end.peer, end.peerPort
+		}
+		end.sw.Unlock()
+
+		if !found {
+			return errors.New(fmt.Sprintf("Switch %s has no port %d", end.sw.DPID(), end.no))
+		}
+	}
+
+	return nil
+}
+
+// run executes queued jobs one by one, state of the switch is changed by
+// jobs only
+func (this *FakeSwitch) run() {
+	for {
+		select {
+		case <-this.wakeup:
+		case <-this.closed:
+			return
+		}
+
+		for {
+			this.queueMu.Lock()
+			if len(this.queue) == 0 {
+				this.queueMu.Unlock()
+				break
+			}
+
+			job := this.queue[0]
+			this.queue = this.queue[1:]
+			this.queueMu.Unlock()
+
+			this.Lock()
+			job()
+			this.Unlock()
+		}
+	}
+}
+
+func (this *FakeSwitch) enqueue(job func()) {
+	this.queueMu.Lock()
+	this.queue = append(this.queue, job)
+	this.queueMu.Unlock()
+
+	select {
+	case this.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Connect connects the switch to controller over a pipe and waits, until
+// applications handle connection up
+func (this *FakeSwitch) Connect(ctrl *Controller) error {
+	conn, peer := net.Pipe()
+
+	this.Lock()
+	this.ctrl = ctrl
+	this.Unlock()
+
+	go ctrl.ServeConn(conn)
+	go this.Serve(peer)
+
+	for deadline := time.Now().Add(RequestTimeout); ; time.Sleep(time.Millisecond) {
+		if _, found := ctrl.Switch(this.DPID()); found {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("Switch %s didn't connect", this.DPID()))
+		}
+	}
+
+	return this.Sync()
+}
+
+// Serve talks to controller on conn, until it's closed
+func (this *FakeSwitch) Serve(conn net.Conn) error {
+	this.Lock()
+	this.conn = conn
+	this.Unlock()
+
+	for {
+		msg, err := ReadMessage(conn)
+		if err != nil {
+			return err
+		}
+
+		this.enqueue(func() {
+			this.handle(msg)
+		})
+	}
+}
+
+// Sync waits, until the switch and applications of controller, which it's
+// connected by Connect, handle everything sent before
+func (this *FakeSwitch) Sync() error {
+	this.Lock()
+	ctrl := this.ctrl
+	this.Unlock()
+
+	if ctrl == nil {
+		return errors.New("Switch isn't connected")
+	}
+
+	sw, found := ctrl.Switch(this.DPID())
+	if !found {
+		return ErrDisconnected
+	}
+
+	return sw.Sync()
+}
+
+// Close disconnects the switch and stops it, it could be called twice
+func (this *FakeSwitch) Close() {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.conn != nil {
+		this.conn.Close()
+	}
+
+	select {
+	case <-this.closed:
+	default:
+		close(this.closed)
+	}
+}
+
+// Inject makes packet received on the port
+func (this *FakeSwitch) Inject(port uint32, data []byte) {
+	data = append([]byte(nil), data...)
+
+	this.enqueue(func() {
+		this.receive(port, data)
+	})
+}
+
+// Transmitted returns packets output to the port, which isn't linked to
+// other switch, since the last call
+func (this *FakeSwitch) Transmitted(port uint32) [][]byte {
+	this.Lock()
+	defer this.Unlock()
+
+	p, found := this.ports[port]
+	if !found {
+		return nil
+	}
+
+	result := p.transmitted
+	p.transmitted = nil
+
+	return result
+}
+
+// Flows returns entries of the table ordered by priority
+func (this *FakeSwitch) Flows(table uint8) []FakeFlow {
+	this.Lock()
+	defer this.Unlock()
+
+	result := make([]FakeFlow, 0, len(this.tables[table]))
+	for _, f := range this.tables[table] {
+		result = append(result, *f)
+	}
+
+	return result
+}
+
+func (this *FakeSwitch) Group(id uint32) (GroupMod, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	g, found := this.groups[id]
+	if !found {
+		return GroupMod{}, false
+	}
+
+	return *g, true
+}
+
+func (this *FakeSwitch) Meter(id uint32) (MeterMod, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	m, found := this.meters[id]
+	if !found {
+		return MeterMod{}, false
+	}
+
+	return *m, true
+}
+
+// AddPort adds port and tells controller about it
+func (this *FakeSwitch) AddPort(no uint32) {
+	this.enqueue(func() {
+		p := &fakePort{Port: this.newPort(no), added: time.Now()}
+		this.ports[no] = p
+		this.send(&PortStatus{Reason: PR_ADD, Desc: p.Port})
+	})
+}
+
+// DeletePort deletes port and tells controller about it
+func (this *FakeSwitch) DeletePort(no uint32) {
+	this.enqueue(func() {
+		if p, found := this.ports[no]; found {
+			delete(this.ports, no)
+			this.send(&PortStatus{Reason: PR_DELETE, Desc: p.Port})
+		}
+	})
+}
+
+func (this *FakeSwitch) send(msg Message) {
+	if this.conn != nil {
+		WriteMessage(this.conn, msg)
+	}
+}
+
+func (this *FakeSwitch) sendError(request Message, typ uint16, code uint16) {
+	data, _ := request.MarshalBinary()
+	if len(data) > 64 {
+		data = data[:64]
+	}
+
+	this.send(&Error{Header: Header{Xid: request.header().Xid}, ErrType: typ, Code: code, Data: data})
+}
+
+func (this *FakeSwitch) handle(msg Message) {
+	xid := msg.header().Xid
+
+	switch m := msg.(type) {
+	case *Hello:
+		this.send(&Hello{empty{Header: Header{Xid: xid}}})
+	case *EchoRequest:
+		this.send(&EchoReply{echo{Header: Header{Xid: xid}, Data: m.Data}})
+	case *FeaturesRequest:
+		this.send(&FeaturesReply{Header: Header{Xid: xid}, DatapathId: this.dpid, Buffers: 256, Tables: FakeTables})
+	case *BarrierRequest:
+		this.send(&BarrierReply{empty{Header: Header{Xid: xid}}})
+	case *MultipartRequest:
+		this.multipart(m)
+	case *FlowMod:
+		this.flowMod(m)
+	case *GroupMod:
+		this.groupMod(m)
+	case *MeterMod:
+		switch m.Command {
+		case MC_DELETE:
+			delete(this.meters, m.MeterId)
+		default:
+			this.meters[m.MeterId] = m
+		}
+	case *PacketOut:
+		data := m.Data
+		if m.BufferId != NO_BUFFER {
+			buffered, found := this.buffers[m.BufferId]
+			if !found {
+				this.sendError(m, ET_BAD_REQUEST, BRC_BUFFER_UNKNOWN)
+				return
+			}
+
+			delete(this.buffers, m.BufferId)
+			data = buffered.data
+		}
+
+		this.execute(m.Actions, m.InPort, data, nil)
+	case *EchoReply, *Error:
+	default:
+		this.sendError(msg, ET_BAD_REQUEST, BRC_BAD_TYPE)
+	}
+}
+
+func (this *FakeSwitch) multipart(m *MultipartRequest) {
+	switch m.MpType {
+	case MP_PORT_DESC:
+		ports := make([]Port, 0, len(this.ports))
+		for _, p := range this.ports {
+			ports = append(ports, p.Port)
+		}
+
+		sort.Slice(ports, func(i, j int) bool {
+			return ports[i].PortNo < ports[j].PortNo
+		})
+
+		reply := NewPortDescReply(ports)
+		reply.Header.Xid = m.Header.Xid
+		this.send(reply)
+	case MP_FLOW:
+		r, err := m.FlowStatsRequest()
+		if err != nil {
+			this.sendError(m, ET_BAD_REQUEST, BRC_BAD_MULTIPART)
+			return
+		}
+
+		if r.TableId >= FakeTables && r.TableId != TT_ALL {
+			this.sendError(m, ET_BAD_REQUEST, BRC_BAD_TABLE_ID)
+			return
+		}
+
+		flows := make([]FlowStats, 0)
+		for table := uint8(0); table < FakeTables; table++ {
+			if r.TableId != TT_ALL && r.TableId != table {
+				continue
+			}
+
+			for _, f := range this.tables[table] {
+				if selects(f, r.Match, r.OutPort, r.Cookie, r.CookieMask) {
+					flows = append(flows, f.stats())
+				}
+			}
+		}
+
+		reply := NewFlowStatsReply(flows)
+		reply.Header.Xid = m.Header.Xid
+		this.send(reply)
+	case MP_TABLE:
+		tables := make([]TableStats, 0, FakeTables)
+		for table := uint8(0); table < FakeTables; table++ {
+			tables = append(tables, TableStats{
+				TableId:      table,
+				ActiveCount:  uint32(len(this.tables[table])),
+				LookupCount:  this.lookups[table],
+				MatchedCount: this.matched[table],
+			})
+		}
+
+		reply := NewTableStatsReply(tables)
+		reply.Header.Xid = m.Header.Xid
+		this.send(reply)
+	case MP_PORT:
+		no, err := m.PortStatsRequest()
+		if err != nil {
+			this.sendError(m, ET_BAD_REQUEST, BRC_BAD_MULTIPART)
+			return
+		}
+
+		if _, found := this.ports[no]; !found && no != P_ANY {
+			this.sendError(m, ET_BAD_REQUEST, BRC_BAD_PORT)
+			return
+		}
+
+		ports := make([]PortStats, 0, len(this.ports))
+		for _, p := range this.ports {
+			if no == P_ANY || no == p.PortNo {
+				stats := p.stats
+				stats.PortNo = p.PortNo
+				stats.DurationSec, stats.DurationNsec = duration(p.added)
+				ports = append(ports, stats)
+			}
+		}
+
+		sort.Slice(ports, func(i, j int) bool {
+			return ports[i].PortNo < ports[j].PortNo
+		})
+
+		reply := NewPortStatsReply(ports)
+		reply.Header.Xid = m.Header.Xid
+		this.send(reply)
+	default:
+		this.sendError(m, ET_BAD_REQUEST, BRC_BAD_MULTIPART)
+	}
+}
+
+// duration returns seconds and nanoseconds since t
+func duration(t time.Time) (uint32, uint32) {
+	d := time.Since(t)
+	return uint32(d / time.Second), uint32(d % time.Second)
+}
+
+func (this *FakeFlow) stats() FlowStats {
+	result := FlowStats{
+		TableId:      this.TableId,
+		Priority:     this.Priority,
+		IdleTimeout:  this.IdleTimeout,
+		HardTimeout:  this.HardTimeout,
+		Flags:        this.Flags,
+		Cookie:       this.Cookie,
+		PacketCount:  this.PacketCount,
+		ByteCount:    this.ByteCount,
+		Match:        this.Match,
+		Instructions: this.Instructions,
+	}
+
+	result.DurationSec, result.DurationNsec = duration(this.added)
+
+	return result
+}
+
+// selects tells, that non-strict modify, delete or stats request selects
+// the flow
+func selects(f *FakeFlow, match Match, outPort uint32, cookie, mask uint64) bool {
+	return f.Cookie&mask == cookie&mask && match.Covers(f.Match) && (outPort == P_ANY || outputs(f.Instructions, outPort))
+}
+
+func (this *FakeSwitch) flowMod(m *FlowMod) {
+	if m.TableId >= FakeTables && !(m.TableId == TT_ALL && (m.Command == FC_DELETE || m.Command == FC_DELETE_STRICT)) {
+		this.sendError(m, ET_FLOW_MOD, FMFC_BAD_TABLE_ID)
+		return
+	}
+
+	strict := m.Command == FC_MODIFY_STRICT || m.Command == FC_DELETE_STRICT
+	selected := func(f *FakeFlow) bool {
+		if strict {
+			return f.Cookie&m.CookieMask == m.Cookie&m.CookieMask && f.Priority == m.Priority && f.Match.Equal(m.Match)
+		}
+
+		return selects(f, m.Match, m.OutPort, m.Cookie, m.CookieMask)
+	}
+
+	switch m.Command {
+	case FC_ADD:
+		flows := this.tables[m.TableId]
+
+		replaced := false
+		for i, f := range flows {
+			if f.Priority == m.Priority && f.Match.Equal(m.Match) {
+				flows[i] = &FakeFlow{FlowMod: *m, added: time.Now()}
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			flows = append(flows, &FakeFlow{FlowMod: *m, added: time.Now()})
+			sort.SliceStable(flows, func(i, j int) bool {
+				return flows[i].Priority > flows[j].Priority
+			})
+		}
+
+		this.tables[m.TableId] = flows
+	case FC_MODIFY, FC_MODIFY_STRICT:
+		for _, f := range this.tables[m.TableId] {
+			if selected(f) {
+				f.Instructions = m.Instructions
+			}
+		}
+	case FC_DELETE, FC_DELETE_STRICT:
+		for id, flows := range this.tables {
+			if m.TableId != TT_ALL && m.TableId != id {
+				continue
+			}
+
+			kept := make([]*FakeFlow, 0, len(flows))
+			for _, f := range flows {
+				if !selected(f) {
+					kept = append(kept, f)
+				}
+			}
+
+			this.tables[id] = kept
+		}
+
+		return
+	}
+
+	if m.BufferId != NO_BUFFER {
+		buffered, found := this.buffers[m.BufferId]
+		if !found {
+			this.sendError(m, ET_BAD_REQUEST, BRC_BUFFER_UNKNOWN)
+			return
+		}
+
+		delete(this.buffers, m.BufferId)
+
+		this.pipeline(buffered.inPort, buffered.data)
+	}
+}
+
+// outputs tells, that instructions output to the port
+func outputs(instructions []Instruction, port uint32) bool {
+	for _, i := range instructions {
+		if ia, ok := i.(*InstructionActions); ok {
+			for _, a := range ia.Actions {
+				if o, ok := a.(*ActionOutput); ok && o.Port == port {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (this *FakeSwitch) groupMod(m *GroupMod) {
+	switch m.Command {
+	case GC_ADD:
+		this.groups[m.GroupId] = m
+	case GC_MODIFY:
+		if _, found := this.groups[m.GroupId]; !found {
+			this.sendError(m, ET_GROUP_MOD, GMFC_UNKNOWN_GROUP)
+			return
+		}
+
+		this.groups[m.GroupId] = m
+	case GC_DELETE:
+		if m.GroupId == G_ALL {
+			this.groups = make(map[uint32]*GroupMod)
+		} else {
+			delete(this.groups, m.GroupId)
+		}
+	}
+}
+
+// packetMatch returns fields of the packet
+func packetMatch(inPort uint32, data []byte) Match {
+	result := Match{InPort: inPort}
+
+	if len(data) < 14 {
+		return result
+	}
+
+	result.EthDst = net.HardwareAddr(data[0:6])
+	result.EthSrc = net.HardwareAddr(data[6:12])
+	result.EthType = binary.BigEndian.Uint16(data[12:])
+
+	if ip := data[14:]; result.EthType == 0x0800 && len(ip) >= 20 {
+		result.IPProto = ip[9]
+		result.IPv4Src = net.IP(ip[12:16])
+		result.IPv4Dst = net.IP(ip[16:20])
+	}
+
+	return result
+}
+
+// setField rewrites packet fields, data is copied
+func setField(data []byte, field Match) []byte {
+	data = append([]byte(nil), data...)
+
+	if len(data) >= 14 {
+		copy(data[0:6], field.EthDst)
+		copy(data[6:12], field.EthSrc)
+
+		if ip := data[14:]; binary.BigEndian.Uint16(data[12:]) == 0x0800 && len(ip) >= 20 {
+			copy(ip[12:16], field.IPv4Src.To4())
+			copy(ip[16:20], field.IPv4Dst.To4())
+		}
+	}
+
+	return data
+}
+
+// receive handles packet received on the port
+func (this *FakeSwitch) receive(port uint32, data []byte) {
+	p, found := this.ports[port]
+	if !found || !p.Up() {
+		return
+	}
+
+	p.stats.RxPackets++
+	p.stats.RxBytes += uint64(len(data))
+
+	this.pipeline(port, data)
+}
+
+// pipeline matches packet against flow tables starting from table 0,
+// packets without matching flow are dropped
+func (this *FakeSwitch) pipeline(inPort uint32, data []byte) {
+	actionSet := make([]Action, 0)
+
+	for table := uint8(0); table < FakeTables; {
+		var flow *FakeFlow
+
+		this.lookups[table]++
+
+		match := packetMatch(inPort, data)
+		for _, f := range this.tables[table] {
+			if f.Match.Covers(match) {
+				flow = f
+				break
+			}
+		}
+
+		if flow == nil {
+			break
+		}
+
+		this.matched[table]++
+		flow.PacketCount++
+		flow.ByteCount += uint64(len(data))
+
+		next, hasNext := uint8(0), false
+
+		for _, i := range flow.Instructions {
+			switch ins := i.(type) {
+			case *InstructionActions:
+				switch ins.Type {
+				case IT_APPLY_ACTIONS:
+					data = this.execute(ins.Actions, inPort, data, flow)
+				case IT_WRITE_ACTIONS:
+					actionSet = append(actionSet, ins.Actions...)
+				case IT_CLEAR_ACTIONS:
+					actionSet = actionSet[:0]
+				}
+			case *InstructionGotoTable:
+				next, hasNext = ins.TableId, true
+			}
+		}
+
+		if !hasNext || next <= table {
+			break
+		}
+
+		table = next
+	}
+
+	this.execute(actionSet, inPort, data, nil)
+}
+
+// execute applies actions to packet and returns it modified, flow is the
+// entry, which sends packet to controller
+func (this *FakeSwitch) execute(actions []Action, inPort uint32, data []byte, flow *FakeFlow) []byte {
+	for _, a := range actions {
+		switch action := a.(type) {
+		case *ActionSetField:
+			data = setField(data, action.Field)
+		case *ActionGroup:
+			if g, found := this.groups[action.GroupId]; found {
+				for _, b := range g.Buckets {
+					this.execute(b.Actions, inPort, data, flow)
+					if g.GroupType != GT_ALL {
+						break
+					}
+				}
+			}
+		case *ActionOutput:
+			this.output(action.Port, inPort, data, flow)
+		}
+	}
+
+	return data
+}
+
+func (this *FakeSwitch) output(port uint32, inPort uint32, data []byte, flow *FakeFlow) {
+	switch port {
+	case P_CONTROLLER:
+		pkt := &PacketIn{BufferId: NO_BUFFER, TotalLen: uint16(len(data)), Reason: R_ACTION, Match: Match{InPort: inPort}, Data: data}
+
+		if flow != nil {
+			pkt.TableId, pkt.Cookie = flow.TableId, flow.Cookie
+
+			if flow.Priority == 0 && flow.Match.Equal(Match{}) {
+				pkt.Reason = R_NO_MATCH
+			}
+		}
+
+		if this.Buffers {
+			this.nextBuffer++
+			this.buffers[this.nextBuffer] = fakePacket{inPort, data}
+			pkt.BufferId = this.nextBuffer
+		}
+
+		this.send(pkt)
+	case P_TABLE:
+		this.pipeline(inPort, data)
+	case P_IN_PORT:
+		this.transmit(inPort, data)
+	case P_FLOOD, P_ALL:
+		for no := range this.ports {
+			if no != inPort {
+				this.transmit(no, data)
+			}
+		}
+	default:
+		// packets aren't sent back to the input port
+		if port != inPort {
+			this.transmit(port, data)
+		}
+	}
+}
+
+func (this *FakeSwitch) transmit(port uint32, data []byte) {
+	p, found := this.ports[port]
+	if !found || !p.Up() {
+		return
+	}
+
+	data = append([]byte(nil), data...)
+
+	p.stats.TxPackets++
+	p.stats.TxBytes += uint64(len(data))
+
+	if p.peer != nil {
+		peer, peerPort := p.peer, p.peerPort
+		peer.enqueue(func() {
+			peer.receive(peerPort, data)
+		})
+
+		return
+	}
+
+	p.transmitted = append(p.transmitted, data)
+}
@@ -0,0 +1,447 @@
+package ofp13
+
+import (
+	"encoding/binary"
+)
+
+// Packet in reasons
+const (
+	R_NO_MATCH = 0
+	R_ACTION   = 1
+)
+
+// PacketIn is a packet sent to controller by a flow or table-miss entry.
+// InPort is taken from Match.
+type PacketIn struct {
+	Header   Header
+	BufferId uint32
+	TotalLen uint16
+	Reason   uint8
+	TableId  uint8
+	Cookie   uint64
+	Match    Match
+	Data     []byte
+}
+
+func (this *PacketIn) header() *Header { return &this.Header }
+
+func (this *PacketIn) InPort() uint32 {
+	return this.Match.InPort
+}
+
+func (this *PacketIn) MarshalBinary() ([]byte, error) {
+	body := make([]byte, 16)
+	binary.BigEndian.PutUint32(body, this.BufferId)
+	binary.BigEndian.PutUint16(body[4:], this.TotalLen)
+	body[6] = this.Reason
+	body[7] = this.TableId
+	binary.BigEndian.PutUint64(body[8:], this.Cookie)
+
+	body = append(body, this.Match.marshal()...)
+	body = append(body, 0, 0)
+
+	return marshal(&this.Header, T_PACKET_IN, append(body, this.Data...)), nil
+}
+
+func (this *PacketIn) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	if len(b) < headerLen+16 {
+		return ErrShort
+	}
+
+	this.BufferId = binary.BigEndian.Uint32(b[8:])
+	this.TotalLen = binary.BigEndian.Uint16(b[12:])
+	this.Reason = b[14]
+	this.TableId = b[15]
+	this.Cookie = binary.BigEndian.Uint64(b[16:])
+
+	n, err := this.Match.unmarshal(b[24:])
+	if err != nil {
+		return err
+	}
+
+	if len(b) < 24+n+2 {
+		return ErrShort
+	}
+
+	this.Data = append([]byte(nil), b[24+n+2:]...)
+
+	return nil
+}
+
+// PacketOut sends buffered packet, or Data, if BufferId is NO_BUFFER,
+// with the actions
+type PacketOut struct {
+	Header   Header
+	BufferId uint32
+	InPort   uint32
+	Actions  []Action
+	Data     []byte
+}
+
+func NewPacketOut() *PacketOut {
+	return &PacketOut{BufferId: NO_BUFFER, InPort: P_CONTROLLER, Actions: make([]Action, 0)}
+}
+
+func (this *PacketOut) header() *Header { return &this.Header }
+
+func (this *PacketOut) AddAction(a Action) {
+	this.Actions = append(this.Actions, a)
+}
+
+func (this *PacketOut) MarshalBinary() ([]byte, error) {
+	actions := marshalActions(this.Actions)
+
+	body := make([]byte, 16, 16+len(actions)+len(this.Data))
+	binary.BigEndian.PutUint32(body, this.BufferId)
+	binary.BigEndian.PutUint32(body[4:], this.InPort)
+	binary.BigEndian.PutUint16(body[8:], uint16(len(actions)))
+
+	body = append(body, actions...)
+
+	return marshal(&this.Header, T_PACKET_OUT, append(body, this.Data...)), nil
+}
+
+func (this *PacketOut) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	if len(b) < headerLen+16 {
+		return ErrShort
+	}
+
+	this.BufferId = binary.BigEndian.Uint32(b[8:])
+	this.InPort = binary.BigEndian.Uint32(b[12:])
+
+	n := int(binary.BigEndian.Uint16(b[16:]))
+	if len(b) < 24+n {
+		return ErrShort
+	}
+
+	var err error
+	if this.Actions, err = unmarshalActions(b[24 : 24+n]); err != nil {
+		return err
+	}
+
+	this.Data = append([]byte(nil), b[24+n:]...)
+
+	return nil
+}
+
+// Flow mod commands
+const (
+	FC_ADD           = 0
+	FC_MODIFY        = 1
+	FC_MODIFY_STRICT = 2
+	FC_DELETE        = 3
+	FC_DELETE_STRICT = 4
+)
+
+// Flow mod flags
+const (
+	FF_SEND_FLOW_REM = 1 << 0
+	FF_CHECK_OVERLAP = 1 << 1
+	FF_RESET_COUNTS  = 1 << 2
+)
+
+// TT_ALL is table id of delete commands for all tables, TT_MAX is the last
+// table id
+const (
+	TT_MAX = 0xfe
+	TT_ALL = 0xff
+)
+
+// G_ANY is out group of flow mods, which don't filter by group
+const G_ANY = 0xffffffff
+
+type FlowMod struct {
+	Header       Header
+	Cookie       uint64
+	CookieMask   uint64
+	TableId      uint8
+	Command      uint8
+	IdleTimeout  uint16
+	HardTimeout  uint16
+	Priority     uint16
+	BufferId     uint32
+	OutPort      uint32
+	OutGroup     uint32
+	Flags        uint16
+	Match        Match
+	Instructions []Instruction
+}
+
+// NewFlowMod returns add command for table 0
+func NewFlowMod() *FlowMod {
+	return &FlowMod{
+		Command:      FC_ADD,
+		BufferId:     NO_BUFFER,
+		OutPort:      P_ANY,
+		OutGroup:     G_ANY,
+		Instructions: make([]Instruction, 0),
+	}
+}
+
+func (this *FlowMod) header() *Header { return &this.Header }
+
+func (this *FlowMod) AddInstruction(i Instruction) {
+	this.Instructions = append(this.Instructions, i)
+}
+
+func (this *FlowMod) MarshalBinary() ([]byte, error) {
+	body := make([]byte, 40)
+	binary.BigEndian.PutUint64(body, this.Cookie)
+	binary.BigEndian.PutUint64(body[8:], this.CookieMask)
+	body[16] = this.TableId
+	body[17] = this.Command
+	binary.BigEndian.PutUint16(body[18:], this.IdleTimeout)
+	binary.BigEndian.PutUint16(body[20:], this.HardTimeout)
+	binary.BigEndian.PutUint16(body[22:], this.Priority)
+	binary.BigEndian.PutUint32(body[24:], this.BufferId)
+	binary.BigEndian.PutUint32(body[28:], this.OutPort)
+	binary.BigEndian.PutUint32(body[32:], this.OutGroup)
+	binary.BigEndian.PutUint16(body[36:], this.Flags)
+
+	body = append(body, this.Match.marshal()...)
+
+	return marshal(&this.Header, T_FLOW_MOD, append(body, marshalInstructions(this.Instructions)...)), nil
+}
+
+func (this *FlowMod) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	if len(b) < headerLen+40 {
+		return ErrShort
+	}
+
+	this.Cookie = binary.BigEndian.Uint64(b[8:])
+	this.CookieMask = binary.BigEndian.Uint64(b[16:])
+	this.TableId = b[24]
+	this.Command = b[25]
+	this.IdleTimeout = binary.BigEndian.Uint16(b[26:])
+	this.HardTimeout = binary.BigEndian.Uint16(b[28:])
+	this.Priority = binary.BigEndian.Uint16(b[30:])
+	this.BufferId = binary.BigEndian.Uint32(b[32:])
+	this.OutPort = binary.BigEndian.Uint32(b[36:])
+	this.OutGroup = binary.BigEndian.Uint32(b[40:])
+	this.Flags = binary.BigEndian.Uint16(b[44:])
+
+	n, err := this.Match.unmarshal(b[48:])
+	if err != nil {
+		return err
+	}
+
+	this.Instructions, err = unmarshalInstructions(b[48+n:])
+
+	return err
+}
+
+// Group mod commands
+const (
+	GC_ADD    = 0
+	GC_MODIFY = 1
+	GC_DELETE = 2
+)
+
+// Group types
+const (
+	GT_ALL      = 0
+	GT_SELECT   = 1
+	GT_INDIRECT = 2
+	GT_FF       = 3
+)
+
+// G_ALL is group id of delete command for all groups
+const G_ALL = 0xfffffffc
+
+// Bucket of a group, ALL group sends packet to every bucket
+type Bucket struct {
+	Weight     uint16
+	WatchPort  uint32
+	WatchGroup uint32
+	Actions    []Action
+}
+
+func NewBucket(actions ...Action) Bucket {
+	return Bucket{WatchPort: P_ANY, WatchGroup: G_ANY, Actions: actions}
+}
+
+type GroupMod struct {
+	Header    Header
+	Command   uint16
+	GroupType uint8
+	GroupId   uint32
+	Buckets   []Bucket
+}
+
+func NewGroupMod(command uint16, typ uint8, id uint32) *GroupMod {
+	return &GroupMod{Command: command, GroupType: typ, GroupId: id, Buckets: make([]Bucket, 0)}
+}
+
+func (this *GroupMod) header() *Header { return &this.Header }
+
+func (this *GroupMod) AddBucket(b Bucket) {
+	this.Buckets = append(this.Buckets, b)
+}
+
+func (this *GroupMod) MarshalBinary() ([]byte, error) {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint16(body, this.Command)
+	body[2] = this.GroupType
+	binary.BigEndian.PutUint32(body[4:], this.GroupId)
+
+	for _, bucket := range this.Buckets {
+		actions := marshalActions(bucket.Actions)
+
+		b := make([]byte, 16, 16+len(actions))
+		binary.BigEndian.PutUint16(b, uint16(16+len(actions)))
+		binary.BigEndian.PutUint16(b[2:], bucket.Weight)
+		binary.BigEndian.PutUint32(b[4:], bucket.WatchPort)
+		binary.BigEndian.PutUint32(b[8:], bucket.WatchGroup)
+
+		body = append(body, append(b, actions...)...)
+	}
+
+	return marshal(&this.Header, T_GROUP_MOD, body), nil
+}
+
+func (this *GroupMod) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	if len(b) < headerLen+8 {
+		return ErrShort
+	}
+
+	this.Command = binary.BigEndian.Uint16(b[8:])
+	this.GroupType = b[10]
+	this.GroupId = binary.BigEndian.Uint32(b[12:])
+	this.Buckets = make([]Bucket, 0)
+
+	for b = b[16:]; len(b) > 0; {
+		if len(b) < 16 {
+			return ErrShort
+		}
+
+		length := int(binary.BigEndian.Uint16(b))
+		if length < 16 || len(b) < length {
+			return ErrShort
+		}
+
+		actions, err := unmarshalActions(b[16:length])
+		if err != nil {
+			return err
+		}
+
+		this.Buckets = append(this.Buckets, Bucket{
+			Weight:     binary.BigEndian.Uint16(b[2:]),
+			WatchPort:  binary.BigEndian.Uint32(b[4:]),
+			WatchGroup: binary.BigEndian.Uint32(b[8:]),
+			Actions:    actions,
+		})
+
+		b = b[length:]
+	}
+
+	return nil
+}
+
+// Meter mod commands
+const (
+	MC_ADD    = 0
+	MC_MODIFY = 1
+	MC_DELETE = 2
+)
+
+// Meter flags
+const (
+	MF_KBPS  = 1 << 0
+	MF_PKTPS = 1 << 1
+	MF_BURST = 1 << 2
+	MF_STATS = 1 << 3
+)
+
+// MBT_DROP band drops packets over the rate
+const MBT_DROP = 1
+
+type MeterBand struct {
+	Type      uint16
+	Rate      uint32
+	BurstSize uint32
+}
+
+type MeterMod struct {
+	Header  Header
+	Command uint16
+	Flags   uint16
+	MeterId uint32
+	Bands   []MeterBand
+}
+
+func NewMeterMod(command uint16, flags uint16, id uint32) *MeterMod {
+	return &MeterMod{Command: command, Flags: flags, MeterId: id, Bands: make([]MeterBand, 0)}
+}
+
+func (this *MeterMod) header() *Header { return &this.Header }
+
+func (this *MeterMod) AddBand(band MeterBand) {
+	this.Bands = append(this.Bands, band)
+}
+
+func (this *MeterMod) MarshalBinary() ([]byte, error) {
+	body := make([]byte, 8, 8+16*len(this.Bands))
+	binary.BigEndian.PutUint16(body, this.Command)
+	binary.BigEndian.PutUint16(body[2:], this.Flags)
+	binary.BigEndian.PutUint32(body[4:], this.MeterId)
+
+	for _, band := range this.Bands {
+		b := make([]byte, 16)
+		binary.BigEndian.PutUint16(b, band.Type)
+		binary.BigEndian.PutUint16(b[2:], 16)
+		binary.BigEndian.PutUint32(b[4:], band.Rate)
+		binary.BigEndian.PutUint32(b[8:], band.BurstSize)
+
+		body = append(body, b...)
+	}
+
+	return marshal(&this.Header, T_METER_MOD, body), nil
+}
+
+func (this *MeterMod) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	if len(b) < headerLen+8 {
+		return ErrShort
+	}
+
+	this.Command = binary.BigEndian.Uint16(b[8:])
+	this.Flags = binary.BigEndian.Uint16(b[10:])
+	this.MeterId = binary.BigEndian.Uint32(b[12:])
+	this.Bands = make([]MeterBand, 0)
+
+	for b = b[16:]; len(b) >= 16; {
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < 16 || len(b) < length {
+			return ErrShort
+		}
+
+		this.Bands = append(this.Bands, MeterBand{
+			Type:      binary.BigEndian.Uint16(b),
+			Rate:      binary.BigEndian.Uint32(b[4:]),
+			BurstSize: binary.BigEndian.Uint32(b[8:]),
+		})
+
+		b = b[length:]
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package ofp13
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	MATCH_OXM     = 1
+	OXM_OPENFLOW  = 0x8000
+	matchFixedLen = 4
+)
+
+// OXM fields of OpenFlow basic class
+const (
+	OXM_IN_PORT  = 0
+	OXM_ETH_DST  = 3
+	OXM_ETH_SRC  = 4
+	OXM_ETH_TYPE = 5
+	OXM_IP_PROTO = 10
+	OXM_IPV4_SRC = 11
+	OXM_IPV4_DST = 12
+)
+
+// Match of flow or packet in. Zero fields are wildcards, fields of other
+// OXM classes and masks aren't supported. IP fields need EthType 0x0800.
+type Match struct {
+	InPort  uint32           `json:",omitempty"`
+	EthDst  net.HardwareAddr `json:",omitempty"`
+	EthSrc  net.HardwareAddr `json:",omitempty"`
+	EthType uint16           `json:",omitempty"`
+	IPProto uint8            `json:",omitempty"`
+	IPv4Src net.IP           `json:",omitempty"`
+	IPv4Dst net.IP           `json:",omitempty"`
+}
+
+func NewMatch() *Match {
+	return new(Match)
+}
+
+// Validate checks field lengths and prerequisites
+func (this Match) Validate() error {
+	for _, addr := range []net.HardwareAddr{this.EthDst, this.EthSrc} {
+		if addr != nil && len(addr) != 6 {
+			return errors.New(fmt.Sprintf("Wrong ethernet address %s", addr))
+		}
+	}
+
+	for _, ip := range []net.IP{this.IPv4Src, this.IPv4Dst} {
+		if ip != nil && ip.To4() == nil {
+			return errors.New(fmt.Sprintf("Wrong IPv4 address %s", ip))
+		}
+	}
+
+	if (this.IPProto != 0 || this.IPv4Src != nil || this.IPv4Dst != nil) && this.EthType != 0x0800 {
+		return errors.New("IP fields require EthType 0x0800")
+	}
+
+	return nil
+}
+
+// Covers tells, if every packet matching other matches this too
+func (this Match) Covers(other Match) bool {
+	return (this.InPort == 0 || this.InPort == other.InPort) &&
+		(this.EthDst == nil || this.EthDst.String() == other.EthDst.String()) &&
+		(this.EthSrc == nil || this.EthSrc.String() == other.EthSrc.String()) &&
+		(this.EthType == 0 || this.EthType == other.EthType) &&
+		(this.IPProto == 0 || this.IPProto == other.IPProto) &&
+		(this.IPv4Src == nil || this.IPv4Src.Equal(other.IPv4Src)) &&
+		(this.IPv4Dst == nil || this.IPv4Dst.Equal(other.IPv4Dst))
+}
+
+// Equal tells, if matches have the same fields
+func (this Match) Equal(other Match) bool {
+	return this.Covers(other) && other.Covers(this)
+}
+
+func oxm(field uint8, value []byte) []byte {
+	b := make([]byte, 4, 4+len(value))
+	binary.BigEndian.PutUint16(b, OXM_OPENFLOW)
+	b[2] = field << 1
+	b[3] = uint8(len(value))
+
+	return append(b, value...)
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// fields returns OXM TLVs of the match
+func (this Match) fields() []byte {
+	b := make([]byte, 0)
+
+	if this.InPort != 0 {
+		b = append(b, oxm(OXM_IN_PORT, u32(this.InPort))...)
+	}
+	if this.EthDst != nil {
+		b = append(b, oxm(OXM_ETH_DST, this.EthDst)...)
+	}
+	if this.EthSrc != nil {
+		b = append(b, oxm(OXM_ETH_SRC, this.EthSrc)...)
+	}
+	if this.EthType != 0 {
+		b = append(b, oxm(OXM_ETH_TYPE, u16(this.EthType))...)
+	}
+	if this.IPProto != 0 {
+		b = append(b, oxm(OXM_IP_PROTO, []byte{this.IPProto})...)
+	}
+	if this.IPv4Src != nil {
+		b = append(b, oxm(OXM_IPV4_SRC, this.IPv4Src.To4())...)
+	}
+	if this.IPv4Dst != nil {
+		b = append(b, oxm(OXM_IPV4_DST, this.IPv4Dst.To4())...)
+	}
+
+	return b
+}
+
+// marshal returns ofp_match padded to 8 bytes
+func (this Match) marshal() []byte {
+	fields := this.fields()
+
+	b := make([]byte, pad8(matchFixedLen+len(fields)))
+	binary.BigEndian.PutUint16(b, MATCH_OXM)
+	binary.BigEndian.PutUint16(b[2:], uint16(matchFixedLen+len(fields)))
+	copy(b[4:], fields)
+
+	return b
+}
+
+// unmarshal decodes ofp_match and returns its padded length
+func (this *Match) unmarshal(b []byte) (int, error) {
+	if len(b) < matchFixedLen {
+		return 0, ErrShort
+	}
+
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length < matchFixedLen || len(b) < pad8(length) {
+		return 0, ErrShort
+	}
+
+	*this = Match{}
+
+	if err := this.setFields(b[matchFixedLen:length]); err != nil {
+		return 0, err
+	}
+
+	return pad8(length), nil
+}
+
+// setFields decodes OXM TLVs, masked values are taken without the mask
+func (this *Match) setFields(b []byte) error {
+	for len(b) >= 4 {
+		class := binary.BigEndian.Uint16(b)
+		field, hasMask, length := b[2]>>1, b[2]&1 == 1, int(b[3])
+
+		if len(b) < 4+length {
+			return ErrShort
+		}
+
+		value := b[4 : 4+length]
+		if hasMask {
+			value = value[:length/2]
+		}
+
+		b = b[4+length:]
+
+		if class != OXM_OPENFLOW {
+			continue
+		}
+
+		switch {
+		case field == OXM_IN_PORT && len(value) == 4:
+			this.InPort = binary.BigEndian.Uint32(value)
+		case field == OXM_ETH_DST && len(value) == 6:
+			this.EthDst = append(net.HardwareAddr(nil), value...)
+		case field == OXM_ETH_SRC && len(value) == 6:
+			this.EthSrc = append(net.HardwareAddr(nil), value...)
+		case field == OXM_ETH_TYPE && len(value) == 2:
+			this.EthType = binary.BigEndian.Uint16(value)
+		case field == OXM_IP_PROTO && len(value) == 1:
+			this.IPProto = value[0]
+		case field == OXM_IPV4_SRC && len(value) == 4:
+			this.IPv4Src = append(net.IP(nil), value...)
+		case field == OXM_IPV4_DST && len(value) == 4:
+			this.IPv4Dst = append(net.IP(nil), value...)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package ofp13
+
+import (
+	"encoding/binary"
+)
+
+// Multipart types
+const (
+	MP_DESC      = 0
+	MP_FLOW      = 1
+	MP_TABLE     = 3
+	MP_PORT      = 4
+	MP_PORT_DESC = 13
+)
+
+// MPF_MORE flag tells, that more parts follow
+const MPF_MORE = 1
+
+type multipart struct {
+	Header Header
+	MpType uint16
+	Flags  uint16
+	Body   []byte
+}
+
+func (this *multipart) header() *Header { return &this.Header }
+
+func (this *multipart) marshal(typ uint8) []byte {
+	body := make([]byte, 8, 8+len(this.Body))
+	binary.BigEndian.PutUint16(body, this.MpType)
+	binary.BigEndian.PutUint16(body[2:], this.Flags)
+
+	return marshal(&this.Header, typ, append(body, this.Body...))
+}
+
+func (this *multipart) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	if len(b) < headerLen+8 {
+		return ErrShort
+	}
+
+	this.MpType = binary.BigEndian.Uint16(b[8:])
+	this.Flags = binary.BigEndian.Uint16(b[10:])
+	this.Body = append([]byte(nil), b[16:]...)
+
+	return nil
+}
+
+// MultipartRequest asks switch for statistics or descriptions, Body
+// depends on MpType
+type MultipartRequest struct{ multipart }
+
+func NewMultipartRequest(typ uint16, body []byte) *MultipartRequest {
+	result := new(MultipartRequest)
+	result.MpType, result.Body = typ, body
+
+	return result
+}
+
+func NewPortDescRequest() *MultipartRequest {
+	return NewMultipartRequest(MP_PORT_DESC, nil)
+}
+
+func (this *MultipartRequest) MarshalBinary() ([]byte, error) {
+	return this.marshal(T_MULTIPART_REQUEST), nil
+}
+
+type MultipartReply struct{ multipart }
+
+func (this *MultipartReply) MarshalBinary() ([]byte, error) {
+	return this.marshal(T_MULTIPART_REPLY), nil
+}
+
+// More tells, that the reply continues in next message
+func (this *MultipartReply) More() bool {
+	return this.Flags&MPF_MORE != 0
+}
+
+// Ports decodes body of MP_PORT_DESC reply
+func (this *MultipartReply) Ports() ([]Port, error) {
+	result := make([]Port, 0)
+
+	for b := this.Body; len(b) > 0; b = b[portLen:] {
+		var p Port
+		if err := p.unmarshal(b); err != nil {
+			return nil, err
+		}
+
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+// NewPortDescReply is what switch answers to port description request
+func NewPortDescReply(ports []Port) *MultipartReply {
+	result := new(MultipartReply)
+	result.MpType = MP_PORT_DESC
+
+	for _, p := range ports {
+		result.Body = append(result.Body, p.marshal()...)
+	}
+
+	return result
+}
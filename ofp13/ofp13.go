@@ -0,0 +1,489 @@
+// Package ofp13 implements OpenFlow 1.3 messages used by mn network
+// applications and a controller, which speaks them to switches. It's a
+// controller of its own, since ogo speaks only OpenFlow 1.0 and decodes
+// messages of that version. Forwarding logic of netapps is shared by
+// both controllers.
+package ofp13
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const Version = 4
+
+// Message types
+const (
+	T_HELLO             = 0
+	T_ERROR             = 1
+	T_ECHO_REQUEST      = 2
+	T_ECHO_REPLY        = 3
+	T_EXPERIMENTER      = 4
+	T_FEATURES_REQUEST  = 5
+	T_FEATURES_REPLY    = 6
+	T_GET_CONFIG_REQ    = 7
+	T_GET_CONFIG_REPLY  = 8
+	T_SET_CONFIG        = 9
+	T_PACKET_IN         = 10
+	T_FLOW_REMOVED      = 11
+	T_PORT_STATUS       = 12
+	T_PACKET_OUT        = 13
+	T_FLOW_MOD          = 14
+	T_GROUP_MOD         = 15
+	T_PORT_MOD          = 16
+	T_TABLE_MOD         = 17
+	T_MULTIPART_REQUEST = 18
+	T_MULTIPART_REPLY   = 19
+	T_BARRIER_REQUEST   = 20
+	T_BARRIER_REPLY     = 21
+	T_METER_MOD         = 29
+)
+
+// Reserved ports
+const (
+	P_MAX        = 0xffffff00
+	P_IN_PORT    = 0xfffffff8
+	P_TABLE      = 0xfffffff9
+	P_NORMAL     = 0xfffffffa
+	P_FLOOD      = 0xfffffffb
+	P_ALL        = 0xfffffffc
+	P_CONTROLLER = 0xfffffffd
+	P_LOCAL      = 0xfffffffe
+	P_ANY        = 0xffffffff
+)
+
+// NO_BUFFER is buffer id of packets, which aren't buffered by switch
+const NO_BUFFER = 0xffffffff
+
+// CML_NO_BUFFER is max length of output to controller, which sends the
+// whole packet
+const CML_NO_BUFFER = 0xffff
+
+const headerLen = 8
+
+var ErrShort = errors.New("Message is too short")
+
+type Header struct {
+	Version uint8
+	Type    uint8
+	Length  uint16
+	Xid     uint32
+}
+
+func (this *Header) header() *Header {
+	return this
+}
+
+func (this *Header) unmarshal(b []byte) error {
+	if len(b) < headerLen {
+		return ErrShort
+	}
+
+	this.Version = b[0]
+	this.Type = b[1]
+	this.Length = binary.BigEndian.Uint16(b[2:])
+	this.Xid = binary.BigEndian.Uint32(b[4:])
+
+	return nil
+}
+
+// Message is OpenFlow message, MarshalBinary and UnmarshalBinary work with
+// the whole message including header
+type Message interface {
+	header() *Header
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(b []byte) error
+}
+
+// marshal prepends header of given type to the body
+func marshal(h *Header, typ uint8, body []byte) []byte {
+	h.Version, h.Type, h.Length = Version, typ, uint16(headerLen+len(body))
+
+	b := make([]byte, headerLen, headerLen+len(body))
+	b[0] = h.Version
+	b[1] = h.Type
+	binary.BigEndian.PutUint16(b[2:], h.Length)
+	binary.BigEndian.PutUint32(b[4:], h.Xid)
+
+	return append(b, body...)
+}
+
+// Parse decodes message, unknown types are returned as *Raw
+func Parse(b []byte) (Message, error) {
+	if len(b) < headerLen {
+		return nil, ErrShort
+	}
+
+	var result Message
+
+	switch b[1] {
+	case T_HELLO:
+		result = new(Hello)
+	case T_ERROR:
+		result = new(Error)
+	case T_ECHO_REQUEST:
+		result = new(EchoRequest)
+	case T_ECHO_REPLY:
+		result = new(EchoReply)
+	case T_FEATURES_REQUEST:
+		result = new(FeaturesRequest)
+	case T_FEATURES_REPLY:
+		result = new(FeaturesReply)
+	case T_PACKET_IN:
+		result = new(PacketIn)
+	case T_PORT_STATUS:
+		result = new(PortStatus)
+	case T_PACKET_OUT:
+		result = new(PacketOut)
+	case T_FLOW_MOD:
+		result = new(FlowMod)
+	case T_GROUP_MOD:
+		result = new(GroupMod)
+	case T_METER_MOD:
+		result = new(MeterMod)
+	case T_MULTIPART_REQUEST:
+		result = new(MultipartRequest)
+	case T_MULTIPART_REPLY:
+		result = new(MultipartReply)
+	case T_BARRIER_REQUEST:
+		result = new(BarrierRequest)
+	case T_BARRIER_REPLY:
+		result = new(BarrierReply)
+	default:
+		result = new(Raw)
+	}
+
+	if err := result.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ReadMessage reads one message from the connection
+func ReadMessage(r io.Reader) (Message, error) {
+	b := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length < headerLen {
+		return nil, errors.New(fmt.Sprintf("Wrong message length %d", length))
+	}
+
+	b = append(b, make([]byte, length-headerLen)...)
+	if _, err := io.ReadFull(r, b[headerLen:]); err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// WriteMessage writes one message to the connection
+func WriteMessage(w io.Writer, msg Message) error {
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+
+	return err
+}
+
+// Raw is a message of type, which isn't decoded
+type Raw struct {
+	Header Header
+	Body   []byte
+}
+
+func (this *Raw) header() *Header { return &this.Header }
+
+func (this *Raw) MarshalBinary() ([]byte, error) {
+	return marshal(&this.Header, this.Header.Type, this.Body), nil
+}
+
+func (this *Raw) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	this.Body = append([]byte(nil), b[headerLen:]...)
+
+	return nil
+}
+
+// empty is a message without body
+type empty struct {
+	Header Header
+}
+
+func (this *empty) header() *Header { return &this.Header }
+
+func (this *empty) UnmarshalBinary(b []byte) error {
+	return this.Header.unmarshal(b)
+}
+
+// Hello elements, e.g. version bitmap, are ignored, the lower version of
+// the two sides is used
+type Hello struct{ empty }
+
+func NewHello() *Hello { return new(Hello) }
+
+func (this *Hello) MarshalBinary() ([]byte, error) {
+	return marshal(&this.Header, T_HELLO, nil), nil
+}
+
+type FeaturesRequest struct{ empty }
+
+func NewFeaturesRequest() *FeaturesRequest { return new(FeaturesRequest) }
+
+func (this *FeaturesRequest) MarshalBinary() ([]byte, error) {
+	return marshal(&this.Header, T_FEATURES_REQUEST, nil), nil
+}
+
+type BarrierRequest struct{ empty }
+
+func NewBarrierRequest() *BarrierRequest { return new(BarrierRequest) }
+
+func (this *BarrierRequest) MarshalBinary() ([]byte, error) {
+	return marshal(&this.Header, T_BARRIER_REQUEST, nil), nil
+}
+
+type BarrierReply struct{ empty }
+
+func (this *BarrierReply) MarshalBinary() ([]byte, error) {
+	return marshal(&this.Header, T_BARRIER_REPLY, nil), nil
+}
+
+// echo is echo request or reply with arbitrary data
+type echo struct {
+	Header Header
+	Data   []byte
+}
+
+func (this *echo) header() *Header { return &this.Header }
+
+func (this *echo) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	this.Data = append([]byte(nil), b[headerLen:]...)
+
+	return nil
+}
+
+type EchoRequest struct{ echo }
+
+func (this *EchoRequest) MarshalBinary() ([]byte, error) {
+	return marshal(&this.Header, T_ECHO_REQUEST, this.Data), nil
+}
+
+type EchoReply struct{ echo }
+
+func (this *EchoReply) MarshalBinary() ([]byte, error) {
+	return marshal(&this.Header, T_ECHO_REPLY, this.Data), nil
+}
+
+// Error is sent by switch, when it can't handle a message, Data has the
+// beginning of the message
+type Error struct {
+	Header  Header
+	ErrType uint16
+	Code    uint16
+	Data    []byte
+}
+
+func (this *Error) header() *Header { return &this.Header }
+
+func (this *Error) Error() string {
+	return fmt.Sprintf("OpenFlow error type %d code %d", this.ErrType, this.Code)
+}
+
+func (this *Error) MarshalBinary() ([]byte, error) {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint16(body, this.ErrType)
+	binary.BigEndian.PutUint16(body[2:], this.Code)
+
+	return marshal(&this.Header, T_ERROR, append(body, this.Data...)), nil
+}
+
+func (this *Error) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	if len(b) < headerLen+4 {
+		return ErrShort
+	}
+
+	this.ErrType = binary.BigEndian.Uint16(b[8:])
+	this.Code = binary.BigEndian.Uint16(b[10:])
+	this.Data = append([]byte(nil), b[12:]...)
+
+	return nil
+}
+
+type FeaturesReply struct {
+	Header       Header
+	DatapathId   uint64
+	Buffers      uint32
+	Tables       uint8
+	AuxiliaryId  uint8
+	Capabilities uint32
+}
+
+func (this *FeaturesReply) header() *Header { return &this.Header }
+
+func (this *FeaturesReply) MarshalBinary() ([]byte, error) {
+	body := make([]byte, 24)
+	binary.BigEndian.PutUint64(body, this.DatapathId)
+	binary.BigEndian.PutUint32(body[8:], this.Buffers)
+	body[12] = this.Tables
+	body[13] = this.AuxiliaryId
+	binary.BigEndian.PutUint32(body[16:], this.Capabilities)
+
+	return marshal(&this.Header, T_FEATURES_REPLY, body), nil
+}
+
+func (this *FeaturesReply) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	if len(b) < 32 {
+		return ErrShort
+	}
+
+	this.DatapathId = binary.BigEndian.Uint64(b[8:])
+	this.Buffers = binary.BigEndian.Uint32(b[16:])
+	this.Tables = b[20]
+	this.AuxiliaryId = b[21]
+	this.Capabilities = binary.BigEndian.Uint32(b[24:])
+
+	return nil
+}
+
+// DPID returns datapath id the way ogo shows it, as 8 bytes hardware
+// address
+func DPID(id uint64) net.HardwareAddr {
+	result := make(net.HardwareAddr, 8)
+	binary.BigEndian.PutUint64(result, id)
+
+	return result
+}
+
+const portLen = 64
+
+// Port state and config bits
+const (
+	PC_PORT_DOWN  = 1 << 0
+	PS_LINK_DOWN  = 1 << 0
+	PS_BLOCKED    = 1 << 1
+	PS_LIVE       = 1 << 2
+	PORT_NAME_LEN = 16
+)
+
+type Port struct {
+	PortNo     uint32
+	HWAddr     net.HardwareAddr
+	Name       string
+	Config     uint32
+	State      uint32
+	Curr       uint32
+	Advertised uint32
+	Supported  uint32
+	Peer       uint32
+	CurrSpeed  uint32
+	MaxSpeed   uint32
+}
+
+// Up tells, that port isn't administratively down and has a link
+func (this Port) Up() bool {
+	return this.Config&PC_PORT_DOWN == 0 && this.State&PS_LINK_DOWN == 0
+}
+
+func (this Port) marshal() []byte {
+	b := make([]byte, portLen)
+	binary.BigEndian.PutUint32(b, this.PortNo)
+	copy(b[8:14], this.HWAddr)
+	copy(b[16:16+PORT_NAME_LEN-1], this.Name)
+
+	for i, v := range []uint32{this.Config, this.State, this.Curr, this.Advertised, this.Supported, this.Peer, this.CurrSpeed, this.MaxSpeed} {
+		binary.BigEndian.PutUint32(b[32+4*i:], v)
+	}
+
+	return b
+}
+
+func (this *Port) unmarshal(b []byte) error {
+	if len(b) < portLen {
+		return ErrShort
+	}
+
+	this.PortNo = binary.BigEndian.Uint32(b)
+	this.HWAddr = append(net.HardwareAddr(nil), b[8:14]...)
+	this.Name = cstring(b[16 : 16+PORT_NAME_LEN])
+
+	for i, v := range []*uint32{&this.Config, &this.State, &this.Curr, &this.Advertised, &this.Supported, &this.Peer, &this.CurrSpeed, &this.MaxSpeed} {
+		*v = binary.BigEndian.Uint32(b[32+4*i:])
+	}
+
+	return nil
+}
+
+func cstring(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+
+	return string(b)
+}
+
+// Port status reasons
+const (
+	PR_ADD    = 0
+	PR_DELETE = 1
+	PR_MODIFY = 2
+)
+
+type PortStatus struct {
+	Header Header
+	Reason uint8
+	Desc   Port
+}
+
+func (this *PortStatus) header() *Header { return &this.Header }
+
+func (this *PortStatus) MarshalBinary() ([]byte, error) {
+	body := make([]byte, 8, 8+portLen)
+	body[0] = this.Reason
+
+	return marshal(&this.Header, T_PORT_STATUS, append(body, this.Desc.marshal()...)), nil
+}
+
+func (this *PortStatus) UnmarshalBinary(b []byte) error {
+	if err := this.Header.unmarshal(b); err != nil {
+		return err
+	}
+
+	if len(b) < headerLen+8+portLen {
+		return ErrShort
+	}
+
+	this.Reason = b[8]
+
+	return this.Desc.unmarshal(b[16:])
+}
+
+// pad8 returns length rounded up to 8 bytes
+func pad8(n int) int {
+	return (n + 7) / 8 * 8
+}
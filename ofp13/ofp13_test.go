@@ -0,0 +1,511 @@
+package ofp13
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func roundtrip(t *testing.T, msg Message) Message {
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b)%8 != 0 && msg.header().Type != T_PACKET_IN && msg.header().Type != T_PACKET_OUT {
+		t.Fatal("Expected message padded to 8 bytes, obtained length", len(b))
+	}
+
+	result, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reflect.TypeOf(result) != reflect.TypeOf(msg) {
+		t.Fatalf("Expected %T, obtained %T", msg, result)
+	}
+
+	return result
+}
+
+func TestFlowMod(t *testing.T) {
+	mac, _ := net.ParseMAC("00:00:00:00:00:01")
+
+	flow := NewFlowMod()
+	flow.TableId = 1
+	flow.Priority = 10
+	flow.IdleTimeout = 30
+	flow.Header.Xid = 7
+	flow.Match = Match{InPort: 2, EthDst: mac, EthType: 0x0800, IPv4Dst: net.ParseIP("10.0.0.1").To4()}
+	flow.AddInstruction(NewInstructionMeter(1))
+	flow.AddInstruction(NewInstructionApplyActions(
+		NewActionSetField(Match{EthSrc: mac}),
+		NewActionOutput(3),
+		NewActionGroup(5),
+	))
+	flow.AddInstruction(NewInstructionGotoTable(2))
+
+	result := roundtrip(t, flow).(*FlowMod)
+
+	if result.Header.Xid != 7 || result.TableId != 1 || result.Priority != 10 || result.IdleTimeout != 30 ||
+		result.BufferId != NO_BUFFER || result.OutPort != P_ANY || result.OutGroup != G_ANY {
+		t.Fatal("Wrong flow mod fields:", result)
+	}
+
+	if !result.Match.Equal(flow.Match) {
+		t.Fatal("Expected match", flow.Match, "obtained:", result.Match)
+	}
+
+	if !reflect.DeepEqual(result.Instructions, flow.Instructions) {
+		t.Fatal("Expected instructions", flow.Instructions, "obtained:", result.Instructions)
+	}
+}
+
+func TestPacketInOut(t *testing.T) {
+	pkt := &PacketIn{BufferId: NO_BUFFER, TotalLen: 4, Reason: R_NO_MATCH, Match: Match{InPort: 3}, Data: []byte{1, 2, 3, 4}}
+
+	result := roundtrip(t, pkt).(*PacketIn)
+	if result.InPort() != 3 || result.BufferId != NO_BUFFER || !reflect.DeepEqual(result.Data, pkt.Data) {
+		t.Fatal("Wrong packet in:", result)
+	}
+
+	out := NewPacketOut()
+	out.AddAction(NewActionOutput(P_FLOOD))
+	out.Data = pkt.Data
+
+	parsed := roundtrip(t, out).(*PacketOut)
+	if parsed.BufferId != NO_BUFFER || parsed.InPort != P_CONTROLLER || !reflect.DeepEqual(parsed.Data, out.Data) {
+		t.Fatal("Wrong packet out:", parsed)
+	}
+
+	if !reflect.DeepEqual(parsed.Actions, out.Actions) {
+		t.Fatal("Expected actions", out.Actions, "obtained:", parsed.Actions)
+	}
+}
+
+func TestGroupMeterMod(t *testing.T) {
+	group := NewGroupMod(GC_ADD, GT_ALL, 1)
+	group.AddBucket(NewBucket(NewActionOutput(1)))
+	group.AddBucket(NewBucket(NewActionOutput(2)))
+
+	if result := roundtrip(t, group).(*GroupMod); !reflect.DeepEqual(result.Buckets, group.Buckets) || result.GroupId != 1 || result.GroupType != GT_ALL {
+		t.Fatal("Expected group", group, "obtained:", result)
+	}
+
+	meter := NewMeterMod(MC_ADD, MF_PKTPS, 1)
+	meter.AddBand(MeterBand{Type: MBT_DROP, Rate: 100})
+
+	if result := roundtrip(t, meter).(*MeterMod); !reflect.DeepEqual(result.Bands, meter.Bands) || result.Flags != MF_PKTPS {
+		t.Fatal("Expected meter", meter, "obtained:", result)
+	}
+}
+
+func TestPorts(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	ports := []Port{
+		{PortNo: 1, HWAddr: mac, Name: "s1-eth1", CurrSpeed: 10000},
+		{PortNo: 2, HWAddr: mac, Name: "s1-eth2", State: PS_LINK_DOWN},
+	}
+
+	result, err := roundtrip(t, NewPortDescReply(ports)).(*MultipartReply).Ports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(result, ports) {
+		t.Fatal("Expected ports", ports, "obtained:", result)
+	}
+
+	if !result[0].Up() || result[1].Up() {
+		t.Fatal("Wrong port state:", result)
+	}
+
+	status := roundtrip(t, &PortStatus{Reason: PR_MODIFY, Desc: ports[1]}).(*PortStatus)
+	if status.Reason != PR_MODIFY || !reflect.DeepEqual(status.Desc, ports[1]) {
+		t.Fatal("Wrong port status:", status)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	mac, _ := net.ParseMAC("00:00:00:00:00:01")
+
+	wide := Match{EthDst: mac}
+	narrow := Match{InPort: 1, EthDst: mac, EthType: 0x0800}
+
+	if !wide.Covers(narrow) || narrow.Covers(wide) {
+		t.Fatal("Expected", wide, "covers", narrow)
+	}
+
+	if err := (Match{IPv4Dst: net.ParseIP("10.0.0.1")}).Validate(); err == nil {
+		t.Fatal("Expected error of IP field without EthType")
+	}
+
+	if err := (Match{EthSrc: net.HardwareAddr{1, 2}}).Validate(); err == nil {
+		t.Fatal("Expected error of short ethernet address")
+	}
+}
+
+type testApp struct {
+	up      chan *Switch
+	packets chan *PacketIn
+}
+
+func (this *testApp) ConnectionUp(sw *Switch) {
+	this.up <- sw
+}
+
+func (this *testApp) PacketIn(sw *Switch, pkt *PacketIn) {
+	out := NewPacketOut()
+	out.AddAction(NewActionOutput(P_FLOOD))
+	out.Data = pkt.Data
+
+	sw.Send(out)
+	this.packets <- pkt
+}
+
+// testSwitch answers handshake of controller on conn
+func testSwitch(conn net.Conn, dpid uint64, ports []Port) {
+	for {
+		msg, err := ReadMessage(conn)
+		if err != nil {
+			return
+		}
+
+		var reply Message
+
+		switch m := msg.(type) {
+		case *Hello:
+			reply = NewHello()
+		case *FeaturesRequest:
+			reply = &FeaturesReply{Header: Header{Xid: m.Header.Xid}, DatapathId: dpid, Tables: 2}
+		case *MultipartRequest:
+			reply = NewPortDescReply(ports)
+			reply.header().Xid = m.Header.Xid
+		case *BarrierRequest:
+			reply = &BarrierReply{empty{Header: Header{Xid: m.Header.Xid}}}
+		}
+
+		if reply != nil {
+			if err := WriteMessage(conn, reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestController(t *testing.T) {
+	app := &testApp{up: make(chan *Switch, 1), packets: make(chan *PacketIn, 1)}
+
+	ctrl := NewController()
+	ctrl.RegisterApplication(func() interface{} {
+		return app
+	})
+
+	conn, peer := net.Pipe()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- ctrl.ServeConn(conn)
+	}()
+
+	ports := []Port{{PortNo: 2, Name: "s1-eth2"}, {PortNo: 1, Name: "s1-eth1"}, {PortNo: P_LOCAL, Name: "s1"}}
+
+	go testSwitch(peer, 1, ports)
+
+	var sw *Switch
+	select {
+	case sw = <-app.up:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Switch didn't connect")
+	}
+
+	if sw.DPID().String() != "00:00:00:00:00:00:00:01" {
+		t.Fatal("Wrong dpid:", sw.DPID())
+	}
+
+	if found, ok := ctrl.Switch(sw.DPID()); !ok || found != sw || len(ctrl.Switches()) != 1 {
+		t.Fatal("Expected switch registered in controller")
+	}
+
+	if result := sw.Ports(); len(result) != 2 || result[0].Name != "s1-eth1" || result[1].Name != "s1-eth2" {
+		t.Fatal("Expected ordered ports without local one, obtained:", result)
+	}
+
+	if err := sw.Barrier(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteMessage(peer, &PacketIn{BufferId: NO_BUFFER, Match: Match{InPort: 1}, Data: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case pkt := <-app.packets:
+		if pkt.InPort() != 1 {
+			t.Fatal("Wrong packet in:", pkt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No packet in")
+	}
+
+	if err := WriteMessage(peer, &PortStatus{Reason: PR_DELETE, Desc: Port{PortNo: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.Barrier(); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := sw.Ports(); len(result) != 1 {
+		t.Fatal("Expected deleted port 2, obtained:", result)
+	}
+
+	peer.Close()
+
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Controller didn't notice disconnect")
+	}
+
+	if len(ctrl.Switches()) != 0 {
+		t.Fatal("Expected switch unregistered")
+	}
+
+	if err := sw.Barrier(); err == nil {
+		t.Fatal("Expected error of disconnected switch")
+	}
+}
+
+func TestControllerVersion(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- NewController().ServeConn(conn)
+	}()
+
+	if _, err := ReadMessage(peer); err != nil {
+		t.Fatal(err)
+	}
+
+	// OpenFlow 1.0 hello
+	go peer.Write([]byte{1, T_HELLO, 0, 8, 0, 0, 0, 1})
+
+	msg, err := ReadMessage(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := msg.(*Error); !ok {
+		t.Fatalf("Expected error, obtained %T", msg)
+	}
+
+	if err := <-served; err == nil {
+		t.Fatal("Expected version error")
+	}
+}
+
+func TestFakeSwitch(t *testing.T) {
+	ctrl := NewController()
+
+	sw := NewFakeSwitch(1, 1, 2, 3)
+	defer sw.Close()
+
+	peer := NewFakeSwitch(2, 1, 2)
+	defer peer.Close()
+
+	if err := FakeLink(sw, 3, peer, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.Connect(ctrl); err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := ctrl.Switch(sw.DPID())
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:02")
+	packet := append(append(append([]byte{}, mac...), 2, 0, 0, 0, 0, 1, 0x08, 0x06), make([]byte, 28)...)
+
+	// table 0 rewrites destination and passes to table 1, which sends
+	// to group of ports 2 and 3
+	first := NewFlowMod()
+	first.Match = Match{InPort: 1}
+	first.AddInstruction(NewInstructionApplyActions(NewActionSetField(Match{EthDst: mac})))
+	first.AddInstruction(NewInstructionGotoTable(1))
+
+	group := NewGroupMod(GC_ADD, GT_ALL, 1)
+	group.AddBucket(NewBucket(NewActionOutput(2)))
+	group.AddBucket(NewBucket(NewActionOutput(3)))
+
+	second := NewFlowMod()
+	second.TableId = 1
+	second.AddInstruction(NewInstructionWriteActions(NewActionGroup(1)))
+
+	for _, msg := range []Message{first, group, second} {
+		if err := s.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sw.Inject(1, packet)
+	sw.Inject(2, packet)
+
+	if err := sw.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := sw.Transmitted(2); len(out) != 1 || net.HardwareAddr(out[0][:6]).String() != mac.String() {
+		t.Fatal("Expected packet with rewritten destination on port 2, obtained:", out)
+	}
+
+	if out := sw.Transmitted(1); len(out) != 0 {
+		t.Fatal("Expected packet of port 2 dropped by table miss, obtained:", out)
+	}
+
+	// port 3 is linked to the peer, which has no flows
+	if out := sw.Transmitted(3); len(out) != 0 {
+		t.Fatal("Expected packet passed to peer switch, obtained:", out)
+	}
+
+	if flows := sw.Flows(0); len(flows) != 1 || flows[0].PacketCount != 1 || flows[0].ByteCount != uint64(len(packet)) {
+		t.Fatal("Wrong flow counters:", flows)
+	}
+
+	out := NewPacketOut()
+	out.BufferId = 100
+	out.AddAction(NewActionOutput(1))
+
+	if _, err := s.Request(out); err == nil {
+		t.Fatal("Expected unknown buffer error")
+	} else if e, ok := err.(*Error); !ok || e.ErrType != ET_BAD_REQUEST || e.Code != BRC_BUFFER_UNKNOWN {
+		t.Fatal("Wrong error:", err)
+	}
+
+	del := NewFlowMod()
+	del.Command = FC_DELETE
+	del.TableId = TT_ALL
+
+	if err := s.Send(del); err != nil {
+		t.Fatal(err)
+	}
+
+	sw.AddPort(4)
+
+	if err := sw.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sw.Flows(0)) != 0 || len(sw.Flows(1)) != 0 {
+		t.Fatal("Expected flows deleted")
+	}
+
+	if _, found := s.Port(4); !found {
+		t.Fatal("Expected port 4 added")
+	}
+}
+
+func TestStats(t *testing.T) {
+	mac, _ := net.ParseMAC("00:00:00:00:00:01")
+
+	r, err := roundtrip(t, NewFlowStatsRequest(2, Match{EthDst: mac})).(*MultipartRequest).FlowStatsRequest()
+	if err != nil || r.TableId != 2 || r.OutPort != P_ANY || r.OutGroup != G_ANY || !r.Match.Equal(Match{EthDst: mac}) {
+		t.Fatal("Wrong flow stats request:", r, err)
+	}
+
+	flows := []FlowStats{
+		{TableId: 1, Priority: 10, Cookie: 5, PacketCount: 2, ByteCount: 120, Match: Match{InPort: 1},
+			Instructions: []Instruction{NewInstructionApplyActions(NewActionOutput(2))}},
+		{TableId: 2, DurationSec: 3, Instructions: []Instruction{}},
+	}
+
+	if result, err := roundtrip(t, NewFlowStatsReply(flows)).(*MultipartReply).FlowStats(); err != nil || !reflect.DeepEqual(result, flows) {
+		t.Fatal("Expected flow stats", flows, "obtained:", result, err)
+	}
+
+	tables := []TableStats{{TableId: 0, ActiveCount: 2, LookupCount: 10, MatchedCount: 7}, {TableId: 1}}
+	if result, err := roundtrip(t, NewTableStatsReply(tables)).(*MultipartReply).TableStats(); err != nil || !reflect.DeepEqual(result, tables) {
+		t.Fatal("Expected table stats", tables, "obtained:", result, err)
+	}
+
+	if no, err := roundtrip(t, NewPortStatsRequest(P_ANY)).(*MultipartRequest).PortStatsRequest(); err != nil || no != P_ANY {
+		t.Fatal("Expected port stats request of any port, obtained:", no, err)
+	}
+
+	ports := []PortStats{{PortNo: 1, RxPackets: 1, TxBytes: 64, Collisions: 3, DurationSec: 4, DurationNsec: 5}}
+	if result, err := roundtrip(t, NewPortStatsReply(ports)).(*MultipartReply).PortStats(); err != nil || !reflect.DeepEqual(result, ports) {
+		t.Fatal("Expected port stats", ports, "obtained:", result, err)
+	}
+}
+
+func TestFakeSwitchStats(t *testing.T) {
+	ctrl := NewController()
+
+	sw := NewFakeSwitch(1, 1, 2)
+	defer sw.Close()
+
+	if err := sw.Connect(ctrl); err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := ctrl.Switch(sw.DPID())
+
+	flow := NewFlowMod()
+	flow.Cookie = 7
+	flow.Match = Match{InPort: 1}
+	flow.AddInstruction(NewInstructionApplyActions(NewActionOutput(2)))
+
+	if err := s.Apply(flow); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := NewFlowMod()
+	bad.TableId = FakeTables
+
+	if err, ok := s.Apply(bad).(*Error); !ok || err.ErrType != ET_FLOW_MOD || err.Code != FMFC_BAD_TABLE_ID {
+		t.Fatal("Expected bad table error, obtained:", err)
+	}
+
+	packet := make([]byte, 60)
+	sw.Inject(1, packet)
+	sw.Inject(2, packet)
+
+	if err := sw.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := func(msg Message) *MultipartReply {
+		reply, err := s.Request(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return reply[0].(*MultipartReply)
+	}
+
+	flows, err := stats(NewFlowStatsRequest(TT_ALL, Match{})).FlowStats()
+	if err != nil || len(flows) != 1 || flows[0].Cookie != 7 || flows[0].PacketCount != 1 || flows[0].ByteCount != 60 {
+		t.Fatal("Expected flow matched once, obtained:", flows, err)
+	}
+
+	if flows, _ = stats(NewFlowStatsRequest(0, Match{InPort: 2})).FlowStats(); len(flows) != 0 {
+		t.Fatal("Expected no flows of port 2, obtained:", flows)
+	}
+
+	tables, err := stats(NewTableStatsRequest()).TableStats()
+	if err != nil || len(tables) != FakeTables || tables[0].ActiveCount != 1 || tables[0].LookupCount != 2 || tables[0].MatchedCount != 1 {
+		t.Fatal("Wrong table stats:", tables, err)
+	}
+
+	ports, err := stats(NewPortStatsRequest(P_ANY)).PortStats()
+	if err != nil || len(ports) != 2 || ports[0].RxPackets != 1 || ports[1].RxPackets != 1 || ports[1].TxPackets != 1 || ports[1].TxBytes != 60 {
+		t.Fatal("Wrong port stats:", ports, err)
+	}
+
+	if _, err := s.Request(NewPortStatsRequest(5)); err == nil {
+		t.Fatal("Expected error of unknown port")
+	}
+}
@@ -0,0 +1,300 @@
+package ofp13
+
+import (
+	"encoding/binary"
+)
+
+const (
+	flowStatsRequestLen = 32
+	flowStatsLen        = 48
+	tableStatsLen       = 24
+	portStatsRequestLen = 8
+	portStatsLen        = 112
+)
+
+// FlowStatsRequest is body of MP_FLOW request, flows are selected the way
+// non-strict delete selects them
+type FlowStatsRequest struct {
+	TableId    uint8
+	OutPort    uint32
+	OutGroup   uint32
+	Cookie     uint64
+	CookieMask uint64
+	Match      Match
+}
+
+// NewFlowStatsRequest asks for flows of the table (TT_ALL for all tables)
+// covered by the match
+func NewFlowStatsRequest(table uint8, match Match) *MultipartRequest {
+	r := FlowStatsRequest{TableId: table, OutPort: P_ANY, OutGroup: G_ANY, Match: match}
+	return NewMultipartRequest(MP_FLOW, r.marshal())
+}
+
+func (this FlowStatsRequest) marshal() []byte {
+	b := make([]byte, flowStatsRequestLen)
+	b[0] = this.TableId
+	binary.BigEndian.PutUint32(b[4:], this.OutPort)
+	binary.BigEndian.PutUint32(b[8:], this.OutGroup)
+	binary.BigEndian.PutUint64(b[16:], this.Cookie)
+	binary.BigEndian.PutUint64(b[24:], this.CookieMask)
+
+	return append(b, this.Match.marshal()...)
+}
+
+// FlowStatsRequest decodes body of MP_FLOW request
+func (this *MultipartRequest) FlowStatsRequest() (FlowStatsRequest, error) {
+	var result FlowStatsRequest
+
+	b := this.Body
+	if len(b) < flowStatsRequestLen {
+		return result, ErrShort
+	}
+
+	result.TableId = b[0]
+	result.OutPort = binary.BigEndian.Uint32(b[4:])
+	result.OutGroup = binary.BigEndian.Uint32(b[8:])
+	result.Cookie = binary.BigEndian.Uint64(b[16:])
+	result.CookieMask = binary.BigEndian.Uint64(b[24:])
+
+	_, err := result.Match.unmarshal(b[flowStatsRequestLen:])
+
+	return result, err
+}
+
+// FlowStats is a flow entry with its counters
+type FlowStats struct {
+	TableId      uint8
+	DurationSec  uint32
+	DurationNsec uint32
+	Priority     uint16
+	IdleTimeout  uint16
+	HardTimeout  uint16
+	Flags        uint16
+	Cookie       uint64
+	PacketCount  uint64
+	ByteCount    uint64
+	Match        Match
+	Instructions []Instruction
+}
+
+func (this FlowStats) marshal() []byte {
+	b := make([]byte, flowStatsLen)
+	b[2] = this.TableId
+	binary.BigEndian.PutUint32(b[4:], this.DurationSec)
+	binary.BigEndian.PutUint32(b[8:], this.DurationNsec)
+	binary.BigEndian.PutUint16(b[12:], this.Priority)
+	binary.BigEndian.PutUint16(b[14:], this.IdleTimeout)
+	binary.BigEndian.PutUint16(b[16:], this.HardTimeout)
+	binary.BigEndian.PutUint16(b[18:], this.Flags)
+	binary.BigEndian.PutUint64(b[24:], this.Cookie)
+	binary.BigEndian.PutUint64(b[32:], this.PacketCount)
+	binary.BigEndian.PutUint64(b[40:], this.ByteCount)
+
+	b = append(b, this.Match.marshal()...)
+	b = append(b, marshalInstructions(this.Instructions)...)
+	binary.BigEndian.PutUint16(b, uint16(len(b)))
+
+	return b
+}
+
+// unmarshal decodes flow stats entry and returns its length
+func (this *FlowStats) unmarshal(b []byte) (int, error) {
+	if len(b) < flowStatsLen {
+		return 0, ErrShort
+	}
+
+	length := int(binary.BigEndian.Uint16(b))
+	if length < flowStatsLen || len(b) < length {
+		return 0, ErrShort
+	}
+
+	this.TableId = b[2]
+	this.DurationSec = binary.BigEndian.Uint32(b[4:])
+	this.DurationNsec = binary.BigEndian.Uint32(b[8:])
+	this.Priority = binary.BigEndian.Uint16(b[12:])
+	this.IdleTimeout = binary.BigEndian.Uint16(b[14:])
+	this.HardTimeout = binary.BigEndian.Uint16(b[16:])
+	this.Flags = binary.BigEndian.Uint16(b[18:])
+	this.Cookie = binary.BigEndian.Uint64(b[24:])
+	this.PacketCount = binary.BigEndian.Uint64(b[32:])
+	this.ByteCount = binary.BigEndian.Uint64(b[40:])
+
+	n, err := this.Match.unmarshal(b[flowStatsLen:length])
+	if err != nil {
+		return 0, err
+	}
+
+	if this.Instructions, err = unmarshalInstructions(b[flowStatsLen+n : length]); err != nil {
+		return 0, err
+	}
+
+	return length, nil
+}
+
+func NewFlowStatsReply(flows []FlowStats) *MultipartReply {
+	result := new(MultipartReply)
+	result.MpType = MP_FLOW
+
+	for _, f := range flows {
+		result.Body = append(result.Body, f.marshal()...)
+	}
+
+	return result
+}
+
+// FlowStats decodes body of MP_FLOW reply
+func (this *MultipartReply) FlowStats() ([]FlowStats, error) {
+	result := make([]FlowStats, 0)
+
+	for b := this.Body; len(b) > 0; {
+		var f FlowStats
+
+		n, err := f.unmarshal(b)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, f)
+		b = b[n:]
+	}
+
+	return result, nil
+}
+
+// TableStats are counters of a flow table
+type TableStats struct {
+	TableId      uint8
+	ActiveCount  uint32
+	LookupCount  uint64
+	MatchedCount uint64
+}
+
+func NewTableStatsRequest() *MultipartRequest {
+	return NewMultipartRequest(MP_TABLE, nil)
+}
+
+func NewTableStatsReply(tables []TableStats) *MultipartReply {
+	result := new(MultipartReply)
+	result.MpType = MP_TABLE
+
+	for _, t := range tables {
+		b := make([]byte, tableStatsLen)
+		b[0] = t.TableId
+		binary.BigEndian.PutUint32(b[4:], t.ActiveCount)
+		binary.BigEndian.PutUint64(b[8:], t.LookupCount)
+		binary.BigEndian.PutUint64(b[16:], t.MatchedCount)
+
+		result.Body = append(result.Body, b...)
+	}
+
+	return result
+}
+
+// TableStats decodes body of MP_TABLE reply
+func (this *MultipartReply) TableStats() ([]TableStats, error) {
+	if len(this.Body)%tableStatsLen != 0 {
+		return nil, ErrShort
+	}
+
+	result := make([]TableStats, 0)
+
+	for b := this.Body; len(b) > 0; b = b[tableStatsLen:] {
+		result = append(result, TableStats{
+			TableId:      b[0],
+			ActiveCount:  binary.BigEndian.Uint32(b[4:]),
+			LookupCount:  binary.BigEndian.Uint64(b[8:]),
+			MatchedCount: binary.BigEndian.Uint64(b[16:]),
+		})
+	}
+
+	return result, nil
+}
+
+// PortStats are counters of a port
+type PortStats struct {
+	PortNo       uint32
+	RxPackets    uint64
+	TxPackets    uint64
+	RxBytes      uint64
+	TxBytes      uint64
+	RxDropped    uint64
+	TxDropped    uint64
+	RxErrors     uint64
+	TxErrors     uint64
+	RxFrameErr   uint64
+	RxOverErr    uint64
+	RxCrcErr     uint64
+	Collisions   uint64
+	DurationSec  uint32
+	DurationNsec uint32
+}
+
+func (this *PortStats) counters() []*uint64 {
+	return []*uint64{
+		&this.RxPackets, &this.TxPackets, &this.RxBytes, &this.TxBytes,
+		&this.RxDropped, &this.TxDropped, &this.RxErrors, &this.TxErrors,
+		&this.RxFrameErr, &this.RxOverErr, &this.RxCrcErr, &this.Collisions,
+	}
+}
+
+// NewPortStatsRequest asks for counters of the port, P_ANY for all ports
+func NewPortStatsRequest(port uint32) *MultipartRequest {
+	b := make([]byte, portStatsRequestLen)
+	binary.BigEndian.PutUint32(b, port)
+
+	return NewMultipartRequest(MP_PORT, b)
+}
+
+// PortStatsRequest decodes port number of MP_PORT request
+func (this *MultipartRequest) PortStatsRequest() (uint32, error) {
+	if len(this.Body) < portStatsRequestLen {
+		return 0, ErrShort
+	}
+
+	return binary.BigEndian.Uint32(this.Body), nil
+}
+
+func NewPortStatsReply(ports []PortStats) *MultipartReply {
+	result := new(MultipartReply)
+	result.MpType = MP_PORT
+
+	for _, p := range ports {
+		b := make([]byte, portStatsLen)
+		binary.BigEndian.PutUint32(b, p.PortNo)
+
+		for i, v := range p.counters() {
+			binary.BigEndian.PutUint64(b[8+8*i:], *v)
+		}
+
+		binary.BigEndian.PutUint32(b[104:], p.DurationSec)
+		binary.BigEndian.PutUint32(b[108:], p.DurationNsec)
+
+		result.Body = append(result.Body, b...)
+	}
+
+	return result
+}
+
+// PortStats decodes body of MP_PORT reply
+func (this *MultipartReply) PortStats() ([]PortStats, error) {
+	if len(this.Body)%portStatsLen != 0 {
+		return nil, ErrShort
+	}
+
+	result := make([]PortStats, 0)
+
+	for b := this.Body; len(b) > 0; b = b[portStatsLen:] {
+		p := PortStats{PortNo: binary.BigEndian.Uint32(b)}
+
+		for i, v := range p.counters() {
+			*v = binary.BigEndian.Uint64(b[8+8*i:])
+		}
+
+		p.DurationSec = binary.BigEndian.Uint32(b[104:])
+		p.DurationNsec = binary.BigEndian.Uint32(b[108:])
+
+		result = append(result, p)
+	}
+
+	return result, nil
+}
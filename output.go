@@ -0,0 +1,362 @@
+package mn
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogDir keeps stdout and stderr of processes, every session and host has
+// its own directory: LogDir/<session>/<host>
+var LogDir = "/var/run/mn/logs"
+
+// Log file is rotated, when it's bigger than LogMaxSize, LogMaxFiles
+// rotated files are kept as <file>.1 (the newest) ... <file>.N
+var (
+	LogMaxSize  int64 = 10 << 20
+	LogMaxFiles       = 3
+)
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+const (
+	logTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+	// outputDrain is how long exited process output is read, children of
+	// the process could keep the pipes open
+	outputDrain = 100 * time.Millisecond
+	// followInterval is how often Follow checks files for new lines
+	followInterval = 100 * time.Millisecond
+)
+
+// LogLine is a line of process output, with time it was read at
+type LogLine struct {
+	Time   time.Time
+	Stream string
+	Text   string
+}
+
+func (this LogLine) String() string {
+	return fmt.Sprintf("%s %s %s", this.Time.Format("2006-01-02 15:04:05.000"), this.Stream, this.Text)
+}
+
+// parseLogLine parses "<time> <text>" line of a log file
+func parseLogLine(stream, line string) LogLine {
+	line = strings.TrimSuffix(line, "\n")
+	result := LogLine{Stream: stream, Text: line}
+
+	parts := strings.SplitN(line, " ", 2)
+	if t, err := time.Parse(logTimeFormat, parts[0]); err == nil {
+		result.Time = t
+		result.Text = ""
+		if len(parts) > 1 {
+			result.Text = parts[1]
+		}
+	}
+
+	return result
+}
+
+func (this *Host) logDir() string {
+	return filepath.Join(LogDir, SessionId, this.Name)
+}
+
+// logWriter appends timestamped lines to a file and rotates it
+type logWriter struct {
+	sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+func openLog(path string) (*logWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &logWriter{path: path, file: file}
+
+	if fi, err := file.Stat(); err == nil {
+		result.size = fi.Size()
+	}
+
+	return result, nil
+}
+
+func (this *logWriter) writeLine(t time.Time, text string) error {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.size >= LogMaxSize {
+		if err := this.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := fmt.Fprintf(this.file, "%s %s\n", t.Format(logTimeFormat), text)
+	this.size += int64(n)
+
+	return err
+}
+
+// rotate shifts <file>.N-1 to <file>.N, ..., <file> to <file>.1 and
+// starts empty file
+func (this *logWriter) rotate() error {
+	this.file.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", this.path, LogMaxFiles))
+
+	for i := LogMaxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", this.path, i), fmt.Sprintf("%s.%d", this.path, i+1))
+	}
+
+	if LogMaxFiles > 0 {
+		os.Rename(this.path, this.path+".1")
+	} else {
+		os.Remove(this.path)
+	}
+
+	file, err := os.OpenFile(this.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	this.file, this.size = file, 0
+
+	return nil
+}
+
+func (this *logWriter) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.file.Close()
+}
+
+// capture writes lines read from r to the log, until r is closed
+func capture(r io.ReadCloser, out *logWriter, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer r.Close()
+	defer out.Close()
+
+	reader := bufio.NewReader(r)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			out.writeLine(time.Now(), strings.TrimSuffix(line, "\n"))
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// openOutput creates pipes for stdout and stderr of the process, the
+// returned channel is closed, when all the output is written to the logs
+func (this *Host) openOutput(p *Process) (stdout, stderr *os.File, captured chan struct{}, err error) {
+	if p.Stdout == "" || p.Stderr == "" {
+		dir := this.logDir()
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, nil, nil, err
+		}
+
+		own(ownedFile, filepath.Join(LogDir, SessionId))
+
+		base := filepath.Join(dir, fmt.Sprintf("%s.%d", filepath.Base(p.Command), time.Now().UnixNano()))
+		p.Stdout, p.Stderr = base+"."+StreamStdout, base+"."+StreamStderr
+	}
+
+	logs := make([]*logWriter, 0)
+	files := make([]*os.File, 0)
+
+	fail := func(err error) (*os.File, *os.File, chan struct{}, error) {
+		for _, l := range logs {
+			l.Close()
+		}
+
+		for _, f := range files {
+			f.Close()
+		}
+
+		return nil, nil, nil, err
+	}
+
+	for _, path := range []string{p.Stdout, p.Stderr} {
+		l, err := openLog(path)
+		if err != nil {
+			return fail(err)
+		}
+		logs = append(logs, l)
+
+		r, w, err := os.Pipe()
+		if err != nil {
+			return fail(err)
+		}
+		files = append(files, r, w)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go capture(files[0], logs[0], &wg)
+	go capture(files[2], logs[1], &wg)
+
+	captured = make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(captured)
+	}()
+
+	return files[1], files[3], captured, nil
+}
+
+func (this *Process) logs() map[string]string {
+	return map[string]string{StreamStdout: this.Stdout, StreamStderr: this.Stderr}
+}
+
+// Logs returns output of the process, including rotated files, stdout and
+// stderr lines are ordered by time
+func (this *Process) Logs() ([]LogLine, error) {
+	result := make([]LogLine, 0)
+
+	for stream, path := range this.logs() {
+		if path == "" {
+			continue
+		}
+
+		for i := LogMaxFiles; i >= 0; i-- {
+			name := path
+			if i > 0 {
+				name = fmt.Sprintf("%s.%d", path, i)
+			}
+
+			file, err := os.Open(name)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			reader := bufio.NewReader(file)
+			for {
+				line, err := reader.ReadString('\n')
+				if len(line) > 0 {
+					result = append(result, parseLogLine(stream, line))
+				}
+
+				if err != nil {
+					break
+				}
+			}
+
+			file.Close()
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
+// Follow streams lines written to stdout and stderr of the process after
+// the call, until ctx is done. Rotated files are followed.
+func (this *Process) Follow(ctx context.Context) (<-chan LogLine, error) {
+	files := make(map[string]*os.File)
+
+	for stream, path := range this.logs() {
+		if path == "" {
+			continue
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+
+		file.Seek(0, io.SeekEnd)
+		files[stream] = file
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New(fmt.Sprintf("No output of %s %v", this.Command, this.Args))
+	}
+
+	ch := make(chan LogLine)
+
+	var wg sync.WaitGroup
+	for stream, file := range files {
+		wg.Add(1)
+		go func(stream string, file *os.File) {
+			defer wg.Done()
+			follow(ctx, stream, this.logs()[stream], file, ch)
+		}(stream, file)
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// follow reads lines appended to file, reopens path, when the file is
+// rotated
+func follow(ctx context.Context, stream, path string, file *os.File, ch chan<- LogLine) {
+	defer func() { file.Close() }()
+
+	reader := bufio.NewReader(file)
+	pending := ""
+
+	for {
+		line, err := reader.ReadString('\n')
+		pending += line
+
+		if err == nil {
+			select {
+			case ch <- parseLogLine(stream, pending):
+			case <-ctx.Done():
+				return
+			}
+
+			pending = ""
+			continue
+		}
+
+		// all the rotated file is read, the new one is read from start
+		if fi, err := os.Stat(path); err == nil {
+			if cur, err := file.Stat(); err == nil && !os.SameFile(fi, cur) {
+				if next, err := os.Open(path); err == nil {
+					file.Close()
+					file, reader = next, bufio.NewReader(next)
+					continue
+				}
+			}
+		}
+
+		select {
+		case <-time.After(followInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
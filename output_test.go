@@ -0,0 +1,121 @@
+package mn
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mn-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(size int64, files int) { LogMaxSize, LogMaxFiles = size, files }(LogMaxSize, LogMaxFiles)
+	LogMaxSize, LogMaxFiles = 100, 2
+
+	p := Process{Stdout: dir + "/out.stdout"}
+
+	l, err := openLog(p.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		if err := l.writeLine(now.Add(time.Duration(i)), fmt.Sprintf("line %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	if _, err := os.Stat(p.Stdout + ".2"); err != nil {
+		t.Fatal("Expected rotated files kept", err)
+	}
+
+	if _, err := os.Stat(p.Stdout + ".3"); !os.IsNotExist(err) {
+		t.Fatal("Expected only", LogMaxFiles, "rotated files kept")
+	}
+
+	lines, err := p.Logs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) == 0 || len(lines) >= 20 {
+		t.Fatal("Expected the oldest lines dropped, obtained", lines)
+	}
+
+	// the newest lines are left in order
+	first := 20 - len(lines)
+	for i, l := range lines {
+		if l.Text != fmt.Sprintf("line %d", first+i) || l.Stream != StreamStdout || !l.Time.Equal(now.Add(time.Duration(first+i))) {
+			t.Fatal("Expected lines in order, obtained", lines)
+		}
+	}
+}
+
+func TestProcessOutput(t *testing.T) {
+	scheme := planScheme(t, "10.55.0.2/24", false)
+	if err := scheme.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	defer scheme.Release()
+
+	h1, _ := scheme.GetHost("plan-h1")
+
+	p, err := h1.RunProcess("sh", "-c", "echo out; sleep 0.05; echo err >&2; sleep 0.3; echo last")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(p.Stdout, h1.logDir()) || !strings.HasSuffix(p.Stderr, "."+StreamStderr) {
+		t.Fatal("Expected output in", h1.logDir(), "obtained", p.Stdout, p.Stderr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := p.Follow(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lines before Follow aren't streamed, the last one is
+	for last := false; !last; {
+		select {
+		case l := <-ch:
+			last = l.Text == "last" && l.Stream == StreamStdout
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected followed output")
+		}
+	}
+
+	cancel()
+	for range ch {
+	}
+
+	for p.alive() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.state.drain()
+
+	lines, err := p.Logs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 3 || lines[0].Text != "out" || lines[0].Stream != StreamStdout || lines[1].Text != "err" || lines[1].Stream != StreamStderr || lines[2].Text != "last" {
+		t.Fatal("Expected stdout and stderr lines in order, obtained", lines)
+	}
+
+	if lines[0].Time.IsZero() || lines[1].Time.Before(lines[0].Time) {
+		t.Fatal("Expected lines timestamped, obtained", lines)
+	}
+}
@@ -0,0 +1,251 @@
+// Package ovsdb implements a small subset of the OVSDB management protocol
+// (RFC 7047), enough to manage Open vSwitch bridges and ports without
+// forking ovs-vsctl.
+package ovsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+const DefaultSocket = "/var/run/openvswitch/db.sock"
+
+var ErrClosed = errors.New("ovsdb connection closed")
+
+// TransactionError is returned, when one of transaction operations failed.
+// Whole transaction is aborted in that case.
+type TransactionError struct {
+	Op      int
+	Reason  string
+	Details string
+}
+
+func (this *TransactionError) Error() string {
+	if this.Op < 0 {
+		return fmt.Sprintf("ovsdb transaction failed: %s (%s)", this.Reason, this.Details)
+	}
+
+	return fmt.Sprintf("ovsdb operation %d failed: %s (%s)", this.Op, this.Reason, this.Details)
+}
+
+type request struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	Id     interface{}   `json:"id"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+	Id     interface{}     `json:"id"`
+}
+
+// message is either request or response, we don't know before decoding
+type message struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+	Id     interface{}     `json:"id"`
+}
+
+type Client struct {
+	conn    net.Conn
+	enc     *json.Encoder
+	encMu   sync.Mutex
+	pending map[uint64]chan response
+	nextId  uint64
+	mu      sync.Mutex
+	err     error
+}
+
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(conn), nil
+}
+
+func DialUnix(path string) (*Client, error) {
+	return Dial("unix", path)
+}
+
+func NewClient(conn net.Conn) *Client {
+	this := &Client{
+		conn:    conn,
+		enc:     json.NewEncoder(conn),
+		pending: make(map[uint64]chan response),
+	}
+
+	go this.read()
+
+	return this
+}
+
+func (this *Client) Close() error {
+	return this.conn.Close()
+}
+
+// Err returns non nil error, when connection is broken and client
+// can't be used anymore.
+func (this *Client) Err() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return this.err
+}
+
+func (this *Client) Echo() error {
+	_, err := this.call("echo", "ping")
+	return err
+}
+
+func (this *Client) ListDbs() ([]string, error) {
+	raw, err := this.call("list_dbs")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Transact executes operations as a single atomic transaction.
+func (this *Client) Transact(db string, ops ...Operation) ([]OperationResult, error) {
+	params := []interface{}{db}
+	for _, op := range ops {
+		params = append(params, op)
+	}
+
+	raw, err := this.call("transact", params...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]OperationResult, 0)
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return nil, err
+	}
+
+	for i, r := range results {
+		if r.Error == "" {
+			continue
+		}
+
+		// extra result after the last operation means commit failure
+		if i >= len(ops) {
+			i = -1
+		}
+
+		return results, &TransactionError{Op: i, Reason: r.Error, Details: r.Details}
+	}
+
+	return results, nil
+}
+
+func (this *Client) call(method string, params ...interface{}) (json.RawMessage, error) {
+	ch := make(chan response, 1)
+
+	this.mu.Lock()
+	if this.err != nil {
+		this.mu.Unlock()
+		return nil, this.err
+	}
+	this.nextId++
+	id := this.nextId
+	this.pending[id] = ch
+	this.mu.Unlock()
+
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	if err := this.send(request{Method: method, Params: params, Id: id}); err != nil {
+		this.mu.Lock()
+		delete(this.pending, id)
+		this.mu.Unlock()
+		return nil, err
+	}
+
+	resp, ok := <-ch
+	if !ok {
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		return nil, this.err
+	}
+
+	if resp.Error != nil {
+		return nil, errors.New(fmt.Sprintf("ovsdb %s error: %v", method, resp.Error))
+	}
+
+	return resp.Result, nil
+}
+
+func (this *Client) send(v interface{}) error {
+	this.encMu.Lock()
+	defer this.encMu.Unlock()
+
+	return this.enc.Encode(v)
+}
+
+func (this *Client) read() {
+	dec := json.NewDecoder(this.conn)
+
+	for {
+		var msg message
+
+		if err := dec.Decode(&msg); err != nil {
+			this.shutdown(err)
+			return
+		}
+
+		// server side inactivity probe
+		if msg.Method == "echo" {
+			this.send(response{Result: msg.Params, Id: msg.Id})
+			continue
+		}
+
+		// update notifications and other requests aren't supported
+		if msg.Method != "" {
+			continue
+		}
+
+		id, ok := msg.Id.(float64)
+		if !ok {
+			continue
+		}
+
+		this.mu.Lock()
+		ch, found := this.pending[uint64(id)]
+		delete(this.pending, uint64(id))
+		this.mu.Unlock()
+
+		if found {
+			ch <- response{Result: msg.Result, Error: msg.Error, Id: msg.Id}
+		}
+	}
+}
+
+func (this *Client) shutdown(err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.err = ErrClosed
+	if err != nil {
+		this.err = errors.New(fmt.Sprintf("%v: %v", ErrClosed, err))
+	}
+
+	for id, ch := range this.pending {
+		close(ch)
+		delete(this.pending, id)
+	}
+}
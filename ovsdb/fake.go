@@ -0,0 +1,515 @@
+package ovsdb
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// FakeServer is an in-memory Open_vSwitch database speaking the same
+// JSON-RPC protocol as ovsdb-server. It's intended for tests, which
+// shouldn't depend on Open vSwitch being installed. Only the operations
+// used by this package are supported: insert, select, update, mutate
+// (insert/delete) and delete.
+type FakeServer struct {
+	tables map[string]map[UUID]Row
+	sync.Mutex
+}
+
+// Tables not reachable from the root ones are garbage collected after
+// every transaction, like ovsdb-server does for Open_vSwitch schema.
+var fakeRoots = map[string]bool{"Open_vSwitch": true}
+
+var fakeIndexes = map[string]string{
+	"Bridge":    "name",
+	"Port":      "name",
+	"Interface": "name",
+}
+
+func NewFakeServer() *FakeServer {
+	this := &FakeServer{tables: make(map[string]map[UUID]Row)}
+
+	root := newUUID()
+	this.tables["Open_vSwitch"] = map[UUID]Row{
+		root: {"_uuid": root, "bridges": Set{}},
+	}
+
+	return this
+}
+
+// Client returns a client connected to the server over in-memory pipe.
+func (this *FakeServer) Client() *Client {
+	server, client := net.Pipe()
+
+	go this.ServeConn(server)
+
+	return NewClient(client)
+}
+
+func (this *FakeServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go this.ServeConn(conn)
+	}
+}
+
+func (this *FakeServer) ServeConn(conn net.Conn) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+
+	for {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+			Id     interface{}       `json:"id"`
+		}
+
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+
+		resp := map[string]interface{}{"id": req.Id, "result": nil, "error": nil}
+
+		switch req.Method {
+		case "echo":
+			resp["result"] = req.Params
+
+		case "list_dbs":
+			resp["result"] = []string{VSwitchDb}
+
+		case "transact":
+			result, err := this.transact(req.Params)
+			if err != nil {
+				resp["error"] = err.Error()
+			} else {
+				resp["result"] = result
+			}
+
+		default:
+			resp["error"] = "unknown method"
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// Rows returns copy of all rows of the table
+func (this *FakeServer) Rows(table string) []Row {
+	this.Lock()
+	defer this.Unlock()
+
+	result := make([]Row, 0)
+	for _, row := range this.tables[table] {
+		result = append(result, copyRow(row))
+	}
+
+	return result
+}
+
+func (this *FakeServer) transact(params []json.RawMessage) ([]interface{}, error) {
+	if len(params) == 0 {
+		return nil, errors.New("syntax error: database name expected")
+	}
+
+	var db string
+	if err := json.Unmarshal(params[0], &db); err != nil || db != VSwitchDb {
+		return nil, errors.New("unknown database")
+	}
+
+	ops := make([]Operation, len(params)-1)
+	for i, raw := range params[1:] {
+		if err := json.Unmarshal(raw, &ops[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	tx := &fakeTx{tables: copyTables(this.tables), names: make(map[string]UUID)}
+
+	// uuid-names may be referenced before the insert, which defines them
+	for _, op := range ops {
+		if op.Op == "insert" && op.UUIDName != "" {
+			tx.names[op.UUIDName] = newUUID()
+		}
+	}
+
+	results := make([]interface{}, len(ops))
+
+	for i, op := range ops {
+		result, err := tx.execute(op)
+		if err != nil {
+			results[i] = map[string]string{"error": "constraint violation", "details": err.Error()}
+			return results, nil
+		}
+
+		results[i] = result
+	}
+
+	tx.collect()
+
+	if err := tx.checkIndexes(); err != nil {
+		return append(results, map[string]string{"error": "constraint violation", "details": err.Error()}), nil
+	}
+
+	this.tables = tx.tables
+
+	return results, nil
+}
+
+type fakeTx struct {
+	tables map[string]map[UUID]Row
+	names  map[string]UUID
+}
+
+func (this *fakeTx) execute(op Operation) (map[string]interface{}, error) {
+	switch op.Op {
+	case "insert":
+		uuid := newUUID()
+		if op.UUIDName != "" {
+			uuid = this.names[op.UUIDName]
+		}
+
+		row := this.resolve(op.Row).(Row)
+		row["_uuid"] = uuid
+
+		if this.tables[op.Table] == nil {
+			this.tables[op.Table] = make(map[UUID]Row)
+		}
+		this.tables[op.Table][uuid] = row
+
+		return map[string]interface{}{"uuid": uuid}, nil
+
+	case "select":
+		rows := make([]Row, 0)
+		for _, uuid := range this.match(op.Table, op.Where) {
+			rows = append(rows, project(this.tables[op.Table][uuid], op.Columns))
+		}
+
+		return map[string]interface{}{"rows": rows}, nil
+
+	case "update":
+		row := this.resolve(op.Row).(Row)
+		matched := this.match(op.Table, op.Where)
+
+		for _, uuid := range matched {
+			for k, v := range row {
+				this.tables[op.Table][uuid][k] = v
+			}
+		}
+
+		return map[string]interface{}{"count": len(matched)}, nil
+
+	case "mutate":
+		matched := this.match(op.Table, op.Where)
+
+		for _, uuid := range matched {
+			for _, m := range op.Mutations {
+				if err := this.mutate(this.tables[op.Table][uuid], m); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return map[string]interface{}{"count": len(matched)}, nil
+
+	case "delete":
+		matched := this.match(op.Table, op.Where)
+
+		for _, uuid := range matched {
+			delete(this.tables[op.Table], uuid)
+		}
+
+		return map[string]interface{}{"count": len(matched)}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("unsupported operation %s", op.Op))
+}
+
+func (this *fakeTx) mutate(row Row, m Mutation) error {
+	if len(m) != 3 {
+		return errors.New("bad mutation")
+	}
+
+	column, _ := m[0].(string)
+	mutator, _ := m[1].(string)
+	value := this.resolve(m[2])
+
+	if current, ok := row[column].(Map); ok {
+		arg, _ := value.(Map)
+		result := make(Map, len(current))
+		for k, v := range current {
+			result[k] = v
+		}
+
+		for k, v := range arg {
+			switch mutator {
+			case "insert":
+				if _, found := result[k]; !found {
+					result[k] = v
+				}
+			case "delete":
+				delete(result, k)
+			}
+		}
+
+		row[column] = result
+		return nil
+	}
+
+	result := asSet(row[column])
+
+	for _, item := range asSet(value) {
+		switch mutator {
+		case "insert":
+			if !contains(result, item) {
+				result = append(result, item)
+			}
+		case "delete":
+			filtered := make(Set, 0, len(result))
+			for _, v := range result {
+				if !reflect.DeepEqual(v, item) {
+					filtered = append(filtered, v)
+				}
+			}
+			result = filtered
+		default:
+			return errors.New(fmt.Sprintf("unsupported mutator %s", mutator))
+		}
+	}
+
+	row[column] = result
+
+	return nil
+}
+
+func (this *fakeTx) match(table string, where []Condition) []UUID {
+	result := make([]UUID, 0)
+
+	for uuid, row := range this.tables[table] {
+		matched := true
+
+		for _, cond := range where {
+			if len(cond) != 3 {
+				matched = false
+				break
+			}
+
+			column, _ := cond[0].(string)
+			function, _ := cond[1].(string)
+			value := this.resolve(cond[2])
+
+			switch function {
+			case "==":
+				matched = equalValues(row[column], value)
+			case "!=":
+				matched = !equalValues(row[column], value)
+			case "includes":
+				matched = true
+				for _, item := range asSet(value) {
+					if !contains(asSet(row[column]), item) {
+						matched = false
+					}
+				}
+			default:
+				matched = false
+			}
+
+			if !matched {
+				break
+			}
+		}
+
+		if matched {
+			result = append(result, uuid)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result
+}
+
+// resolve replaces named uuids with real ones
+func (this *fakeTx) resolve(v interface{}) interface{} {
+	switch t := v.(type) {
+	case NamedUUID:
+		return this.names[string(t)]
+	case Set:
+		result := make(Set, 0, len(t))
+		for _, item := range t {
+			result = append(result, this.resolve(item))
+		}
+		return result
+	case Row:
+		result := make(Row, len(t))
+		for k, item := range t {
+			result[k] = this.resolve(item)
+		}
+		return result
+	}
+
+	return v
+}
+
+// collect removes rows of non-root tables, which aren't referenced anymore
+func (this *fakeTx) collect() {
+	reachable := make(map[UUID]bool)
+	queue := make([]Row, 0)
+
+	for table := range fakeRoots {
+		for _, row := range this.tables[table] {
+			queue = append(queue, row)
+		}
+	}
+
+	for len(queue) > 0 {
+		row := queue[0]
+		queue = queue[1:]
+
+		for column, value := range row {
+			if column == "_uuid" {
+				continue
+			}
+
+			for _, item := range asSet(value) {
+				uuid, ok := item.(UUID)
+				if !ok || reachable[uuid] {
+					continue
+				}
+
+				reachable[uuid] = true
+
+				for _, rows := range this.tables {
+					if r, found := rows[uuid]; found {
+						queue = append(queue, r)
+					}
+				}
+			}
+		}
+	}
+
+	for table, rows := range this.tables {
+		if fakeRoots[table] {
+			continue
+		}
+
+		for uuid := range rows {
+			if !reachable[uuid] {
+				delete(rows, uuid)
+			}
+		}
+	}
+}
+
+func (this *fakeTx) checkIndexes() error {
+	for table, column := range fakeIndexes {
+		seen := make(map[interface{}]bool)
+
+		for _, row := range this.tables[table] {
+			key := fmt.Sprint(row[column])
+			if seen[key] {
+				return errors.New(fmt.Sprintf("Transaction causes multiple rows in \"%s\" table to have identical values (%s) for index on column \"%s\"", table, key, column))
+			}
+			seen[key] = true
+		}
+	}
+
+	return nil
+}
+
+func project(row Row, columns []string) Row {
+	if columns == nil {
+		return copyRow(row)
+	}
+
+	result := make(Row, len(columns))
+	for _, c := range columns {
+		if v, found := row[c]; found {
+			result[c] = v
+		}
+	}
+
+	return result
+}
+
+func equalValues(a, b interface{}) bool {
+	sa, sb := asSet(a), asSet(b)
+	if len(sa) != len(sb) {
+		return false
+	}
+
+	for _, item := range sb {
+		if !contains(sa, item) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(set Set, item interface{}) bool {
+	for _, v := range set {
+		if reflect.DeepEqual(v, item) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func copyRow(row Row) Row {
+	result := make(Row, len(row))
+	for k, v := range row {
+		switch t := v.(type) {
+		case Set:
+			result[k] = append(Set{}, t...)
+		case Map:
+			m := make(Map, len(t))
+			for mk, mv := range t {
+				m[mk] = mv
+			}
+			result[k] = m
+		default:
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+func copyTables(tables map[string]map[UUID]Row) map[string]map[UUID]Row {
+	result := make(map[string]map[UUID]Row, len(tables))
+	for name, rows := range tables {
+		result[name] = make(map[UUID]Row, len(rows))
+		for uuid, row := range rows {
+			result[name][uuid] = copyRow(row)
+		}
+	}
+
+	return result
+}
+
+func newUUID() UUID {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return UUID(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
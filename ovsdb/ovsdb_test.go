@@ -0,0 +1,207 @@
+package ovsdb
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestEcho(t *testing.T) {
+	c := NewFakeServer().Client()
+	defer c.Close()
+
+	if err := c.Echo(); err != nil {
+		t.Fatal(err)
+	}
+
+	dbs, err := c.ListDbs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(dbs, []string{VSwitchDb}) {
+		t.Fatal("Expected", VSwitchDb, "obtained:", dbs)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	srv := NewFakeServer()
+	c := srv.Client()
+	defer c.Close()
+
+	if err := c.AddBridge("s1", map[string]string{"owner": "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.AddBridge("s1", nil); err == nil {
+		t.Fatal("Expected duplicate bridge error")
+	}
+
+	found, err := c.BridgeExists("s1")
+	if err != nil || !found {
+		t.Fatal("Expected bridge s1 exists, err:", err)
+	}
+
+	found, err = c.BridgeExists("xcvxcv")
+	if err != nil || found {
+		t.Fatal("Expected bridge xcvxcv does not exist, err:", err)
+	}
+
+	br, err := c.Bridge("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if owner := br.Map("external_ids")["owner"]; owner != "test" {
+		t.Fatal("Expected external_ids:owner=test, obtained:", owner)
+	}
+
+	if err := c.AddPort("s1", Port{Name: "s1-eth0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.AddPort("s1", Port{Name: "s1-pp0", Type: "patch", Options: map[string]string{"peer": "s2-pp0"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.AddPort("xcvxcv", Port{Name: "xcvxcv-eth0"}); err != ErrNoSuchBridge {
+		t.Fatal("Expected", ErrNoSuchBridge, "obtained:", err)
+	}
+
+	ports, err := c.Ports("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(ports)
+	if !reflect.DeepEqual(ports, []string{"s1-eth0", "s1-pp0"}) {
+		t.Fatal("Unexpected ports:", ports)
+	}
+
+	iface, err := c.Interface("s1-pp0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if iface.String("type") != "patch" || iface.Map("options")["peer"] != "s2-pp0" {
+		t.Fatal("Unexpected patch interface:", iface)
+	}
+
+	if err := c.DelPort("xcvxcv", "s1-eth0"); err == nil {
+		t.Fatal("Expected no such port on bridge error")
+	}
+
+	if err := c.DelPort("s1", "s1-eth0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Interface("s1-eth0"); err == nil {
+		t.Fatal("Expected s1-eth0 interface to be collected")
+	}
+
+	if err := c.DelPort("s1", "s1-eth0"); err == nil {
+		t.Fatal("Expected no such port error")
+	}
+
+	if err := c.SetController("s1", "tcp:127.0.0.1:6633"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rows := srv.Rows("Controller"); len(rows) != 1 || rows[0].String("target") != "tcp:127.0.0.1:6633" {
+		t.Fatal("Unexpected controllers:", rows)
+	}
+
+	if err := c.SetProtocols("s1", "OpenFlow10", "OpenFlow13"); err != nil {
+		t.Fatal(err)
+	}
+
+	if br, err := c.Bridge("s1"); err != nil || len(br.Strings("protocols")) != 2 {
+		t.Fatal("Expected 2 protocols, obtained", br, err)
+	}
+
+	if err := c.DelBridge("s1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DelBridge("s1"); err != ErrNoSuchBridge {
+		t.Fatal("Expected", ErrNoSuchBridge, "obtained:", err)
+	}
+
+	// everything referenced by bridge should be collected
+	for _, table := range []string{"Bridge", "Port", "Interface", "Controller"} {
+		if rows := srv.Rows(table); len(rows) != 0 {
+			t.Fatal("Expected empty", table, "table, obtained:", rows)
+		}
+	}
+}
+
+func TestTransactionIsAtomic(t *testing.T) {
+	srv := NewFakeServer()
+	c := srv.Client()
+	defer c.Close()
+
+	if err := c.AddBridge("s1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// second port with the same name fails whole transaction
+	_, err := c.Transact(VSwitchDb,
+		Operation{Op: "insert", Table: "Interface", Row: Row{"name": "p1"}, UUIDName: "i1"},
+		Operation{Op: "insert", Table: "Interface", Row: Row{"name": "p1"}, UUIDName: "i2"},
+		Operation{Op: "insert", Table: "Port", Row: Row{"name": "p1", "interfaces": Set{NamedUUID("i1"), NamedUUID("i2")}}, UUIDName: "p"},
+		Operation{Op: "mutate", Table: "Bridge", Where: []Condition{Equal("name", "s1")}, Mutations: []Mutation{Insert("ports", NamedUUID("p"))}},
+	)
+
+	if _, ok := err.(*TransactionError); !ok {
+		t.Fatal("Expected TransactionError, obtained:", err)
+	}
+
+	ports, err := c.Ports("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ports) != 0 {
+		t.Fatal("Expected no ports, obtained:", ports)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ovsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "db.sock")
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go NewFakeServer().Serve(l)
+
+	c, err := DialUnix(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.AddBridge("s1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := c.ListBridges()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(names, []string{"s1"}) {
+		t.Fatal("Expected [s1], obtained:", names)
+	}
+}
@@ -0,0 +1,342 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// RFC 7047 wire types.
+//
+// Values read from the server are converted by parseValue, so rows contain
+// UUID, Set and Map instead of raw ["uuid", ...], ["set", ...] and
+// ["map", ...] arrays.
+
+type UUID string
+
+func (this UUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"uuid", string(this)})
+}
+
+func (this *UUID) UnmarshalJSON(b []byte) error {
+	var pair []string
+
+	if err := json.Unmarshal(b, &pair); err != nil {
+		return err
+	}
+
+	if len(pair) != 2 || pair[0] != "uuid" {
+		return errors.New(fmt.Sprintf("Unexpected uuid value: %s", b))
+	}
+
+	*this = UUID(pair[1])
+
+	return nil
+}
+
+// NamedUUID refers to a row inserted by the same transaction under
+// Operation.UUIDName.
+type NamedUUID string
+
+func (this NamedUUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"named-uuid", string(this)})
+}
+
+type Set []interface{}
+
+func (this Set) MarshalJSON() ([]byte, error) {
+	if this == nil {
+		return json.Marshal([]interface{}{"set", []interface{}{}})
+	}
+
+	return json.Marshal([]interface{}{"set", []interface{}(this)})
+}
+
+type Map map[string]string
+
+func (this Map) MarshalJSON() ([]byte, error) {
+	keys := make([]string, 0, len(this))
+	for k := range this {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([][]string, 0, len(this))
+	for _, k := range keys {
+		pairs = append(pairs, []string{k, this[k]})
+	}
+
+	return json.Marshal([]interface{}{"map", pairs})
+}
+
+type Row map[string]interface{}
+
+// Strings returns column value as a list of strings, no matter if it's
+// stored as an atom or as a set.
+func (this Row) Strings(column string) []string {
+	result := make([]string, 0)
+
+	for _, v := range asSet(this[column]) {
+		switch t := v.(type) {
+		case string:
+			result = append(result, t)
+		case UUID:
+			result = append(result, string(t))
+		}
+	}
+
+	return result
+}
+
+func (this Row) String(column string) string {
+	if s := this.Strings(column); len(s) > 0 {
+		return s[0]
+	}
+
+	return ""
+}
+
+func (this Row) UUID() UUID {
+	if u, ok := this["_uuid"].(UUID); ok {
+		return u
+	}
+
+	return ""
+}
+
+func (this Row) Map(column string) Map {
+	if m, ok := this[column].(Map); ok {
+		return m
+	}
+
+	return Map{}
+}
+
+// Condition is a [column, function, value] triplet.
+type Condition []interface{}
+
+func Equal(column string, value interface{}) Condition {
+	return Condition{column, "==", value}
+}
+
+func Includes(column string, value interface{}) Condition {
+	return Condition{column, "includes", value}
+}
+
+// Mutation is a [column, mutator, value] triplet.
+type Mutation []interface{}
+
+func Insert(column string, value interface{}) Mutation {
+	return Mutation{column, "insert", value}
+}
+
+func Delete(column string, value interface{}) Mutation {
+	return Mutation{column, "delete", value}
+}
+
+type Operation struct {
+	Op        string
+	Table     string
+	Row       Row
+	Where     []Condition
+	Columns   []string
+	Mutations []Mutation
+	UUIDName  string
+}
+
+func (this Operation) MarshalJSON() ([]byte, error) {
+	result := map[string]interface{}{
+		"op":    this.Op,
+		"table": this.Table,
+	}
+
+	switch this.Op {
+	case "insert":
+		result["row"] = this.Row
+		if this.UUIDName != "" {
+			result["uuid-name"] = this.UUIDName
+		}
+
+	case "select", "update", "mutate", "delete":
+		where := this.Where
+		if where == nil {
+			where = []Condition{}
+		}
+		result["where"] = where
+
+		if this.Op == "select" && this.Columns != nil {
+			result["columns"] = this.Columns
+		}
+		if this.Op == "update" {
+			result["row"] = this.Row
+		}
+		if this.Op == "mutate" {
+			result["mutations"] = this.Mutations
+		}
+
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported operation: %s", this.Op))
+	}
+
+	return json.Marshal(result)
+}
+
+func (this *Operation) UnmarshalJSON(b []byte) error {
+	var op struct {
+		Op        string                 `json:"op"`
+		Table     string                 `json:"table"`
+		Row       map[string]interface{} `json:"row"`
+		Where     []Condition            `json:"where"`
+		Columns   []string               `json:"columns"`
+		Mutations []Mutation             `json:"mutations"`
+		UUIDName  string                 `json:"uuid-name"`
+	}
+
+	if err := json.Unmarshal(b, &op); err != nil {
+		return err
+	}
+
+	*this = Operation{
+		Op:        op.Op,
+		Table:     op.Table,
+		Row:       parseRow(op.Row),
+		Where:     op.Where,
+		Columns:   op.Columns,
+		Mutations: op.Mutations,
+		UUIDName:  op.UUIDName,
+	}
+
+	for i := range this.Where {
+		if len(this.Where[i]) == 3 {
+			this.Where[i][2] = parseValue(this.Where[i][2])
+		}
+	}
+
+	for i := range this.Mutations {
+		if len(this.Mutations[i]) == 3 {
+			this.Mutations[i][2] = parseValue(this.Mutations[i][2])
+		}
+	}
+
+	return nil
+}
+
+type OperationResult struct {
+	Count   int    `json:"count,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Details string `json:"details,omitempty"`
+	UUID    UUID   `json:"uuid,omitempty"`
+	Rows    []Row  `json:"rows,omitempty"`
+}
+
+func (this *OperationResult) UnmarshalJSON(b []byte) error {
+	var r struct {
+		Count   int                      `json:"count"`
+		Error   string                   `json:"error"`
+		Details string                   `json:"details"`
+		UUID    *UUID                    `json:"uuid"`
+		Rows    []map[string]interface{} `json:"rows"`
+	}
+
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	this.Count = r.Count
+	this.Error = r.Error
+	this.Details = r.Details
+
+	if r.UUID != nil {
+		this.UUID = *r.UUID
+	}
+
+	for _, row := range r.Rows {
+		this.Rows = append(this.Rows, parseRow(row))
+	}
+
+	return nil
+}
+
+func parseRow(raw map[string]interface{}) Row {
+	if raw == nil {
+		return nil
+	}
+
+	result := make(Row, len(raw))
+	for k, v := range raw {
+		result[k] = parseValue(v)
+	}
+
+	return result
+}
+
+// parseValue converts decoded JSON into UUID, NamedUUID, Set and Map
+// where the value is tagged as such.
+func parseValue(v interface{}) interface{} {
+	list, ok := v.([]interface{})
+	if !ok || len(list) != 2 {
+		return v
+	}
+
+	tag, ok := list[0].(string)
+	if !ok {
+		return v
+	}
+
+	switch tag {
+	case "uuid":
+		if s, ok := list[1].(string); ok {
+			return UUID(s)
+		}
+
+	case "named-uuid":
+		if s, ok := list[1].(string); ok {
+			return NamedUUID(s)
+		}
+
+	case "set":
+		items, ok := list[1].([]interface{})
+		if !ok {
+			return v
+		}
+
+		result := make(Set, 0, len(items))
+		for _, item := range items {
+			result = append(result, parseValue(item))
+		}
+
+		return result
+
+	case "map":
+		items, ok := list[1].([]interface{})
+		if !ok {
+			return v
+		}
+
+		result := make(Map, len(items))
+		for _, item := range items {
+			pair, ok := item.([]interface{})
+			if !ok || len(pair) != 2 {
+				continue
+			}
+			result[fmt.Sprint(pair[0])] = fmt.Sprint(pair[1])
+		}
+
+		return result
+	}
+
+	return v
+}
+
+// asSet treats atom as a set of one element, as RFC 7047 allows
+func asSet(v interface{}) Set {
+	switch t := v.(type) {
+	case nil:
+		return Set{}
+	case Set:
+		return t
+	}
+
+	return Set{v}
+}
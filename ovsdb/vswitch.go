@@ -0,0 +1,301 @@
+package ovsdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Open_vSwitch database helpers. Every call is one transaction, the same
+// changes ovs-vsctl would do, but without forking it for each step.
+
+const VSwitchDb = "Open_vSwitch"
+
+var ErrNoSuchBridge = errors.New("no such bridge")
+
+type Port struct {
+	Name        string
+	Type        string
+	Options     map[string]string
+	ExternalIds map[string]string
+}
+
+func (this *Client) AddBridge(name string, externalIds map[string]string) error {
+	_, err := this.Transact(VSwitchDb,
+		Operation{
+			Op:       "insert",
+			Table:    "Interface",
+			Row:      Row{"name": name, "type": "internal"},
+			UUIDName: "iface",
+		},
+		Operation{
+			Op:       "insert",
+			Table:    "Port",
+			Row:      Row{"name": name, "interfaces": NamedUUID("iface")},
+			UUIDName: "port",
+		},
+		Operation{
+			Op:       "insert",
+			Table:    "Bridge",
+			Row:      Row{"name": name, "ports": NamedUUID("port"), "external_ids": Map(externalIds)},
+			UUIDName: "bridge",
+		},
+		Operation{
+			Op:        "mutate",
+			Table:     "Open_vSwitch",
+			Mutations: []Mutation{Insert("bridges", NamedUUID("bridge"))},
+		},
+	)
+
+	return err
+}
+
+func (this *Client) Bridge(name string) (Row, error) {
+	results, err := this.Transact(VSwitchDb, Operation{
+		Op:    "select",
+		Table: "Bridge",
+		Where: []Condition{Equal("name", name)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 || len(results[0].Rows) == 0 {
+		return nil, ErrNoSuchBridge
+	}
+
+	return results[0].Rows[0], nil
+}
+
+func (this *Client) BridgeExists(name string) (bool, error) {
+	_, err := this.Bridge(name)
+	if err == ErrNoSuchBridge {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (this *Client) ListBridges() ([]string, error) {
+	results, err := this.Transact(VSwitchDb, Operation{
+		Op:      "select",
+		Table:   "Bridge",
+		Columns: []string{"name"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, row := range results[0].Rows {
+		names = append(names, row.String("name"))
+	}
+
+	return names, nil
+}
+
+// DelBridge removes bridge, its ports and interfaces are garbage collected
+// by ovsdb-server. Named uuids refer only to rows inserted by the
+// transaction, so bridge uuid is read first and the mutation applies only
+// while the bridge is still there.
+func (this *Client) DelBridge(name string) error {
+	br, err := this.Bridge(name)
+	if err != nil {
+		return err
+	}
+
+	results, err := this.Transact(VSwitchDb, Operation{
+		Op:        "mutate",
+		Table:     "Open_vSwitch",
+		Where:     []Condition{Includes("bridges", br.UUID())},
+		Mutations: []Mutation{Delete("bridges", br.UUID())},
+	})
+	if err != nil {
+		return err
+	}
+
+	if results[0].Count == 0 {
+		return ErrNoSuchBridge
+	}
+
+	return nil
+}
+
+func (this *Client) AddPort(bridge string, port Port) error {
+	iface := Row{"name": port.Name}
+	if port.Type != "" {
+		iface["type"] = port.Type
+	}
+	if port.Options != nil {
+		iface["options"] = Map(port.Options)
+	}
+
+	results, err := this.Transact(VSwitchDb,
+		Operation{
+			Op:       "insert",
+			Table:    "Interface",
+			Row:      iface,
+			UUIDName: "iface",
+		},
+		Operation{
+			Op:       "insert",
+			Table:    "Port",
+			Row:      Row{"name": port.Name, "interfaces": NamedUUID("iface"), "external_ids": Map(port.ExternalIds)},
+			UUIDName: "port",
+		},
+		Operation{
+			Op:        "mutate",
+			Table:     "Bridge",
+			Where:     []Condition{Equal("name", bridge)},
+			Mutations: []Mutation{Insert("ports", NamedUUID("port"))},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if results[2].Count == 0 {
+		return ErrNoSuchBridge
+	}
+
+	return nil
+}
+
+// DelPort detaches port from the bridge, the port and its interfaces are
+// garbage collected by ovsdb-server. Like in DelBridge, port uuid is read
+// first and the bridge is changed only while it has the port.
+func (this *Client) DelPort(bridge string, name string) error {
+	results, err := this.Transact(VSwitchDb, Operation{
+		Op:      "select",
+		Table:   "Port",
+		Where:   []Condition{Equal("name", name)},
+		Columns: []string{"_uuid"},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(results[0].Rows) == 0 {
+		return errors.New(fmt.Sprintf("no such port %s", name))
+	}
+
+	uuid := results[0].Rows[0].UUID()
+
+	results, err = this.Transact(VSwitchDb, Operation{
+		Op:        "mutate",
+		Table:     "Bridge",
+		Where:     []Condition{Equal("name", bridge), Includes("ports", uuid)},
+		Mutations: []Mutation{Delete("ports", uuid)},
+	})
+	if err != nil {
+		return err
+	}
+
+	if results[0].Count == 0 {
+		return errors.New(fmt.Sprintf("no port %s on bridge %s", name, bridge))
+	}
+
+	return nil
+}
+
+// Ports returns names of bridge ports, except bridge own internal port.
+func (this *Client) Ports(bridge string) ([]string, error) {
+	br, err := this.Bridge(bridge)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]Operation, 0)
+	for _, uuid := range br.Strings("ports") {
+		ops = append(ops, Operation{
+			Op:      "select",
+			Table:   "Port",
+			Where:   []Condition{Equal("_uuid", UUID(uuid))},
+			Columns: []string{"name"},
+		})
+	}
+
+	if len(ops) == 0 {
+		return []string{}, nil
+	}
+
+	results, err := this.Transact(VSwitchDb, ops...)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, result := range results {
+		for _, row := range result.Rows {
+			if name := row.String("name"); name != bridge {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// Interface returns Interface table row by name
+func (this *Client) Interface(name string) (Row, error) {
+	results, err := this.Transact(VSwitchDb, Operation{
+		Op:    "select",
+		Table: "Interface",
+		Where: []Condition{Equal("name", name)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results[0].Rows) == 0 {
+		return nil, errors.New(fmt.Sprintf("no such interface %s", name))
+	}
+
+	return results[0].Rows[0], nil
+}
+
+// SetProtocols sets OpenFlow versions, the bridge speaks to controllers,
+// e.g. "OpenFlow13". Empty list means Open vSwitch default.
+func (this *Client) SetProtocols(bridge string, protocols ...string) error {
+	values := make(Set, 0)
+	for _, p := range protocols {
+		values = append(values, p)
+	}
+
+	_, err := this.Transact(VSwitchDb, Operation{
+		Op:    "update",
+		Table: "Bridge",
+		Where: []Condition{Equal("name", bridge)},
+		Row:   Row{"protocols": values},
+	})
+
+	return err
+}
+
+// SetController replaces bridge controllers with the given targets,
+// e.g. "tcp:127.0.0.1:6633".
+func (this *Client) SetController(bridge string, targets ...string) error {
+	ops := make([]Operation, 0)
+	refs := make(Set, 0)
+
+	for i, target := range targets {
+		name := fmt.Sprintf("ctrl%d", i)
+		ops = append(ops, Operation{
+			Op:       "insert",
+			Table:    "Controller",
+			Row:      Row{"target": target},
+			UUIDName: name,
+		})
+		refs = append(refs, NamedUUID(name))
+	}
+
+	ops = append(ops, Operation{
+		Op:    "update",
+		Table: "Bridge",
+		Where: []Condition{Equal("name", bridge)},
+		Row:   Row{"controller": refs},
+	})
+
+	_, err := this.Transact(VSwitchDb, ops...)
+
+	return err
+}
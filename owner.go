@@ -0,0 +1,368 @@
+package mn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/NodePrime/open-mininet/cgroup"
+	"github.com/vishvananda/netlink"
+)
+
+// SessionId tags everything the library creates, so Cleanup removes only
+// objects of the session and leaves the rest of the machine alone. It's
+// taken from MN_SESSION environment variable, "default" otherwise.
+var SessionId = sessionFromEnv()
+
+// OwnedDir keeps namespaces, cgroups and files created by sessions, they
+// can't be tagged themselves. Links and bridges carry the tag: link alias
+// is "mn:<session>", OVS bridge has external_ids:mn-session=<session>.
+var OwnedDir = "/var/run/mn/owned"
+
+const (
+	ownerAlias = "mn:"
+	ownerKey   = "mn-session"
+)
+
+const (
+	ownedNetns  = "netns"
+	ownedCgroup = "cgroup"
+	ownedFile   = "file"
+)
+
+func sessionFromEnv() string {
+	if session := os.Getenv("MN_SESSION"); session != "" {
+		return session
+	}
+
+	return "default"
+}
+
+// ownerTag is the link alias of the current session
+func ownerTag() string {
+	return ownerAlias + SessionId
+}
+
+// ownedBy checks link alias or OVS external id against the session, any
+// session matches empty one
+func ownedBy(tag, session string) bool {
+	if !strings.HasPrefix(tag, ownerAlias) {
+		return false
+	}
+
+	return session == "" || tag == ownerAlias+session
+}
+
+// owned is the content of OwnedDir/<session>.json
+type owned struct {
+	Netns   []string
+	Cgroups []string
+	Files   []string
+}
+
+var ownedMu sync.Mutex
+
+func ownedPath(session string) string {
+	return filepath.Join(OwnedDir, session+".json")
+}
+
+func readOwned(session string) (*owned, error) {
+	result := &owned{}
+
+	data, err := ioutil.ReadFile(ownedPath(session))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (this *owned) write(session string) error {
+	data, err := json.MarshalIndent(this, "", "      ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(OwnedDir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(ownedPath(session), data, 0644)
+}
+
+func (this *owned) list(kind string) *[]string {
+	switch kind {
+	case ownedNetns:
+		return &this.Netns
+	case ownedCgroup:
+		return &this.Cgroups
+	default:
+		return &this.Files
+	}
+}
+
+// own records object created by the current session. Failure doesn't
+// fail the build, object just won't be cleaned up.
+func own(kind, name string) {
+	updateOwned(kind, name, true)
+}
+
+// disown forgets removed object
+func disown(kind, name string) {
+	updateOwned(kind, name, false)
+}
+
+func updateOwned(kind, name string, add bool) {
+	ownedMu.Lock()
+	defer ownedMu.Unlock()
+
+	o, err := readOwned(SessionId)
+	if err != nil {
+		log.Println("Unable to read owned objects:", err)
+		return
+	}
+
+	list := o.list(kind)
+
+	found := -1
+	for i, item := range *list {
+		if item == name {
+			found = i
+		}
+	}
+
+	switch {
+	case add && found < 0:
+		*list = append(*list, name)
+	case !add && found >= 0:
+		*list = append((*list)[:found], (*list)[found+1:]...)
+	default:
+		return
+	}
+
+	if err := o.write(SessionId); err != nil {
+		log.Println("Unable to record owned", kind, name, err)
+	}
+}
+
+// Sessions returns sessions, which have owned namespaces, cgroups or files
+func Sessions() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(OwnedDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	for _, f := range files {
+		result = append(result, strings.TrimSuffix(filepath.Base(f), ".json"))
+	}
+
+	return result, nil
+}
+
+// ownedNamespaces returns namespaces owned by any session
+func ownedNamespaces() (map[string]bool, error) {
+	sessions, err := Sessions()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool)
+	for _, session := range sessions {
+		o, err := readOwned(session)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range o.Netns {
+			result[name] = true
+		}
+	}
+
+	return result, nil
+}
+
+// Cleanup removes objects of the session, of all sessions if it's empty:
+// processes in owned namespaces, the namespaces, tagged links and
+// bridges, owned cgroups and files. Removed objects are returned. All of
+// them are tried, errors are collected.
+func Cleanup(session string) ([]string, error) {
+	sessions := []string{session}
+	if session == "" {
+		var err error
+		if sessions, err = Sessions(); err != nil {
+			return nil, err
+		}
+	}
+
+	removed := make([]string, 0)
+	failed := make([]string, 0)
+
+	remove := func(object, name string, err error) {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s %s: %v", object, name, err))
+			return
+		}
+
+		removed = append(removed, object+" "+name)
+	}
+
+	registries := make(map[string]*owned)
+	for _, s := range sessions {
+		o, err := readOwned(s)
+		if err != nil {
+			remove("session", s, err)
+			continue
+		}
+
+		registries[s] = o
+
+		for _, name := range o.Netns {
+			ns := NetNs{name: name}
+			if !ns.Exists() {
+				continue
+			}
+
+			// namespace isn't released with processes left inside
+			procs, err := nsProcs(name)
+			if err != nil {
+				remove("processes", name, err)
+				continue
+			}
+
+			for pid := range procs {
+				remove("process", strconv.Itoa(pid), syscall.Kill(pid, syscall.SIGKILL))
+			}
+
+			remove(ownedNetns, name, ns.Release())
+		}
+	}
+
+	cleanupLinks(session, remove)
+	cleanupOVS(session, remove)
+
+	for s, o := range registries {
+		if len(o.Cgroups) > 0 {
+			cgroup.Init()
+		}
+
+		for _, name := range o.Cgroups {
+			cg := cgroup.NewCgroup(name)
+			if cg.Get() != nil {
+				continue
+			}
+
+			if pids, err := cg.Procs(); err == nil {
+				for _, pid := range pids {
+					remove("process", strconv.Itoa(pid), syscall.Kill(pid, syscall.SIGKILL))
+				}
+			}
+
+			remove(ownedCgroup, name, cg.DeleteExt(cgroup.DeleteRecursive))
+		}
+
+		for _, name := range o.Files {
+			if _, err := os.Stat(name); os.IsNotExist(err) {
+				continue
+			}
+
+			remove(ownedFile, name, os.RemoveAll(name))
+		}
+
+		if len(failed) == 0 {
+			os.Remove(ownedPath(s))
+		}
+	}
+
+	if len(failed) > 0 {
+		return removed, errors.New("Cleanup failed: " + strings.Join(failed, "; "))
+	}
+
+	return removed, nil
+}
+
+// cleanupLinks deletes tagged links of the root namespace, veth ends go
+// before bridges. Links in namespaces are gone with them.
+func cleanupLinks(session string, remove func(object, name string, err error)) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		remove("links", "", err)
+		return
+	}
+
+	bridges := make([]netlink.Link, 0)
+
+	for _, link := range links {
+		if !ownedBy(link.Attrs().Alias, session) {
+			continue
+		}
+
+		if link.Type() == "bridge" {
+			bridges = append(bridges, link)
+			continue
+		}
+
+		remove("link", link.Attrs().Name, deleteLink(link))
+	}
+
+	for _, link := range bridges {
+		remove("switch", link.Attrs().Name, deleteLink(link))
+	}
+}
+
+// deleteLink ignores links, which are gone already, e.g. the other end
+// of removed veth
+func deleteLink(link netlink.Link) error {
+	if err := netlink.LinkDel(link); err != nil && !errors.Is(nlError(err), ErrLinkNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// cleanupOVS deletes bridges with the session in external_ids. Machines
+// without OVS have nothing to clean.
+func cleanupOVS(session string, remove func(object, name string, err error)) {
+	if _, err := os.Stat(OVSDBSocket); err != nil {
+		return
+	}
+
+	c, err := ovs()
+	if err != nil {
+		remove("switches", "", err)
+		return
+	}
+
+	bridges, err := c.ListBridges()
+	if err != nil {
+		remove("switches", "", err)
+		return
+	}
+
+	for _, name := range bridges {
+		row, err := c.Bridge(name)
+		if err != nil {
+			remove("switch", name, err)
+			continue
+		}
+
+		if tag, found := row.Map("external_ids")[ownerKey]; found && ownedBy(ownerAlias+tag, session) {
+			remove("switch", name, c.DelBridge(name))
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package mn
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// tempSession makes objects owned by the session, recorded in temporary
+// OwnedDir, returned func restores both
+func tempSession(t *testing.T, session string) func() {
+	dir, err := ioutil.TempDir("", "mn-owned")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevDir, prevSession := OwnedDir, SessionId
+	OwnedDir, SessionId = dir, session
+
+	return func() {
+		OwnedDir, SessionId = prevDir, prevSession
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	defer tempSession(t, "test-own")()
+
+	scheme := planScheme(t, "10.55.0.2/24", true)
+	defer scheme.Release()
+
+	if err := scheme.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	h1, _ := scheme.GetHost("plan-h1")
+	p, err := h1.RunProcess("sleep", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := p.Pid
+
+	// objects of other session and not tagged ones stay
+	SessionId = "test-other"
+	other := &Switch{Name: "own-other", Type: SwitchLinux}
+	if err := other.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Release()
+
+	SessionId = "test-own"
+
+	foreign := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "own-keep0"}, PeerName: "own-keep1"}
+	if err := netlink.LinkAdd(foreign); err != nil {
+		t.Fatal(err)
+	}
+	defer netlink.LinkDel(foreign)
+
+	removed, err := Cleanup("test-own")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(removed)
+	t.Log("Removed:", removed)
+
+	for _, h := range scheme.Hosts {
+		if h.NetNs().Exists() {
+			t.Fatal("Expected netns", h.Name, "removed")
+		}
+
+		if _, err := os.Stat(h.etcDir()); !os.IsNotExist(err) {
+			t.Fatal("Expected hosts files of", h.Name, "removed")
+		}
+	}
+
+	s, _ := scheme.GetSwitch("plan-s1")
+	if s.Exists() {
+		t.Fatal("Expected switch removed")
+	}
+
+	for _, port := range s.Ports {
+		if port.Exists() {
+			t.Fatal("Expected link", port.Name, "removed")
+		}
+	}
+
+	if _, err := os.Stat(p.Stdout); !os.IsNotExist(err) {
+		t.Fatal("Expected process output removed")
+	}
+
+	dead := false
+	for i := 0; i < 50 && !dead; i++ {
+		// supervisor reaps it, pid could be reused by then
+		time.Sleep(10 * time.Millisecond)
+		comm, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+		dead = !strings.HasPrefix(string(comm), "sleep")
+	}
+
+	if !dead {
+		t.Fatal("Expected process in owned netns killed")
+	}
+
+	if !other.Exists() {
+		t.Fatal("Expected switch of other session kept")
+	}
+
+	if _, err := netlink.LinkByName("own-keep0"); err != nil {
+		t.Fatal("Expected link without tag kept:", err)
+	}
+
+	if removed, err := Cleanup("test-other"); err != nil || other.Exists() {
+		t.Fatal("Expected other session cleaned up, obtained", removed, err)
+	}
+}
@@ -0,0 +1,825 @@
+package mn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/NodePrime/open-mininet/cgroup"
+)
+
+const (
+	PlanAdd    = "add"
+	PlanChange = "change"
+	PlanDelete = "delete"
+)
+
+// Objects in the order they are created, deletion goes backwards
+var planObjects = []string{"cgroup", "switch", "host", "link", "port", "address", "route", "shaping", "process"}
+
+// StateFile keeps names of nodes created by Apply. Only these nodes are
+// deleted when they disappear from the scheme, everything else on the
+// machine is left alone.
+var StateFile = "/var/run/mn/state.json"
+
+// Action is a single step of Plan
+type Action struct {
+	Op     string
+	Object string
+	Target string
+	Detail string
+	do     func() error
+}
+
+func (this Action) String() string {
+	sign := map[string]string{PlanAdd: "+", PlanChange: "~", PlanDelete: "-"}[this.Op]
+
+	result := sign + " " + this.Object + " " + this.Target
+	if this.Detail != "" {
+		result += " " + this.Detail
+	}
+
+	return result
+}
+
+func (this Action) order() int {
+	for i, object := range planObjects {
+		if object != this.Object {
+			continue
+		}
+
+		if this.Op == PlanDelete {
+			return len(planObjects) - i
+		}
+
+		return len(planObjects) + 1 + i
+	}
+
+	return 0
+}
+
+// Plan is a list of actions, which bring the system to the state
+// described by scheme. It's made by Scheme.Plan and executed by
+// Scheme.Apply of the same scheme.
+type Plan struct {
+	Actions []Action
+	scheme  *Scheme
+}
+
+func (this Plan) Empty() bool {
+	return len(this.Actions) == 0
+}
+
+// String returns human readable plan, one action per line marked with
+// "+" for add, "~" for change and "-" for delete, and the summary line
+func (this Plan) String() string {
+	if this.Empty() {
+		return "No changes."
+	}
+
+	counts := make(map[string]int)
+	lines := make([]string, 0)
+
+	for _, action := range this.Actions {
+		counts[action.Op]++
+		lines = append(lines, action.String())
+	}
+
+	lines = append(lines, fmt.Sprintf("Plan: %d to add, %d to change, %d to delete.",
+		counts[PlanAdd], counts[PlanChange], counts[PlanDelete]))
+
+	return strings.Join(lines, "\n")
+}
+
+func (this *Plan) add(op, object, target, detail string, do func() error) {
+	this.Actions = append(this.Actions, Action{Op: op, Object: object, Target: target, Detail: detail, do: do})
+}
+
+// Plan compares scheme with the live system and returns actions needed
+// to make them equal. Nothing is changed.
+func (this *Scheme) Plan() (*Plan, error) {
+	plan := &Plan{Actions: make([]Action, 0), scheme: this}
+
+	prev, err := readApplied()
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, err := this.desiredPairs()
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := listNetNs()
+	if err != nil {
+		return nil, err
+	}
+
+	nsExists := make(map[string]bool)
+	for _, name := range namespaces {
+		nsExists[name] = true
+	}
+
+	live := make(map[string]*liveNs)
+	if live[""], err = observeNs(""); err != nil {
+		return nil, err
+	}
+
+	for _, h := range this.Hosts {
+		if !nsExists[h.Name] {
+			continue
+		}
+
+		if live[h.Name], err = observeNs(h.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := this.planNodes(plan, prev, nsExists); err != nil {
+		return nil, err
+	}
+
+	for _, pair := range pairs {
+		this.planPair(plan, pair, live)
+	}
+
+	for _, s := range this.Switches {
+		this.planSwitchPorts(plan, s, live[""])
+	}
+
+	for _, h := range this.Hosts {
+		this.planHostLinks(plan, h, live[h.Name])
+
+		if err := this.planProcs(plan, h, nsExists[h.Name]); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(plan.Actions, func(i, j int) bool {
+		return plan.Actions[i].order() < plan.Actions[j].order()
+	})
+
+	return plan, nil
+}
+
+// Apply executes plan made by Plan of the same scheme, nil plan is made
+// on the fly. With dryRun nothing is done, plan is only checked to belong
+// to the scheme. Apply stops on the first failed action, Plan shows what is
+// left then.
+func (this *Scheme) Apply(plan *Plan, dryRun bool) error {
+	if plan == nil {
+		var err error
+		if plan, err = this.Plan(); err != nil {
+			return err
+		}
+	}
+
+	if plan.scheme != this {
+		return errors.New("Plan was made for another scheme")
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	for _, action := range plan.Actions {
+		if err := action.do(); err != nil {
+			return errors.New(fmt.Sprintf("%s: %v", action, err))
+		}
+	}
+
+	if err := this.UpdateHosts(); err != nil {
+		return err
+	}
+
+	return this.writeApplied()
+}
+
+// planNodes adds missing switches, hosts and cgroups and deletes the ones
+// created by previous Apply, but gone from the scheme
+func (this *Scheme) planNodes(plan *Plan, prev *applied, nsExists map[string]bool) error {
+	for _, s := range this.Switches {
+		sw := s
+
+		if !sw.Exists() {
+			plan.add(PlanAdd, "switch", sw.Name, sw.Type, func() error {
+				if err := sw.Create(); err != nil {
+					return err
+				}
+
+				return sw.configure(sw.Controller)
+			})
+		}
+	}
+
+	names := make([]string, 0)
+	for name := range prev.Switches {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, found := this.GetSwitch(name); found {
+			continue
+		}
+
+		sw := &Switch{Name: name, Type: prev.Switches[name]}
+		if _, err := sw.backend(); err != nil {
+			return err
+		}
+
+		if sw.Exists() {
+			plan.add(PlanDelete, "switch", name, "", func() error {
+				b, _ := sw.backend()
+				return b.Release(sw.Name)
+			})
+		}
+	}
+
+	for _, h := range this.Hosts {
+		host := h
+
+		if !nsExists[host.Name] {
+			plan.add(PlanAdd, "host", host.Name, "", func() error {
+				if err := host.NetNs().Create(); err != nil {
+					return err
+				}
+
+				if len(host.Links) > 1 {
+					return host.EnableForwarding()
+				}
+
+				return nil
+			})
+		}
+
+		if host.Cgroup != nil && !host.Cgroup.Exists() {
+			plan.add(PlanAdd, "cgroup", host.Cgroup.Name, "", host.Cgroup.apply)
+		}
+	}
+
+	for _, name := range prev.Hosts {
+		if _, found := this.GetHost(name); found || !nsExists[name] {
+			continue
+		}
+
+		ns := name
+		plan.add(PlanDelete, "host", ns, "", func() error {
+			return releaseNs(ns)
+		})
+	}
+
+	for _, name := range prev.Cgroups {
+		if this.hasCgroup(name) {
+			continue
+		}
+
+		group := name
+		if !(&Cgroup{Name: group}).Exists() {
+			continue
+		}
+
+		plan.add(PlanDelete, "cgroup", group, "", func() error {
+			cg, err := NewCgroup(group)
+			if err != nil {
+				return err
+			}
+
+			if err := cg.Get(); err != nil {
+				return err
+			}
+
+			if err := cg.DeleteExt(cgroup.DeleteRecursive); err != nil {
+				return err
+			}
+
+			disown(ownedCgroup, group)
+
+			return nil
+		})
+	}
+
+	return nil
+}
+
+// planPair makes sure both ends of the link exist, are up and plugged
+// into switches
+func (this *Scheme) planPair(plan *Plan, pair Pair, live map[string]*liveNs) {
+	ln, _ := this.GetNode(pair.Left.NodeName)
+	rn, _ := this.GetNode(pair.Right.NodeName)
+
+	if canPatch(ln, rn) {
+		pair.Left = pair.Left.SetPatch()
+		pair.Right = pair.Right.SetPatch()
+
+		this.planPort(plan, ln.(*Switch), pair.Left)
+		this.planPort(plan, rn.(*Switch), pair.Right)
+
+		return
+	}
+
+	left := live[pair.Left.NetNs].link(pair.Left.Name)
+	right := live[pair.Right.NetNs].link(pair.Right.Name)
+	target := linkTarget(pair.Left) + " <-> " + linkTarget(pair.Right)
+
+	switch {
+	case left == nil && right == nil:
+		plan.add(PlanAdd, "link", target, "", func() error {
+			return createPair(pair)
+		})
+	case left == nil || right == nil:
+		plan.add(PlanChange, "link", target, "recreate, one end is missing", func() error {
+			// the surviving end goes, addresses of the pair stay in the pool
+			if err := dropLink(pair.Left); err != nil {
+				return err
+			}
+
+			if err := dropLink(pair.Right); err != nil {
+				return err
+			}
+
+			return createPair(pair)
+		})
+	case !left.Up || !right.Up:
+		plan.add(PlanChange, "link", target, "up", func() error {
+			if err := pair.Left.Up(); err != nil {
+				return err
+			}
+
+			return pair.Right.Up()
+		})
+	}
+
+	if isSwitch(ln) {
+		this.planPort(plan, ln.(*Switch), pair.Left)
+	}
+
+	if isSwitch(rn) {
+		this.planPort(plan, rn.(*Switch), pair.Right)
+	}
+
+	if left == nil || right == nil {
+		live[pair.Left.NetNs].forget(pair.Left.Name)
+		live[pair.Right.NetNs].forget(pair.Right.Name)
+		left, right = nil, nil
+	}
+
+	planAddrs(plan, pair.Left, left)
+	planAddrs(plan, pair.Right, right)
+	planShaping(plan, pair.Left, left)
+	planShaping(plan, pair.Right, right)
+}
+
+func (this *Scheme) planPort(plan *Plan, sw *Switch, l Link) {
+	if sw.Exists() && sw.HasPort(l.Name) {
+		return
+	}
+
+	plan.add(PlanAdd, "port", linkTarget(l), "", func() error {
+		return sw.attach(l)
+	})
+}
+
+// planSwitchPorts detaches ports, which aren't in the scheme, veth ports
+// are deleted as well
+func (this *Scheme) planSwitchPorts(plan *Plan, sw *Switch, root *liveNs) {
+	if !sw.Exists() {
+		return
+	}
+
+	b, err := sw.backend()
+	if err != nil {
+		return
+	}
+
+	ports, err := b.Ports(sw.Name)
+	if err != nil {
+		return
+	}
+
+	for _, name := range ports {
+		if sw.Ports.byName(name) {
+			continue
+		}
+
+		l := Link{Name: name, NodeName: sw.Name}
+		veth := root.link(name) != nil && root.link(name).Kind == "veth"
+
+		plan.add(PlanDelete, "port", linkTarget(l), "", func() error {
+			if err := sw.DelPort(l.Name); err != nil {
+				return err
+			}
+
+			if veth {
+				return linkBackend.Delete(l)
+			}
+
+			return nil
+		})
+	}
+}
+
+// planHostLinks deletes links, ports and routes which aren't in the scheme
+func (this *Scheme) planHostLinks(plan *Plan, h *Host, ns *liveNs) {
+	if ns == nil {
+		ns = &liveNs{Name: h.Name, Links: make(map[string]*liveLink)}
+	}
+
+	names := make([]string, 0)
+	for name := range ns.Links {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if h.Links.byName(name) {
+			continue
+		}
+
+		l := Link{Name: name, NodeName: h.Name, NetNs: h.Name}
+		plan.add(PlanDelete, "link", linkTarget(l), "", func() error {
+			return linkBackend.Delete(l)
+		})
+	}
+
+	want := make(map[Route]bool)
+	for _, l := range h.Links {
+		for _, r := range l.Routes {
+			want[normRoute(r)] = true
+		}
+	}
+
+	// kernel drops route together with the address its gateway is
+	// reachable through, so routes survive only behind addresses kept
+	subnets := make([]*net.IPNet, 0)
+	for _, l := range h.Links {
+		live := ns.link(l.Name)
+		if live == nil {
+			continue
+		}
+
+		kept := make(map[string]bool)
+		for _, cidr := range l.Cidrs() {
+			kept[normCidr(cidr)] = true
+		}
+
+		for _, addr := range live.Addrs {
+			if _, subnet, err := net.ParseCIDR(addr); err == nil && kept[addr] {
+				subnets = append(subnets, subnet)
+			}
+		}
+	}
+
+	reachable := func(gw string) bool {
+		for _, subnet := range subnets {
+			if subnet.Contains(net.ParseIP(gw)) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	have := make(map[Route]bool)
+	for _, r := range ns.Routes {
+		have[r] = reachable(r.Gw)
+
+		if want[r] {
+			continue
+		}
+
+		route := r
+		l := Link{NodeName: h.Name, NetNs: h.Name}
+		plan.add(PlanDelete, "route", h.Name, route.Dst+" via "+route.Gw, func() error {
+			return linkBackend.DelRoute(l, route)
+		})
+	}
+
+	for _, l := range h.Links {
+		for _, r := range l.Routes {
+			if have[normRoute(r)] {
+				continue
+			}
+
+			link, route := l, r
+			plan.add(PlanAdd, "route", h.Name, route.Dst+" via "+route.Gw, func() error {
+				return linkBackend.AddRoute(link, route)
+			})
+		}
+	}
+}
+
+// planProcs starts missing processes and stops the ones, which aren't in
+// the scheme
+func (this *Scheme) planProcs(plan *Plan, h *Host, nsExists bool) error {
+	running := make(map[int]procInfo)
+
+	if nsExists {
+		var err error
+		if running, err = nsProcInfo(h.Name); err != nil {
+			return err
+		}
+	}
+
+	matched := make(map[int]bool)
+
+	// init of isolated host isn't a scheme process
+	if h.Isolation.enabled() {
+		for pid, info := range running {
+			if isInit(h.Name, info.cmdline()) {
+				matched[pid] = true
+			}
+		}
+	}
+
+	for i, proc := range h.Procs {
+		cmdline := strings.TrimSpace(proc.Command + " " + strings.Join(proc.Args, " "))
+
+		found := false
+		for pid, info := range running {
+			if proc.matches(info) && !matched[pid] {
+				matched[pid] = true
+				found = true
+				break
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		host, index := h, i
+		plan.add(PlanAdd, "process", h.Name, cmdline, func() error {
+			return host.Supervisor().start(host.Procs[index])
+		})
+	}
+
+	pids := make([]int, 0)
+	for pid := range running {
+		if !matched[pid] {
+			pids = append(pids, pid)
+		}
+	}
+
+	sort.Ints(pids)
+
+	for _, pid := range pids {
+		p := pid
+		plan.add(PlanDelete, "process", h.Name, fmt.Sprintf("[%d] %s", p, running[p].cmdline()), func() error {
+			return syscall.Kill(p, syscall.SIGTERM)
+		})
+	}
+
+	return nil
+}
+
+func planAddrs(plan *Plan, l Link, live *liveLink) {
+	want := make(map[string]bool)
+	for _, cidr := range l.Cidrs() {
+		want[normCidr(cidr)] = true
+	}
+
+	if live != nil {
+		for _, addr := range live.Addrs {
+			if want[addr] {
+				delete(want, addr)
+				continue
+			}
+
+			cidr := addr
+			plan.add(PlanDelete, "address", linkTarget(l), cidr, func() error {
+				return linkBackend.DelAddr(l, cidr)
+			})
+		}
+	}
+
+	for _, cidr := range l.Cidrs() {
+		if !want[normCidr(cidr)] {
+			continue
+		}
+
+		addr := cidr
+		plan.add(PlanAdd, "address", linkTarget(l), normCidr(addr), func() error {
+			if err := l.ApplyIpv6(); err != nil {
+				return err
+			}
+
+			return linkBackend.AddAddr(l, addr)
+		})
+	}
+}
+
+func planShaping(plan *Plan, l Link, live *liveLink) {
+	want := ""
+	if l.Shaping != nil {
+		if l.Shaping.Rate != "" {
+			want = "tbf"
+		} else if l.Shaping.hasNetem() {
+			want = "netem"
+		}
+	}
+
+	have := ""
+	if live != nil {
+		have = live.Qdisc
+	}
+
+	// same qdiscs with edited parameters are changed too
+	if want != "" && want == have && live.Shaping != nil && !l.Shaping.matches(*live.Shaping) {
+		want, have = l.Shaping.String(), live.Shaping.String()
+	}
+
+	switch {
+	case want == have:
+		return
+	case live == nil:
+		plan.add(PlanAdd, "shaping", linkTarget(l), want, l.ApplyShaping)
+	default:
+		plan.add(PlanChange, "shaping", linkTarget(l), have+" -> "+want, func() error {
+			_, err := l.Shape(l.Shaping)
+			return err
+		})
+	}
+}
+
+// desiredPairs returns every link of the scheme once, with both its ends
+func (this *Scheme) desiredPairs() ([]Pair, error) {
+	result := make([]Pair, 0)
+	seen := make(map[string]bool)
+
+	links := make(Links, 0)
+	for _, s := range this.Switches {
+		for _, l := range s.Ports {
+			links = append(links, l.SetNodeName(s))
+		}
+	}
+	for _, h := range this.Hosts {
+		for _, l := range h.Links {
+			links = append(links, l.SetNodeName(h))
+		}
+	}
+
+	for _, l := range links {
+		if seen[linkTarget(l)] {
+			continue
+		}
+
+		peer, found := this.GetNode(l.Peer.NodeName)
+		if !found {
+			return nil, errors.New(fmt.Sprintf("Can't find node %s", l.Peer.NodeName))
+		}
+
+		r := peer.GetLinks().LinkByPeer(l.Peer)
+		if r.NodeName == "" {
+			return nil, errors.New(fmt.Sprintf("Node %s has no link %s", l.Peer.NodeName, l.Peer.IfName))
+		}
+
+		seen[linkTarget(l)] = true
+		seen[linkTarget(r)] = true
+
+		result = append(result, Pair{l, r})
+	}
+
+	return result, nil
+}
+
+func (this *Scheme) hasCgroup(name string) bool {
+	for _, h := range this.Hosts {
+		if h.Cgroup != nil && h.Cgroup.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func createPair(pair Pair) error {
+	if err := pair.Create(); err != nil {
+		return err
+	}
+
+	if err := pair.Left.Up(); err != nil {
+		return err
+	}
+
+	return pair.Right.Up()
+}
+
+// releaseNs stops everything running in the namespace, deletes its links
+// and the namespace itself
+func releaseNs(name string) error {
+	procs, err := nsProcs(name)
+	if err != nil {
+		return err
+	}
+
+	for pid := range procs {
+		syscall.Kill(pid, syscall.SIGTERM)
+	}
+
+	ns, err := observeNs(name)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range ns.Links {
+		linkBackend.Delete(Link{Name: l.Name, NetNs: name})
+	}
+
+	return (&NetNs{name: name}).Release()
+}
+
+func linkTarget(l Link) string {
+	return l.NodeName + "/" + l.Name
+}
+
+func (this *liveNs) link(name string) *liveLink {
+	if this == nil {
+		return nil
+	}
+
+	return this.Links[name]
+}
+
+// forget drops the link, which is going to be deleted, so planning of
+// addresses and routes behind it starts from scratch
+func (this *liveNs) forget(name string) {
+	if this != nil {
+		delete(this.Links, name)
+	}
+}
+
+func (this Links) byName(name string) bool {
+	for _, link := range this {
+		if link.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applied is the content of StateFile
+type applied struct {
+	Hosts    []string
+	Switches map[string]string
+	Cgroups  []string
+}
+
+func readApplied() (*applied, error) {
+	result := &applied{Switches: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(StateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+
+	if result.Switches == nil {
+		result.Switches = make(map[string]string)
+	}
+
+	return result, nil
+}
+
+func (this *Scheme) writeApplied() error {
+	state := applied{Hosts: make([]string, 0), Switches: make(map[string]string), Cgroups: make([]string, 0)}
+
+	for _, s := range this.Switches {
+		state.Switches[s.Name] = s.Type
+	}
+
+	for _, h := range this.Hosts {
+		state.Hosts = append(state.Hosts, h.Name)
+
+		if h.Cgroup != nil {
+			state.Cgroups = append(state.Cgroups, h.Cgroup.Name)
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "      ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(StateFile), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(StateFile, data, 0644)
+}
@@ -0,0 +1,311 @@
+package mn
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/NodePrime/open-mininet/pool"
+	"github.com/vishvananda/netlink"
+)
+
+func TestPlanString(t *testing.T) {
+	plan := &Plan{}
+
+	if plan.String() != "No changes." {
+		t.Fatal("Unexpected empty plan:", plan)
+	}
+
+	plan.add(PlanAdd, "route", "h1", "0.0.0.0/0 via 10.0.0.1", nil)
+	plan.add(PlanDelete, "host", "h2", "", nil)
+	plan.add(PlanAdd, "host", "h1", "", nil)
+	plan.add(PlanDelete, "route", "h2", "10.0.0.0/8 via 10.0.0.1", nil)
+	plan.add(PlanChange, "link", "h1/eth0 <-> s1/h1-eth0", "up", nil)
+
+	// same ordering Plan does
+	sorted := &Plan{}
+	for order := 0; order < 2*len(planObjects)+1; order++ {
+		for _, action := range plan.Actions {
+			if action.order() == order {
+				sorted.Actions = append(sorted.Actions, action)
+			}
+		}
+	}
+
+	expected := strings.Join([]string{
+		"- route h2 10.0.0.0/8 via 10.0.0.1",
+		"- host h2",
+		"+ host h1",
+		"~ link h1/eth0 <-> s1/h1-eth0 up",
+		"+ route h1 0.0.0.0/0 via 10.0.0.1",
+		"Plan: 2 to add, 1 to change, 2 to delete.",
+	}, "\n")
+
+	if sorted.String() != expected {
+		t.Fatal("Expected plan:\n", expected, "\nobtained:\n", sorted)
+	}
+}
+
+// planScheme returns desired state of linux bridge with one or two hosts
+func planScheme(t *testing.T, cidr string, withH2 bool) *Scheme {
+	s := &Switch{Name: "plan-s1", Type: SwitchLinux, Ports: make(Links, 0)}
+	scheme := NewScheme().AddNode(s)
+
+	hosts := map[string]string{"plan-h1": cidr}
+	if withH2 {
+		hosts["plan-h2"] = "10.55.0.3/24"
+	}
+
+	for _, name := range []string{"plan-h1", "plan-h2"} {
+		addr, found := hosts[name]
+		if !found {
+			continue
+		}
+
+		port := Link{Name: name + "-eth0", NodeName: s.Name, Cidr: noip, Peer: Peer{IfName: "eth0", NodeName: name}}
+		link := Link{Name: "eth0", NodeName: name, NetNs: name, Cidr: addr, Peer: Peer{IfName: port.Name, NodeName: s.Name}}
+
+		if name == "plan-h1" {
+			link.Routes = []Route{{Dst: "10.66.0.0/16", Gw: "10.55.0.1"}}
+		}
+
+		s.Ports = append(s.Ports, port)
+		scheme.AddNode(&Host{Name: name, Links: Links{link}})
+	}
+
+	data, err := json.Marshal(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ParseScheme(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+// tempStateFile points StateFile to temporary file, returned func
+// restores it
+func tempStateFile(t *testing.T) func() {
+	f, err := ioutil.TempFile("", "mn-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	orig := StateFile
+	StateFile = f.Name()
+
+	return func() {
+		os.Remove(StateFile)
+		StateFile = orig
+	}
+}
+
+func planObjectsOf(plan *Plan) []string {
+	result := make([]string, 0)
+	for _, action := range plan.Actions {
+		result = append(result, action.Op+" "+action.Object)
+	}
+
+	return result
+}
+
+func TestSchemePlanApply(t *testing.T) {
+	defer tempStateFile(t)()
+
+	scheme := planScheme(t, "10.55.0.2/24", true)
+	defer scheme.Release()
+
+	if (&NetNs{name: "plan-h1"}).Exists() {
+		t.Fatal("Expected ParseScheme doesn't create anything")
+	}
+
+	plan, err := scheme.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"add switch",
+		"add host", "add host",
+		"add link", "add link",
+		"add port", "add port",
+		"add address", "add address",
+		"add route",
+	}
+
+	if strings.Join(planObjectsOf(plan), ",") != strings.Join(expected, ",") {
+		t.Fatal("Unexpected plan:\n", plan)
+	}
+
+	if err := scheme.Apply(plan, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if (&NetNs{name: "plan-h1"}).Exists() {
+		t.Fatal("Expected dry run doesn't create anything")
+	}
+
+	if err := scheme.Apply(plan, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if plan, err = scheme.Plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.Empty() {
+		t.Fatal("Expected no changes after apply, obtained:\n", plan)
+	}
+
+	// drop second host and readdress the first one
+	desired := planScheme(t, "10.55.0.4/24", false)
+
+	if plan, err = desired.Plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []string{
+		"delete address",
+		"delete port",
+		"delete host",
+		"add address",
+		"add route", // dropped by kernel with the old address
+	}
+
+	if strings.Join(planObjectsOf(plan), ",") != strings.Join(expected, ",") {
+		t.Fatal("Unexpected plan:\n", plan)
+	}
+
+	if err := scheme.Apply(plan, false); err == nil {
+		t.Fatal("Expected error applying plan of another scheme")
+	}
+
+	if err := desired.Apply(plan, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if plan, err = desired.Plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.Empty() {
+		t.Fatal("Expected no changes after apply, obtained:\n", plan)
+	}
+
+	if (&NetNs{name: "plan-h2"}).Exists() {
+		t.Fatal("Expected plan-h2 deleted")
+	}
+}
+
+func TestSchemePlanRecreateLink(t *testing.T) {
+	defer tempStateFile(t)()
+
+	scheme := planScheme(t, "10.55.0.2/24", false)
+	defer scheme.Release()
+
+	hw := "02:55:00:00:00:02"
+	scheme.Hosts[0].Links[0].HwAddr = hw
+	if err := pool.ThePool().ReserveMac(hw); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := scheme.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scheme.Apply(plan, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// veth ends go away together, renamed end is missing for the plan
+	port, err := netlink.LinkByName(scheme.Switches[0].Ports[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := netlink.LinkSetName(port, "plan-gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	if plan, err = scheme.Plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(plan.String(), "recreate, one end is missing") {
+		t.Fatal("Expected link recreated, obtained plan:\n", plan)
+	}
+
+	if err := scheme.Apply(plan, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if plan, err = scheme.Plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.Empty() {
+		t.Fatal("Expected no changes after apply, obtained:\n", plan)
+	}
+
+	if err := pool.ThePool().ReserveMac(hw); !errors.Is(err, pool.ErrInUse) {
+		t.Fatal("Expected", hw, "still allocated, obtained", err)
+	}
+}
+
+func TestSchemePlanShaping(t *testing.T) {
+	defer tempStateFile(t)()
+
+	scheme := planScheme(t, "10.55.0.2/24", false)
+	defer scheme.Release()
+
+	scheme.Hosts[0].Links[0].Shaping = &Shaping{Rate: "10mbit", Delay: "10ms", Loss: 1}
+
+	plan, err := scheme.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scheme.Apply(plan, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if plan, err = scheme.Plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.Empty() {
+		t.Fatal("Expected no changes after apply, obtained:\n", plan)
+	}
+
+	// only the delay is edited, qdiscs stay the same
+	scheme.Hosts[0].Links[0].Shaping = &Shaping{Rate: "10mbit", Delay: "20ms", Loss: 1}
+
+	if plan, err = scheme.Plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(planObjectsOf(plan), ",") != "change shaping" || !strings.Contains(plan.String(), "delay 20ms") {
+		t.Fatal("Expected shaping changed, obtained plan:\n", plan)
+	}
+
+	if err := scheme.Apply(plan, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if plan, err = scheme.Plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.Empty() {
+		t.Fatal("Expected no changes after apply, obtained:\n", plan)
+	}
+}
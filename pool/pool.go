@@ -1,156 +1,637 @@
 package pool
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"sync"
 )
 
-type pool struct {
-	cache   map[string]*net.IPNet
-	pool    map[string]bool
-	created bool
+// Default6 is the prefix of NextCidr6, when no IPv6 subnet was added
+const Default6 = "fd00:6d6e::/64"
+
+// DefaultSubnet is the name of the subnet addresses are allocated from,
+// when no subnet is asked for
+const DefaultSubnet = "default"
+
+// how many random MACs to try before giving up
+const macAttempts = 64
+
+var (
+	ErrExhausted     = errors.New("no free addresses")
+	ErrUnknownSubnet = errors.New("unknown subnet")
+	ErrSubnetExists  = errors.New("subnet exists with another cidr")
+	ErrInUse         = errors.New("address in use")
+)
+
+// Error describes failed pool operation, Err is one of the Err* values
+type Error struct {
+	Subnet string
+	Addr   string
+	Err    error
+}
+
+func (this *Error) Error() string {
+	result := this.Err.Error()
+
+	if this.Subnet != "" {
+		result = "subnet " + this.Subnet + ": " + result
+	}
+
+	if this.Addr != "" {
+		result += " (" + this.Addr + ")"
+	}
+
+	return result
+}
+
+func (this *Error) Unwrap() error {
+	return this.Err
+}
+
+type subnet struct {
+	name      string
+	ipnet     *net.IPNet
+	last      net.IP
+	allocated map[string]bool
+}
+
+// Pool allocates addresses from named subnets and MACs, every address is
+// handed out once until it's released. It's safe for concurrent use.
+// Pool is a part of the scheme JSON, so allocations survive restarts.
+type Pool struct {
+	mutex   sync.Mutex
+	subnets []*subnet
+	macs    map[string]bool
 	preset  bool
 }
 
-var instance pool
+var (
+	instance *Pool
+	once     sync.Once
+)
 
-func ThePool(args ...interface{}) pool {
-	if len(args) > 0 && !instance.created {
-		ip, ipnet, err := net.ParseCIDR(args[0].(string))
-		if err != nil {
-			panic(err)
+// ThePool returns the process wide pool used for generated links. Cidr
+// argument, e.g. ThePool("192.168.55.1/24"), adds the default subnet, if
+// there is none yet.
+func ThePool(args ...interface{}) *Pool {
+	once.Do(func() {
+		instance = New()
+	})
+
+	if len(args) > 0 {
+		instance.mutex.Lock()
+		defer instance.mutex.Unlock()
+
+		if instance.byName(DefaultSubnet) == nil {
+			if err := instance.addSubnet(DefaultSubnet, args[0].(string)); err != nil {
+				panic(err)
+			}
+
+			instance.preset = true
 		}
+	}
+
+	return instance
+}
 
-		key := ip.Mask(ipnet.Mask)
-		instance = newPool()
-		instance.cache[key.String()] = ipnet
-		instance.preset = true
+func New() *Pool {
+	return &Pool{
+		subnets: make([]*subnet, 0),
+		macs:    make(map[string]bool),
 	}
+}
+
+// AddSubnet adds named subnet, adding the same one again does nothing
+func (this *Pool) AddSubnet(name, cidr string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
 
-	if instance.created == false {
-		instance = newPool()
+	return this.addSubnet(name, cidr)
+}
+
+// AddPrefix adds subnet named after its network, it's the way to enable
+// IPv6 addresses, see NextCidr6
+func (this *Pool) AddPrefix(cidr string) error {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
 	}
 
-	return instance
+	return this.AddSubnet(ipnet.String(), cidr)
 }
 
-func newPool() pool {
-	return pool{
-		cache:   make(map[string]*net.IPNet),
-		pool:    make(map[string]bool),
-		created: true,
+// Subnets returns names of the subnets in the order they were added
+func (this *Pool) Subnets() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	result := make([]string, 0)
+	for _, s := range this.subnets {
+		result = append(result, s.name)
 	}
+
+	return result
 }
 
-// @todo refactor it. make private next() method, which do all stuff
-// and NextCidr and NextAddr wrappers
+// Allocate returns next free address of the subnet in cidr form, e.g.
+// 192.168.55.3/24. Empty name means the default subnet: "default" one, or
+// the first IPv4 subnet.
+func (this *Pool) Allocate(name string) (string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
 
-// @todo check for ip uniqueness
+	s := this.byName(name)
+	if name == "" {
+		s = this.default4()
+	}
 
-func (this pool) NextCidr(args ...interface{}) string {
-	var cidr string
+	if s == nil {
+		return "", &Error{Subnet: name, Err: ErrUnknownSubnet}
+	}
 
-	if len(args) == 1 {
-		cidr = args[0].(string)
-	} else {
-		for k, _ := range this.cache {
-			ipnet := this.cache[k]
-			cidr = ipnet.String()
-			break
-		}
+	return s.allocate()
+}
+
+// Reserve marks address as allocated, addresses outside of the pool
+// subnets aren't managed and are accepted as is. ErrInUse means address
+// was already allocated, it stays so.
+func (this *Pool) Reserve(cidr string) error {
+	ip, s, err := this.find(cidr)
+	if err != nil || s == nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if s.allocated[ip.String()] {
+		return &Error{Subnet: s.name, Addr: ip.String(), Err: ErrInUse}
+	}
+
+	s.allocated[ip.String()] = true
+
+	return nil
+}
+
+// Release returns address to its subnet
+func (this *Pool) Release(cidr string) {
+	ip, s, err := this.find(cidr)
+	if err != nil || s == nil {
+		return
 	}
 
-	ip, ipnet, err, key := this.get(cidr)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	delete(s.allocated, ip.String())
+}
+
+// AllocateMac returns unused MAC with vendor part of mac
+func (this *Pool) AllocateMac(mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
-	inc := func(ip net.IP) {
-		for j := len(ip) - 1; j >= 0; j-- {
-			ip[j]++
-			if ip[j] > 0 {
-				break
-			}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	buf := make([]byte, 6)
+
+	for i := 0; i < macAttempts; i++ {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+
+		result := fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", hw[0], hw[1], hw[2], buf[3], buf[4], buf[5])
+		if !this.macs[result] {
+			this.macs[result] = true
+			return result, nil
 		}
 	}
 
-	inc(ip)
-	if !ipnet.Contains(ip) {
-		ip, ipnet, _ = net.ParseCIDR(ipnet.String())
+	return "", &Error{Addr: mac, Err: ErrExhausted}
+}
+
+// ReserveMac marks MAC as used, ErrInUse means it already was
+func (this *Pool) ReserveMac(mac string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
 	}
 
-	ipnet.IP = ip
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
 
-	if _, found := this.cache[key]; !found {
-		this.cache[key] = &net.IPNet{}
+	if this.macs[hw.String()] {
+		return &Error{Addr: hw.String(), Err: ErrInUse}
 	}
 
-	this.cache[key] = ipnet
+	this.macs[hw.String()] = true
 
-	return ipnet.String()
+	return nil
 }
 
-func (this pool) NextAddr(args ...interface{}) string {
-	var addr, netmask string
-	var ipnet *net.IPNet
+func (this *Pool) ReleaseMac(mac string) {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		this.mutex.Lock()
+		delete(this.macs, hw.String())
+		this.mutex.Unlock()
+	}
+}
+
+// Merge adds subnets and allocations of other pool, e.g. the one loaded
+// with a scheme
+func (this *Pool) Merge(other *Pool) error {
+	if other == nil || other == this {
+		return nil
+	}
+
+	data, err := json.Marshal(other)
+	if err != nil {
+		return err
+	}
+
+	spec := poolSpec{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.load(spec)
+}
+
+// NextCidr allocates address of the subnet with cidr network, the subnet
+// is added if needed. Without arguments it allocates from the default
+// subnet. Empty string means there is no free address, see Allocate.
+func (this *Pool) NextCidr(args ...interface{}) string {
+	name := ""
+
+	if len(args) == 1 {
+		_, ipnet, err := net.ParseCIDR(args[0].(string))
+		if err != nil {
+			return ""
+		}
+
+		name = this.subnetOf(ipnet)
+	}
+
+	result, err := this.Allocate(name)
+	if err != nil {
+		return ""
+	}
+
+	return result
+}
+
+// NextAddr is NextCidr without prefix length, subnet is given by address
+// and netmask
+func (this *Pool) NextAddr(args ...interface{}) string {
+	var cidr string
 
 	switch len(args) {
 	case 2:
-		addr = args[0].(string)
-		netmask = args[1].(string)
-		ipnet = &net.IPNet{
-			IP:   net.ParseIP(addr),
-			Mask: net.IPMask(net.ParseIP(netmask)),
+		ip := net.ParseIP(args[0].(string))
+		mask := net.IPMask(net.ParseIP(args[1].(string)).To4())
+		if ip == nil || mask == nil {
+			return ""
 		}
 
+		cidr = this.NextCidr((&net.IPNet{IP: ip, Mask: mask}).String())
 	case 0:
-		for k, _ := range this.cache {
-			ipnet = this.cache[k]
-			break
-		}
+		cidr = this.NextCidr()
 	default:
 		return ""
 	}
 
-	ip, _, _ := net.ParseCIDR(this.NextCidr(ipnet.String()))
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ""
+	}
 
 	return ip.String()
 }
 
-func (this pool) NextMac(mac string) string {
-	hw, err := net.ParseMAC(mac)
+// Has6 tells whether IPv6 subnet was added
+func (this *Pool) Has6() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.default6() != nil
+}
+
+// NextCidr6 allocates address of the first IPv6 subnet, Default6 is
+// added if there is none
+func (this *Pool) NextCidr6() string {
+	this.mutex.Lock()
+	s := this.default6()
+	this.mutex.Unlock()
+
+	if s == nil {
+		return this.NextCidr(Default6)
+	}
+
+	result, err := this.Allocate(s.name)
 	if err != nil {
 		return ""
 	}
 
-	buf := make([]byte, 6)
+	return result
+}
 
-	_, err = rand.Read(buf)
+// NextMac is AllocateMac, which returns empty string on errors
+func (this *Pool) NextMac(mac string) string {
+	result, err := this.AllocateMac(mac)
 	if err != nil {
 		return ""
 	}
 
-	buf[0] |= 2
-	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", hw[0], hw[1], hw[2], buf[3], buf[4], buf[5])
+	return result
+}
+
+func (this *Pool) Preset() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.preset
+}
+
+type subnetSpec struct {
+	Name      string
+	Cidr      string
+	Last      string
+	Allocated []string
+}
+
+type poolSpec struct {
+	Subnets []subnetSpec
+	Macs    []string
+}
+
+func (this *Pool) MarshalJSON() ([]byte, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	spec := poolSpec{Subnets: make([]subnetSpec, 0), Macs: keys(this.macs)}
+
+	for _, s := range this.subnets {
+		last := ""
+		if s.last != nil {
+			last = s.last.String()
+		}
+
+		spec.Subnets = append(spec.Subnets, subnetSpec{s.name, s.ipnet.String(), last, keys(s.allocated)})
+	}
+
+	return json.Marshal(spec)
 }
 
-func (this pool) get(cidr string) (net.IP, *net.IPNet, error, string) {
-	ip, ipnet, err := net.ParseCIDR(cidr)
+func (this *Pool) UnmarshalJSON(b []byte) error {
+	spec := poolSpec{}
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.subnets = make([]*subnet, 0)
+	this.macs = make(map[string]bool)
+
+	return this.load(spec)
+}
+
+func (this *Pool) load(spec poolSpec) error {
+	for _, ss := range spec.Subnets {
+		if err := this.addSubnet(ss.Name, ss.Cidr); err != nil {
+			return err
+		}
 
-	// This is because inventory nic's contains cidr as a an ip address and
-	// we can not use it as a keys in cache map. So we have to convert them
-	// to the mask. E.g. cidr 10.200.22.31/16 becomes 10.200.0.0, and we use
-	// it as a key.
-	ip = ip.Mask(ipnet.Mask)
+		s := this.byName(ss.Name)
 
-	if c, found := this.cache[ip.String()]; found {
-		return c.IP, c, nil, ip.String()
+		for _, addr := range ss.Allocated {
+			if ip := net.ParseIP(addr); ip != nil && s.ipnet.Contains(ip) {
+				s.allocated[normalize(ip).String()] = true
+			}
+		}
+
+		if ip := net.ParseIP(ss.Last); ip != nil && s.ipnet.Contains(ip) && (s.last == nil || bytes.Compare(normalize(ip), s.last) > 0) {
+			s.last = normalize(ip)
+		}
+	}
+
+	for _, mac := range spec.Macs {
+		if hw, err := net.ParseMAC(mac); err == nil {
+			this.macs[hw.String()] = true
+		}
 	}
 
-	return ip, ipnet, err, ip.String()
+	return nil
 }
 
-func (this pool) Preset() bool {
-	return this.preset
+func (this *Pool) addSubnet(name, cidr string) error {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	if s := this.byName(name); s != nil {
+		if s.ipnet.String() != ipnet.String() {
+			return &Error{Subnet: name, Addr: cidr, Err: ErrSubnetExists}
+		}
+
+		return nil
+	}
+
+	ipnet.IP = normalize(ipnet.IP)
+
+	this.subnets = append(this.subnets, &subnet{name: name, ipnet: ipnet, allocated: make(map[string]bool)})
+
+	return nil
+}
+
+// subnetOf returns name of the subnet with ipnet network, adding it
+func (this *Pool) subnetOf(ipnet *net.IPNet) string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, s := range this.subnets {
+		if s.ipnet.String() == ipnet.String() {
+			return s.name
+		}
+	}
+
+	this.addSubnet(ipnet.String(), ipnet.String())
+
+	return ipnet.String()
+}
+
+// find returns address of cidr and the most specific subnet it's in
+func (this *Pool) find(cidr string) (net.IP, *subnet, error) {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		if ip = net.ParseIP(cidr); ip == nil {
+			return nil, nil, err
+		}
+	}
+
+	ip = normalize(ip)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var result *subnet
+	for _, s := range this.subnets {
+		if !s.ipnet.Contains(ip) {
+			continue
+		}
+
+		if ones, _ := s.ipnet.Mask.Size(); result == nil || ones > prefixLen(result) {
+			result = s
+		}
+	}
+
+	return ip, result, nil
+}
+
+func (this *Pool) byName(name string) *subnet {
+	for _, s := range this.subnets {
+		if s.name == name {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func (this *Pool) default4() *subnet {
+	if s := this.byName(DefaultSubnet); s != nil {
+		return s
+	}
+
+	for _, s := range this.subnets {
+		if s.ipnet.IP.To4() != nil {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func (this *Pool) default6() *subnet {
+	for _, s := range this.subnets {
+		if s.ipnet.IP.To4() == nil {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// allocate takes the first free address after the last allocated one,
+// it wraps around once and fails when the whole subnet is in use
+func (this *subnet) allocate() (string, error) {
+	first := this.first()
+
+	start := first
+	if this.last != nil {
+		start = this.next(this.last)
+	}
+
+	ip := start
+	for {
+		if this.usable(ip) && !this.allocated[ip.String()] {
+			this.allocated[ip.String()] = true
+			this.last = ip
+
+			return (&net.IPNet{IP: ip, Mask: this.ipnet.Mask}).String(), nil
+		}
+
+		if ip = this.next(ip); ip.Equal(start) {
+			return "", &Error{Subnet: this.name, Addr: this.ipnet.String(), Err: ErrExhausted}
+		}
+	}
+}
+
+// first is the address after the network one, unless the subnet is too
+// small to have it
+func (this *subnet) first() net.IP {
+	ip := inc(this.ipnet.IP)
+	if !this.ipnet.Contains(ip) || prefixLen(this) >= bits(this)-1 {
+		return dup(this.ipnet.IP)
+	}
+
+	return ip
+}
+
+// next returns following address, the first one after the last
+func (this *subnet) next(ip net.IP) net.IP {
+	result := inc(ip)
+	if !this.ipnet.Contains(result) {
+		return this.first()
+	}
+
+	return result
+}
+
+// usable excludes IPv4 broadcast
+func (this *subnet) usable(ip net.IP) bool {
+	if ip.To4() == nil || prefixLen(this) >= 31 {
+		return true
+	}
+
+	return this.ipnet.Contains(inc(ip))
+}
+
+func prefixLen(s *subnet) int {
+	ones, _ := s.ipnet.Mask.Size()
+	return ones
+}
+
+func bits(s *subnet) int {
+	_, result := s.ipnet.Mask.Size()
+	return result
+}
+
+func inc(ip net.IP) net.IP {
+	result := dup(ip)
+
+	for j := len(result) - 1; j >= 0; j-- {
+		result[j]++
+		if result[j] > 0 {
+			break
+		}
+	}
+
+	return result
+}
+
+func dup(ip net.IP) net.IP {
+	return append(net.IP{}, ip...)
+}
+
+func normalize(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
+
+func keys(m map[string]bool) []string {
+	result := make([]string, 0)
+	for k := range m {
+		result = append(result, k)
+	}
+
+	sort.Strings(result)
+
+	return result
 }
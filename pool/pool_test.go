@@ -1,6 +1,10 @@
 package pool
 
 import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
 	"testing"
 )
 
@@ -52,3 +56,168 @@ func TestCustomRange(t *testing.T) {
 		t.Fatal("Expected ip3 = 192.168.0.3/24, obtained =", ip3)
 	}
 }
+
+func TestIPv6(t *testing.T) {
+	for _, prefix := range []string{"10.10.0.0/24", "fd00:10::/64"} {
+		if err := ThePool().AddPrefix(prefix); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !ThePool().Has6() {
+		t.Fatal("Expected IPv6 prefix in the pool")
+	}
+
+	ip1 := ThePool().NextCidr6()
+	ip2 := ThePool().NextCidr6()
+
+	if ip1 != "fd00:10::1/64" {
+		t.Fatal("Expected ip1 = fd00:10::1/64, obtained =", ip1)
+	}
+
+	if ip2 != "fd00:10::2/64" {
+		t.Fatal("Expected ip2 = fd00:10::2/64, obtained =", ip2)
+	}
+
+	ip4 := ThePool().NextCidr()
+	if ip, _, _ := net.ParseCIDR(ip4); ip.To4() == nil {
+		t.Fatal("Expected IPv4 address from NextCidr, obtained =", ip4)
+	}
+}
+
+func TestExhausted(t *testing.T) {
+	p := New()
+	if err := p.AddSubnet("small", "10.20.0.0/30"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"10.20.0.1/30", "10.20.0.2/30"} {
+		if cidr, err := p.Allocate("small"); err != nil || cidr != expected {
+			t.Fatal("Expected", expected, "obtained", cidr, err)
+		}
+	}
+
+	if cidr, err := p.Allocate("small"); !errors.Is(err, ErrExhausted) {
+		t.Fatal("Expected ErrExhausted, obtained", cidr, err)
+	}
+
+	p.Release("10.20.0.1/30")
+
+	if cidr, err := p.Allocate("small"); err != nil || cidr != "10.20.0.1/30" {
+		t.Fatal("Expected released 10.20.0.1/30, obtained", cidr, err)
+	}
+
+	if _, err := p.Allocate("missing"); !errors.Is(err, ErrUnknownSubnet) {
+		t.Fatal("Expected ErrUnknownSubnet, obtained", err)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	p := New()
+	p.AddSubnet("lan", "10.30.0.0/24")
+
+	if err := p.Reserve("10.30.0.1/24"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Reserve("10.30.0.1/24"); !errors.Is(err, ErrInUse) {
+		t.Fatal("Expected ErrInUse, obtained", err)
+	}
+
+	if err := p.Reserve("172.16.0.1/24"); err != nil {
+		t.Fatal("Expected address outside of subnets accepted, obtained", err)
+	}
+
+	if cidr, _ := p.Allocate("lan"); cidr != "10.30.0.2/24" {
+		t.Fatal("Expected reserved address skipped, obtained", cidr)
+	}
+
+	mac, err := p.AllocateMac("08:00:27:00:00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.ReserveMac(mac); !errors.Is(err, ErrInUse) {
+		t.Fatal("Expected ErrInUse for allocated mac, obtained", err)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	p := New()
+	p.AddSubnet("lan", "10.40.0.0/24")
+	p.AddSubnet("lan6", "fd00:40::/64")
+
+	p.Allocate("lan")
+	p.Allocate("lan")
+	p.Allocate("lan6")
+	p.ReserveMac("08:00:27:01:02:03")
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := New()
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+
+	if cidr, _ := restored.Allocate("lan"); cidr != "10.40.0.3/24" {
+		t.Fatal("Expected 10.40.0.3/24 after restore, obtained", cidr, string(data))
+	}
+
+	if cidr, _ := restored.Allocate("lan6"); cidr != "fd00:40::2/64" {
+		t.Fatal("Expected fd00:40::2/64 after restore, obtained", cidr, string(data))
+	}
+
+	if err := restored.ReserveMac("08:00:27:01:02:03"); !errors.Is(err, ErrInUse) {
+		t.Fatal("Expected mac restored, obtained", err)
+	}
+
+	merged := New()
+	merged.AddSubnet("lan", "10.40.0.0/24")
+
+	if err := merged.Merge(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if cidr, _ := merged.Allocate("lan"); cidr != "10.40.0.3/24" {
+		t.Fatal("Expected 10.40.0.3/24 after merge, obtained", cidr)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	p := New()
+	p.AddSubnet("lan", "10.50.0.0/24")
+
+	results := make(chan string, 254)
+	var wg sync.WaitGroup
+
+	for i := 0; i < 254; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			cidr, err := p.Allocate("lan")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- cidr
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	seen := make(map[string]bool)
+	for cidr := range results {
+		if seen[cidr] {
+			t.Fatal("Address allocated twice:", cidr)
+		}
+		seen[cidr] = true
+	}
+
+	if _, err := p.Allocate("lan"); !errors.Is(err, ErrExhausted) {
+		t.Fatal("Expected ErrExhausted, obtained", err)
+	}
+}
@@ -1,12 +1,14 @@
 package mn
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strconv"
-	"strings"
+	"sort"
+	"syscall"
+	"time"
 )
 
 type Procs []*Process
@@ -15,8 +17,21 @@ type Process struct {
 	*os.Process
 	Command string
 	Args    []string
-	attr    os.ProcAttr
-	Output  string
+	// Stdout and Stderr are log files of the process output, they are
+	// created in LogDir, when process starts
+	Stdout  string         `json:",omitempty"`
+	Stderr  string         `json:",omitempty"`
+	Restart *RestartPolicy `json:",omitempty"`
+	// StopTimeout is how long Stop waits after SIGINT before SIGKILL,
+	// package StopTimeout by default
+	StopTimeout string      `json:",omitempty"`
+	Exit        *ExitStatus `json:",omitempty"`
+	Restarts    int         `json:",omitempty"`
+	// StartTime of the process in clock ticks after boot, field 22 of
+	// /proc/<pid>/stat. Recovery adopts running process only with the
+	// same start time.
+	StartTime uint64 `json:",omitempty"`
+	state     *procState
 }
 
 func (this Procs) Add(p *Process) {
@@ -25,10 +40,7 @@ func (this Procs) Add(p *Process) {
 
 func (this Procs) GetByPid(pid int) *Process {
 	for i, _ := range this {
-		if this[i].Process == nil {
-			continue
-		}
-		if this[i].Pid == pid {
+		if p := this[i].GetPid(); p != 0 && p == pid {
 			return this[i]
 		}
 	}
@@ -36,76 +48,190 @@ func (this Procs) GetByPid(pid int) *Process {
 	return nil
 }
 
-func (this Process) GetPid() int {
+func (this *Process) GetPid() int {
+	return this.Status().Pid
+}
+
+// ProcessStatus is a snapshot of the process run
+type ProcessStatus struct {
+	Pid       int
+	Restarts  int
+	Exit      *ExitStatus
+	StartTime uint64
+}
+
+// Status returns pid, restarts and the last exit of the process, which
+// supervisor updates, while it restarts the process
+func (this *Process) Status() ProcessStatus {
+	if this.state != nil {
+		this.state.Lock()
+		defer this.state.Unlock()
+	}
+
+	result := ProcessStatus{Restarts: this.Restarts, Exit: this.Exit, StartTime: this.StartTime}
 	if this.Process != nil {
-		return this.Pid
+		result.Pid = this.Pid
 	}
 
-	return 0
+	return result
 }
 
-func (this Process) Stop() error {
-	if this.Process == nil {
-		return errors.New(fmt.Sprintf("No such process: ", this.Command, this.Args))
+// MarshalJSON exports the process the way Status sees it
+func (this *Process) MarshalJSON() ([]byte, error) {
+	type process Process
+
+	if this.state != nil {
+		this.state.Lock()
 	}
 
-	if err := this.Signal(os.Interrupt); err != nil {
-		return err
+	snapshot := process(*this)
+
+	if this.state != nil {
+		this.state.Unlock()
 	}
 
-	return nil
+	return json.Marshal(&snapshot)
 }
 
-// os.FindProcess() actually doesn't find it on posix systems, it just
-// populates the struct and does SetFinalizer
-// Idiomatic go way to find process by pid is:
-//
-// 	if e := syscall.Kill(pid, syscall.Signal(0)); e != nil {
-// 		return nil, NewSyscallError("find process", e)
-// 	}
-//
-// But we can't be sure, that found process actually the same
-// so it looks, that finding it by name makes sence.
-func (this Process) findProcessByName(netns string) (*os.Process, error) {
-	out, err := RunCommand("ps", "-A", "-eo", "%p,%a")
-	if err != nil {
-		return nil, err
+// current returns the current run of the process
+func (this *Process) current() *os.Process {
+	if this.state != nil {
+		this.state.Lock()
+		defer this.state.Unlock()
+	}
+
+	return this.Process
+}
+
+// alive checks, that process is started and hasn't exited yet
+func (this *Process) alive() bool {
+	process := this.current()
+	return process != nil && process.Signal(syscall.Signal(0)) == nil
+}
+
+// Stop interrupts the process and waits StopTimeout for it to exit, then
+// kills it. Stopped process isn't restarted by supervisor.
+func (this *Process) Stop() error {
+	process, done := this.current(), (chan struct{})(nil)
+
+	if this.state != nil {
+		if process, done = this.state.halt(); process == nil {
+			return nil
+		}
 	}
 
-	name := this.Command + " " + strings.Join(this.Args, " ")
+	if process == nil {
+		return errors.New(fmt.Sprintf("No such process: %s %v", this.Command, this.Args))
+	}
 
-	lines := strings.Split(out, "\n")
+	timeout := parseDuration(this.StopTimeout, StopTimeout)
 
-	for _, line := range lines {
-		parts := strings.Split(line, ",")
-		if len(parts) != 2 {
-			continue
+	if err := process.Signal(os.Interrupt); err != nil {
+		if errors.Is(err, os.ErrProcessDone) {
+			return nil
 		}
-		if parts[1] != name {
-			continue
+		return err
+	}
+
+	if waitExit(process, done, timeout) {
+		return nil
+	}
+
+	log.Println("Process", process.Pid, this.Command, "didn't stop in", timeout, "killing it")
+
+	if err := process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+
+	if !waitExit(process, done, timeout) {
+		return errors.New(fmt.Sprintf("Process %d %s didn't exit after SIGKILL", process.Pid, this.Command))
+	}
+
+	return nil
+}
+
+// waitExit waits for done to be closed by supervisor, or polls processes,
+// which aren't children
+func waitExit(process *os.Process, done chan struct{}, timeout time.Duration) bool {
+	if done != nil {
+		select {
+		case <-done:
+			return true
+		case <-time.After(timeout):
+			return false
 		}
+	}
 
-		pid, err := strconv.Atoi(strings.Trim(parts[0], " "))
-		if err != nil {
-			return nil, err
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		if process.Signal(syscall.Signal(0)) != nil {
+			return true
 		}
 
-		// check that process is in the right netns
-		if netns == netnsByPid(pid) {
-			return os.FindProcess(pid)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+// matches tells, if running process is this one: command line is the
+// same argument by argument, and start time, if it's known
+func (this *Process) matches(info procInfo) bool {
+	args := append([]string{this.Command}, this.Args...)
+
+	if len(args) != len(info.Args) {
+		return false
+	}
+
+	for i := range args {
+		if args[i] != info.Args[i] {
+			return false
 		}
 	}
 
-	return nil, nil
+	return this.StartTime == 0 || this.StartTime == info.StartTime
 }
 
-// Beware, the old versions of ip utility don't support 'identify' command
-func netnsByPid(pid int) string {
-	out, err := RunCommand("ip", "netns", "identify", strconv.Itoa(pid))
+// adopt finds the process running in the netns, which isn't taken by
+// other process of the host yet, and makes it the current run. Start time
+// makes sure, that reused pid isn't mistaken for the process, it's
+// recorded, if scheme hasn't it.
+func (this *Process) adopt(netns string, taken map[int]bool) (*os.Process, error) {
+	procs, err := nsProcInfo(netns)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make([]int, 0)
+	for pid, info := range procs {
+		if !taken[pid] && this.matches(info) {
+			pids = append(pids, pid)
+		}
+	}
+
+	if len(pids) == 0 {
+		return nil, nil
+	}
+
+	sort.Ints(pids)
+
+	process, err := os.FindProcess(pids[0])
 	if err != nil {
-		log.Println(err)
-		return ""
+		return nil, err
+	}
+
+	taken[pids[0]] = true
+	this.adopted(process, procs[pids[0]].StartTime)
+
+	return process, nil
+}
+
+// adopted sets running process found by adopt as the current run
+func (this *Process) adopted(process *os.Process, startTime uint64) {
+	if this.state != nil {
+		this.state.Lock()
+		defer this.state.Unlock()
 	}
 
-	return strings.Trim(out, "\n")
+	this.Process = process
+	this.StartTime = startTime
 }
@@ -0,0 +1,347 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Recover brings the scheme up: missing switches and namespaces are
+// created, missing links are created and plugged, processes are started.
+// If it fails, everything it created is removed, see KeepPartial.
+func (this Scheme) Recover() error {
+	_, err := this.RecoverReport()
+	return err
+}
+
+// RecoverReport is Recover, which also tells how long every step took.
+// Steps make a dependency graph: namespace -> veth -> port attach ->
+// address -> route -> process. Independent branches, e.g. links of
+// different hosts, run concurrently, RecoverWorkers at once. Report is
+// returned on error too, with the steps finished so far.
+func (this Scheme) RecoverReport() (*BuildReport, error) {
+	journal := NewJournal()
+	journal.Keep = this.KeepPartial
+
+	start := time.Now()
+
+	graph, err := this.recoverGraph(journal)
+	if err != nil {
+		return &BuildReport{Total: time.Since(start)}, err
+	}
+
+	err = graph.run(RecoverWorkers)
+	if err == nil {
+		err = this.UpdateHosts()
+	}
+
+	report := graph.report(time.Since(start))
+
+	return report, journal.Done(err)
+}
+
+// recovery collects steps of a scheme being recovered
+type recovery struct {
+	scheme  Scheme
+	journal *Journal
+	graph   *buildGraph
+	// nodes holds switch and netns creation steps, nil if node exists
+	nodes map[string]*graphStep
+	// links holds steps bringing links of a host up
+	links map[string][]*graphStep
+	// created holds new links of a host, their routes are applied
+	created map[string][]Link
+	// ends are links already taken by a pair, node/name
+	ends map[string]bool
+}
+
+// recoverGraph checks what exists and returns steps creating the rest.
+// Nothing is changed yet.
+func (this Scheme) recoverGraph(journal *Journal) (*buildGraph, error) {
+	r := &recovery{
+		scheme:  this,
+		journal: journal,
+		graph:   &buildGraph{},
+		nodes:   make(map[string]*graphStep),
+		links:   make(map[string][]*graphStep),
+		created: make(map[string][]Link),
+		ends:    make(map[string]bool),
+	}
+
+	for _, s := range this.Switches {
+		r.switchStep(s)
+	}
+
+	for _, h := range this.Hosts {
+		r.netnsStep(h)
+	}
+
+	for _, s := range this.Switches {
+		if err := r.switchPorts(s); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, h := range this.Hosts {
+		r.hostLinks(h)
+	}
+
+	for _, h := range this.Hosts {
+		r.hostSteps(h)
+	}
+
+	return r.graph, nil
+}
+
+func (this *recovery) switchStep(s *Switch) {
+	if s.Exists() {
+		return
+	}
+
+	this.nodes[s.Name] = this.graph.add("switch", s.Name, func() error {
+		if err := s.Create(); err != nil {
+			return err
+		}
+
+		this.journal.Record("switch", s.Name, func() error {
+			b, err := s.backend()
+			if err != nil {
+				return err
+			}
+
+			return b.Release(s.Name)
+		})
+
+		return s.configure(s.Controller)
+	})
+}
+
+func (this *recovery) netnsStep(h *Host) {
+	if h.NetNs().Exists() {
+		return
+	}
+
+	this.nodes[h.Name] = this.graph.add("netns", h.Name, func() error {
+		if err := h.NetNs().Create(); err != nil {
+			return err
+		}
+
+		this.journal.Record("netns", h.Name, h.NetNs().Release)
+
+		if len(h.Links) > 1 {
+			h.EnableForwarding()
+		}
+
+		return nil
+	})
+}
+
+// take marks both ends of the pair taken, false if they already are, e.g.
+// when the pair is seen from the other end
+func (this *recovery) take(pair Pair) bool {
+	left := linkTarget(pair.Left)
+	right := linkTarget(pair.Right)
+
+	if this.ends[left] || this.ends[right] {
+		return false
+	}
+
+	this.ends[left] = true
+	this.ends[right] = true
+
+	return true
+}
+
+func (this *recovery) switchPorts(s *Switch) error {
+	for _, port := range s.Ports {
+		peer, found := this.scheme.GetNode(port.Peer.NodeName)
+		if !found {
+			return errors.New(fmt.Sprintf("Can't find host %s", port.Peer.NodeName))
+		}
+
+		link := peer.GetLinks().LinkByPeer(port.Peer)
+		pair := Pair{port, link}
+
+		if !this.take(pair) {
+			continue
+		}
+
+		s2, isSwitch := this.scheme.GetSwitch(peer.NodeName())
+
+		// switches are connected with patch ports, if both of them
+		// support it, or with a veth pair otherwise
+		if isSwitch && canPatch(s, s2) {
+			this.patchPort(s, pair.Left.SetPatch())
+			this.patchPort(s2, pair.Right.SetPatch())
+			continue
+		}
+
+		if port.Exists() {
+			continue
+		}
+
+		if isSwitch {
+			this.pair(pair, s, s2)
+		} else {
+			this.pair(pair, s, nil)
+		}
+	}
+
+	return nil
+}
+
+func (this *recovery) patchPort(s *Switch, l Link) {
+	if s.HasPort(l.Name) {
+		return
+	}
+
+	this.portStep(s, l)
+}
+
+func (this *recovery) portStep(s *Switch, l Link, deps ...*graphStep) *graphStep {
+	deps = append(deps, this.nodes[s.Name])
+
+	return this.graph.add("port", s.Name+" "+l.Name, func() error {
+		if err := s.attach(l); err != nil {
+			return err
+		}
+
+		this.journal.recordPort(s, l)
+
+		return nil
+	}, deps...)
+}
+
+// Recover host to host connectivity
+func (this *recovery) hostLinks(h *Host) {
+	for _, left := range h.Links {
+		peer, found := this.scheme.GetHost(left.Peer.NodeName)
+		if !found {
+			continue
+		}
+
+		right := peer.Links.LinkByPeer(left.Peer)
+		if right.NodeName == "" {
+			// nothing found
+			// @todo-maybe return (Link, bool) form LinkByPeer
+			continue
+		}
+
+		pair := Pair{left, right}
+		if !this.take(pair) || left.Exists() {
+			continue
+		}
+
+		this.pair(pair, nil, nil)
+	}
+}
+
+// pair adds steps creating veth pair, ends are plugged into the switches
+// given, the other ones are host ends. Host ends are up before their
+// routes are applied.
+func (this *recovery) pair(pair Pair, leftSwitch, rightSwitch *Switch) {
+	name := linkTarget(pair.Left) + " <-> " + linkTarget(pair.Right)
+
+	deps := make([]*graphStep, 0)
+	hosts := make([]Link, 0)
+
+	for _, end := range []struct {
+		link Link
+		s    *Switch
+	}{{pair.Left, leftSwitch}, {pair.Right, rightSwitch}} {
+		if end.s == nil {
+			deps = append(deps, this.nodes[end.link.NodeName])
+			hosts = append(hosts, end.link)
+		}
+	}
+
+	veth := this.graph.add("veth", name, func() error {
+		if err := pair.Create(); err != nil {
+			return err
+		}
+
+		this.journal.recordPair(pair)
+
+		return nil
+	}, deps...)
+
+	up := []*graphStep{veth}
+
+	if leftSwitch != nil {
+		up = append(up, this.portStep(leftSwitch, pair.Left, veth))
+	}
+
+	if rightSwitch != nil {
+		up = append(up, this.portStep(rightSwitch, pair.Right, veth))
+	}
+
+	if len(pair.Left.Cidrs()) > 0 || len(pair.Right.Cidrs()) > 0 || pair.Left.Ipv6 != nil || pair.Right.Ipv6 != nil {
+		up = append(up, this.graph.add("address", name, func() error {
+			for _, l := range []Link{pair.Left, pair.Right} {
+				if err := l.ApplyIpv6(); err != nil {
+					return errors.New(fmt.Sprint("Unable to ApplyIpv6 ", linkTarget(l), ", error:", err))
+				}
+
+				if err := l.ApplyCidr(); err != nil {
+					return errors.New(fmt.Sprint("Unable to ApplyCidr ", linkTarget(l), ", error:", err))
+				}
+			}
+
+			return nil
+		}, veth))
+	}
+
+	step := this.graph.add("up", name, func() error {
+		for _, l := range []Link{pair.Left, pair.Right} {
+			if err := l.Up(); err != nil {
+				return errors.New(fmt.Sprint("Unable to Up ", linkTarget(l), ", error:", err))
+			}
+
+			if err := l.ApplyShaping(); err != nil {
+				return errors.New(fmt.Sprint("Unable to ApplyShaping ", linkTarget(l), ", error:", err))
+			}
+		}
+
+		fmt.Println("[Link]", pair.Left.NodeName, pair.Left.Name, pair.Left.Cidr, "<--->", pair.Right.NodeName, pair.Right.Name, pair.Right.Cidr)
+
+		return nil
+	}, up...)
+
+	for _, l := range hosts {
+		this.links[l.NodeName] = append(this.links[l.NodeName], step)
+		this.created[l.NodeName] = append(this.created[l.NodeName], l)
+	}
+}
+
+// hostSteps adds routes of new host links, once all of them are up, as
+// gateway could be behind any of them, and processes after that
+func (this *recovery) hostSteps(h *Host) {
+	deps := append([]*graphStep{this.nodes[h.Name]}, this.links[h.Name]...)
+
+	routes := false
+	for _, l := range this.created[h.Name] {
+		routes = routes || len(l.Routes) > 0
+	}
+
+	if routes {
+		links := this.created[h.Name]
+
+		deps = []*graphStep{this.graph.add("route", h.Name, func() error {
+			for _, l := range links {
+				if err := l.ApplyRoutes(); err != nil {
+					return errors.New(fmt.Sprint("Unable to ApplyRoutes ", linkTarget(l), ", error:", err))
+				}
+			}
+
+			return nil
+		}, deps...)}
+	}
+
+	if len(h.Procs) == 0 {
+		return
+	}
+
+	this.graph.add("process", h.Name, func() error {
+		return h.recoverProcs(this.journal)
+	}, deps...)
+}
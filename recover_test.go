@@ -0,0 +1,245 @@
+package mn
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBuildGraph(t *testing.T) {
+	var mu sync.Mutex
+	order := make([]string, 0)
+
+	var running, peak int32
+
+	step := func(name string) func() error {
+		return func() error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+
+			return nil
+		}
+	}
+
+	g := &buildGraph{}
+	ns := g.add("netns", "h", step("netns"))
+
+	ups := make([]*graphStep, 0)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		veth := g.add("veth", name, step("veth "+name), ns)
+		ups = append(ups, g.add("up", name, step("up "+name), veth, nil))
+	}
+
+	g.add("route", "h", step("route"), ups...)
+
+	if err := g.run(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if order[0] != "netns" || order[len(order)-1] != "route" {
+		t.Fatal("Expected netns first and route last, obtained", order)
+	}
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		veth, up := -1, -1
+		for i, done := range order {
+			switch done {
+			case "veth " + name:
+				veth = i
+			case "up " + name:
+				up = i
+			}
+		}
+
+		if veth < 0 || up < veth {
+			t.Fatal("Expected veth", name, "before its up, obtained", order)
+		}
+	}
+
+	if peak != 2 {
+		t.Fatal("Expected 2 steps at once, obtained", peak)
+	}
+
+	report := g.report(time.Second)
+	if len(report.Steps) != 10 || !strings.Contains(report.String(), "veth        4") {
+		t.Fatal("Unexpected report:\n", report)
+	}
+
+	failed := errors.New("failed")
+	started := int32(0)
+
+	g = &buildGraph{}
+	first := g.add("netns", "h", func() error { return failed })
+	g.add("veth", "a", func() error {
+		atomic.AddInt32(&started, 1)
+		return nil
+	}, first)
+
+	if err := g.run(4); err != failed {
+		t.Fatal("Expected step error, obtained", err)
+	}
+
+	if started != 0 || len(g.report(0).Steps) != 1 {
+		t.Fatal("Expected no steps started after error")
+	}
+}
+
+func TestSchemeRecoverReport(t *testing.T) {
+	scheme := planScheme(t, "10.55.0.2/24", true)
+	defer scheme.Release()
+
+	h1, _ := scheme.GetHost("plan-h1")
+	h2, _ := scheme.GetHost("plan-h2")
+
+	// direct host to host link besides the switch
+	left := Link{Name: "p2p", NodeName: h1.Name, NetNs: h1.Name, Cidr: "10.56.0.1/30", Peer: Peer{IfName: "p2p", NodeName: h2.Name}}
+	right := Link{Name: "p2p", NodeName: h2.Name, NetNs: h2.Name, Cidr: "10.56.0.2/30", Peer: Peer{IfName: "p2p", NodeName: h1.Name}}
+	h1.AddLink(left)
+	h2.AddLink(right)
+
+	report, err := scheme.RecoverReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for _, step := range report.Steps {
+		counts[step.Kind]++
+	}
+
+	expected := map[string]int{"switch": 1, "netns": 2, "veth": 3, "port": 2, "address": 3, "up": 3, "route": 1}
+	for kind, n := range expected {
+		if counts[kind] != n {
+			t.Fatal("Expected", n, kind, "steps, obtained", counts[kind], "\n", report)
+		}
+	}
+
+	for _, ip := range []string{"10.55.0.3", "10.56.0.2"} {
+		if err := dial(h1, h2, ip+":7777"); err != nil {
+			t.Fatal("Expected", ip, "reachable from plan-h1, obtained", err)
+		}
+	}
+
+	// everything exists, nothing to do
+	if report, err = scheme.RecoverReport(); err != nil || len(report.Steps) != 0 {
+		t.Fatal("Expected nothing to build, obtained", err, "\n", report)
+	}
+}
+
+func TestProcessAdopt(t *testing.T) {
+	scheme := planScheme(t, "10.55.0.2/24", false)
+	if err := scheme.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	defer scheme.Release()
+
+	h1, _ := scheme.GetHost("plan-h1")
+
+	// arguments with commas and spaces
+	args := []string{"-c", "sleep 100; echo a,b", "x y"}
+
+	p, err := h1.RunProcess(append([]string{"sh"}, args...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.StartTime == 0 {
+		t.Fatal("Expected start time recorded")
+	}
+
+	// shell waits for sleep before it handles SIGINT
+	p.StopTimeout = "100ms"
+
+	// scheme is loaded by another program
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded Process
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := loaded.adopt(h1.Name, make(map[int]bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found == nil || found.Pid != p.Pid {
+		t.Fatal("Expected process", p.Pid, "adopted, obtained", found)
+	}
+
+	// the same command line, which started at other time, isn't ours
+	other := &Process{Command: "sh", Args: args, StartTime: p.StartTime + 1}
+	if found, err = other.adopt(h1.Name, make(map[int]bool)); err != nil || found != nil {
+		t.Fatal("Expected process with other start time not adopted, obtained", found, err)
+	}
+
+	// arguments are compared one by one, not joined
+	other = &Process{Command: "sh", Args: []string{"-c", "sleep 100; echo a,b x y"}}
+	if found, err = other.adopt(h1.Name, make(map[int]bool)); err != nil || found != nil {
+		t.Fatal("Expected process with other arguments not adopted, obtained", found, err)
+	}
+
+	// taken process isn't adopted twice, start time is recorded
+	taken := make(map[int]bool)
+	first, second := &Process{Command: "sh", Args: args}, &Process{Command: "sh", Args: args}
+
+	if found, err = first.adopt(h1.Name, taken); err != nil || found == nil || first.StartTime != p.StartTime {
+		t.Fatal("Expected process adopted with start time", p.StartTime, "obtained", found, first.StartTime, err)
+	}
+
+	if found, err = second.adopt(h1.Name, taken); err != nil || found != nil {
+		t.Fatal("Expected taken process not adopted again, obtained", found, err)
+	}
+}
+
+// dial connects from one host to the listener of another one
+func dial(from, to *Host, addr string) error {
+	var ln net.Listener
+
+	err := to.NetNs().Do(func() error {
+		var err error
+		ln, err = net.Listen("tcp", addr)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	return from.NetNs().Do(func() error {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	})
+}
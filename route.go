@@ -0,0 +1,90 @@
+package mn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	FamilyInet  = "inet"
+	FamilyInet6 = "inet6"
+)
+
+// Family returns "inet" or "inet6" by the gateway, or by the destination
+// if gateway isn't an address
+func (this Route) Family() string {
+	ip := net.ParseIP(this.Gw)
+	if ip == nil {
+		ip, _, _ = net.ParseCIDR(this.Dst)
+	}
+
+	if ip != nil && ip.To4() == nil {
+		return FamilyInet6
+	}
+
+	return FamilyInet
+}
+
+// MarshalJSON adds family to the route, e.g.
+//
+//	{"Dst": "fd00:1::/64", "Gw": "fd00::1", "Family": "inet6"}
+func (this Route) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Dst    string
+		Gw     string
+		Family string
+	}{this.Dst, this.Gw, this.Family()})
+}
+
+// UnmarshalJSON accepts route with or without family, "default" Dst
+// becomes the default route of the family. Family must agree with
+// addresses.
+func (this *Route) UnmarshalJSON(b []byte) error {
+	r := struct {
+		Dst    string
+		Gw     string
+		Family string
+	}{}
+
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	this.Dst = r.Dst
+	this.Gw = r.Gw
+
+	family := r.Family
+	if family == "" {
+		family = this.Family()
+	}
+
+	if family != FamilyInet && family != FamilyInet6 {
+		return errors.New(fmt.Sprintf("Unknown route family %s, expected %s or %s", family, FamilyInet, FamilyInet6))
+	}
+
+	if this.Dst == "default" {
+		this.Dst = "0.0.0.0/0"
+		if family == FamilyInet6 {
+			this.Dst = "::/0"
+		}
+	}
+
+	if this.Family() != family {
+		return errors.New(fmt.Sprintf("Route %s via %s isn't %s", this.Dst, this.Gw, family))
+	}
+
+	for _, addr := range []string{this.Gw, this.Dst} {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			ip, _, _ = net.ParseCIDR(addr)
+		}
+
+		if ip != nil && (ip.To4() == nil) != (family == FamilyInet6) {
+			return errors.New(fmt.Sprintf("Route %s via %s mixes address families", this.Dst, this.Gw))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package mn
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteJson(t *testing.T) {
+	data, err := json.Marshal([]Route{{"10.66.0.0/16", "10.55.0.1"}, {"fd00:66::/64", "fd00:55::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"Dst":"10.66.0.0/16","Gw":"10.55.0.1","Family":"inet"},` +
+		`{"Dst":"fd00:66::/64","Gw":"fd00:55::1","Family":"inet6"}]`
+
+	if string(data) != expected {
+		t.Fatal("Unexpected routes json:", string(data))
+	}
+
+	cases := map[string]Route{
+		`{"Dst":"10.66.0.0/16","Gw":"10.55.0.1"}`:                   {"10.66.0.0/16", "10.55.0.1"},
+		`{"Dst":"default","Gw":"10.55.0.1"}`:                        {"0.0.0.0/0", "10.55.0.1"},
+		`{"Dst":"default","Gw":"fd00:55::1"}`:                       {"::/0", "fd00:55::1"},
+		`{"Dst":"default","Gw":"fd00:55::1","Family":"inet6"}`:      {"::/0", "fd00:55::1"},
+		`{"Dst":"fd00:66::/64","Gw":"fd00:55::1","Family":"inet6"}`: {"fd00:66::/64", "fd00:55::1"},
+	}
+
+	for in, expected := range cases {
+		r := Route{}
+		if err := json.Unmarshal([]byte(in), &r); err != nil {
+			t.Fatal(in, err)
+		}
+
+		if r != expected {
+			t.Fatal("Expected", expected, "obtained", r, "from", in)
+		}
+	}
+
+	for _, in := range []string{
+		`{"Dst":"10.66.0.0/16","Gw":"10.55.0.1","Family":"inet6"}`,
+		`{"Dst":"fd00:66::/64","Gw":"10.55.0.1"}`,
+		`{"Dst":"default","Gw":"10.55.0.1","Family":"ipx"}`,
+	} {
+		r := Route{}
+		if err := json.Unmarshal([]byte(in), &r); err == nil {
+			t.Fatal("Expected error unmarshaling", in, "obtained", r)
+		}
+	}
+}
+
+// dualStack adds IPv6 address and route to the plan scheme hosts
+func dualStack(scheme *Scheme) {
+	for _, h := range scheme.Hosts {
+		l := &h.Links[0]
+		ip, _, _ := net.ParseCIDR(l.Cidr)
+
+		l.Addrs = []string{"fd00:55::" + strings.Split(ip.String(), ".")[3] + "/64"}
+		l.Ipv6 = &Ipv6{NoSlaac: true, NoDad: true}
+
+		if h.Name == "plan-h1" {
+			l.Routes = append(l.Routes, Route{Dst: "fd00:66::/64", Gw: "fd00:55::1"})
+		}
+	}
+}
+
+func TestSchemeIpv6(t *testing.T) {
+	defer tempStateFile(t)()
+
+	scheme := planScheme(t, "10.55.0.2/24", true)
+	dualStack(scheme)
+
+	defer scheme.Release()
+
+	plan, err := scheme.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scheme.Apply(plan, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if plan, err = scheme.Plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.Empty() {
+		t.Fatal("Expected no changes after apply, obtained:\n", plan)
+	}
+
+	ns, err := observeNs("plan-h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, r := range ns.Routes {
+		if r == (Route{"fd00:66::/64", "fd00:55::1"}) {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatal("Expected IPv6 route in plan-h1, obtained:", ns.Routes)
+	}
+
+	h1, _ := scheme.GetHost("plan-h1")
+	h2, _ := scheme.GetHost("plan-h2")
+
+	for knob, expected := range map[string]string{"accept_dad": "0", "autoconf": "0", "disable_ipv6": "0"} {
+		out, err := h1.RunCommand("cat", "/proc/sys/net/ipv6/conf/eth0/"+knob)
+		if err != nil {
+			t.Fatal(out, err)
+		}
+
+		if strings.TrimSpace(out) != expected {
+			t.Fatal("Expected", knob, "=", expected, "obtained", out)
+		}
+	}
+
+	var ln net.Listener
+
+	err = h2.NetNs().Do(func() error {
+		var err error
+		ln, err = net.Listen("tcp", "[fd00:55::3]:7777")
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Write([]byte("ok"))
+			conn.Close()
+		}
+	}()
+
+	reply := make([]byte, 2)
+
+	err = h1.NetNs().Do(func() error {
+		conn, err := net.DialTimeout("tcp", "[fd00:55::3]:7777", time.Second)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(reply)
+
+		return err
+	})
+
+	if err != nil || string(reply) != "ok" {
+		t.Fatal("Expected plan-h2 reachable over IPv6, obtained:", string(reply), err)
+	}
+}
@@ -6,12 +6,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+
+	"github.com/NodePrime/open-mininet/pool"
 )
 
 type Scheme struct {
 	Switches []*Switch
 	Hosts    []*Host
-	pairs    map[string]bool
+	Dns      *Dns
+	Pool     *pool.Pool
+	// KeepPartial leaves objects created by failed Recover for debugging,
+	// instead of rolling them back
+	KeepPartial bool `json:"-"`
 }
 
 func (this Scheme) String() string {
@@ -27,7 +33,9 @@ func NewScheme() *Scheme {
 	return &Scheme{
 		make([]*Switch, 0),
 		make([]*Host, 0),
-		make(map[string]bool),
+		nil,
+		pool.ThePool(),
+		false,
 	}
 }
 
@@ -38,15 +46,120 @@ func NewSchemeFromJson(fname string) (*Scheme, error) {
 	}
 
 	scheme := NewScheme()
+	scheme.Pool = nil
 
 	err = json.Unmarshal(data, scheme)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := scheme.adoptPool(scheme.Pool); err != nil {
+		return nil, err
+	}
+
+	if err := scheme.UpdateHosts(); err != nil {
+		return nil, err
+	}
+
+	return scheme, nil
+}
+
+// LoadScheme reads scheme from file without touching the system, unlike
+// NewSchemeFromJson it doesn't create anything. Result is a desired state
+// for Plan and Apply.
+func LoadScheme(fname string) (*Scheme, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseScheme(data)
+}
+
+func ParseScheme(data []byte) (*Scheme, error) {
+	type plainHost Host
+	type plainSwitch Switch
+	type plainCgroup Cgroup
+
+	type hostSpec struct {
+		plainHost
+		Cgroup *plainCgroup
+	}
+
+	spec := struct {
+		Switches []*plainSwitch
+		Hosts    []*hostSpec
+		Dns      *Dns
+		Pool     *pool.Pool
+	}{}
+
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+
+	scheme := NewScheme()
+
+	for _, s := range spec.Switches {
+		sw := Switch(*s)
+		if sw.Type == "" {
+			sw.Type = SwitchOVS
+		}
+
+		if _, err := sw.backend(); err != nil {
+			return nil, err
+		}
+
+		scheme.AddNode(&sw)
+	}
+
+	for _, h := range spec.Hosts {
+		host := Host(h.plainHost)
+		host.netns = &NetNs{name: host.Name}
+		host.Cgroup = (*Cgroup)(h.Cgroup)
+
+		scheme.AddNode(&host)
+	}
+
+	scheme.Dns = spec.Dns
+
+	if err := scheme.adoptPool(spec.Pool); err != nil {
+		return nil, err
+	}
+
 	return scheme, nil
 }
 
+// adoptPool merges pool loaded with the scheme into the default one and
+// reserves addresses of the scheme links there, so new links don't get
+// them again
+func (this *Scheme) adoptPool(loaded *pool.Pool) error {
+	this.Pool = pool.ThePool()
+
+	if err := this.Pool.Merge(loaded); err != nil {
+		return err
+	}
+
+	links := make(Links, 0)
+	for _, s := range this.Switches {
+		links = append(links, s.Ports...)
+	}
+	for _, h := range this.Hosts {
+		links = append(links, h.Links...)
+	}
+
+	for _, l := range links {
+		for _, cidr := range l.Cidrs() {
+			this.Pool.Reserve(cidr)
+		}
+
+		if l.HwAddr != "" {
+			this.Pool.ReserveMac(l.HwAddr)
+		}
+	}
+
+	return nil
+}
+
 func (this *Scheme) AddNode(n interface{}) *Scheme {
 	switch t := n.(type) {
 	case *Switch:
@@ -115,123 +228,46 @@ func (this Scheme) Export() string {
 	return this.String()
 }
 
-func (this Scheme) Recover() error {
-
-	for node := range this.Nodes() {
-		switch t := node.(type) {
-		case *Switch:
-			this.recoverSwitchPorts(node.(*Switch))
-		case *Host:
-			this.recoverHostLinks(node.(*Host))
-		default:
-			log.Println("Unexpected type", t)
-		}
+// ShapeLink changes traffic shaping of node interface on the fly.
+// nil Shaping removes it.
+func (this *Scheme) ShapeLink(nodeName, ifName string, shaping *Shaping) error {
+	var links Links
+
+	if h, found := this.GetHost(nodeName); found {
+		links = h.Links
+	} else if s, found := this.GetSwitch(nodeName); found {
+		links = s.Ports
+	} else {
+		return errors.New(fmt.Sprintf("Can't find node %s", nodeName))
 	}
 
-	for _, host := range this.Hosts {
-		if err := host.recoverProcs(); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// Recover switch to host connectivity
-func (this Scheme) recoverSwitchPorts(s *Switch) error {
-	for _, port := range s.Ports {
-		if port.Exists() {
-			continue
-		}
-
-		peer, found := this.GetNode(port.Peer.NodeName)
-		if !found {
-			return errors.New(fmt.Sprintf("Can't find host %s", port.Peer.NodeName))
-		}
-
-		link := peer.GetLinks().LinkByPeer(port.Peer)
-		pair := Pair{port, link}
-
-		hash := port.Name + "-" + link.Name
-		if this.pairs[hash] {
-			log.Println("Wrong scheme. Two identic pairs found:", pair, "Skipping.")
-			continue
-		}
-
-		// patch link
-		if s2, found := this.GetSwitch(peer.NodeName()); found {
-			s.AddPatchPort(pair.Left)
-			s2.AddPatchPort(pair.Right)
+	for i, link := range links {
+		if link.Name != ifName {
 			continue
 		}
 
-		if err := pair.Create(); err != nil {
-			return err
-		}
-
-		if err := s.AddLink(pair.Left); err != nil {
-			return err
-		}
-
-		_, err := pair.Up()
+		result, err := link.Shape(shaping)
 		if err != nil {
 			return err
 		}
 
-		this.pairs[hash] = true
+		links[i] = result
+
+		return nil
 	}
 
-	return nil
+	return errors.New(fmt.Sprintf("Node %s has no link %s", nodeName, ifName))
 }
 
-// Recover host to host connectivity  @todo
-func (this Scheme) recoverHostLinks(h *Host) error {
-	for _, left := range h.Links {
-		peer, found := this.GetHost(left.Peer.NodeName)
-		if !found {
-			continue
-		}
-
-		right := peer.Links.LinkByPeer(left.Peer)
-		if right.NodeName == "" {
-			// nothing found
-			// @todo-maybe return (Link, bool) form LinkByPeer
-			continue
-		}
-
-		pair := Pair{left, right}
-
-		hash := left.NodeName + left.Name + right.NodeName + right.Name
-		if this.pairs[hash] {
-			continue
-		}
-
-		if err := pair.Create(); err != nil {
-			return err
-		}
-
-		_, err := pair.Up()
-		if err != nil {
-			return err
-		}
-
-		h.AddLink(left)
-
-		h2, found := this.GetHost(right.NodeName)
-		if !found {
-			return errors.New(fmt.Sprintf("Can't find host node", right.NodeName))
-		}
-
-		h2.AddLink(right)
-
-		this.pairs[hash] = true
-	}
-
-	return nil
+// StopDns stops DNS responder of the scheme, if it's running, e.g. when
+// another scheme takes over
+func (this *Scheme) StopDns() {
+	this.Dns.stop()
 }
 
+// Release is Teardown, which logs errors
 func (this *Scheme) Release() {
-	for node := range this.Nodes() {
-		node.Release()
+	if err := this.Teardown(); err != nil {
+		log.Println(err)
 	}
 }
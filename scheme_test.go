@@ -1,8 +1,12 @@
 package mn
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
 	"time"
+
+	"github.com/NodePrime/open-mininet/pool"
 )
 
 const exampleScheme = "apps/example.json"
@@ -116,3 +120,45 @@ func TestSchemeApply(t *testing.T) {
 	// wait until ping ends
 	time.Sleep(time.Second * 5)
 }
+
+func TestSchemePool(t *testing.T) {
+	if err := pool.ThePool().AddSubnet("scheme-pool", "10.77.0.0/24"); err != nil {
+		t.Fatal(err)
+	}
+
+	cidr, err := pool.ThePool().Allocate("scheme-pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheme := NewScheme()
+	scheme.AddNode(&Host{Name: "pool-h1", Links: Links{{Name: "eth0", Cidr: "10.77.0.200/24", HwAddr: "08:00:27:77:00:01"}}})
+
+	data, err := json.Marshal(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// forget everything, as if scheme was loaded by another process
+	pool.ThePool().Release(cidr)
+	pool.ThePool().Release("10.77.0.200/24")
+	pool.ThePool().ReleaseMac("08:00:27:77:00:01")
+
+	if _, err := ParseScheme(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range []string{cidr, "10.77.0.200/24"} {
+		if err := pool.ThePool().Reserve(addr); !errors.Is(err, pool.ErrInUse) {
+			t.Fatal("Expected", addr, "reserved after load, obtained", err)
+		}
+	}
+
+	if err := pool.ThePool().ReserveMac("08:00:27:77:00:01"); !errors.Is(err, pool.ErrInUse) {
+		t.Fatal("Expected mac reserved after load, obtained", err)
+	}
+
+	if next, _ := pool.ThePool().Allocate("scheme-pool"); next == cidr {
+		t.Fatal("Expected no duplicate after load, obtained", next)
+	}
+}
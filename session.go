@@ -0,0 +1,159 @@
+package mn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// StopTimeout is how long teardown waits for processes to exit after
+// they are stopped
+var StopTimeout = 3 * time.Second
+
+// TeardownError lists failed removals and objects, which are still on the
+// machine after teardown
+type TeardownError struct {
+	Errors []error
+	Leaked []string
+}
+
+func (this *TeardownError) Error() string {
+	parts := make([]string, 0)
+	for _, err := range this.Errors {
+		parts = append(parts, err.Error())
+	}
+
+	if len(this.Leaked) > 0 {
+		parts = append(parts, "left: "+strings.Join(this.Leaked, ", "))
+	}
+
+	return "Teardown failed: " + strings.Join(parts, "; ")
+}
+
+func (this *TeardownError) Unwrap() []error {
+	return this.Errors
+}
+
+func (this *TeardownError) add(err error) {
+	if err != nil {
+		this.Errors = append(this.Errors, err)
+	}
+}
+
+// err returns nil, if nothing went wrong
+func (this *TeardownError) err() error {
+	if len(this.Errors) == 0 && len(this.Leaked) == 0 {
+		return nil
+	}
+
+	return this
+}
+
+// Teardown removes the scheme from the machine: processes are stopped,
+// then links, switches, namespaces and cgroups are removed. Every object
+// is tried, errors and objects left are returned as TeardownError.
+func (this *Scheme) Teardown() error {
+	result := &TeardownError{}
+
+	this.StopDns()
+
+	for _, h := range this.Hosts {
+		h.stopProcs(result)
+	}
+
+	for _, h := range this.Hosts {
+		h.releaseLinks(result)
+	}
+
+	for _, s := range this.Switches {
+		s.releasePorts(result)
+	}
+
+	for _, s := range this.Switches {
+		s.releaseBridge(result)
+	}
+
+	for _, h := range this.Hosts {
+		h.releaseNs(result)
+	}
+
+	for _, h := range this.Hosts {
+		h.releaseCgroup(result)
+		h.releaseFiles(result)
+	}
+
+	for _, s := range this.Switches {
+		result.Leaked = append(result.Leaked, s.leaked()...)
+	}
+
+	for _, h := range this.Hosts {
+		result.Leaked = append(result.Leaked, h.leaked()...)
+	}
+
+	return result.err()
+}
+
+// Session runs a scheme for the life of a program. The scheme is torn
+// down, when Run returns, also on SIGINT and SIGTERM, which skip deferred
+// calls otherwise.
+type Session struct {
+	Scheme *Scheme
+	// Signals interrupting the session, SIGINT and SIGTERM by default
+	Signals []os.Signal
+}
+
+func NewSession(scheme *Scheme) *Session {
+	return &Session{Scheme: scheme, Signals: []os.Signal{os.Interrupt, syscall.SIGTERM}}
+}
+
+// Run recovers the scheme and calls f. Context of f is done, when ctx is
+// done or a signal comes, nil f just waits for it. The scheme is torn
+// down after f returns or panics. The second signal isn't caught, so
+// stuck f could be interrupted the usual way. Errors of recover, f and
+// teardown are combined.
+func (this *Session) Run(ctx context.Context, f func(ctx context.Context) error) (err error) {
+	ctx, stop := signal.NotifyContext(ctx, this.Signals...)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	defer func() {
+		p := recover()
+
+		if terr := this.Scheme.Teardown(); terr != nil {
+			if err == nil {
+				err = terr
+			} else {
+				err = errors.New(fmt.Sprintf("%v, %v", err, terr))
+			}
+		}
+
+		if p != nil {
+			panic(p)
+		}
+	}()
+
+	if err := this.Scheme.Recover(); err != nil {
+		return err
+	}
+
+	if f == nil {
+		<-ctx.Done()
+		return nil
+	}
+
+	return f(ctx)
+}
+
+// Run is NewSession(scheme).Run(ctx, f)
+func (this *Scheme) Run(ctx context.Context, f func(ctx context.Context) error) error {
+	return NewSession(this).Run(ctx, f)
+}
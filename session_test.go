@@ -0,0 +1,110 @@
+package mn
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// gone checks, that nothing of the scheme is left
+func gone(t *testing.T, scheme *Scheme) {
+	for _, h := range scheme.Hosts {
+		if h.NetNs().Exists() {
+			t.Fatal("Expected netns", h.Name, "removed")
+		}
+	}
+
+	for _, s := range scheme.Switches {
+		if s.Exists() {
+			t.Fatal("Expected switch", s.Name, "removed")
+		}
+
+		for _, port := range s.Ports {
+			if port.Exists() {
+				t.Fatal("Expected link", port.Name, "removed")
+			}
+		}
+	}
+}
+
+func TestSessionRun(t *testing.T) {
+	scheme := planScheme(t, "10.55.0.2/24", true)
+	failed := errors.New("failed")
+
+	err := scheme.Run(context.Background(), func(ctx context.Context) error {
+		for _, h := range scheme.Hosts {
+			if !h.NetNs().Exists() {
+				t.Fatal("Expected netns", h.Name, "recovered")
+			}
+		}
+
+		return failed
+	})
+
+	if err != failed {
+		t.Fatal("Expected error of the function, obtained", err)
+	}
+
+	gone(t, scheme)
+
+	// signal interrupts the session, nothing is left
+	scheme = planScheme(t, "10.55.0.2/24", true)
+	h1, _ := scheme.GetHost("plan-h1")
+
+	go func() {
+		for !h1.NetNs().Exists() {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}()
+
+	done := make(chan error)
+	go func() {
+		done <- scheme.Run(context.Background(), nil)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected session interrupted by SIGINT")
+	}
+
+	gone(t, scheme)
+}
+
+func TestTeardownKills(t *testing.T) {
+	defer func(timeout time.Duration) { StopTimeout = timeout }(StopTimeout)
+	StopTimeout = 100 * time.Millisecond
+
+	scheme := planScheme(t, "10.55.0.2/24", true)
+	if err := scheme.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	h1, _ := scheme.GetHost("plan-h1")
+
+	// shell ignores SIGINT, so it's killed after StopTimeout
+	p, err := h1.RunProcess("sh", "-c", `trap "" INT; while true; do sleep 0.05; done`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := scheme.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exit := p.Status().Exit; exit == nil || exit.Signal != "killed" {
+		t.Fatal("Expected process killed, obtained", exit)
+	}
+
+	gone(t, scheme)
+}
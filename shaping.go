@@ -0,0 +1,274 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shaping describes egress traffic conditions of a link, like mininet's
+// TCLink does. Rate is enforced with tbf, everything else with netem.
+// Rates and sizes are in tc(8) notation, e.g. "10mbit", "32kb",
+// delays are "100ms", "1.5s", etc. Loss, Duplicate, Corrupt and Reorder
+// are percents.
+type Shaping struct {
+	Rate      string
+	Burst     string
+	Delay     string
+	Jitter    string
+	Loss      float64
+	Duplicate float64
+	Corrupt   float64
+	Reorder   float64
+	Limit     int
+}
+
+const (
+	defaultBurst    = "32kbit"
+	defaultLatency  = 50 * time.Millisecond
+	defaultQueueLen = 1000
+)
+
+// Validate checks all values could be parsed
+func (this Shaping) Validate() error {
+	if this.Rate != "" {
+		if _, err := parseRate(this.Rate); err != nil {
+			return err
+		}
+	}
+
+	if this.Burst != "" {
+		if _, err := parseSize(this.Burst); err != nil {
+			return err
+		}
+	}
+
+	if this.Delay != "" {
+		if _, err := time.ParseDuration(this.Delay); err != nil {
+			return err
+		}
+	}
+
+	if this.Jitter != "" {
+		if _, err := time.ParseDuration(this.Jitter); err != nil {
+			return err
+		}
+
+		if this.Delay == "" {
+			return errors.New("Jitter requires Delay")
+		}
+	}
+
+	for _, p := range []float64{this.Loss, this.Duplicate, this.Corrupt, this.Reorder} {
+		if p < 0 || p > 100 {
+			return errors.New(fmt.Sprintf("Wrong percentage value: %v", p))
+		}
+	}
+
+	if this.Reorder > 0 && this.Delay == "" {
+		return errors.New("Reorder requires Delay")
+	}
+
+	if this.Limit < 0 {
+		return errors.New(fmt.Sprintf("Wrong queue limit: %d", this.Limit))
+	}
+
+	return nil
+}
+
+func (this Shaping) hasNetem() bool {
+	return this.Delay != "" || this.Loss > 0 || this.Duplicate > 0 ||
+		this.Corrupt > 0 || this.Reorder > 0 || this.Limit > 0
+}
+
+func (this Shaping) burst() string {
+	if this.Burst == "" {
+		return defaultBurst
+	}
+
+	return this.Burst
+}
+
+func (this Shaping) limit() int {
+	if this.Limit == 0 {
+		return defaultQueueLen
+	}
+
+	return this.Limit
+}
+
+// tbfArgs returns tc arguments for root token bucket qdisc
+func (this Shaping) tbfArgs() []string {
+	return []string{"tbf", "rate", this.Rate, "burst", this.burst(), "latency", defaultLatency.String()}
+}
+
+// netemArgs returns tc arguments for netem qdisc
+func (this Shaping) netemArgs() []string {
+	result := []string{"netem"}
+
+	if this.Delay != "" {
+		result = append(result, "delay", this.Delay)
+		if this.Jitter != "" {
+			result = append(result, this.Jitter)
+		}
+	}
+
+	if this.Loss > 0 {
+		result = append(result, "loss", percent(this.Loss))
+	}
+
+	if this.Duplicate > 0 {
+		result = append(result, "duplicate", percent(this.Duplicate))
+	}
+
+	if this.Corrupt > 0 {
+		result = append(result, "corrupt", percent(this.Corrupt))
+	}
+
+	if this.Reorder > 0 {
+		result = append(result, "reorder", percent(this.Reorder))
+	}
+
+	return append(result, "limit", strconv.Itoa(this.limit()))
+}
+
+// String returns tc arguments of the qdiscs
+func (this Shaping) String() string {
+	result := make([]string, 0)
+	if this.Rate != "" {
+		result = append(result, this.tbfArgs()...)
+	}
+	if this.hasNetem() {
+		result = append(result, this.netemArgs()...)
+	}
+
+	return strings.Join(result, " ")
+}
+
+// matches tells live shaping read from the kernel is this one. Kernel keeps
+// times in ticks and the bucket as time to send it, so they are compared
+// with a tolerance.
+func (this Shaping) matches(live Shaping) bool {
+	if (this.Rate != "") != (live.Rate != "") || this.hasNetem() != live.hasNetem() {
+		return false
+	}
+
+	if this.Rate != "" {
+		want, err1 := parseRate(this.Rate)
+		have, err2 := parseRate(live.Rate)
+		if err1 != nil || err2 != nil || want/8 != have/8 {
+			return false
+		}
+
+		wantBurst, err1 := parseSize(this.burst())
+		haveBurst, err2 := parseSize(live.burst())
+		if err1 != nil || err2 != nil || math.Abs(float64(wantBurst)-float64(haveBurst)) > float64(wantBurst)/100+1 {
+			return false
+		}
+	}
+
+	if !this.hasNetem() {
+		return true
+	}
+
+	near := func(want, have string) bool {
+		if want == "" || have == "" {
+			return want == have
+		}
+
+		w, err1 := time.ParseDuration(want)
+		h, err2 := time.ParseDuration(have)
+
+		return err1 == nil && err2 == nil && w-h <= time.Microsecond && h-w <= time.Microsecond
+	}
+
+	want := []float64{this.Loss, this.Duplicate, this.Corrupt, this.Reorder}
+	have := []float64{live.Loss, live.Duplicate, live.Corrupt, live.Reorder}
+	for i := range want {
+		if math.Abs(want[i]-have[i]) > 0.01 {
+			return false
+		}
+	}
+
+	return near(this.Delay, live.Delay) && near(this.Jitter, live.Jitter) && this.limit() == live.limit()
+}
+
+func percent(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64) + "%"
+}
+
+var rateUnits = map[string]float64{
+	"bit":  1,
+	"kbit": 1e3,
+	"mbit": 1e6,
+	"gbit": 1e9,
+	"tbit": 1e12,
+	"bps":  8,
+	"kbps": 8e3,
+	"mbps": 8e6,
+	"gbps": 8e9,
+	"tbps": 8e12,
+}
+
+// parseRate returns rate in bits per second
+func parseRate(s string) (uint64, error) {
+	value, unit := splitUnit(s)
+
+	multiplier, found := rateUnits[unit]
+	if unit == "" {
+		multiplier, found = 8, true // tc treats bare number as bytes per second
+	}
+
+	if !found || value <= 0 {
+		return 0, errors.New(fmt.Sprintf("Wrong rate: %s", s))
+	}
+
+	return uint64(value * multiplier), nil
+}
+
+var sizeUnits = map[string]float64{
+	"":     1,
+	"b":    1,
+	"k":    1024,
+	"kb":   1024,
+	"m":    1024 * 1024,
+	"mb":   1024 * 1024,
+	"g":    1024 * 1024 * 1024,
+	"gb":   1024 * 1024 * 1024,
+	"kbit": 1024 / 8,
+	"mbit": 1024 * 1024 / 8,
+	"gbit": 1024 * 1024 * 1024 / 8,
+}
+
+// parseSize returns size in bytes
+func parseSize(s string) (uint32, error) {
+	value, unit := splitUnit(s)
+
+	multiplier, found := sizeUnits[unit]
+	if !found || value <= 0 {
+		return 0, errors.New(fmt.Sprintf("Wrong size: %s", s))
+	}
+
+	return uint32(value * multiplier), nil
+}
+
+func splitUnit(s string) (float64, string) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return -1, ""
+	}
+
+	return value, s[i:]
+}
@@ -0,0 +1,247 @@
+package mn
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/NodePrime/open-mininet/pool"
+	"github.com/vishvananda/netlink"
+)
+
+func TestShapingParse(t *testing.T) {
+	rates := map[string]uint64{
+		"10mbit":  10000000,
+		"1.5kbit": 1500,
+		"1mbps":   8000000,
+		"100":     800,
+	}
+
+	for s, expected := range rates {
+		v, err := parseRate(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != expected {
+			t.Fatal("Expected rate", s, "=", expected, "obtained:", v)
+		}
+	}
+
+	sizes := map[string]uint32{
+		"32kbit": 4096,
+		"1kb":    1024,
+		"1500":   1500,
+	}
+
+	for s, expected := range sizes {
+		v, err := parseSize(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != expected {
+			t.Fatal("Expected size", s, "=", expected, "obtained:", v)
+		}
+	}
+
+	for _, s := range []string{"", "fast", "10parsecs", "-1mbit"} {
+		if _, err := parseRate(s); err == nil {
+			t.Fatal("Expected error for rate", s)
+		}
+	}
+}
+
+func TestShapingValidate(t *testing.T) {
+	good := []Shaping{
+		{Rate: "10mbit"},
+		{Delay: "100ms", Jitter: "10ms", Loss: 1.5},
+		{Delay: "10ms", Reorder: 25},
+	}
+
+	for _, s := range good {
+		if err := s.Validate(); err != nil {
+			t.Fatal(s, err)
+		}
+	}
+
+	bad := []Shaping{
+		{Rate: "fast"},
+		{Delay: "100"},
+		{Jitter: "10ms"},
+		{Loss: 101},
+		{Reorder: 10},
+		{Limit: -1},
+	}
+
+	for _, s := range bad {
+		if err := s.Validate(); err == nil {
+			t.Fatal("Expected error for", s)
+		}
+	}
+}
+
+func TestShapingArgs(t *testing.T) {
+	s := Shaping{Rate: "1mbit", Delay: "100ms", Jitter: "10ms", Loss: 0.5, Limit: 50}
+
+	expected := []string{"netem", "delay", "100ms", "10ms", "loss", "0.5%", "limit", "50"}
+	if args := s.netemArgs(); !reflect.DeepEqual(args, expected) {
+		t.Fatal("\nExpected:", expected, "\nObtained:", args)
+	}
+
+	expected = []string{"tbf", "rate", "1mbit", "burst", "32kbit", "latency", "50ms"}
+	if args := s.tbfArgs(); !reflect.DeepEqual(args, expected) {
+		t.Fatal("\nExpected:", expected, "\nObtained:", args)
+	}
+}
+
+func TestShapingMatches(t *testing.T) {
+	s := Shaping{Rate: "10mbit", Delay: "20ms", Loss: 1}
+
+	// qdiscs the way NetlinkBackend adds them
+	tbf := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{Handle: netlink.MakeHandle(1, 0), Parent: netlink.HANDLE_ROOT},
+		Rate:       10e6 / 8,
+		Buffer:     netlink.Xmittime(10e6/8, 4096),
+	}
+	netem := netlink.NewNetem(netlink.QdiscAttrs{Handle: netlink.MakeHandle(10, 0), Parent: netlink.MakeHandle(1, 1)},
+		netlink.NetemQdiscAttrs{Latency: 20000, Loss: 1, Limit: 1000})
+
+	live := *observeShaping(tbf, []netlink.Qdisc{tbf, netem})
+	if !s.matches(live) {
+		t.Fatal("Expected", s, "matches", live)
+	}
+
+	for _, edited := range []Shaping{
+		{Rate: "10mbit", Delay: "10ms", Loss: 1},
+		{Rate: "20mbit", Delay: "20ms", Loss: 1},
+		{Rate: "10mbit", Delay: "20ms", Loss: 2},
+		{Rate: "10mbit", Delay: "20ms", Loss: 1, Limit: 100},
+		{Delay: "20ms", Loss: 1},
+	} {
+		if edited.matches(live) {
+			t.Fatal("Expected", edited, "doesn't match", live)
+		}
+	}
+}
+
+func TestLinkShaping(t *testing.T) {
+	pool.ThePool("192.168.55.1/24")
+
+	defer SetLinkBackend(GetLinkBackend())
+
+	for _, backend := range []LinkBackend{NetlinkBackend{}, ExecBackend{}} {
+		SetLinkBackend(backend)
+
+		h1, err := NewHost()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h1.Release()
+
+		h2, err := NewHost()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h2.Release()
+
+		shaping := &Shaping{Rate: "10mbit", Delay: "20ms", Loss: 1}
+
+		p := NewLink(h1, h2, Link{Shaping: shaping})
+
+		if err := p.Create(); err != nil {
+			t.Fatal(err)
+		}
+
+		if p, err = p.Up(); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := RunCommand("ip", "netns", "exec", h1.NodeName(), "tc", "qdisc", "show", "dev", p.Left.Name)
+		if err != nil {
+			t.Fatal(err, out)
+		}
+
+		if !strings.Contains(out, "tbf") || !strings.Contains(out, "netem") || !strings.Contains(out, "delay 20ms") {
+			t.Fatal("Expected tbf and netem qdiscs, obtained:", out)
+		}
+
+		left, err := p.Left.Shape(&Shaping{Delay: "5ms"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, _ = RunCommand("ip", "netns", "exec", h1.NodeName(), "tc", "qdisc", "show", "dev", left.Name)
+		if strings.Contains(out, "tbf") || !strings.Contains(out, "delay 5ms") {
+			t.Fatal("Expected only netem with delay 5ms, obtained:", out)
+		}
+
+		if _, err := left.Shape(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		out, _ = RunCommand("ip", "netns", "exec", h1.NodeName(), "tc", "qdisc", "show", "dev", left.Name)
+		if strings.Contains(out, "netem") {
+			t.Fatal("Expected no netem qdisc, obtained:", out)
+		}
+	}
+}
+
+func TestLinkShapingBurst(t *testing.T) {
+	pool.ThePool("192.168.55.1/24")
+
+	defer SetLinkBackend(GetLinkBackend())
+	SetLinkBackend(NetlinkBackend{})
+
+	h1, err := NewHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Release()
+
+	h2, err := NewHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Release()
+
+	// at 1mbit the bucket is a few ticks only, it must still hold 32kbit
+	p := NewLink(h1, h2, Link{Shaping: &Shaping{Rate: "1mbit", Burst: "32kbit"}})
+
+	if err := p.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err = p.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := nlHandle(p.Left.NetNs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Delete()
+
+	link, err := h.LinkByName(p.Left.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qdiscs, err := h.QdiscList(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range qdiscs {
+		if tbf, ok := q.(*netlink.Tbf); ok {
+			burst := netlink.Xmitsize(tbf.Rate, tbf.Buffer)
+			if burst < 4000 || burst > 4200 {
+				t.Fatal("Expected burst of 4096 bytes, obtained:", burst)
+			}
+
+			return
+		}
+	}
+
+	t.Fatal("Expected tbf qdisc, obtained:", qdiscs)
+}
@@ -0,0 +1,417 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Restart policies of host processes
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// Types of process events
+const (
+	EventStart   = "start"
+	EventExit    = "exit"
+	EventRestart = "restart"
+)
+
+const (
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// RestartPolicy tells supervisor what to do, when process exits. Backoff
+// is the delay before restart, it doubles for every restart of a process,
+// which keeps failing, up to MaxBackoff. Process running longer than
+// MaxBackoff starts over with Backoff. MaxRestarts 0 means no limit.
+type RestartPolicy struct {
+	Policy      string
+	Backoff     string `json:",omitempty"`
+	MaxBackoff  string `json:",omitempty"`
+	MaxRestarts int    `json:",omitempty"`
+}
+
+func (this *RestartPolicy) Validate() error {
+	if this == nil {
+		return nil
+	}
+
+	switch this.Policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return errors.New(fmt.Sprintf("Unknown restart policy %s", this.Policy))
+	}
+
+	for _, d := range []string{this.Backoff, this.MaxBackoff} {
+		if d == "" {
+			continue
+		}
+
+		if _, err := time.ParseDuration(d); err != nil {
+			return errors.New(fmt.Sprintf("Wrong restart backoff %s: %v", d, err))
+		}
+	}
+
+	return nil
+}
+
+// restart decides, if process with given exit status and restarts count
+// should be started again
+func (this *RestartPolicy) restart(exit *ExitStatus, restarts int) bool {
+	if this == nil || (this.MaxRestarts > 0 && restarts >= this.MaxRestarts) {
+		return false
+	}
+
+	switch this.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return !exit.Success()
+	}
+
+	return false
+}
+
+func (this *RestartPolicy) backoff() time.Duration {
+	return parseDuration(this.Backoff, defaultBackoff)
+}
+
+func (this *RestartPolicy) maxBackoff() time.Duration {
+	return parseDuration(this.MaxBackoff, defaultMaxBackoff)
+}
+
+// delay before restart after given number of failures in a row
+func (this *RestartPolicy) delay(failures int) time.Duration {
+	result := this.backoff()
+
+	for i := 0; i < failures && result < this.maxBackoff(); i++ {
+		result *= 2
+	}
+
+	if result > this.maxBackoff() {
+		result = this.maxBackoff()
+	}
+
+	return result
+}
+
+func parseDuration(s string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d
+	}
+
+	return def
+}
+
+// ExitStatus of finished process. Code is -1, if it was killed by Signal
+// or couldn't be waited for.
+type ExitStatus struct {
+	Code   int
+	Signal string `json:",omitempty"`
+	Time   time.Time
+}
+
+func (this ExitStatus) Success() bool {
+	return this.Code == 0 && this.Signal == ""
+}
+
+func (this ExitStatus) String() string {
+	if this.Signal != "" {
+		return "signal: " + this.Signal
+	}
+
+	return fmt.Sprintf("exit code %d", this.Code)
+}
+
+func exitStatus(state *os.ProcessState, err error) *ExitStatus {
+	result := &ExitStatus{Code: -1, Time: time.Now()}
+
+	if err != nil {
+		log.Println("Unable to wait for process:", err)
+		return result
+	}
+
+	result.Code = state.ExitCode()
+
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		result.Signal = ws.Signal().String()
+	}
+
+	return result
+}
+
+// ProcessEvent is sent by supervisor, when process starts, exits and is
+// restarted. Exit is set for exit events.
+type ProcessEvent struct {
+	Type    string
+	Host    string
+	Pid     int
+	Command string
+	Exit    *ExitStatus
+	Time    time.Time
+}
+
+// Supervisor starts processes of a host, records their exit status and
+// restarts them according to their RestartPolicy. Processes found running
+// by recovery aren't children, so they aren't supervised.
+type Supervisor struct {
+	host *Host
+
+	sync.Mutex
+	subscribers []chan ProcessEvent
+}
+
+var supervisorMu sync.Mutex
+
+// Supervisor of the host, it's created with the first process
+func (this *Host) Supervisor() *Supervisor {
+	supervisorMu.Lock()
+	defer supervisorMu.Unlock()
+
+	if this.supervisor == nil {
+		this.supervisor = &Supervisor{host: this}
+	}
+
+	return this.supervisor
+}
+
+// Subscribe returns channel of process events. Events are dropped, if the
+// channel is full, reader shouldn't fall behind.
+func (this *Supervisor) Subscribe() <-chan ProcessEvent {
+	this.Lock()
+	defer this.Unlock()
+
+	ch := make(chan ProcessEvent, 64)
+	this.subscribers = append(this.subscribers, ch)
+
+	return ch
+}
+
+// Unsubscribe closes channel returned by Subscribe
+func (this *Supervisor) Unsubscribe(ch <-chan ProcessEvent) {
+	this.Lock()
+	defer this.Unlock()
+
+	for i, sub := range this.subscribers {
+		if sub == ch {
+			this.subscribers = append(this.subscribers[:i], this.subscribers[i+1:]...)
+			close(sub)
+			return
+		}
+	}
+}
+
+func (this *Supervisor) emit(typ string, p *Process, pid int, exit *ExitStatus) {
+	e := ProcessEvent{Type: typ, Host: this.host.Name, Pid: pid, Command: p.Command, Exit: exit, Time: time.Now()}
+
+	this.Lock()
+	defer this.Unlock()
+
+	for _, sub := range this.subscribers {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+}
+
+// start runs the process and supervises it
+func (this *Supervisor) start(p *Process) error {
+	if err := p.Restart.Validate(); err != nil {
+		return err
+	}
+
+	if p.state == nil {
+		p.state = &procState{stop: make(chan struct{})}
+	}
+
+	process, err := this.spawn(p)
+	if err != nil {
+		return err
+	}
+
+	this.emit(EventStart, p, process.Pid, nil)
+
+	go this.watch(p, process)
+
+	return nil
+}
+
+// spawn starts the process once, output of all runs is appended to the
+// same file
+func (this *Supervisor) spawn(p *Process) (*os.Process, error) {
+	command, err := exec.LookPath(p.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]string{p.Command}, p.Args...)
+
+	stdout, stderr, captured, err := this.host.openOutput(p)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to capture output of %s: %v", p.Command, err))
+	}
+
+	// capture goroutines finish, when the process and its children close
+	// the pipes
+	defer stdout.Close()
+	defer stderr.Close()
+
+	attr := os.ProcAttr{Sys: &syscall.SysProcAttr{}, Files: []*os.File{nil, stdout, stderr}}
+
+	var process *os.Process
+
+	err = this.host.spawn(attr.Sys, func() error {
+		var err error
+		process, err = os.StartProcess(command, args, &attr)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.state.started(p, process, captured)
+
+	fmt.Println("Started", args, "in", this.host.Name, "output goes to", p.Stdout, p.Stderr)
+
+	return process, nil
+}
+
+// watch waits for the process and restarts it, until the policy says no
+// or the process is stopped
+func (this *Supervisor) watch(p *Process, process *os.Process) {
+	failures := 0
+
+	for {
+		started := time.Now()
+		pid := process.Pid
+
+		exit := exitStatus(process.Wait())
+		p.state.drain()
+		stopping := p.state.exited(p, exit)
+
+		log.Printf("Process [%d] %s %v finished with %v", pid, p.Command, p.Args, exit)
+		this.emit(EventExit, p, pid, exit)
+
+		for {
+			if stopping || !p.Restart.restart(exit, p.Status().Restarts) {
+				return
+			}
+
+			if time.Since(started) > p.Restart.maxBackoff() {
+				failures = 0
+			}
+
+			select {
+			case <-time.After(p.Restart.delay(failures)):
+			case <-p.state.stop:
+				return
+			}
+
+			failures++
+			p.state.restarted(p)
+
+			var err error
+			if process, err = this.spawn(p); err == nil {
+				break
+			}
+
+			log.Println("Unable to restart", p.Command, p.Args, "in", this.host.Name, err)
+			exit = &ExitStatus{Code: -1, Time: time.Now()}
+			started = time.Now()
+		}
+
+		this.emit(EventRestart, p, process.Pid, nil)
+	}
+}
+
+// procState is shared by copies of supervised Process
+type procState struct {
+	sync.Mutex
+	stopping bool
+	// stop is closed by Stop, it interrupts restart backoff
+	stop chan struct{}
+	// process is the current run, done is closed, when it exits
+	process *os.Process
+	done    chan struct{}
+	// captured is closed, when output of the current run is written
+	captured chan struct{}
+}
+
+func (this *procState) started(p *Process, process *os.Process, captured chan struct{}) {
+	this.Lock()
+	defer this.Unlock()
+
+	p.Process = process
+	p.StartTime, _ = procStartTime(process.Pid)
+	this.process = process
+	this.captured = captured
+	this.done = make(chan struct{})
+
+	// stopped while restarting
+	if this.stopping {
+		process.Kill()
+	}
+}
+
+// restarted counts restart of the process
+func (this *procState) restarted(p *Process) {
+	this.Lock()
+	defer this.Unlock()
+
+	p.Restarts++
+}
+
+// drain waits for the output of exited process
+func (this *procState) drain() {
+	this.Lock()
+	captured := this.captured
+	this.Unlock()
+
+	select {
+	case <-captured:
+	case <-time.After(outputDrain):
+	}
+}
+
+// exited records exit status and tells, if process is being stopped.
+// Process keeps the finished run, so its pid is still known.
+func (this *procState) exited(p *Process, exit *ExitStatus) bool {
+	this.Lock()
+	defer this.Unlock()
+
+	p.Exit = exit
+	this.process = nil
+	close(this.done)
+
+	return this.stopping
+}
+
+// halt prevents restarts, returns the current run and channel closed on
+// its exit, nil if nothing runs
+func (this *procState) halt() (*os.Process, chan struct{}) {
+	this.Lock()
+	defer this.Unlock()
+
+	if !this.stopping {
+		this.stopping = true
+		close(this.stop)
+	}
+
+	if this.process == nil {
+		return nil, nil
+	}
+
+	return this.process, this.done
+}
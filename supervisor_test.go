@@ -0,0 +1,154 @@
+package mn
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRestartPolicy(t *testing.T) {
+	policy := &RestartPolicy{Policy: RestartOnFailure, Backoff: "1s", MaxBackoff: "5s", MaxRestarts: 3}
+
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for failures, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := policy.delay(failures); d != expected {
+			t.Fatal("Expected delay", expected, "after", failures, "failures, obtained", d)
+		}
+	}
+
+	failed := &ExitStatus{Code: 1}
+	killed := &ExitStatus{Code: -1, Signal: "killed"}
+	ok := &ExitStatus{}
+
+	if !policy.restart(failed, 0) || !policy.restart(killed, 2) {
+		t.Fatal("Expected failed process restarted")
+	}
+
+	if policy.restart(ok, 0) || policy.restart(failed, 3) {
+		t.Fatal("Expected process not restarted after success or MaxRestarts")
+	}
+
+	if !(&RestartPolicy{Policy: RestartAlways}).restart(ok, 100) {
+		t.Fatal("Expected process always restarted")
+	}
+
+	if (&RestartPolicy{}).restart(failed, 0) || (*RestartPolicy)(nil).restart(failed, 0) {
+		t.Fatal("Expected process never restarted by default")
+	}
+
+	for _, wrong := range []*RestartPolicy{{Policy: "sometimes"}, {Policy: RestartAlways, Backoff: "1"}} {
+		if wrong.Validate() == nil {
+			t.Fatal("Expected error for", *wrong)
+		}
+	}
+}
+
+// events reads n events or fails after timeout
+func events(t *testing.T, ch <-chan ProcessEvent, n int) []ProcessEvent {
+	result := make([]ProcessEvent, 0)
+
+	for len(result) < n {
+		select {
+		case e := <-ch:
+			result = append(result, e)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected", n, "events, obtained", result)
+		}
+	}
+
+	return result
+}
+
+func TestSupervisor(t *testing.T) {
+	scheme := planScheme(t, "10.55.0.2/24", false)
+	if err := scheme.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	defer scheme.Release()
+
+	h1, _ := scheme.GetHost("plan-h1")
+
+	ch := h1.Supervisor().Subscribe()
+	defer h1.Supervisor().Unsubscribe(ch)
+
+	p := &Process{
+		Command: "sh",
+		Args:    []string{"-c", "exit 3"},
+		Restart: &RestartPolicy{Policy: RestartOnFailure, Backoff: "10ms", MaxRestarts: 2},
+	}
+	h1.Procs = append(h1.Procs, p)
+
+	if err := h1.Supervisor().start(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// mn-ctl reads status and exports scheme, while process restarts
+	done := make(chan struct{})
+	defer close(done)
+
+	go func(p *Process) {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			p.Status()
+			json.Marshal(Procs{p})
+			time.Sleep(time.Millisecond)
+		}
+	}(p)
+
+	types := make([]string, 0)
+	for _, e := range events(t, ch, 6) {
+		types = append(types, e.Type)
+
+		if e.Type == EventExit && (e.Exit == nil || e.Exit.Code != 3) {
+			t.Fatal("Expected exit code 3, obtained", e.Exit)
+		}
+	}
+
+	expected := []string{EventStart, EventExit, EventRestart, EventExit, EventRestart, EventExit}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Fatal("Expected events", expected, "obtained", types)
+		}
+	}
+
+	if st := p.Status(); st.Restarts != 2 || st.Exit.Code != 3 {
+		t.Fatal("Expected 2 restarts and exit code 3, obtained", st.Restarts, st.Exit)
+	}
+
+	// stopped process isn't restarted even by always policy
+	p = &Process{
+		Command:     "sleep",
+		Args:        []string{"100"},
+		Restart:     &RestartPolicy{Policy: RestartAlways, Backoff: "10ms"},
+		StopTimeout: "1s",
+	}
+	h1.Procs = append(h1.Procs, p)
+
+	if err := h1.Supervisor().start(p); err != nil {
+		t.Fatal(err)
+	}
+
+	events(t, ch, 1)
+
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := events(t, ch, 1)[0]; e.Type != EventExit || e.Exit.Signal != "interrupt" {
+		t.Fatal("Expected process interrupted, obtained", e)
+	}
+
+	select {
+	case e := <-ch:
+		t.Fatal("Expected stopped process not restarted, obtained", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -7,10 +7,24 @@ import (
 	"log"
 )
 
+// OpenFlow versions of switch Protocols
+const (
+	OpenFlow10 = "OpenFlow10"
+	OpenFlow11 = "OpenFlow11"
+	OpenFlow12 = "OpenFlow12"
+	OpenFlow13 = "OpenFlow13"
+	OpenFlow14 = "OpenFlow14"
+	OpenFlow15 = "OpenFlow15"
+)
+
 type Switch struct {
 	Name       string
+	Type       string
 	Ports      Links
 	Controller string
+	// Protocols are OpenFlow versions the switch speaks to controller,
+	// e.g. ["OpenFlow13"], switch default if empty
+	Protocols []string `json:",omitempty"`
 }
 
 func (this Switch) String() string {
@@ -23,11 +37,21 @@ func (this Switch) String() string {
 }
 
 func NewSwitch(name ...string) (*Switch, error) {
+	return NewSwitchOf(SwitchOVS, name...)
+}
+
+// NewSwitchOf creates switch of given type, e.g. SwitchLinux
+func NewSwitchOf(typ string, name ...string) (*Switch, error) {
 	this := &Switch{
 		Name:  "",
+		Type:  typ,
 		Ports: make(Links, 0),
 	}
 
+	if _, err := this.backend(); err != nil {
+		return nil, err
+	}
+
 	if len(name) == 0 || name[0] == "" {
 		this.Name = switchname()
 	} else {
@@ -54,34 +78,59 @@ func (this *Switch) UnmarshalJSON(b []byte) error {
 	}
 
 	this.Name = s.Name
+	this.Type = s.Type
 	this.Ports = s.Ports
+	this.Protocols = s.Protocols
+
+	if this.Type == "" {
+		this.Type = SwitchOVS
+	}
+
+	if _, err := this.backend(); err != nil {
+		return err
+	}
+
 	if !this.Exists() {
 		if err := this.Create(); err != nil {
 			return err
 		}
 	}
 
-	if s.Controller != "" {
-		if err := this.SetController(s.Controller); err != nil {
-			return err
-		}
-	}
+	return this.configure(s.Controller)
+}
 
-	return nil
+func (this Switch) backend() (SwitchBackend, error) {
+	return switchBackend(this.Type)
 }
 
 func (this *Switch) Create() error {
-	out, err := RunCommand("ovs-vsctl", "add-br", this.Name)
+	b, err := this.backend()
 	if err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+		return err
 	}
 
-	return nil
+	return b.Create(this.Name)
 }
 
 func (this *Switch) Exists() bool {
-	_, err := RunCommand("ovs-vsctl", "br-exists", this.Name)
-	return err == nil
+	b, err := this.backend()
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	return b.Exists(this.Name)
+}
+
+// SupportsPatch reports whether switch can be connected to another one
+// with a patch port.
+func (this *Switch) SupportsPatch() bool {
+	b, err := this.backend()
+	if err != nil {
+		return false
+	}
+
+	return b.SupportsPatch()
 }
 
 func (this *Switch) AddLink(l Link) error {
@@ -92,10 +141,29 @@ func (this *Switch) AddLink(l Link) error {
 	return this.AddPort(l)
 }
 
+// attach plugs already known port into the switch, without adding it
+// to the Ports list
+func (this *Switch) attach(l Link) error {
+	b, err := this.backend()
+	if err != nil {
+		return err
+	}
+
+	if l.patch {
+		return b.AddPatchPort(this.Name, l)
+	}
+
+	return b.AddPort(this.Name, l)
+}
+
 func (this *Switch) AddPort(l Link) error {
-	out, err := RunCommand("ovs-vsctl", "add-port", this.Name, l.Name)
+	b, err := this.backend()
 	if err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+		return err
+	}
+
+	if err := b.AddPort(this.Name, l); err != nil {
+		return err
 	}
 
 	this.Ports = append(this.Ports, l)
@@ -104,16 +172,13 @@ func (this *Switch) AddPort(l Link) error {
 }
 
 func (this *Switch) AddPatchPort(l Link) error {
-	if out, err := RunCommand("ovs-vsctl", "add-port", this.NodeName(), l.Name); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
-	}
-
-	if out, err := RunCommand("ovs-vsctl", "set", "interface", l.Name, "type=patch"); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+	b, err := this.backend()
+	if err != nil {
+		return err
 	}
 
-	if out, err := RunCommand("ovs-vsctl", "set", "interface", l.Name, "options:peer="+l.Peer.Name); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+	if err := b.AddPatchPort(this.NodeName(), l); err != nil {
+		return err
 	}
 
 	l = l.SetState("UP")
@@ -123,31 +188,166 @@ func (this *Switch) AddPatchPort(l Link) error {
 	return nil
 }
 
+// DelPort detaches port from the switch and forgets it
+func (this *Switch) DelPort(name string) error {
+	b, err := this.backend()
+	if err != nil {
+		return err
+	}
+
+	if err := b.DelPort(this.Name, name); err != nil {
+		return err
+	}
+
+	for i, port := range this.Ports {
+		if port.Name == name {
+			this.Ports = append(this.Ports[:i], this.Ports[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// HasPort checks, that switch has a port with given name attached
+func (this *Switch) HasPort(name string) bool {
+	b, err := this.backend()
+	if err != nil {
+		return false
+	}
+
+	ports, err := b.Ports(this.Name)
+	if err != nil {
+		return false
+	}
+
+	for _, port := range ports {
+		if port == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetController connects the switch to controller, e.g.
+// "tcp:127.0.0.1:6633". Protocols of the switch are set first, so it
+// talks to the controller in the right OpenFlow version.
 func (this *Switch) SetController(addr string) error {
-	if out, err := RunCommand("ovs-vsctl", "set-controller", this.NodeName(), addr); err != nil {
-		return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
+	b, err := this.backend()
+	if err != nil {
+		return err
+	}
+
+	if len(this.Protocols) > 0 {
+		if err := this.SetProtocols(this.Protocols...); err != nil {
+			return err
+		}
 	}
 
-	// if out, err := RunCommand("ovs-vsctl", "set", "bridge", this.NodeName(), "protocols=OpenFlow13"); err != nil {
-	// 	return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
-	// }
+	if err := b.SetController(this.NodeName(), addr); err != nil {
+		return err
+	}
 
-	// if out, err := RunCommand("ovs-vsctl", "set", "bridge", this.NodeName()); err != nil {
-	// 	return errors.New(fmt.Sprintf("Error: %v, output: %s", err, out))
-	// }
+	this.Controller = addr
 
 	return nil
 }
 
-func (this Switch) Release() error {
-	out, err := RunCommand("ovs-vsctl", "del-br", this.Name)
+// SetProtocols sets OpenFlow versions of the switch, e.g. OpenFlow13
+func (this *Switch) SetProtocols(protocols ...string) error {
+	for _, p := range protocols {
+		switch p {
+		case OpenFlow10, OpenFlow11, OpenFlow12, OpenFlow13, OpenFlow14, OpenFlow15:
+		default:
+			return errors.New(fmt.Sprintf("Unknown OpenFlow protocol %s", p))
+		}
+	}
+
+	b, err := this.backend()
 	if err != nil {
-		log.Println("Unable to delete bridge", this.Name, err, out)
+		return err
 	}
 
+	if err := b.SetProtocols(this.NodeName(), protocols); err != nil {
+		return err
+	}
+
+	this.Protocols = protocols
+
 	return nil
 }
 
+// configure sets controller and protocols of created switch, if the
+// scheme has them
+func (this *Switch) configure(controller string) error {
+	if controller != "" {
+		return this.SetController(controller)
+	}
+
+	if len(this.Protocols) > 0 {
+		return this.SetProtocols(this.Protocols...)
+	}
+
+	return nil
+}
+
+// Release deletes the switch and its ports. Errors and objects left are
+// returned as TeardownError.
+func (this Switch) Release() error {
+	result := &TeardownError{}
+
+	this.releaseBridge(result)
+	this.releasePorts(result)
+
+	result.Leaked = this.leaked()
+
+	return result.err()
+}
+
+func (this Switch) releaseBridge(result *TeardownError) {
+	b, err := this.backend()
+	if err != nil {
+		result.add(err)
+		return
+	}
+
+	if !b.Exists(this.Name) {
+		return
+	}
+
+	if err := b.Release(this.Name); err != nil {
+		result.add(errors.New(fmt.Sprintf("Unable to delete switch %s: %v", this.Name, err)))
+	}
+}
+
+// releasePorts removes veths to other switches, they live in the root
+// namespace, nothing else takes them away
+func (this Switch) releasePorts(result *TeardownError) {
+	for _, port := range this.Ports {
+		if port.Exists() {
+			result.add(port.remove())
+		}
+	}
+}
+
+// leaked returns the switch and its ports, if they are still there
+func (this Switch) leaked() []string {
+	result := make([]string, 0)
+
+	if b, err := this.backend(); err == nil && b.Exists(this.Name) {
+		result = append(result, "switch "+this.Name)
+	}
+
+	for _, port := range this.Ports {
+		if !port.patch && port.Exists() {
+			result = append(result, "link "+linkTarget(port))
+		}
+	}
+
+	return result
+}
+
 func (this Switch) NodeName() string {
 	return this.Name
 }
@@ -0,0 +1,56 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	SwitchOVS   = "ovs"
+	SwitchLinux = "linux"
+)
+
+// SwitchBackend implements Switch operations for particular kind of
+// software switch. Backend is chosen by Switch.Type.
+type SwitchBackend interface {
+	Create(name string) error
+	Exists(name string) bool
+	// List returns names of all switches of this type
+	List() ([]string, error)
+	AddPort(name string, l Link) error
+	// AddPatchPort connects two switches without veth pair, backends
+	// which can't do it should return false from SupportsPatch, then
+	// switches are interconnected with a veth pair, like hosts are.
+	AddPatchPort(name string, l Link) error
+	SupportsPatch() bool
+	// DelPort detaches port from the switch, the link itself is left
+	DelPort(name string, port string) error
+	Ports(name string) ([]string, error)
+	SetController(name string, addr string) error
+	// SetProtocols sets OpenFlow versions, e.g. "OpenFlow13"
+	SetProtocols(name string, protocols []string) error
+	Release(name string) error
+}
+
+var switchBackends = map[string]SwitchBackend{
+	SwitchOVS:   OVSBackend{},
+	SwitchLinux: BridgeBackend{},
+}
+
+// RegisterSwitchBackend makes backend available for switches of given type
+func RegisterSwitchBackend(typ string, b SwitchBackend) {
+	switchBackends[typ] = b
+}
+
+func switchBackend(typ string) (SwitchBackend, error) {
+	if typ == "" {
+		typ = SwitchOVS
+	}
+
+	b, found := switchBackends[typ]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("Unknown switch type: %s", typ))
+	}
+
+	return b, nil
+}
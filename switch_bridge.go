@@ -0,0 +1,150 @@
+package mn
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// BridgeBackend uses kernel Linux bridge. It doesn't need anything
+// installed, but knows nothing about OpenFlow and patch ports, so
+// switches of this type are interconnected with veth pairs.
+type BridgeBackend struct{}
+
+func (this BridgeBackend) Create(name string) error {
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: name}}
+
+	if err := netlink.LinkAdd(br); err != nil {
+		return &LinkError{Op: "create bridge", Link: name, Err: nlError(err)}
+	}
+
+	if err := netlink.LinkSetAlias(br, ownerTag()); err != nil {
+		return &LinkError{Op: "set alias", Link: name, Err: nlError(err)}
+	}
+
+	if err := netlink.LinkSetUp(br); err != nil {
+		return &LinkError{Op: "up", Link: name, Err: nlError(err)}
+	}
+
+	return nil
+}
+
+func (this BridgeBackend) Exists(name string) bool {
+	_, err := this.bridge(name)
+	return err == nil
+}
+
+func (this BridgeBackend) List() ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	for _, link := range links {
+		if link.Type() == "bridge" {
+			result = append(result, link.Attrs().Name)
+		}
+	}
+
+	return result, nil
+}
+
+func (this BridgeBackend) AddPort(name string, l Link) error {
+	br, err := this.bridge(name)
+	if err != nil {
+		return err
+	}
+
+	port, err := netlink.LinkByName(l.Name)
+	if err != nil {
+		return linkError("add port", l, nlError(err))
+	}
+
+	if err := netlink.LinkSetMaster(port, br); err != nil {
+		return linkError("add port", l, nlError(err))
+	}
+
+	return nil
+}
+
+func (this BridgeBackend) AddPatchPort(name string, l Link) error {
+	return errors.New(fmt.Sprintf("Can't add %s to %s, linux bridge doesn't support patch ports", l.Name, name))
+}
+
+func (this BridgeBackend) SupportsPatch() bool {
+	return false
+}
+
+func (this BridgeBackend) DelPort(name string, port string) error {
+	if _, err := this.bridge(name); err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(port)
+	if err != nil {
+		return &LinkError{Op: "delete port", Link: port, Err: nlError(err)}
+	}
+
+	if err := netlink.LinkSetNoMaster(link); err != nil {
+		return &LinkError{Op: "delete port", Link: port, Err: nlError(err)}
+	}
+
+	return nil
+}
+
+func (this BridgeBackend) Ports(name string) ([]string, error) {
+	br, err := this.bridge(name)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	for _, link := range links {
+		if link.Attrs().MasterIndex == br.Attrs().Index {
+			result = append(result, link.Attrs().Name)
+		}
+	}
+
+	return result, nil
+}
+
+func (this BridgeBackend) SetController(name string, addr string) error {
+	return errors.New(fmt.Sprintf("Can't set controller for %s, linux bridge doesn't support OpenFlow", name))
+}
+
+func (this BridgeBackend) SetProtocols(name string, protocols []string) error {
+	return errors.New(fmt.Sprintf("Can't set protocols for %s, linux bridge doesn't support OpenFlow", name))
+}
+
+func (this BridgeBackend) Release(name string) error {
+	br, err := this.bridge(name)
+	if err != nil {
+		return err
+	}
+
+	if err := netlink.LinkDel(br); err != nil {
+		return &LinkError{Op: "delete bridge", Link: name, Err: nlError(err)}
+	}
+
+	return nil
+}
+
+func (this BridgeBackend) bridge(name string) (netlink.Link, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, &LinkError{Op: "find bridge", Link: name, Err: nlError(err)}
+	}
+
+	if link.Type() != "bridge" {
+		return nil, &LinkError{Op: "find bridge", Link: name, Err: errors.New(fmt.Sprintf("%s is %s, not a bridge", name, link.Type()))}
+	}
+
+	return link, nil
+}
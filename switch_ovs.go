@@ -0,0 +1,172 @@
+package mn
+
+import (
+	"log"
+	"sync"
+
+	"github.com/NodePrime/open-mininet/ovsdb"
+)
+
+// OVSDBSocket is the ovsdb-server socket switches are managed through.
+var OVSDBSocket = ovsdb.DefaultSocket
+
+var (
+	ovsClient *ovsdb.Client
+	ovsMu     sync.Mutex
+)
+
+// SetOVSDB makes switches use given client instead of connecting to
+// OVSDBSocket, e.g. client of ovsdb.FakeServer in tests.
+func SetOVSDB(c *ovsdb.Client) {
+	ovsMu.Lock()
+	defer ovsMu.Unlock()
+
+	ovsClient = c
+}
+
+func ovs() (*ovsdb.Client, error) {
+	ovsMu.Lock()
+	defer ovsMu.Unlock()
+
+	if ovsClient != nil && ovsClient.Err() == nil {
+		return ovsClient, nil
+	}
+
+	c, err := ovsdb.DialUnix(OVSDBSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	ovsClient = c
+
+	return ovsClient, nil
+}
+
+// OVSBackend manages Open vSwitch bridges over OVSDB
+type OVSBackend struct{}
+
+func (this OVSBackend) Create(name string) error {
+	c, err := ovs()
+	if err != nil {
+		return err
+	}
+
+	return c.AddBridge(name, map[string]string{ownerKey: SessionId})
+}
+
+func (this OVSBackend) Exists(name string) bool {
+	c, err := ovs()
+	if err != nil {
+		log.Println("Unable to connect to ovsdb:", err)
+		return false
+	}
+
+	found, err := c.BridgeExists(name)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return found
+}
+
+func (this OVSBackend) List() ([]string, error) {
+	c, err := ovs()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ListBridges()
+}
+
+func (this OVSBackend) AddPort(name string, l Link) error {
+	c, err := ovs()
+	if err != nil {
+		return err
+	}
+
+	return c.AddPort(name, ovsdb.Port{Name: l.Name})
+}
+
+// AddPatchPort creates port, sets its type and peer in one transaction
+func (this OVSBackend) AddPatchPort(name string, l Link) error {
+	c, err := ovs()
+	if err != nil {
+		return err
+	}
+
+	port := ovsdb.Port{
+		Name:    l.Name,
+		Type:    "patch",
+		Options: map[string]string{"peer": l.Peer.Name},
+	}
+
+	return c.AddPort(name, port)
+}
+
+func (this OVSBackend) SupportsPatch() bool {
+	return true
+}
+
+// PatchPeer returns peer of the patch port, empty string for other ports
+func (this OVSBackend) PatchPeer(port string) (string, error) {
+	c, err := ovs()
+	if err != nil {
+		return "", err
+	}
+
+	iface, err := c.Interface(port)
+	if err != nil {
+		return "", err
+	}
+
+	if iface.String("type") != "patch" {
+		return "", nil
+	}
+
+	return iface.Map("options")["peer"], nil
+}
+
+func (this OVSBackend) DelPort(name string, port string) error {
+	c, err := ovs()
+	if err != nil {
+		return err
+	}
+
+	return c.DelPort(name, port)
+}
+
+func (this OVSBackend) Ports(name string) ([]string, error) {
+	c, err := ovs()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Ports(name)
+}
+
+func (this OVSBackend) SetController(name string, addr string) error {
+	c, err := ovs()
+	if err != nil {
+		return err
+	}
+
+	return c.SetController(name, addr)
+}
+
+func (this OVSBackend) SetProtocols(name string, protocols []string) error {
+	c, err := ovs()
+	if err != nil {
+		return err
+	}
+
+	return c.SetProtocols(name, protocols...)
+}
+
+func (this OVSBackend) Release(name string) error {
+	c, err := ovs()
+	if err != nil {
+		return err
+	}
+
+	return c.DelBridge(name)
+}
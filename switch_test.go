@@ -0,0 +1,130 @@
+package mn
+
+import (
+	"testing"
+
+	"github.com/NodePrime/open-mininet/ovsdb"
+)
+
+func TestSwitchOVSDB(t *testing.T) {
+	srv := ovsdb.NewFakeServer()
+
+	SetOVSDB(srv.Client())
+	defer SetOVSDB(nil)
+
+	s1, err := NewSwitch("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := NewSwitch("s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s1.Exists() || !s2.Exists() {
+		t.Fatal("Expected switches s1 and s2 exist")
+	}
+
+	if err := s1.AddPort(Link{Name: "s1-eth0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewLink(s1, s2)
+
+	if err := s1.AddLink(p.Left); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s2.AddLink(p.Right); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s1.SetController("tcp:127.0.0.1:6633"); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := s1.LinksCount(); c != 2 {
+		t.Fatal("Expected 2 ports, obtained:", c)
+	}
+
+	ifaces := make(map[string]ovsdb.Row)
+	for _, row := range srv.Rows("Interface") {
+		ifaces[row.String("name")] = row
+	}
+
+	for _, name := range []string{"s1", "s2", "s1-eth0", p.Left.Name, p.Right.Name} {
+		if _, found := ifaces[name]; !found {
+			t.Fatal("Expected interface", name, "not found")
+		}
+	}
+
+	if peer := ifaces[p.Left.Name].Map("options")["peer"]; peer != p.Right.Name {
+		t.Fatal("Expected", p.Left.Name, "peer", p.Right.Name, "obtained:", peer)
+	}
+
+	if rows := srv.Rows("Controller"); len(rows) != 1 {
+		t.Fatal("Expected one controller, obtained:", rows)
+	}
+
+	s1.Release()
+	s2.Release()
+
+	if s1.Exists() {
+		t.Fatal("Expected switch s1 released")
+	}
+
+	if rows := srv.Rows("Interface"); len(rows) != 0 {
+		t.Fatal("Expected no interfaces left, obtained:", rows)
+	}
+}
+
+func TestLinuxBridge(t *testing.T) {
+	srv := ovsdb.NewFakeServer()
+
+	SetOVSDB(srv.Client())
+	defer SetOVSDB(nil)
+
+	s1, err := NewSwitchOf(SwitchLinux, switchname())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Release()
+
+	s2, err := NewSwitch(switchname())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Release()
+
+	if !s1.Exists() {
+		t.Fatal("Expected bridge", s1.NodeName(), "exists")
+	}
+
+	p := NewLink(s1, s2)
+
+	if p.IsPatch() {
+		t.Fatal("Expected veth pair between linux bridge and ovs, obtained patch")
+	}
+
+	if p.Left.Cidr != noip || p.Right.Cidr != noip {
+		t.Fatal("Expected no addresses on switch to switch link, obtained:", p.Left.Cidr, p.Right.Cidr)
+	}
+
+	if err := p.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	if err := s1.AddLink(p.Left); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s1.HasPort(p.Left.Name) {
+		t.Fatal("Expected port", p.Left.Name, "attached to", s1.NodeName())
+	}
+
+	if _, err := NewSwitchOf("xcvxcv"); err == nil {
+		t.Fatal("Expected unknown switch type error")
+	}
+}
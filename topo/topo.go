@@ -0,0 +1,392 @@
+// Package topo generates common topologies. Generators only describe the
+// network, nothing is created until the scheme is recovered or applied:
+//
+//	scheme, err := topo.Tree(2, 3)
+//	...
+//	err = scheme.Recover()
+//
+// Hosts are named h1, h2, ..., switches s1, s2, ... Every host has eth0
+// with the next address of Options.Cidr. Topologies with loops (ring,
+// mesh, fat-tree) need a controller or spanning tree to forward anything.
+package topo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	mn "github.com/NodePrime/open-mininet"
+)
+
+// Options of generated topologies, zero value means OVS switches and
+// hosts in 10.0.0.0/8
+type Options struct {
+	SwitchType string
+	Controller string
+	// Protocols of switches, e.g. ["OpenFlow13"]
+	Protocols []string
+	Cidr      string
+}
+
+const defaultCidr = "10.0.0.0/8"
+
+// Generator makes topology of its size arguments
+type Generator struct {
+	Usage string
+	Args  int
+	Build func(b *Builder, args ...int) error
+}
+
+// Generators by name, as mn-ctl "new topo" knows them
+var Generators = map[string]Generator{
+	"linear": {"linear n: chain of n switches, host per switch", 1, func(b *Builder, args ...int) error {
+		return b.linear(args[0])
+	}},
+	"tree": {"tree depth fanout: switch tree, fanout hosts per leaf switch", 2, func(b *Builder, args ...int) error {
+		return b.tree(args[0], args[1])
+	}},
+	"star": {"star n: n hosts on one switch", 1, func(b *Builder, args ...int) error {
+		return b.star(args[0])
+	}},
+	"fattree": {"fattree k: k-ary fat-tree, k pods, (k/2)^2 core switches", 1, func(b *Builder, args ...int) error {
+		return b.fatTree(args[0])
+	}},
+	"mesh": {"mesh n: n fully connected switches, host per switch", 1, func(b *Builder, args ...int) error {
+		return b.mesh(args[0])
+	}},
+	"ring": {"ring n: n switches in a ring, host per switch", 1, func(b *Builder, args ...int) error {
+		return b.ring(args[0])
+	}},
+}
+
+// Names returns generator names sorted
+func Names() []string {
+	result := make([]string, 0)
+	for name := range Generators {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// New generates topology by name, e.g. New("tree", []int{2, 3})
+func New(name string, args []int, opts ...Options) (*mn.Scheme, error) {
+	g, found := Generators[name]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("Unknown topology %s", name))
+	}
+
+	if len(args) != g.Args {
+		return nil, errors.New(fmt.Sprintf("Wrong arguments of %s, usage: %s", name, g.Usage))
+	}
+
+	for _, arg := range args {
+		if arg < 1 {
+			return nil, errors.New(fmt.Sprintf("Wrong arguments of %s, positive numbers expected", name))
+		}
+	}
+
+	b, err := NewBuilder(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.Build(b, args...); err != nil {
+		return nil, err
+	}
+
+	return b.Scheme()
+}
+
+func Linear(n int, opts ...Options) (*mn.Scheme, error) {
+	return New("linear", []int{n}, opts...)
+}
+
+func Tree(depth, fanout int, opts ...Options) (*mn.Scheme, error) {
+	return New("tree", []int{depth, fanout}, opts...)
+}
+
+func Star(n int, opts ...Options) (*mn.Scheme, error) {
+	return New("star", []int{n}, opts...)
+}
+
+func FatTree(k int, opts ...Options) (*mn.Scheme, error) {
+	return New("fattree", []int{k}, opts...)
+}
+
+func Mesh(n int, opts ...Options) (*mn.Scheme, error) {
+	return New("mesh", []int{n}, opts...)
+}
+
+func Ring(n int, opts ...Options) (*mn.Scheme, error) {
+	return New("ring", []int{n}, opts...)
+}
+
+// Builder collects switches, hosts and links of a topology
+type Builder struct {
+	opts     Options
+	switches []*mn.Switch
+	hosts    []*mn.Host
+	ipnet    *net.IPNet
+	ip       net.IP
+}
+
+func NewBuilder(opts ...Options) (*Builder, error) {
+	this := &Builder{}
+	if len(opts) > 0 {
+		this.opts = opts[0]
+	}
+
+	if this.opts.Cidr == "" {
+		this.opts.Cidr = defaultCidr
+	}
+
+	_, ipnet, err := net.ParseCIDR(this.opts.Cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	this.ipnet = ipnet
+	this.ip = ipnet.IP
+
+	return this, nil
+}
+
+// Switch adds switch s<N>
+func (this *Builder) Switch() *mn.Switch {
+	s := &mn.Switch{
+		Name:       "s" + strconv.Itoa(len(this.switches)+1),
+		Type:       this.opts.SwitchType,
+		Controller: this.opts.Controller,
+		Protocols:  this.opts.Protocols,
+		Ports:      make(mn.Links, 0),
+	}
+
+	this.switches = append(this.switches, s)
+
+	return s
+}
+
+// Host adds host h<N> plugged into the switch
+func (this *Builder) Host(s *mn.Switch) (*mn.Host, error) {
+	cidr, err := this.nextCidr()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &mn.Host{Name: "h" + strconv.Itoa(len(this.hosts)+1), Links: make(mn.Links, 0)}
+	this.hosts = append(this.hosts, h)
+
+	port := mn.Link{Name: this.portName(s), NodeName: s.Name, Cidr: "noip", State: "UP"}
+	link := mn.Link{Name: "eth0", NodeName: h.Name, NetNs: h.Name, Cidr: cidr, State: "UP"}
+
+	port.Peer = mn.Peer{Name: link.Name, IfName: link.Name, NodeName: h.Name}
+	link.Peer = mn.Peer{Name: port.Name, IfName: port.Name, NodeName: s.Name}
+
+	s.Ports = append(s.Ports, port)
+	h.Links = append(h.Links, link)
+
+	return h, nil
+}
+
+// Connect links two switches
+func (this *Builder) Connect(s1, s2 *mn.Switch) {
+	left := mn.Link{Name: this.portName(s1), NodeName: s1.Name, Cidr: "noip", State: "UP"}
+	s1.Ports = append(s1.Ports, left)
+
+	right := mn.Link{Name: this.portName(s2), NodeName: s2.Name, Cidr: "noip", State: "UP"}
+	right.Peer = mn.Peer{Name: left.Name, IfName: left.Name, NodeName: s1.Name}
+	s2.Ports = append(s2.Ports, right)
+
+	s1.Ports[len(s1.Ports)-1].Peer = mn.Peer{Name: right.Name, IfName: right.Name, NodeName: s2.Name}
+}
+
+// Scheme returns the topology as a desired state, see mn.ParseScheme
+func (this *Builder) Scheme() (*mn.Scheme, error) {
+	spec := struct {
+		Switches []*mn.Switch
+		Hosts    []*mn.Host
+	}{this.switches, this.hosts}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return mn.ParseScheme(data)
+}
+
+// portName follows mininet naming, s1-eth1, s1-eth2, ...
+func (this *Builder) portName(s *mn.Switch) string {
+	return fmt.Sprintf("%s-eth%d", s.Name, len(s.Ports)+1)
+}
+
+func (this *Builder) nextCidr() (string, error) {
+	ip := inc(this.ip)
+
+	// the last one is broadcast
+	if !this.ipnet.Contains(ip) || !this.ipnet.Contains(inc(ip)) {
+		return "", errors.New(fmt.Sprintf("No more addresses in %s", this.ipnet))
+	}
+
+	this.ip = ip
+	ones, _ := this.ipnet.Mask.Size()
+
+	return fmt.Sprintf("%s/%d", ip, ones), nil
+}
+
+func inc(ip net.IP) net.IP {
+	result := append(net.IP{}, ip...)
+
+	for j := len(result) - 1; j >= 0; j-- {
+		result[j]++
+		if result[j] > 0 {
+			break
+		}
+	}
+
+	return result
+}
+
+func (this *Builder) linear(n int) error {
+	var prev *mn.Switch
+
+	for i := 0; i < n; i++ {
+		s := this.Switch()
+		if _, err := this.Host(s); err != nil {
+			return err
+		}
+
+		if prev != nil {
+			this.Connect(prev, s)
+		}
+
+		prev = s
+	}
+
+	return nil
+}
+
+// tree has depth levels of switches, leaf switches get fanout hosts
+func (this *Builder) tree(depth, fanout int) error {
+	var add func(level int) (*mn.Switch, error)
+
+	add = func(level int) (*mn.Switch, error) {
+		s := this.Switch()
+
+		for i := 0; i < fanout; i++ {
+			if level == depth {
+				if _, err := this.Host(s); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			child, err := add(level + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			this.Connect(s, child)
+		}
+
+		return s, nil
+	}
+
+	_, err := add(1)
+
+	return err
+}
+
+func (this *Builder) star(n int) error {
+	s := this.Switch()
+
+	for i := 0; i < n; i++ {
+		if _, err := this.Host(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fatTree is k-ary fat-tree: k pods of k/2 aggregation and k/2 edge
+// switches, every edge switch has k/2 hosts, every aggregation switch
+// connects to k/2 of (k/2)^2 core switches
+func (this *Builder) fatTree(k int) error {
+	if k%2 != 0 {
+		return errors.New(fmt.Sprintf("Fat-tree k must be even, obtained %d", k))
+	}
+
+	half := k / 2
+
+	core := make([]*mn.Switch, 0)
+	for i := 0; i < half*half; i++ {
+		core = append(core, this.Switch())
+	}
+
+	for pod := 0; pod < k; pod++ {
+		aggs := make([]*mn.Switch, 0)
+		for i := 0; i < half; i++ {
+			agg := this.Switch()
+			aggs = append(aggs, agg)
+
+			for j := 0; j < half; j++ {
+				this.Connect(core[i*half+j], agg)
+			}
+		}
+
+		for i := 0; i < half; i++ {
+			edge := this.Switch()
+
+			for _, agg := range aggs {
+				this.Connect(agg, edge)
+			}
+
+			for j := 0; j < half; j++ {
+				if _, err := this.Host(edge); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (this *Builder) mesh(n int) error {
+	switches := make([]*mn.Switch, 0)
+
+	for i := 0; i < n; i++ {
+		s := this.Switch()
+		if _, err := this.Host(s); err != nil {
+			return err
+		}
+
+		for _, prev := range switches {
+			this.Connect(prev, s)
+		}
+
+		switches = append(switches, s)
+	}
+
+	return nil
+}
+
+func (this *Builder) ring(n int) error {
+	if err := this.linear(n); err != nil {
+		return err
+	}
+
+	// two switches are already connected by the chain
+	if n > 2 {
+		this.Connect(this.switches[n-1], this.switches[0])
+	}
+
+	return nil
+}
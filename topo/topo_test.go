@@ -0,0 +1,90 @@
+package topo
+
+import (
+	"testing"
+
+	mn "github.com/NodePrime/open-mininet"
+)
+
+// links counts pairs of the scheme and checks both ends refer to each
+// other
+func links(t *testing.T, scheme *mn.Scheme) int {
+	result := 0
+
+	for node := range scheme.Nodes() {
+		for _, l := range node.GetLinks() {
+			peer, found := scheme.GetNode(l.Peer.NodeName)
+			if !found {
+				t.Fatal("Peer node", l.Peer.NodeName, "of", l.NodeName, l.Name, "not found")
+			}
+
+			r := peer.GetLinks().LinkByPeer(l.Peer)
+			if r.Peer.NodeName != l.NodeName || r.Peer.IfName != l.Name {
+				t.Fatal("Link", l.NodeName, l.Name, "and its peer disagree")
+			}
+
+			result++
+		}
+	}
+
+	return result / 2
+}
+
+func TestGenerators(t *testing.T) {
+	cases := []struct {
+		name                   string
+		args                   []int
+		switches, hosts, pairs int
+	}{
+		{"linear", []int{4}, 4, 4, 7},
+		{"tree", []int{2, 3}, 4, 9, 12},
+		{"tree", []int{1, 2}, 1, 2, 2},
+		{"star", []int{5}, 1, 5, 5},
+		{"fattree", []int{4}, 20, 16, 48},
+		{"mesh", []int{4}, 4, 4, 10},
+		{"ring", []int{4}, 4, 4, 8},
+	}
+
+	for _, c := range cases {
+		scheme, err := New(c.name, c.args)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+
+		if len(scheme.Switches) != c.switches || len(scheme.Hosts) != c.hosts {
+			t.Fatal(c.name, c.args, "expected", c.switches, "switches and", c.hosts, "hosts, obtained",
+				len(scheme.Switches), len(scheme.Hosts))
+		}
+
+		if pairs := links(t, scheme); pairs != c.pairs {
+			t.Fatal(c.name, c.args, "expected", c.pairs, "links, obtained", pairs)
+		}
+	}
+}
+
+func TestGeneratorOptions(t *testing.T) {
+	scheme, err := Star(2, Options{SwitchType: mn.SwitchLinux, Cidr: "192.168.7.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scheme.Switches[0].Type != mn.SwitchLinux {
+		t.Fatal("Expected linux switch, obtained", scheme.Switches[0].Type)
+	}
+
+	if cidr := scheme.Hosts[1].Links[0].Cidr; cidr != "192.168.7.2/24" {
+		t.Fatal("Expected h2 address 192.168.7.2/24, obtained", cidr)
+	}
+
+	if _, err := Star(3, Options{Cidr: "192.168.7.0/30"}); err == nil {
+		t.Fatal("Expected error, /30 has two addresses")
+	}
+
+	if _, err := FatTree(3); err == nil {
+		t.Fatal("Expected error for odd fat-tree k")
+	}
+
+	if _, err := New("tree", []int{2}); err == nil {
+		t.Fatal("Expected error for missing fanout")
+	}
+}